@@ -0,0 +1,162 @@
+// Command realm-apply reconciles a cluster's realm with a desired-state document kept in git.
+//
+//	realm-apply -cluster 3 -file realm.yaml -dry-run
+//	realm-apply -cluster 3 -file realm.yaml -prune
+//
+// It talks to the backend API. Authenticate with -token (or KMM_TOKEN), or with
+// -username and -password (or KMM_USERNAME and KMM_PASSWORD).
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"keycloak-multi-manage/internal/domain"
+)
+
+func main() {
+	server := flag.String("server", envOr("KMM_SERVER", "http://localhost:8080"), "backend URL")
+	token := flag.String("token", os.Getenv("KMM_TOKEN"), "API token")
+	username := flag.String("username", os.Getenv("KMM_USERNAME"), "username to log in with when no token is given")
+	password := flag.String("password", os.Getenv("KMM_PASSWORD"), "password to log in with when no token is given")
+	clusterID := flag.Int("cluster", 0, "ID of the target cluster")
+	file := flag.String("file", "", "desired-state document (YAML or JSON), - reads stdin")
+	prune := flag.Bool("prune", false, "delete roles, clients and groups the document does not list")
+	dryRun := flag.Bool("dry-run", false, "only show the diff and the planned steps")
+	asJSON := flag.Bool("json", false, "print the full result as JSON")
+	flag.Parse()
+
+	if *clusterID == 0 || *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	document, err := readDocument(*file)
+	if err != nil {
+		fail("failed to read document: %v", err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	base := strings.TrimRight(*server, "/")
+
+	if *token == "" {
+		if *username == "" || *password == "" {
+			fail("a token or a username and password are required")
+		}
+		*token, err = login(client, base, *username, *password)
+		if err != nil {
+			fail("failed to log in: %v", err)
+		}
+	}
+
+	query := url.Values{}
+	query.Set("prune", strconv.FormatBool(*prune))
+	query.Set("dry_run", strconv.FormatBool(*dryRun))
+	endpoint := fmt.Sprintf("%s/api/desired-state/clusters/%d/apply?%s", base, *clusterID, query.Encode())
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(document))
+	if err != nil {
+		fail("%v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	req.Header.Set("Content-Type", "application/yaml")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		fail("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fail("failed to read response: %v", err)
+	}
+
+	var result domain.DesiredStateResult
+	if err := json.Unmarshal(body, &result); err != nil || result.Status == "" {
+		fail("apply failed: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	if *asJSON {
+		os.Stdout.Write(body)
+		fmt.Println()
+	} else {
+		printResult(&result)
+	}
+
+	if result.Status == "failed" {
+		os.Exit(1)
+	}
+}
+
+func printResult(result *domain.DesiredStateResult) {
+	statuses := make(map[int]domain.SyncStepResult)
+	for _, r := range result.Results {
+		statuses[r.Order] = r
+	}
+
+	for _, step := range result.Steps {
+		line := fmt.Sprintf("%3d. %s", step.Order, step.Description)
+		if r, ok := statuses[step.Order]; ok {
+			line += " [" + r.Status + "]"
+			if r.Error != "" {
+				line += ": " + r.Error
+			}
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Printf("%s: %d step(s)\n", result.Status, len(result.Steps))
+}
+
+func login(client *http.Client, base, username, password string) (string, error) {
+	payload, err := json.Marshal(domain.LoginRequest{Username: username, Password: password})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Post(base+"/api/auth/login", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var auth domain.AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return "", err
+	}
+	return auth.Token, nil
+}
+
+func readDocument(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "realm-apply: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,61 @@
+// Command rotate-secrets re-encrypts the secrets stored in the database for the active key.
+//
+//	rotate-secrets -generate-key
+//	SECRET_KEYS=2025-02:<new key>,2024-01:<old key> rotate-secrets
+//
+// Add the new key in front of SECRET_KEYS (or set SECRET_ACTIVE_KEY), run the command with the
+// server's database settings, then remove the old key once the server runs with the new keyring.
+// Only the wrapped data keys change; the encrypted secrets themselves stay the same.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"keycloak-multi-manage/internal/repository/postgres"
+	"keycloak-multi-manage/pkg/database"
+	"keycloak-multi-manage/pkg/secrets"
+)
+
+func main() {
+	generateKey := flag.Bool("generate-key", false, "print a new random key for SECRET_KEYS and exit")
+	flag.Parse()
+
+	if *generateKey {
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			fail("failed to generate key: %v", err)
+		}
+		fmt.Println(key)
+		return
+	}
+
+	keyring, err := secrets.LoadKeyring()
+	if err != nil {
+		fail("%v", err)
+	}
+
+	db, err := database.NewPostgresConnection()
+	if err != nil {
+		fail("failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	clusters, err := postgres.NewClusterRepository(db, keyring).ReencryptSecrets(true)
+	if err != nil {
+		fail("failed to rotate cluster client secrets: %v", err)
+	}
+	ldapConfigs, err := postgres.NewLDAPConfigRepository(db, keyring).ReencryptSecrets(true)
+	if err != nil {
+		fail("failed to rotate LDAP bind passwords: %v", err)
+	}
+
+	fmt.Printf("Re-encrypted %d cluster client secret(s) and %d LDAP bind password(s) with key %s\n",
+		clusters, ldapConfigs, keyring.ActiveKeyID())
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "rotate-secrets: "+format+"\n", args...)
+	os.Exit(1)
+}
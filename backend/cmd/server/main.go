@@ -3,15 +3,20 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"keycloak-multi-manage/internal/client/keycloak"
+	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/handler"
 	"keycloak-multi-manage/internal/middleware"
 	"keycloak-multi-manage/internal/repository/postgres"
 	"keycloak-multi-manage/internal/service"
 	"keycloak-multi-manage/pkg/database"
+	"keycloak-multi-manage/pkg/secrets"
 )
 
 func main() {
@@ -22,13 +27,49 @@ func main() {
 	}
 	defer db.Close()
 	
+	// Stored secrets are encrypted with the keys of SECRET_KEYS / SECRET_KEYS_FILE
+	keyring, err := secrets.LoadKeyring()
+	if err != nil {
+		log.Fatalf("Failed to load secret encryption keys: %v", err)
+	}
+	
+	// Secrets are read from the database or, when referenced there, from a mounted directory (SECRETS_DIR)
+	// or Vault (VAULT_ADDR) each time they are used
+	secretResolver, err := secrets.LoadResolver()
+	if err != nil {
+		log.Fatalf("Failed to configure secret stores: %v", err)
+	}
+	secretResolver.Register(secrets.DatabaseScheme, postgres.NewSecretStore(db, keyring))
+	keycloak.SetSecretResolver(secretResolver)
+	
 	// Initialize repositories
-	clusterRepo := postgres.NewClusterRepository(db)
+	clusterRepo := postgres.NewClusterRepository(db, keyring)
 	userRepo := postgres.NewUserRepository(db)
 	permissionRepo := postgres.NewPermissionRepository(db)
 	appRoleRepo := postgres.NewAppRoleRepository(db)
-	ldapConfigRepo := postgres.NewLDAPConfigRepository(db)
+	ldapConfigRepo := postgres.NewLDAPConfigRepository(db, keyring)
 	environmentTagRepo := postgres.NewEnvironmentTagRepository(db)
+	syncPlanRepo := postgres.NewSyncPlanRepository(db)
+	rewriteRuleRepo := postgres.NewRewriteRuleRepository(db)
+	driftRepo := postgres.NewDriftRepository(db)
+	snapshotRepo := postgres.NewSnapshotRepository(db)
+	jobRepo := postgres.NewJobRepository(db)
+	auditRepo := postgres.NewAuditRepository(db)
+	keycloakEventRepo := postgres.NewKeycloakEventRepository(db)
+	secretRotationRepo := postgres.NewSecretRotationRepository(db, keyring)
+	
+	// Encrypt secrets written before encryption was introduced or before they were bound to their row;
+	// the keyring refuses to decrypt them until then. rotate-secrets moves them to a new key.
+	if n, err := clusterRepo.ReencryptSecrets(false); err != nil {
+		log.Fatalf("Failed to encrypt cluster client secrets: %v", err)
+	} else if n > 0 {
+		log.Printf("Encrypted %d plaintext or legacy cluster client secret(s)", n)
+	}
+	if n, err := ldapConfigRepo.ReencryptSecrets(false); err != nil {
+		log.Fatalf("Failed to encrypt LDAP bind password: %v", err)
+	} else if n > 0 {
+		log.Printf("Encrypted %d plaintext or legacy LDAP bind password(s)", n)
+	}
 	
 	// Initialize default admin user if it doesn't exist
 	if err := service.InitDefaultAdmin(userRepo); err != nil {
@@ -42,21 +83,54 @@ func main() {
 	clusterService := service.NewClusterService(clusterRepo)
 	clusterService.SetTagRepository(environmentTagRepo) // Inject tag repository
 	roleService := service.NewRoleService(clusterRepo)
+	rewriteRuleService := service.NewRewriteRuleService(rewriteRuleRepo, environmentTagRepo)
 	diffService := service.NewDiffService(roleService, clusterService)
-	syncService := service.NewSyncService(clusterRepo)
+	diffService.SetRewriteService(rewriteRuleService)
+	syncService := service.NewSyncService(clusterRepo, syncPlanRepo)
+	syncService.SetRewriteService(rewriteRuleService)
+	bulkSyncService := service.NewBulkSyncService(syncService, diffService)
+	desiredStateService := service.NewDesiredStateService(syncService)
+	driftService := service.NewDriftService(driftRepo, clusterRepo, diffService)
+	snapshotService := service.NewSnapshotService(snapshotRepo, clusterRepo)
+	diffService.SetSnapshotService(snapshotService)
+	restoreService := service.NewRestoreService(syncService, snapshotService, diffService)
 	exportImportService := service.NewExportImportService(clusterRepo)
-	authService := service.NewAuthService(userRepo, appRoleRepo, ldapConfigRepo, certService)
+	exportImportService.SetRewriteService(rewriteRuleService)
+	authService := service.NewAuthService(userRepo, appRoleRepo, ldapConfigRepo, certService, secretResolver)
 	userService := service.NewUserService(userRepo, appRoleRepo)
 	appRoleService := service.NewAppRoleService(appRoleRepo, permissionRepo)
-	ldapConfigService := service.NewLDAPConfigService(ldapConfigRepo, certService)
+	ldapConfigService := service.NewLDAPConfigService(ldapConfigRepo, certService, secretResolver)
 	environmentTagService := service.NewEnvironmentTagService(environmentTagRepo, clusterRepo)
 	userFederationService := service.NewUserFederationService(clusterRepo)
+	eventService := service.NewEventService()
+	jobService := service.NewJobService(jobRepo)
+	jobService.SetEventService(eventService)
+	auditService := service.NewAuditService(auditRepo, clusterRepo, userRepo)
+	jobService.SetAuditService(auditService)
+	keycloakEventService := service.NewKeycloakEventService(keycloakEventRepo, clusterRepo)
+	secretRotationService := service.NewSecretRotationService(secretRotationRepo, clusterRepo, environmentTagRepo)
+	secretSinks, err := secrets.LoadSinks()
+	if err != nil {
+		log.Fatalf("Failed to configure secret sinks: %v", err)
+	}
+	secretRotationService.SetSinks(secretSinks)
+	bulkSyncService.SetEventService(eventService)
+	exportImportService.SetEventService(eventService)
+	driftService.SetEventService(eventService)
+	jobService.Register(domain.JobTypeBulkSync, 1, bulkSyncService.RunJob)
+	jobService.Register(domain.JobTypeRealmImport, 1, exportImportService.ImportRealmJob)
+	jobService.Register(domain.JobTypeFederationSync, 3, userFederationService.SyncUserFederationJob)
+	jobService.Register(domain.JobTypeUserExport, 3, exportImportService.ExportUsersJob)
+	jobService.Register(domain.JobTypeSecretRotation, 1, secretRotationService.RotateClustersJob)
+	jobService.Register(domain.JobTypeClientSecretRotation, 1, secretRotationService.RotateClientSecretsJob)
 	
 	// Initialize handlers
-	clusterHandler := handler.NewClusterHandler(clusterService)
+	clusterHandler := handler.NewClusterHandler(clusterService, appRoleService)
 	roleHandler := handler.NewRoleHandler(roleService)
-	diffHandler := handler.NewDiffHandler(diffService)
-	syncHandler := handler.NewSyncHandler(syncService)
+	diffHandler := handler.NewDiffHandler(diffService, appRoleService)
+	syncHandler := handler.NewSyncHandler(syncService, appRoleService)
+	bulkSyncHandler := handler.NewBulkSyncHandler(bulkSyncService, appRoleService)
+	desiredStateHandler := handler.NewDesiredStateHandler(desiredStateService)
 	exportImportHandler := handler.NewExportImportHandler(exportImportService)
 	authHandler := handler.NewAuthHandler(authService)
 	userHandler := handler.NewUserHandler(userService)
@@ -64,9 +138,85 @@ func main() {
 	ldapConfigHandler := handler.NewLDAPConfigHandler(ldapConfigService)
 	environmentTagHandler := handler.NewEnvironmentTagHandler(environmentTagService)
 	userFederationHandler := handler.NewUserFederationHandler(userFederationService)
+	rewriteRuleHandler := handler.NewRewriteRuleHandler(rewriteRuleService)
+	driftHandler := handler.NewDriftHandler(driftService, appRoleService)
+	snapshotHandler := handler.NewSnapshotHandler(snapshotService, restoreService, appRoleService)
+	jobHandler := handler.NewJobHandler(jobService, appRoleService)
+	eventHandler := handler.NewEventHandler(eventService, appRoleService)
+	auditHandler := handler.NewAuditHandler(auditService)
+	keycloakEventHandler := handler.NewKeycloakEventHandler(keycloakEventService, appRoleService)
+	secretRotationHandler := handler.NewSecretRotationHandler(secretRotationService, jobService, appRoleService)
+	bulkSyncHandler.SetJobService(jobService)
+	exportImportHandler.SetJobService(jobService)
+	userFederationHandler.SetJobService(jobService)
+	
+	// Run background jobs (JOB_WORKERS, default 2); jobs of a stopped server are picked up again
+	jobWorkers := 2
+	if value := os.Getenv("JOB_WORKERS"); value != "" {
+		jobWorkers, err = strconv.Atoi(value)
+		if err != nil || jobWorkers < 1 {
+			log.Fatalf("Invalid JOB_WORKERS: %s", value)
+		}
+	}
+	jobService.Start(jobWorkers)
+	
+	// Diff cluster pairs in the background; each pair runs on its own interval
+	driftService.StartScheduler(time.Minute)
+	
+	// Snapshot every cluster's realm periodically (SNAPSHOT_INTERVAL, default 24h, "0" disables)
+	snapshotInterval := 24 * time.Hour
+	if value := os.Getenv("SNAPSHOT_INTERVAL"); value != "" {
+		snapshotInterval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid SNAPSHOT_INTERVAL: %v", err)
+		}
+	}
+	if snapshotInterval > 0 {
+		snapshotService.StartScheduler(snapshotInterval)
+	}
+	
+	// Collect every cluster's login and admin events (KEYCLOAK_EVENT_INTERVAL, default 5m, "0" disables)
+	keycloakEventInterval := 5 * time.Minute
+	if value := os.Getenv("KEYCLOAK_EVENT_INTERVAL"); value != "" {
+		keycloakEventInterval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid KEYCLOAK_EVENT_INTERVAL: %v", err)
+		}
+	}
+	if keycloakEventInterval > 0 {
+		keycloakEventService.StartCollector(keycloakEventInterval)
+	}
+	
+	// Rotate the multi-manage client secrets older than SECRET_ROTATION_MAX_AGE (e.g. 2160h for 90 days, disabled when empty or "0")
+	if value := os.Getenv("SECRET_ROTATION_MAX_AGE"); value != "" {
+		secretRotationMaxAge, err := time.ParseDuration(value)
+		if err != nil || secretRotationMaxAge < 0 {
+			log.Fatalf("Invalid SECRET_ROTATION_MAX_AGE: %s", value)
+		}
+		if secretRotationMaxAge > 0 {
+			secretRotationService.StartScheduler(secretRotationMaxAge)
+		}
+	}
+	
+	// Export every cluster's configuration into a git repository (GIT_EXPORT_PATH, disabled when empty)
+	var gitExportHandler *handler.GitExportHandler
+	if gitExportPath := os.Getenv("GIT_EXPORT_PATH"); gitExportPath != "" {
+		gitExportInterval := time.Hour
+		if value := os.Getenv("GIT_EXPORT_INTERVAL"); value != "" {
+			gitExportInterval, err = time.ParseDuration(value)
+			if err != nil || gitExportInterval <= 0 {
+				log.Fatalf("Invalid GIT_EXPORT_INTERVAL: %s", value)
+			}
+		}
+		gitExportService := service.NewGitExportService(clusterRepo, gitExportPath)
+		gitExportService.StartScheduler(gitExportInterval)
+		gitExportHandler = handler.NewGitExportHandler(gitExportService)
+	}
 	
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
+		// Large uploads such as NDJSON user imports are read as they arrive
+		StreamRequestBody: true,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -97,8 +247,8 @@ func main() {
 		return c.Status(200).SendString("OK")
 	})
 	
-	// Routes
-	api := app.Group("/api")
+	// Routes; every mutating call is recorded in the audit log
+	api := app.Group("/api", middleware.AuditMiddleware(auditService))
 	
 	// Auth routes (public)
 	auth := api.Group("/auth")
@@ -110,36 +260,41 @@ func main() {
 	ldapConfigPublic := api.Group("/ldap-config")
 	ldapConfigPublic.Get("/", ldapConfigHandler.Get)
 	
+	// Live progress events; EventSource clients pass the token as access_token
+	api.Get("/events/stream", middleware.QueryTokenMiddleware(), middleware.AuthMiddleware(authService), eventHandler.Stream)
+	
 	// Protected routes
 	protected := api.Group("", middleware.AuthMiddleware(authService))
 	
-	// Cluster routes with permission checks
+	// Cluster routes with permission checks; routes of one cluster also check the scope of the user's roles
 	clusters := protected.Group("/clusters")
 	clusters.Get("/", middleware.PermissionMiddleware(appRoleService, "view_clusters"), clusterHandler.GetAll)
 	clusters.Post("/search", middleware.PermissionMiddleware(appRoleService, "view_clusters"), clusterHandler.Search)
-	clusters.Get("/:id", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetByID)
-	clusters.Get("/:id/health", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.HealthCheck)
-	clusters.Get("/:id/metrics", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetMetrics)
-	clusters.Get("/:id/prometheus-metrics", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetPrometheusMetrics)
-	clusters.Get("/:id/rbac-analysis", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetRBACAnalysis)
-	clusters.Get("/:id/server-info", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetServerInfo)
-	clusters.Post("/:id/user-token", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetUserToken)
-	clusters.Get("/:id/clients", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetClients)
-	clusters.Get("/:id/clients/details", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetClientDetails)
-	clusters.Get("/:id/clients/secret", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetClientSecret)
-	clusters.Get("/:id/users", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetUsers)
-	clusters.Get("/:id/users/details", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetUserDetails)
-	clusters.Get("/:id/groups", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetGroups)
-	clusters.Get("/:id/groups/details", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetGroupDetails)
+	clusters.Get("/:id", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetByID)
+	clusters.Get("/:id/health", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.HealthCheck)
+	clusters.Get("/:id/metrics", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetMetrics)
+	clusters.Get("/:id/prometheus-metrics", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetPrometheusMetrics)
+	clusters.Get("/:id/rbac-analysis", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetRBACAnalysis)
+	clusters.Get("/:id/server-info", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetServerInfo)
+	clusters.Post("/:id/user-token", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetUserToken)
+	clusters.Get("/:id/clients", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetClients)
+	clusters.Get("/:id/clients/details", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetClientDetails)
+	clusters.Get("/:id/clients/secret", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetClientSecret)
+	clusters.Get("/:id/users", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetUsers)
+	clusters.Get("/:id/users/details", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetUserDetails)
+	clusters.Get("/:id/groups", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetGroups)
+	clusters.Get("/:id/groups/details", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), clusterHandler.GetGroupDetails)
 	
 	// Admin-only cluster operations
 	adminClusters := protected.Group("/clusters", middleware.PermissionMiddleware(appRoleService, "manage_roles"))
 	adminClusters.Post("/", middleware.PermissionMiddleware(appRoleService, "create_cluster"), clusterHandler.Create)
 	adminClusters.Post("/discover", middleware.PermissionMiddleware(appRoleService, "create_cluster"), clusterHandler.DiscoverRealms)
-	adminClusters.Put("/:id", middleware.PermissionMiddleware(appRoleService, "update_cluster"), clusterHandler.Update)
-	adminClusters.Delete("/:id", middleware.PermissionMiddleware(appRoleService, "delete_cluster"), clusterHandler.Delete)
+	adminClusters.Put("/:id", middleware.ClusterPermissionMiddleware(appRoleService, "update_cluster"), clusterHandler.Update)
+	adminClusters.Delete("/:id", middleware.ClusterPermissionMiddleware(appRoleService, "delete_cluster"), clusterHandler.Delete)
+	adminClusters.Post("/:id/rotate-secret", middleware.ClusterPermissionMiddleware(appRoleService, "update_cluster"), secretRotationHandler.RotateCluster)
+	adminClusters.Post("/:id/clients/rotate-secret", middleware.ClusterPermissionMiddleware(appRoleService, "update_cluster"), secretRotationHandler.RotateClientSecret)
 	
-	// Keycloak management operations
+	// Keycloak management operations; they only need manage_roles, which roles with a cluster scope do not grant
 	adminClusters.Post("/:id/users/assign-realm-roles", clusterHandler.AssignRealmRolesToUser)
 	adminClusters.Post("/:id/users/assign-client-roles", clusterHandler.AssignClientRolesToUser)
 	adminClusters.Post("/:id/users/add-to-group", clusterHandler.AddUserToGroup)
@@ -155,30 +310,100 @@ func main() {
 	
 	// Role routes
 	roles := protected.Group("/roles")
-	roles.Get("/cluster/:id", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), roleHandler.GetRoles)
+	roles.Get("/cluster/:id", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), roleHandler.GetRoles)
 	
 	// Diff routes
 	diff := protected.Group("/diff", middleware.PermissionMiddleware(appRoleService, "view_diff"))
-	diff.Get("/roles", diffHandler.GetRoleDiff)
-	diff.Get("/clients", diffHandler.GetClientDiff)
-	diff.Get("/groups", diffHandler.GetGroupDiff)
-	diff.Get("/users", diffHandler.GetUserDiff)
+	diff.Get("/roles", middleware.QueryClusterPermissionMiddleware(appRoleService, "view_diff", "source", "destination"), diffHandler.GetRoleDiff)
+	diff.Get("/clients", middleware.QueryClusterPermissionMiddleware(appRoleService, "view_diff", "source", "destination"), diffHandler.GetClientDiff)
+	diff.Get("/groups", middleware.QueryClusterPermissionMiddleware(appRoleService, "view_diff", "source", "destination"), diffHandler.GetGroupDiff)
+	diff.Get("/users", middleware.QueryClusterPermissionMiddleware(appRoleService, "view_diff", "source", "destination"), diffHandler.GetUserDiff)
+	diff.Post("/compare", diffHandler.Compare)
+	
+	// Drift detection routes
+	drift := protected.Group("/drift", middleware.PermissionMiddleware(appRoleService, "view_diff"))
+	drift.Get("/pairs", driftHandler.GetPairs)
+	drift.Get("/pairs/:id", driftHandler.GetPair)
+	drift.Post("/pairs", middleware.AdminMiddleware(appRoleService), driftHandler.CreatePair)
+	drift.Put("/pairs/:id", middleware.AdminMiddleware(appRoleService), driftHandler.UpdatePair)
+	drift.Delete("/pairs/:id", middleware.AdminMiddleware(appRoleService), driftHandler.DeletePair)
+	drift.Post("/pairs/:id/run", driftHandler.RunPair)
+	drift.Get("/pairs/:id/runs", driftHandler.GetRuns)
+	drift.Get("/pairs/:id/changes", driftHandler.GetChanges)
+	drift.Get("/runs/:runId", driftHandler.GetRun)
+	
+	// Snapshot routes
+	snapshots := protected.Group("/snapshots", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"))
+	snapshots.Get("/", snapshotHandler.ListSnapshots)
+	snapshots.Post("/clusters/:id", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), snapshotHandler.TakeSnapshot)
+	snapshots.Get("/:id", snapshotHandler.GetSnapshot)
+	snapshots.Get("/:id/sections/:section", snapshotHandler.GetSnapshotSection)
+	snapshots.Post("/:id/restore", middleware.PermissionMiddleware(appRoleService, "sync_items"), snapshotHandler.RestoreSnapshot)
+	snapshots.Delete("/:id", middleware.AdminMiddleware(appRoleService), snapshotHandler.DeleteSnapshot)
+	
+	// Git export routes (only when the exporter is enabled)
+	if gitExportHandler != nil {
+		protected.Post("/git-export/run", middleware.AdminMiddleware(appRoleService), gitExportHandler.Run)
+	}
 	
 	// Sync routes
 	sync := protected.Group("/sync", middleware.PermissionMiddleware(appRoleService, "sync_items"))
-	sync.Post("/role", syncHandler.SyncRole)
-	sync.Post("/client", syncHandler.SyncClient)
-	sync.Post("/group", syncHandler.SyncGroup)
-	sync.Post("/user", syncHandler.SyncUser)
+	sync.Post("/role", middleware.QueryClusterPermissionMiddleware(appRoleService, "sync_items", "source", "destination"), syncHandler.SyncRole)
+	sync.Post("/client", middleware.QueryClusterPermissionMiddleware(appRoleService, "sync_items", "source", "destination"), syncHandler.SyncClient)
+	sync.Post("/group", middleware.QueryClusterPermissionMiddleware(appRoleService, "sync_items", "source", "destination"), syncHandler.SyncGroup)
+	sync.Post("/user", middleware.QueryClusterPermissionMiddleware(appRoleService, "sync_items", "source", "destination"), syncHandler.SyncUser)
+	sync.Post("/plans", syncHandler.CreatePlan)
+	sync.Get("/plans", syncHandler.ListPlans)
+	sync.Get("/plans/:id", syncHandler.GetPlan)
+	sync.Post("/plans/:id/apply", syncHandler.ApplyPlan)
+	sync.Post("/bulk", bulkSyncHandler.Run)
+	
+	// Desired-state (GitOps) routes
+	desiredState := protected.Group("/desired-state", middleware.PermissionMiddleware(appRoleService, "sync_items"))
+	desiredState.Post("/clusters/:id/apply", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), desiredStateHandler.Apply)
 	
 	// Export/Import routes
 	exportImport := protected.Group("/export-import")
-	exportImport.Get("/clusters/:id/realm/export", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), exportImportHandler.ExportRealm)
-	exportImport.Post("/clusters/:id/realm/import", middleware.PermissionMiddleware(appRoleService, "sync_items"), exportImportHandler.ImportRealm)
-	exportImport.Get("/clusters/:id/users/export", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), exportImportHandler.ExportUsers)
-	exportImport.Post("/clusters/:id/users/import", middleware.PermissionMiddleware(appRoleService, "sync_items"), exportImportHandler.ImportUsers)
-	exportImport.Get("/clusters/:id/clients/export", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), exportImportHandler.ExportClients)
-	exportImport.Post("/clusters/:id/clients/import", middleware.PermissionMiddleware(appRoleService, "sync_items"), exportImportHandler.ImportClients)
+	exportImport.Get("/clusters/:id/realm/export", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), exportImportHandler.ExportRealm)
+	exportImport.Post("/clusters/:id/realm/import", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), exportImportHandler.ImportRealm)
+	exportImport.Get("/clusters/:id/users/export", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), exportImportHandler.ExportUsers)
+	exportImport.Post("/clusters/:id/users/import", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), exportImportHandler.ImportUsers)
+	exportImport.Get("/clusters/:id/users/export/ndjson", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), exportImportHandler.ExportUsersNDJSON)
+	exportImport.Post("/clusters/:id/users/export/ndjson", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), exportImportHandler.StartUsersExport)
+	exportImport.Post("/clusters/:id/users/import/ndjson", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), exportImportHandler.ImportUsersNDJSON)
+	exportImport.Get("/clusters/:id/users/export/csv", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), exportImportHandler.ExportUsersCSV)
+	exportImport.Post("/clusters/:id/users/import/csv", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), exportImportHandler.ImportUsersCSV)
+	exportImport.Get("/clusters/:id/clients/export", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), exportImportHandler.ExportClients)
+	exportImport.Post("/clusters/:id/clients/import", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), exportImportHandler.ImportClients)
+	
+	// Background job routes
+	jobs := protected.Group("/jobs", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"))
+	jobs.Get("/", jobHandler.GetJobs)
+	jobs.Get("/:id", jobHandler.GetJob)
+	jobs.Get("/:id/logs", jobHandler.GetLogs)
+	jobs.Get("/:id/stream", jobHandler.Stream)
+	jobs.Get("/:id/output", jobHandler.GetOutput)
+	jobs.Post("/:id/cancel", middleware.PermissionMiddleware(appRoleService, "sync_items"), jobHandler.Cancel)
+	jobs.Post("/:id/retry", middleware.PermissionMiddleware(appRoleService, "sync_items"), jobHandler.Retry)
+	
+	// Audit log routes
+	audit := protected.Group("/audit-events", middleware.PermissionMiddleware(appRoleService, "view_audit_log"))
+	audit.Get("/", auditHandler.Search)
+	audit.Get("/export", auditHandler.Export)
+	
+	// Keycloak login and admin event routes
+	keycloakEvents := protected.Group("/keycloak-events")
+	keycloakEvents.Get("/", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), keycloakEventHandler.Search)
+	keycloakEvents.Get("/checkpoints", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), keycloakEventHandler.GetCheckpoints)
+	keycloakEvents.Post("/clusters/:id/collect", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), keycloakEventHandler.Collect)
+	keycloakEvents.Get("/clusters/:id/config", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), keycloakEventHandler.GetConfig)
+	keycloakEvents.Put("/clusters/:id/config", middleware.ClusterPermissionMiddleware(appRoleService, "update_cluster"), keycloakEventHandler.UpdateConfig)
+	
+	// Service account secret rotation routes
+	secretRotations := protected.Group("/secret-rotations")
+	secretRotations.Get("/", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), secretRotationHandler.GetRotations)
+	secretRotations.Post("/", middleware.PermissionMiddleware(appRoleService, "update_cluster"), secretRotationHandler.RotateClusters)
+	secretRotations.Post("/clients", middleware.PermissionMiddleware(appRoleService, "update_cluster"), secretRotationHandler.RotateClientSecrets)
 	
 	// User management routes (admin only)
 	adminUsers := protected.Group("/users", middleware.AdminMiddleware(appRoleService))
@@ -216,17 +441,26 @@ func main() {
 	envTags.Post("/remove", environmentTagHandler.RemoveTagsFromClusters)
 	envTags.Get("/clusters/:clusterId", environmentTagHandler.GetTagsByClusterID)
 	
+	// Rewrite rule routes (admin only)
+	rewriteRules := protected.Group("/rewrite-rules", middleware.AdminMiddleware(appRoleService))
+	rewriteRules.Get("/", rewriteRuleHandler.GetAll)
+	rewriteRules.Post("/preview", rewriteRuleHandler.Preview)
+	rewriteRules.Get("/:id", rewriteRuleHandler.GetByID)
+	rewriteRules.Post("/", rewriteRuleHandler.Create)
+	rewriteRules.Put("/:id", rewriteRuleHandler.Update)
+	rewriteRules.Delete("/:id", rewriteRuleHandler.Delete)
+	
 	// User Federation routes (for managing LDAP providers in Keycloak realms)
-	userFederation := protected.Group("/clusters/:id/user-federation", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"))
+	userFederation := protected.Group("/clusters/:id/user-federation", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"))
 	userFederation.Get("/", userFederationHandler.GetUserFederationProviders)
 	userFederation.Get("/:providerId", userFederationHandler.GetUserFederationProvider)
-	userFederation.Post("/", middleware.PermissionMiddleware(appRoleService, "sync_items"), userFederationHandler.CreateUserFederationProvider)
-	userFederation.Put("/:providerId", middleware.PermissionMiddleware(appRoleService, "sync_items"), userFederationHandler.UpdateUserFederationProvider)
-	userFederation.Delete("/:providerId", middleware.PermissionMiddleware(appRoleService, "sync_items"), userFederationHandler.DeleteUserFederationProvider)
-	userFederation.Post("/:providerId/test", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), userFederationHandler.TestUserFederationConnection)
-	userFederation.Post("/:providerId/sync", middleware.PermissionMiddleware(appRoleService, "sync_items"), userFederationHandler.SyncUserFederation)
-	userFederation.Post("/test-connection", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), userFederationHandler.TestLDAPConnection)
-	userFederation.Post("/test-authentication", middleware.PermissionMiddleware(appRoleService, "view_cluster_detail"), userFederationHandler.TestLDAPAuthentication)
+	userFederation.Post("/", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), userFederationHandler.CreateUserFederationProvider)
+	userFederation.Put("/:providerId", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), userFederationHandler.UpdateUserFederationProvider)
+	userFederation.Delete("/:providerId", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), userFederationHandler.DeleteUserFederationProvider)
+	userFederation.Post("/:providerId/test", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), userFederationHandler.TestUserFederationConnection)
+	userFederation.Post("/:providerId/sync", middleware.ClusterPermissionMiddleware(appRoleService, "sync_items"), userFederationHandler.SyncUserFederation)
+	userFederation.Post("/test-connection", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), userFederationHandler.TestLDAPConnection)
+	userFederation.Post("/test-authentication", middleware.ClusterPermissionMiddleware(appRoleService, "view_cluster_detail"), userFederationHandler.TestLDAPAuthentication)
 	
 	// Start server
 	port := os.Getenv("SERVER_PORT")
@@ -0,0 +1,214 @@
+package keycloak
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/pkg/secrets"
+)
+
+// secretResolverTimeout bounds a read from an external secret store
+const secretResolverTimeout = 10 * time.Second
+
+// secretResolver reads the secrets of clusters from their stores: the database, or an external store a
+// cluster references. Like the token cache it is shared by every Client; SetSecretResolver configures it
+// once at startup.
+var secretResolver = secrets.NewResolver()
+
+// SetSecretResolver sets the stores cluster secrets are resolved with
+func SetSecretResolver(resolver *secrets.Resolver) {
+	secretResolver = resolver
+}
+
+// ValidateSecretRef checks that a secret reference given by a user names a configured external store
+func (c *Client) ValidateSecretRef(ref string) error {
+	return secretResolver.ValidateExternal(ref)
+}
+
+// ResolveSecret reads the secret a reference given by a user, such as "vault:keycloak/prod#client_secret",
+// points to. Secrets kept in the database are not resolved for references.
+func (c *Client) ResolveSecret(ref string) (string, error) {
+	if err := secretResolver.ValidateExternal(ref); err != nil {
+		return "", err
+	}
+	return resolveSecret(ref)
+}
+
+func resolveSecret(ref string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretResolverTimeout)
+	defer cancel()
+	return secretResolver.Resolve(ctx, ref)
+}
+
+// GetClusterToken returns a cached token of a cluster's service account. The secret is read from its store,
+// the cluster's secret reference or else the database, only when a new token is fetched: it never stays in
+// memory with the cluster, and a secret rotated in its store is picked up with the next token.
+func (c *Client) GetClusterToken(cluster *domain.Cluster) (*TokenResponse, error) {
+	ref := cluster.SecretRef
+	if ref == "" {
+		ref = secrets.DatabaseRef("clusters", cluster.ID)
+	}
+
+	baseURL, realm, clientID := cluster.BaseURL, cluster.Realm, cluster.ClientID
+	key := strings.Join([]string{baseURL, realm, clientID, "ref:" + ref}, "|")
+	return tokens.get(key, func() (*TokenResponse, error) {
+		secret, err := resolveSecret(ref)
+		if err != nil {
+			return nil, err
+		}
+		return c.GetClientCredentialsToken(baseURL, realm, clientID, secret)
+	})
+}
+
+// ClusterTokenSource returns a TokenSource that gets every token through GetClusterToken, so callers
+// that run longer than a token's lifetime always send the cluster's current token
+func (c *Client) ClusterTokenSource(cluster *domain.Cluster) TokenSource {
+	return func() (string, error) {
+		tokenResp, err := c.GetClusterToken(cluster)
+		if err != nil {
+			return "", fmt.Errorf("failed to get access token: %w", err)
+		}
+		return tokenResp.AccessToken, nil
+	}
+}
+
+// SetClientSecret replaces the secret of a confidential client with the given value
+func (c *Client) SetClientSecret(baseURL, realm, accessToken, clientID, secret string) error {
+	clientUUID, err := c.findClientUUID(baseURL, realm, accessToken, clientID)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.getObject(fmt.Sprintf("%s/admin/realms/%s/clients/%s", baseURL, realm, clientUUID), accessToken, "client")
+	if err != nil {
+		return err
+	}
+	client["secret"] = secret
+
+	return c.putJSON(fmt.Sprintf("%s/admin/realms/%s/clients/%s", baseURL, realm, clientUUID), accessToken, client, "set client secret")
+}
+
+// RegenerateClientSecret makes Keycloak generate a new secret for a confidential client and returns it.
+// Unless a secret-rotation client policy applies, the previous secret stops working right away.
+func (c *Client) RegenerateClientSecret(baseURL, realm, accessToken, clientID string) (string, error) {
+	clientUUID, err := c.findClientUUID(baseURL, realm, accessToken, clientID)
+	if err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("%s/admin/realms/%s/clients/%s/client-secret", baseURL, realm, clientUUID)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to regenerate client secret: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var credential struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&credential); err != nil {
+		return "", err
+	}
+	if credential.Value == "" {
+		return "", fmt.Errorf("keycloak returned an empty client secret")
+	}
+	return credential.Value, nil
+}
+
+// GetCurrentClientSecret returns the current secret of a confidential client by its clientId
+func (c *Client) GetCurrentClientSecret(baseURL, realm, accessToken, clientID string) (string, error) {
+	clientUUID, err := c.findClientUUID(baseURL, realm, accessToken, clientID)
+	if err != nil {
+		return "", err
+	}
+	return c.GetClientSecret(baseURL, realm, accessToken, clientUUID)
+}
+
+// GetRotatedClientSecretExpiry tells until when Keycloak still accepts the previous secret of a client
+// after a regeneration. That is the case when a client policy with the secret-rotation executor applies
+// to the client (Keycloak 22+); otherwise nil is returned.
+func (c *Client) GetRotatedClientSecretExpiry(baseURL, realm, accessToken, clientID string) (*time.Time, error) {
+	clientUUID, err := c.findClientUUID(baseURL, realm, accessToken, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/admin/realms/%s/clients/%s/client-secret/rotated", baseURL, realm, clientUUID)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// No rotated secret, or a Keycloak version without dual secrets
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get rotated client secret: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	client, err := c.getObject(fmt.Sprintf("%s/admin/realms/%s/clients/%s", baseURL, realm, clientUUID), accessToken, "client")
+	if err != nil {
+		return nil, err
+	}
+	attributes, _ := client["attributes"].(map[string]interface{})
+	seconds, err := strconv.ParseInt(getString(attributes, "client.secret.rotated.expiration.time"), 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	expiresAt := time.Unix(seconds, 0)
+	return &expiresAt, nil
+}
+
+// InvalidateRotatedClientSecret makes Keycloak stop accepting the previous secret of a client before its grace period ends
+func (c *Client) InvalidateRotatedClientSecret(baseURL, realm, accessToken, clientID string) error {
+	clientUUID, err := c.findClientUUID(baseURL, realm, accessToken, clientID)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/admin/realms/%s/clients/%s/client-secret/rotated", baseURL, realm, clientUUID)
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to invalidate rotated client secret: status %d, body: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package keycloak
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/pkg/secrets"
+)
+
+// mapStore is a secrets.Store over a map that counts its reads
+type mapStore struct {
+	mu      sync.Mutex
+	secrets map[string]string
+	reads   int
+}
+
+func (s *mapStore) Get(ctx context.Context, path string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads++
+	secret, ok := s.secrets[path]
+	if !ok {
+		return "", secrets.ErrNotFound
+	}
+	return secret, nil
+}
+
+func (s *mapStore) set(path, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[path] = secret
+}
+
+// useSecretStores resolves cluster secrets with the given stores and a fresh token cache for the test
+func useSecretStores(t *testing.T, stores map[string]secrets.Store) {
+	t.Helper()
+	resolver := secrets.NewResolver()
+	for scheme, store := range stores {
+		resolver.Register(scheme, store)
+	}
+	oldResolver, oldTokens := secretResolver, tokens
+	secretResolver, tokens = resolver, newTokenCache()
+	t.Cleanup(func() { secretResolver, tokens = oldResolver, oldTokens })
+}
+
+// tokenServer issues "token-for-<secret>" for any client credentials grant
+func tokenServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token-for-" + r.FormValue("client_secret"),
+			"expires_in":   300,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetClusterTokenReadsSecretOnFetch(t *testing.T) {
+	db := &mapStore{secrets: map[string]string{"clusters/7": "db-1"}}
+	vault := &mapStore{secrets: map[string]string{"keycloak/prod#client_secret": "vault-1"}}
+	useSecretStores(t, map[string]secrets.Store{secrets.DatabaseScheme: db, "vault": vault})
+	srv := tokenServer(t)
+	c := NewClient()
+
+	stored := &domain.Cluster{ID: 7, BaseURL: srv.URL, Realm: "master", ClientID: "multi-manage"}
+	if resp, err := c.GetClusterToken(stored); err != nil || resp.AccessToken != "token-for-db-1" {
+		t.Fatalf("GetClusterToken = %+v, %v, want a token for the secret in the database", resp, err)
+	}
+
+	// A cached token is reused without reading the secret again
+	db.set("clusters/7", "db-2")
+	if resp, _ := c.GetClusterToken(stored); resp.AccessToken != "token-for-db-1" || db.reads != 1 {
+		t.Fatalf("got %q after %d reads, want the cached token", resp.AccessToken, db.reads)
+	}
+
+	// The next token is fetched with the rotated secret, without the cluster being loaded again
+	tokens.invalidate("token-for-db-1")
+	if resp, err := c.GetClusterToken(stored); err != nil || resp.AccessToken != "token-for-db-2" {
+		t.Fatalf("GetClusterToken after rotation = %+v, %v, want a token for the rotated secret", resp, err)
+	}
+
+	referenced := &domain.Cluster{ID: 8, BaseURL: srv.URL, Realm: "master", ClientID: "multi-manage", SecretRef: "vault:keycloak/prod#client_secret"}
+	if resp, err := c.GetClusterToken(referenced); err != nil || resp.AccessToken != "token-for-vault-1" {
+		t.Fatalf("GetClusterToken with a secret reference = %+v, %v", resp, err)
+	}
+	vault.set("keycloak/prod#client_secret", "vault-2")
+	tokens.invalidate("token-for-vault-1")
+	if resp, _ := c.GetClusterToken(referenced); resp.AccessToken != "token-for-vault-2" {
+		t.Fatalf("got %q, want a token for the secret changed in Vault", resp.AccessToken)
+	}
+
+	if _, err := c.GetClusterToken(&domain.Cluster{ID: 9, BaseURL: srv.URL, Realm: "master", ClientID: "multi-manage"}); err == nil {
+		t.Error("GetClusterToken succeeded for a cluster without a stored secret")
+	}
+}
+
+func TestSecretRefsCannotNameTheDatabase(t *testing.T) {
+	db := &mapStore{secrets: map[string]string{"clusters/7": "db-1"}}
+	useSecretStores(t, map[string]secrets.Store{secrets.DatabaseScheme: db, "file": &mapStore{secrets: map[string]string{"prod": "s3cret"}}})
+	c := NewClient()
+
+	if err := c.ValidateSecretRef("file:prod"); err != nil {
+		t.Errorf("ValidateSecretRef(file:prod) = %v", err)
+	}
+	if secret, err := c.ResolveSecret("file:prod"); err != nil || secret != "s3cret" {
+		t.Errorf("ResolveSecret(file:prod) = %q, %v", secret, err)
+	}
+
+	ref := secrets.DatabaseRef("clusters", 7)
+	if err := c.ValidateSecretRef(ref); err == nil {
+		t.Errorf("ValidateSecretRef(%s) accepted a database secret", ref)
+	}
+	if secret, err := c.ResolveSecret(ref); err == nil || db.reads != 0 {
+		t.Errorf("ResolveSecret(%s) = %q, %v, want the database secret not read", ref, secret, err)
+	}
+}
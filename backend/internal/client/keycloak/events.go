@@ -0,0 +1,60 @@
+package keycloak
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"keycloak-multi-manage/internal/domain"
+)
+
+// ForEachEvent iterates over the login events of a realm from the day of since on, newest first, one page at a time.
+// Keycloak filters events by day only, so older events of that day are returned as well.
+func (c *Client) ForEachEvent(baseURL, realm, accessToken string, since time.Time, fn func(events []map[string]interface{}) error) error {
+	url := fmt.Sprintf("%s/admin/realms/%s/events?dateFrom=%s", baseURL, realm, since.UTC().Format("2006-01-02"))
+	return c.forEachPage(url, accessToken, "events", fn)
+}
+
+// ForEachAdminEvent iterates over the admin events of a realm from the day of since on, newest first, one page at a time
+func (c *Client) ForEachAdminEvent(baseURL, realm, accessToken string, since time.Time, fn func(events []map[string]interface{}) error) error {
+	url := fmt.Sprintf("%s/admin/realms/%s/admin-events?dateFrom=%s", baseURL, realm, since.UTC().Format("2006-01-02"))
+	return c.forEachPage(url, accessToken, "admin events", fn)
+}
+
+// GetEventsConfig returns the event settings of a realm
+func (c *Client) GetEventsConfig(baseURL, realm, accessToken string) (*domain.RealmEventsConfig, error) {
+	url := fmt.Sprintf("%s/admin/realms/%s/events/config", baseURL, realm)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get events config: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var config domain.RealmEventsConfig
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// UpdateEventsConfig replaces the event settings of a realm
+func (c *Client) UpdateEventsConfig(baseURL, realm, accessToken string, config *domain.RealmEventsConfig) error {
+	url := fmt.Sprintf("%s/admin/realms/%s/events/config", baseURL, realm)
+	return c.putJSON(url, accessToken, config, "update events config")
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -16,12 +17,20 @@ import (
 
 type Client struct {
 	httpClient *http.Client
+	// longClient is used for calls that can legitimately take minutes, such as realm imports and federation syncs
+	longClient *http.Client
 }
 
 func NewClient() *Client {
+	transport := &tokenTransport{base: http.DefaultTransport}
 	return &Client{
 		httpClient: &http.Client{
-			Timeout: 15 * time.Second,
+			Timeout:   15 * time.Second,
+			Transport: transport,
+		},
+		longClient: &http.Client{
+			Timeout:   30 * time.Minute,
+			Transport: transport,
 		},
 	}
 }
@@ -65,33 +74,152 @@ func (c *Client) GetAccessToken(baseURL, realm, username, password string) (stri
 }
 
 func (c *Client) GetRoles(baseURL, realm, accessToken string) ([]domain.Role, error) {
-	url := fmt.Sprintf("%s/admin/realms/%s/roles", baseURL, realm)
+	var roles []domain.Role
+	err := c.ForEachRealmRole(baseURL, realm, accessToken, true, func(page []domain.Role) error {
+		roles = append(roles, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	
-	req, err := http.NewRequest("GET", url, nil)
+	return roles, nil
+}
+
+// GetRoleDetails gets all realm roles with their attributes and direct composite children
+func (c *Client) GetRoleDetails(baseURL, realm, accessToken string) ([]domain.Role, error) {
+	var roles []domain.Role
+	err := c.ForEachRealmRole(baseURL, realm, accessToken, false, func(page []domain.Role) error {
+		roles = append(roles, page...)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 	
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
+	// Client composites reference the client by UUID, map them back to clientId
+	var clientIDs map[string]string
+	for i := range roles {
+		if !roles[i].Composite {
+			continue
+		}
+		
+		composites, err := c.getRoleComposites(baseURL, realm, accessToken, roles[i].Name, &clientIDs)
+		if err != nil {
+			return nil, err
+		}
+		roles[i].Composites = composites
+	}
 	
-	resp, err := c.httpClient.Do(req)
+	return roles, nil
+}
+
+// GetRoleDetail gets a single realm role with its attributes and direct composite children
+func (c *Client) GetRoleDetail(baseURL, realm, accessToken, roleName string) (*domain.Role, error) {
+	url := fmt.Sprintf("%s/admin/realms/%s/roles/%s", baseURL, realm, neturl.PathEscape(roleName))
+	
+	roleData, err := c.getObject(url, accessToken, "role")
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 	
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get roles: status %d, body: %s", resp.StatusCode, string(body))
+	jsonData, err := json.Marshal(roleData)
+	if err != nil {
+		return nil, err
 	}
 	
-	var roles []domain.Role
-	if err := json.NewDecoder(resp.Body).Decode(&roles); err != nil {
+	var role domain.Role
+	if err := json.Unmarshal(jsonData, &role); err != nil {
 		return nil, err
 	}
 	
-	return roles, nil
+	if role.Composite {
+		var clientIDs map[string]string
+		composites, err := c.getRoleComposites(baseURL, realm, accessToken, role.Name, &clientIDs)
+		if err != nil {
+			return nil, err
+		}
+		role.Composites = composites
+	}
+	
+	return &role, nil
+}
+
+// GetClientRoleDetail gets a single client role with its attributes and direct composite children
+func (c *Client) GetClientRoleDetail(baseURL, realm, accessToken, clientUUID, roleName string) (*domain.Role, error) {
+	url := fmt.Sprintf("%s/admin/realms/%s/clients/%s/roles/%s", baseURL, realm, clientUUID, neturl.PathEscape(roleName))
+	
+	roleData, err := c.getObject(url, accessToken, "client role")
+	if err != nil {
+		return nil, err
+	}
+	
+	jsonData, err := json.Marshal(roleData)
+	if err != nil {
+		return nil, err
+	}
+	
+	var role domain.Role
+	if err := json.Unmarshal(jsonData, &role); err != nil {
+		return nil, err
+	}
+	
+	if role.Composite {
+		children, err := c.getList(url+"/composites", accessToken, "client role composites")
+		if err != nil {
+			return nil, err
+		}
+		var clientIDs map[string]string
+		composites, err := c.splitRoleComposites(baseURL, realm, accessToken, children, &clientIDs)
+		if err != nil {
+			return nil, err
+		}
+		role.Composites = composites
+	}
+	
+	return &role, nil
+}
+
+// getRoleComposites splits the composite children of a realm role into realm and client roles.
+// clientIDs caches the client UUID -> clientId mapping across calls and is loaded on first use.
+func (c *Client) getRoleComposites(baseURL, realm, accessToken, roleName string, clientIDs *map[string]string) (*domain.RoleComposites, error) {
+	composites, err := c.getCompositeRoles(baseURL, realm, accessToken, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get composites of role %s: %w", roleName, err)
+	}
+	
+	return c.splitRoleComposites(baseURL, realm, accessToken, composites, clientIDs)
+}
+
+// splitRoleComposites sorts composite children into realm roles and client roles by clientId
+func (c *Client) splitRoleComposites(baseURL, realm, accessToken string, composites []map[string]interface{}, clientIDs *map[string]string) (*domain.RoleComposites, error) {
+	roleComposites := &domain.RoleComposites{}
+	for _, composite := range composites {
+		name := getString(composite, "name")
+		if !getBool(composite, "clientRole") {
+			roleComposites.Realm = append(roleComposites.Realm, name)
+			continue
+		}
+		
+		if *clientIDs == nil {
+			clients, err := c.getClients(baseURL, realm, accessToken)
+			if err != nil {
+				return nil, err
+			}
+			*clientIDs = make(map[string]string)
+			for _, client := range clients {
+				(*clientIDs)[getString(client, "id")] = getString(client, "clientId")
+			}
+		}
+		
+		clientID := (*clientIDs)[getString(composite, "containerId")]
+		if roleComposites.Client == nil {
+			roleComposites.Client = make(map[string][]string)
+		}
+		roleComposites.Client[clientID] = append(roleComposites.Client[clientID], name)
+	}
+	
+	return roleComposites, nil
 }
 
 func (c *Client) HealthCheck(baseURL, realm string) (bool, error) {
@@ -129,7 +257,7 @@ func (c *Client) GetMetrics(baseURL, realm, accessToken string) (*domain.Cluster
 	metrics.Roles = len(roles)
 	
 	// Get Users count
-	usersCount, err := c.getUsersCount(baseURL, realm, accessToken)
+	usersCount, err := c.GetUsersCount(baseURL, realm, accessToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get users count: %w", err)
 	}
@@ -150,84 +278,27 @@ func (c *Client) GetClients(baseURL, realm, accessToken string) ([]map[string]in
 }
 
 func (c *Client) getClients(baseURL, realm, accessToken string) ([]map[string]interface{}, error) {
-	url := fmt.Sprintf("%s/admin/realms/%s/clients", baseURL, realm)
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
-	
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get clients: status %d, body: %s", resp.StatusCode, string(body))
-	}
-	
-	var clients []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&clients); err != nil {
-		return nil, err
-	}
-	
-	return clients, nil
+	return c.collectPages(fmt.Sprintf("%s/admin/realms/%s/clients", baseURL, realm), accessToken, "clients")
 }
 
-func (c *Client) getUsersCount(baseURL, realm, accessToken string) (int, error) {
-	// Get all users and count them (more reliable than header)
-	users, err := c.GetUsers(baseURL, realm, accessToken, 0) // 0 means no limit
-	if err != nil {
-		return 0, err
-	}
-	return len(users), nil
+// GetUsersCount returns the number of users in the realm
+func (c *Client) GetUsersCount(baseURL, realm, accessToken string) (int, error) {
+	return c.getCount(fmt.Sprintf("%s/admin/realms/%s/users/count", baseURL, realm), accessToken, "users")
 }
 
+// getGroupsCount counts the top level groups of the realm, like the group lists do
 func (c *Client) getGroupsCount(baseURL, realm, accessToken string) (int, error) {
-	// Get all groups and count them (more reliable than header)
-	groups, err := c.GetGroups(baseURL, realm, accessToken, 0) // 0 means no limit
-	if err != nil {
-		return 0, err
-	}
-	return len(groups), nil
+	return c.getCount(fmt.Sprintf("%s/admin/realms/%s/groups/count?top=true", baseURL, realm), accessToken, "groups")
 }
 
+// GetUsers returns the first max users, or all users page by page when max is 0
 func (c *Client) GetUsers(baseURL, realm, accessToken string, max int) ([]map[string]interface{}, error) {
 	url := fmt.Sprintf("%s/admin/realms/%s/users", baseURL, realm)
 	if max > 0 {
-		url = fmt.Sprintf("%s?max=%d", url, max)
-	}
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+		return c.getList(fmt.Sprintf("%s?first=0&max=%d", url, max), accessToken, "users")
 	}
 	
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
-	
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get users: status %d, body: %s", resp.StatusCode, string(body))
-	}
-	
-	var users []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
-		return nil, err
-	}
-	
-	return users, nil
+	return c.collectPages(url, accessToken, "users")
 }
 
 // SearchUsers searches for users matching the query
@@ -304,33 +375,28 @@ func (c *Client) SearchRoles(baseURL, realm, accessToken, query string) ([]domai
 	return results, nil
 }
 
+// GetGroups returns the first max top level groups, or all of them page by page when max is 0.
+// Every group has its complete subgroup tree in subGroups.
 func (c *Client) GetGroups(baseURL, realm, accessToken string, max int) ([]map[string]interface{}, error) {
-	url := fmt.Sprintf("%s/admin/realms/%s/groups", baseURL, realm)
 	if max > 0 {
-		url = fmt.Sprintf("%s?max=%d", url, max)
-	}
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
-	
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get groups: status %d, body: %s", resp.StatusCode, string(body))
+		groups, err := c.getList(fmt.Sprintf("%s/admin/realms/%s/groups?first=0&max=%d", baseURL, realm, max), accessToken, "groups")
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			if err := c.fillSubGroups(baseURL, realm, accessToken, group); err != nil {
+				return nil, err
+			}
+		}
+		return groups, nil
 	}
 	
 	var groups []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+	err := c.ForEachGroup(baseURL, realm, accessToken, func(page []map[string]interface{}) error {
+		groups = append(groups, page...)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	
@@ -1030,121 +1096,145 @@ func (c *Client) getGroupRealmRoles(baseURL, realm, accessToken, groupID string)
 }
 
 func (c *Client) getGroupClientRoles(baseURL, realm, accessToken, groupID string) (map[string][]string, error) {
-	url := fmt.Sprintf("%s/admin/realms/%s/groups/%s/role-mappings/clients", baseURL, realm, groupID)
-	
-	req, err := http.NewRequest("GET", url, nil)
+	url := fmt.Sprintf("%s/admin/realms/%s/groups/%s/role-mappings", baseURL, realm, groupID)
+	return c.getClientRoleMappings(url, accessToken)
+}
+
+// GetUserDetails gets detailed user information including roles, groups, and attributes
+func (c *Client) GetUserDetails(baseURL, realm, accessToken string) ([]domain.UserDetail, error) {
+	users, err := c.GetUsers(baseURL, realm, accessToken, 0)
 	if err != nil {
 		return nil, err
 	}
 	
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
+	var userDetails []domain.UserDetail
+	for _, user := range users {
+		userDetails = append(userDetails, c.convertToUserDetail(user, baseURL, realm, accessToken))
+	}
 	
-	resp, err := c.httpClient.Do(req)
+	return userDetails, nil
+}
+
+// GetUserDetail gets detailed information for a single user by ID
+func (c *Client) GetUserDetail(baseURL, realm, accessToken, userID string) (*domain.UserDetail, error) {
+	url := fmt.Sprintf("%s/admin/realms/%s/users/%s", baseURL, realm, userID)
+	
+	user, err := c.getObject(url, accessToken, "user")
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 	
-	if resp.StatusCode != http.StatusOK {
-		return make(map[string][]string), nil
+	detail := c.convertToUserDetail(user, baseURL, realm, accessToken)
+	return &detail, nil
+}
+
+func (c *Client) convertToUserDetail(user map[string]interface{}, baseURL, realm, accessToken string) domain.UserDetail {
+	userID := getString(user, "id")
+	
+	// Determine user origin: federation or local
+	federationLink := getString(user, "federationLink")
+	origin := "local"
+	if federationLink != "" {
+		origin = "federation"
 	}
 	
-	var clientRolesMap map[string][]map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&clientRolesMap); err != nil {
-		return make(map[string][]string), nil
+	detail := domain.UserDetail{
+		ID:              userID,
+		Username:        getString(user, "username"),
+		Email:           getString(user, "email"),
+		FirstName:       getString(user, "firstName"),
+		LastName:        getString(user, "lastName"),
+		Enabled:         getBool(user, "enabled"),
+		Attributes:      make(map[string][]string),
+		ClientRoles:     make(map[string][]string),
+		Origin:          origin,
 	}
 	
-	result := make(map[string][]string)
-	for clientID, roles := range clientRolesMap {
-		var roleNames []string
-		for _, role := range roles {
-			if name, ok := role["name"].(string); ok {
-				roleNames = append(roleNames, name)
+	// Get attributes
+	if attrs, ok := user["attributes"].(map[string]interface{}); ok {
+		for k, v := range attrs {
+			if arr, ok := v.([]interface{}); ok {
+				var strArr []string
+				for _, item := range arr {
+					if str, ok := item.(string); ok {
+						strArr = append(strArr, str)
+					}
+				}
+				detail.Attributes[k] = strArr
 			}
 		}
-		result[clientID] = roleNames
 	}
 	
-	return result, nil
+	// Get required actions
+	if actions, ok := user["requiredActions"].([]interface{}); ok {
+		for _, action := range actions {
+			if str, ok := action.(string); ok {
+				detail.RequiredActions = append(detail.RequiredActions, str)
+			}
+		}
+	}
+	
+	// Get realm roles
+	realmRoles, err := c.getUserRealmRoles(baseURL, realm, accessToken, userID)
+	if err == nil {
+		detail.RealmRoles = realmRoles
+	}
+	
+	// Get client roles
+	clientRoles, err := c.getUserClientRoles(baseURL, realm, accessToken, userID)
+	if err == nil {
+		detail.ClientRoles = clientRoles
+	}
+	
+	// Get groups
+	groups, err := c.getUserGroups(baseURL, realm, accessToken, userID)
+	if err == nil {
+		detail.Groups = groups
+	}
+	
+	return detail
 }
 
-// GetUserDetails gets detailed user information including roles, groups, and attributes
-func (c *Client) GetUserDetails(baseURL, realm, accessToken string) ([]domain.UserDetail, error) {
-	users, err := c.GetUsers(baseURL, realm, accessToken, 0)
+// GetGroupDetail gets detailed information for a single group by ID
+func (c *Client) GetGroupDetail(baseURL, realm, accessToken, groupID string) (*domain.GroupDetail, error) {
+	url := fmt.Sprintf("%s/admin/realms/%s/groups/%s", baseURL, realm, groupID)
+	
+	group, err := c.getObject(url, accessToken, "group")
 	if err != nil {
 		return nil, err
 	}
 	
-	var userDetails []domain.UserDetail
-	for _, user := range users {
-		userID := getString(user, "id")
-		
-		// Determine user origin: federation or local
-		federationLink := getString(user, "federationLink")
-		origin := "local"
-		if federationLink != "" {
-			origin = "federation"
-		}
-		
-		detail := domain.UserDetail{
-			ID:              userID,
-			Username:        getString(user, "username"),
-			Email:           getString(user, "email"),
-			FirstName:       getString(user, "firstName"),
-			LastName:        getString(user, "lastName"),
-			Enabled:         getBool(user, "enabled"),
-			Attributes:      make(map[string][]string),
-			ClientRoles:     make(map[string][]string),
-			Origin:          origin,
-		}
-		
-		// Get attributes
-		if attrs, ok := user["attributes"].(map[string]interface{}); ok {
-			for k, v := range attrs {
-				if arr, ok := v.([]interface{}); ok {
-					var strArr []string
-					for _, item := range arr {
-						if str, ok := item.(string); ok {
-							strArr = append(strArr, str)
-						}
-					}
-					detail.Attributes[k] = strArr
-				}
-			}
-		}
-		
-		// Get required actions
-		if actions, ok := user["requiredActions"].([]interface{}); ok {
-			for _, action := range actions {
-				if str, ok := action.(string); ok {
-					detail.RequiredActions = append(detail.RequiredActions, str)
-				}
-			}
-		}
-		
-		// Get realm roles
-		realmRoles, err := c.getUserRealmRoles(baseURL, realm, accessToken, userID)
-		if err == nil {
-			detail.RealmRoles = realmRoles
-		}
-		
-		// Get client roles
-		clientRoles, err := c.getUserClientRoles(baseURL, realm, accessToken, userID)
-		if err == nil {
-			detail.ClientRoles = clientRoles
-		}
-		
-		// Get groups
-		groups, err := c.getUserGroups(baseURL, realm, accessToken, userID)
-		if err == nil {
-			detail.Groups = groups
-		}
-		
-		userDetails = append(userDetails, detail)
+	detail := c.convertToGroupDetail(group, baseURL, realm, accessToken)
+	return &detail, nil
+}
+
+// getObject fetches a single JSON object from the Admin REST API
+func (c *Client) getObject(url, accessToken, what string) (map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
 	}
 	
-	return userDetails, nil
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("Content-Type", "application/json")
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get %s: status %d, body: %s", what, resp.StatusCode, string(body))
+	}
+	
+	var obj map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return nil, err
+	}
+	
+	return obj, nil
 }
 
 func (c *Client) getUserRealmRoles(baseURL, realm, accessToken, userID string) ([]string, error) {
@@ -1184,8 +1274,13 @@ func (c *Client) getUserRealmRoles(baseURL, realm, accessToken, userID string) (
 }
 
 func (c *Client) getUserClientRoles(baseURL, realm, accessToken, userID string) (map[string][]string, error) {
-	url := fmt.Sprintf("%s/admin/realms/%s/users/%s/role-mappings/clients", baseURL, realm, userID)
-	
+	url := fmt.Sprintf("%s/admin/realms/%s/users/%s/role-mappings", baseURL, realm, userID)
+	return c.getClientRoleMappings(url, accessToken)
+}
+
+// getClientRoleMappings reads the clientMappings section of a role-mappings endpoint.
+// The result is keyed by clientId, matching what the role assignment helpers expect.
+func (c *Client) getClientRoleMappings(url, accessToken string) (map[string][]string, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -1204,15 +1299,23 @@ func (c *Client) getUserClientRoles(baseURL, realm, accessToken, userID string)
 		return make(map[string][]string), nil
 	}
 	
-	var clientRolesMap map[string][]map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&clientRolesMap); err != nil {
+	var mappings struct {
+		ClientMappings map[string]struct {
+			Client   string                   `json:"client"`
+			Mappings []map[string]interface{} `json:"mappings"`
+		} `json:"clientMappings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&mappings); err != nil {
 		return make(map[string][]string), nil
 	}
 	
 	result := make(map[string][]string)
-	for clientID, roles := range clientRolesMap {
+	for clientID, clientMapping := range mappings.ClientMappings {
+		if clientMapping.Client != "" {
+			clientID = clientMapping.Client
+		}
 		var roleNames []string
-		for _, role := range roles {
+		for _, role := range clientMapping.Mappings {
 			if name, ok := role["name"].(string); ok {
 				roleNames = append(roleNames, name)
 			}
@@ -1341,7 +1444,7 @@ func (c *Client) ImportRealm(baseURL, accessToken string, realmConfig map[string
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 	req.Header.Set("Content-Type", "application/json")
 	
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.longClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -1363,81 +1466,83 @@ func (c *Client) ExportUsers(baseURL, realm, accessToken string) ([]map[string]i
 // ImportUsers imports users from JSON array
 func (c *Client) ImportUsers(baseURL, realm, accessToken string, users []map[string]interface{}) error {
 	for _, user := range users {
-		username := getString(user, "username")
-		
-		// Remove ID to allow Keycloak to generate new one or use existing
-		delete(user, "id")
-		delete(user, "createdTimestamp")
-		
-		jsonData, err := json.Marshal(user)
-		if err != nil {
-			return fmt.Errorf("failed to marshal user: %w", err)
-		}
-		
-		url := fmt.Sprintf("%s/admin/realms/%s/users", baseURL, realm)
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-		if err != nil {
-			return err
-		}
-		
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-		req.Header.Set("Content-Type", "application/json")
-		
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
+		if _, err := c.ImportUser(baseURL, realm, accessToken, user); err != nil {
 			return err
 		}
-		defer resp.Body.Close()
-		
-		// If user already exists (409), try to update it
-		if resp.StatusCode == http.StatusConflict {
-			// Find existing user by username
-			existingUsers, err := c.GetUsers(baseURL, realm, accessToken, 0)
-			if err != nil {
-				return fmt.Errorf("failed to get existing users: %w", err)
-			}
-			
-			var existingUserID string
-			for _, eu := range existingUsers {
-				if getString(eu, "username") == username {
-					existingUserID = getString(eu, "id")
-					break
-				}
-			}
-			
-			if existingUserID == "" {
-				return fmt.Errorf("user %v already exists but could not find its ID", username)
-			}
-			
-			// Update existing user
-			updateURL := fmt.Sprintf("%s/admin/realms/%s/users/%s", baseURL, realm, existingUserID)
-			updateReq, err := http.NewRequest("PUT", updateURL, bytes.NewBuffer(jsonData))
-			if err != nil {
-				return err
-			}
-			
-			updateReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-			updateReq.Header.Set("Content-Type", "application/json")
-			
-			updateResp, err := c.httpClient.Do(updateReq)
-			if err != nil {
-				return err
-			}
-			defer updateResp.Body.Close()
-			
-			if updateResp.StatusCode != http.StatusNoContent {
-				body, _ := io.ReadAll(updateResp.Body)
-				return fmt.Errorf("failed to update existing user %v: status %d, body: %s", username, updateResp.StatusCode, string(body))
-			}
-		} else if resp.StatusCode != http.StatusCreated {
-			body, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("failed to import user %v: status %d, body: %s", username, resp.StatusCode, string(body))
-		}
 	}
 	
 	return nil
 }
 
+// ImportUser creates a user, or updates the user with the same username when it already exists.
+// It reports whether the user was created.
+func (c *Client) ImportUser(baseURL, realm, accessToken string, user map[string]interface{}) (bool, error) {
+	username := getString(user, "username")
+	
+	// Remove ID to allow Keycloak to generate new one or use existing
+	delete(user, "id")
+	delete(user, "createdTimestamp")
+	
+	jsonData, err := json.Marshal(user)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal user: %w", err)
+	}
+	
+	url := fmt.Sprintf("%s/admin/realms/%s/users", baseURL, realm)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return false, err
+	}
+	
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("Content-Type", "application/json")
+	
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	
+	if resp.StatusCode == http.StatusCreated {
+		return true, nil
+	}
+	if resp.StatusCode != http.StatusConflict {
+		body, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("failed to import user %v: status %d, body: %s", username, resp.StatusCode, string(body))
+	}
+	
+	// The user already exists, update it
+	existingUserID, err := c.FindUserIDByUsername(baseURL, realm, accessToken, username)
+	if err != nil {
+		return false, fmt.Errorf("failed to find existing user %v: %w", username, err)
+	}
+	if existingUserID == "" {
+		return false, fmt.Errorf("user %v already exists but could not find its ID", username)
+	}
+	
+	updateURL := fmt.Sprintf("%s/admin/realms/%s/users/%s", baseURL, realm, existingUserID)
+	updateReq, err := http.NewRequest("PUT", updateURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return false, err
+	}
+	
+	updateReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	updateReq.Header.Set("Content-Type", "application/json")
+	
+	updateResp, err := c.httpClient.Do(updateReq)
+	if err != nil {
+		return false, err
+	}
+	defer updateResp.Body.Close()
+	
+	if updateResp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(updateResp.Body)
+		return false, fmt.Errorf("failed to update existing user %v: status %d, body: %s", username, updateResp.StatusCode, string(body))
+	}
+	
+	return false, nil
+}
+
 // GetServerInfo gets Keycloak server information including version
 func (c *Client) GetServerInfo(baseURL, accessToken string) (map[string]interface{}, error) {
 	url := fmt.Sprintf("%s/admin/serverinfo", baseURL)
@@ -2253,28 +2358,10 @@ func (c *Client) getClientRolesForRole(baseURL, realm, accessToken, roleName str
 }
 
 func (c *Client) getClientRoles(baseURL, realm, accessToken, clientID string) ([]map[string]interface{}, error) {
-	url := fmt.Sprintf("%s/admin/realms/%s/clients/%s/roles", baseURL, realm, clientID)
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
-	
-	resp, err := c.httpClient.Do(req)
+	roles, err := c.GetClientRoles(baseURL, realm, accessToken, clientID)
 	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
 		return []map[string]interface{}{}, nil
 	}
-	
-	var roles []map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&roles)
 	return roles, nil
 }
 
@@ -3062,29 +3149,10 @@ func (c *Client) AssignClientRolesToUser(baseURL, realm, accessToken, userID str
 		}
 		
 		// Get client roles
-		url := fmt.Sprintf("%s/admin/realms/%s/clients/%s/roles", baseURL, realm, clientUUID)
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			continue
-		}
-		
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-		req.Header.Set("Content-Type", "application/json")
-		
-		resp, err := c.httpClient.Do(req)
+		clientRolesList, err := c.GetClientRoles(baseURL, realm, accessToken, clientUUID)
 		if err != nil {
 			continue
 		}
-		defer resp.Body.Close()
-		
-		if resp.StatusCode != http.StatusOK {
-			continue
-		}
-		
-		var clientRolesList []map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&clientRolesList); err != nil {
-			continue
-		}
 		
 		// Find role IDs
 		var roleIDs []map[string]interface{}
@@ -3237,29 +3305,10 @@ func (c *Client) AssignClientRolesToGroup(baseURL, realm, accessToken, groupID s
 		}
 		
 		// Get client roles
-		url := fmt.Sprintf("%s/admin/realms/%s/clients/%s/roles", baseURL, realm, clientUUID)
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			continue
-		}
-		
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-		req.Header.Set("Content-Type", "application/json")
-		
-		resp, err := c.httpClient.Do(req)
+		clientRolesList, err := c.GetClientRoles(baseURL, realm, accessToken, clientUUID)
 		if err != nil {
 			continue
 		}
-		defer resp.Body.Close()
-		
-		if resp.StatusCode != http.StatusOK {
-			continue
-		}
-		
-		var clientRolesList []map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&clientRolesList); err != nil {
-			continue
-		}
 		
 		// Find role IDs
 		var roleIDs []map[string]interface{}
@@ -3306,35 +3355,9 @@ func (c *Client) AssignClientRolesToGroup(baseURL, realm, accessToken, groupID s
 	return nil
 }
 
-// GetClientRoles gets all roles for a specific client (public method)
+// GetClientRoles gets all roles for a specific client (public method), page by page
 func (c *Client) GetClientRoles(baseURL, realm, accessToken, clientUUID string) ([]map[string]interface{}, error) {
-	url := fmt.Sprintf("%s/admin/realms/%s/clients/%s/roles", baseURL, realm, clientUUID)
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
-	
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get client roles: status %d, body: %s", resp.StatusCode, string(body))
-	}
-	
-	var roles []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&roles); err != nil {
-		return nil, err
-	}
-	
-	return roles, nil
+	return c.collectPages(fmt.Sprintf("%s/admin/realms/%s/clients/%s/roles", baseURL, realm, clientUUID), accessToken, "client roles")
 }
 
 // getClientRoleDetails gets details of a client role
@@ -3618,30 +3641,38 @@ func (c *Client) TestUserFederationConnection(baseURL, realm, accessToken, provi
 	return result, nil
 }
 
-// SyncUserFederation syncs users from a user federation provider
-func (c *Client) SyncUserFederation(baseURL, realm, accessToken, providerID, action string) error {
+// SyncUserFederation syncs users from a user federation provider.
+// Keycloak answers a user sync with the synchronization result (added, updated, removed, failed), which is returned when present.
+func (c *Client) SyncUserFederation(baseURL, realm, accessToken, providerID, action string) (map[string]interface{}, error) {
 	// action can be: "triggerFullSync", "triggerChangedUsersSync", "triggerLdapKeyCache"
 	url := fmt.Sprintf("%s/admin/realms/%s/user-storage/%s/%s", baseURL, realm, providerID, action)
 	
 	req, err := http.NewRequest("POST", url, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 	req.Header.Set("Content-Type", "application/json")
 	
-	resp, err := c.httpClient.Do(req)
+	// A full sync of a large directory takes far longer than the default timeout
+	resp, err := c.longClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to sync user federation: status %d, body: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("failed to sync user federation: status %d, body: %s", resp.StatusCode, string(body))
 	}
 	
-	return nil
+	var result map[string]interface{}
+	body, _ := io.ReadAll(resp.Body)
+	if len(bytes.TrimSpace(body)) > 0 {
+		json.Unmarshal(body, &result)
+	}
+	
+	return result, nil
 }
 
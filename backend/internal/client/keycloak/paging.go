@@ -0,0 +1,225 @@
+package keycloak
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"keycloak-multi-manage/internal/domain"
+)
+
+// pageSize is the number of objects requested per page from list endpoints
+const pageSize = 200
+
+// TokenSource returns the access token for the next request. Iterations that can outlive a token
+// take one from it for every page.
+type TokenSource func() (string, error)
+
+// StaticToken is a TokenSource that always returns the same token
+func StaticToken(accessToken string) TokenSource {
+	return func() (string, error) {
+		return accessToken, nil
+	}
+}
+
+// forEachPage requests a list endpoint page by page with first/max until a short page is returned.
+// fn receives every page; returning an error stops the iteration.
+func (c *Client) forEachPage(url, accessToken, what string, fn func(page []map[string]interface{}) error) error {
+	return c.forEachPageWithToken(url, StaticToken(accessToken), what, fn)
+}
+
+// forEachPageWithToken is forEachPage with a new token from token for every page
+func (c *Client) forEachPageWithToken(url string, token TokenSource, what string, fn func(page []map[string]interface{}) error) error {
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+
+	for first := 0; ; first += pageSize {
+		accessToken, err := token()
+		if err != nil {
+			return err
+		}
+		page, err := c.getList(fmt.Sprintf("%s%sfirst=%d&max=%d", url, separator, first, pageSize), accessToken, what)
+		if err != nil {
+			return err
+		}
+		if len(page) > 0 {
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+	}
+}
+
+func (c *Client) getList(url, accessToken, what string) ([]map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get %s: status %d, body: %s", what, resp.StatusCode, string(body))
+	}
+
+	var list []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// collectPages returns every object of a paged list endpoint
+func (c *Client) collectPages(url, accessToken, what string) ([]map[string]interface{}, error) {
+	var all []map[string]interface{}
+	err := c.forEachPage(url, accessToken, what, func(page []map[string]interface{}) error {
+		all = append(all, page...)
+		return nil
+	})
+	return all, err
+}
+
+// ForEachUser iterates over all users of a realm, one page at a time. Every page is requested
+// with a token from token, so exports of large realms are not cut off when a token expires.
+func (c *Client) ForEachUser(baseURL, realm string, token TokenSource, fn func(users []map[string]interface{}) error) error {
+	return c.forEachPageWithToken(fmt.Sprintf("%s/admin/realms/%s/users", baseURL, realm), token, "users", fn)
+}
+
+// ForEachGroup iterates over the top level groups of a realm, one page at a time.
+// Every group has its complete subgroup tree in subGroups.
+func (c *Client) ForEachGroup(baseURL, realm, accessToken string, fn func(groups []map[string]interface{}) error) error {
+	return c.forEachPage(fmt.Sprintf("%s/admin/realms/%s/groups", baseURL, realm), accessToken, "groups", func(page []map[string]interface{}) error {
+		for _, group := range page {
+			if err := c.fillSubGroups(baseURL, realm, accessToken, group); err != nil {
+				return err
+			}
+		}
+		return fn(page)
+	})
+}
+
+// fillSubGroups loads the subgroups Keycloak left out of a group representation.
+// Since Keycloak 23 group listings carry a subGroupCount and subgroups have to be paged through /children.
+func (c *Client) fillSubGroups(baseURL, realm, accessToken string, group map[string]interface{}) error {
+	subGroups, _ := group["subGroups"].([]interface{})
+	count, hasCount := group["subGroupCount"].(float64)
+
+	if hasCount && int(count) > len(subGroups) {
+		url := fmt.Sprintf("%s/admin/realms/%s/groups/%s/children", baseURL, realm, getString(group, "id"))
+		children, err := c.collectPages(url, accessToken, "subgroups")
+		if err != nil {
+			return err
+		}
+		subGroups = make([]interface{}, 0, len(children))
+		for _, child := range children {
+			subGroups = append(subGroups, child)
+		}
+		group["subGroups"] = subGroups
+	}
+
+	for _, sg := range subGroups {
+		if child, ok := sg.(map[string]interface{}); ok {
+			if err := c.fillSubGroups(baseURL, realm, accessToken, child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ForEachClient iterates over all clients of a realm, one page at a time
+func (c *Client) ForEachClient(baseURL, realm, accessToken string, fn func(clients []map[string]interface{}) error) error {
+	return c.forEachPage(fmt.Sprintf("%s/admin/realms/%s/clients", baseURL, realm), accessToken, "clients", fn)
+}
+
+// ForEachRealmRole iterates over all realm roles, one page at a time.
+// With brief set only names, ids and the composite flag are returned.
+func (c *Client) ForEachRealmRole(baseURL, realm, accessToken string, brief bool, fn func(roles []domain.Role) error) error {
+	url := fmt.Sprintf("%s/admin/realms/%s/roles?briefRepresentation=%t", baseURL, realm, brief)
+	return c.forEachPage(url, accessToken, "roles", func(page []map[string]interface{}) error {
+		var roles []domain.Role
+		if err := remarshal(page, &roles); err != nil {
+			return err
+		}
+		return fn(roles)
+	})
+}
+
+// ForEachRoleMember iterates over the users that have a realm role directly, one page at a time
+func (c *Client) ForEachRoleMember(baseURL, realm, accessToken, roleName string, fn func(users []map[string]interface{}) error) error {
+	url := fmt.Sprintf("%s/admin/realms/%s/roles/%s/users", baseURL, realm, neturl.PathEscape(roleName))
+	return c.forEachPage(url, accessToken, "role members", fn)
+}
+
+// GetRoleMembers returns the users that have a realm role directly
+func (c *Client) GetRoleMembers(baseURL, realm, accessToken, roleName string) ([]map[string]interface{}, error) {
+	var members []map[string]interface{}
+	err := c.ForEachRoleMember(baseURL, realm, accessToken, roleName, func(users []map[string]interface{}) error {
+		members = append(members, users...)
+		return nil
+	})
+	return members, err
+}
+
+// getCount reads one of Keycloak's /count endpoints
+func (c *Client) getCount(url, accessToken, what string) (int, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("failed to count %s: status %d, body: %s", what, resp.StatusCode, string(body))
+	}
+
+	// /users/count returns a number, /groups/count an object with a count field
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return 0, err
+	}
+	var count int
+	if err := json.Unmarshal(raw, &count); err == nil {
+		return count, nil
+	}
+	var wrapped struct {
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err != nil {
+		return 0, err
+	}
+	return wrapped.Count, nil
+}
+
+func remarshal(value interface{}, target interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,218 @@
+package keycloak
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// pagedServer serves total objects from any list path, honouring first and max
+func pagedServer(t *testing.T, total int, requests *[]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r.URL.RequestURI())
+		first, _ := strconv.Atoi(r.URL.Query().Get("first"))
+		max, err := strconv.Atoi(r.URL.Query().Get("max"))
+		if err != nil {
+			t.Errorf("request without max: %s", r.URL.RequestURI())
+			max = total
+		}
+
+		page := []map[string]interface{}{}
+		for i := first; i < total && i < first+max; i++ {
+			page = append(page, map[string]interface{}{"id": fmt.Sprintf("id-%d", i), "name": fmt.Sprintf("name-%d", i)})
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestForEachPage(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+		pages int
+	}{
+		{"empty", 0, 1},
+		{"short page", 5, 1},
+		{"exactly one page", pageSize, 2},
+		{"several pages", 2*pageSize + 1, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			server := pagedServer(t, tt.total, &requests)
+
+			var ids []string
+			err := NewClient().forEachPage(server.URL+"/admin/realms/r/users", "token", "users", func(page []map[string]interface{}) error {
+				if len(page) == 0 {
+					t.Error("fn called with an empty page")
+				}
+				for _, object := range page {
+					ids = append(ids, object["id"].(string))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ids) != tt.total {
+				t.Errorf("got %d objects, want %d", len(ids), tt.total)
+			}
+			for i, id := range ids {
+				if id != fmt.Sprintf("id-%d", i) {
+					t.Fatalf("object %d is %s, pages overlap or skip objects", i, id)
+				}
+			}
+			if len(requests) != tt.pages {
+				t.Errorf("made %d requests, want %d: %v", len(requests), tt.pages, requests)
+			}
+		})
+	}
+}
+
+func TestForEachPageKeepsQuery(t *testing.T) {
+	var requests []string
+	server := pagedServer(t, 1, &requests)
+
+	err := NewClient().forEachPage(server.URL+"/roles?briefRepresentation=true", "token", "roles", func([]map[string]interface{}) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("/roles?briefRepresentation=true&first=0&max=%d", pageSize)
+	if len(requests) != 1 || requests[0] != want {
+		t.Fatalf("requests = %v, want [%s]", requests, want)
+	}
+}
+
+func TestForEachPageStopsOnError(t *testing.T) {
+	var requests []string
+	server := pagedServer(t, 3*pageSize, &requests)
+
+	stop := fmt.Errorf("stop")
+	err := NewClient().forEachPage(server.URL+"/users", "token", "users", func([]map[string]interface{}) error { return stop })
+	if err != stop || len(requests) != 1 {
+		t.Fatalf("err = %v after %d requests, want the callback error after the first page", err, len(requests))
+	}
+}
+
+func TestForEachPageStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := NewClient().forEachPage(server.URL+"/users", "token", "users", func([]map[string]interface{}) error { return nil })
+	if err == nil {
+		t.Fatal("expected an error for a 403 page")
+	}
+}
+
+func TestGetClientRolesPages(t *testing.T) {
+	var requests []string
+	server := pagedServer(t, pageSize+10, &requests)
+
+	roles, err := NewClient().GetClientRoles(server.URL, "r", "token", "client-uuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != pageSize+10 || len(requests) != 2 {
+		t.Fatalf("got %d roles in %d requests, want %d in 2", len(roles), len(requests), pageSize+10)
+	}
+}
+
+func TestGetCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    int
+		wantErr bool
+	}{
+		{"number", http.StatusOK, "1234", 1234, false},
+		{"object", http.StatusOK, `{"count": 17}`, 17, false},
+		{"zero", http.StatusOK, "0", 0, false},
+		{"object without count", http.StatusOK, `{}`, 0, false},
+		{"not a count", http.StatusOK, `"many"`, 0, true},
+		{"invalid json", http.StatusOK, `{`, 0, true},
+		{"status error", http.StatusForbidden, `{"error":"forbidden"}`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			count, err := NewClient().getCount(server.URL, "token", "users")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if count != tt.want {
+				t.Errorf("count = %d, want %d", count, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetGroupsCountTopLevel(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"count": 3}`))
+	}))
+	defer server.Close()
+
+	count, err := NewClient().getGroupsCount(server.URL, "r", "token")
+	if err != nil || count != 3 {
+		t.Fatalf("got %d, %v", count, err)
+	}
+	if query != "top=true" {
+		t.Errorf("query = %q, want only top level groups counted", query)
+	}
+}
+
+func TestForEachUserTakesTokenPerPage(t *testing.T) {
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		first, _ := strconv.Atoi(r.URL.Query().Get("first"))
+		page := []map[string]interface{}{}
+		for i := first; i < 2*pageSize+1 && i < first+pageSize; i++ {
+			page = append(page, map[string]interface{}{"id": strconv.Itoa(i)})
+		}
+		json.NewEncoder(w).Encode(page)
+	}))
+	defer server.Close()
+
+	issued := 0
+	token := func() (string, error) {
+		issued++
+		return fmt.Sprintf("token-%d", issued), nil
+	}
+	err := NewClient().ForEachUser(server.URL, "r", token, func([]map[string]interface{}) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Bearer token-1", "Bearer token-2", "Bearer token-3"}
+	if fmt.Sprint(authorizations) != fmt.Sprint(want) {
+		t.Fatalf("authorizations = %v, want %v", authorizations, want)
+	}
+}
+
+func TestForEachUserTokenError(t *testing.T) {
+	failure := fmt.Errorf("no token")
+	err := NewClient().ForEachUser("http://127.0.0.1:0", "r", func() (string, error) { return "", failure }, func([]map[string]interface{}) error { return nil })
+	if err != failure {
+		t.Fatalf("err = %v, want the token source error", err)
+	}
+}
@@ -0,0 +1,32 @@
+package keycloak
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFindClientUUID(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("clientId")
+		if query == "my app" {
+			w.Write([]byte(`[{"id": "uuid-1", "clientId": "my app"}]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	uuid, err := NewClient().FindClientUUID(server.URL, "r", "token", "my app")
+	if err != nil || uuid != "uuid-1" {
+		t.Fatalf("got %q, %v, want uuid-1", uuid, err)
+	}
+	if query != "my app" {
+		t.Errorf("clientId query = %q, want the client looked up by its clientId", query)
+	}
+
+	if _, err := NewClient().FindClientUUID(server.URL, "r", "token", "missing"); err == nil {
+		t.Error("expected an error for a missing client")
+	}
+}
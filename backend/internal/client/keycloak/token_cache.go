@@ -0,0 +1,210 @@
+package keycloak
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenExpiryMargin keeps tokens from being handed out shortly before they expire
+	tokenExpiryMargin = 30 * time.Second
+	// tokenRefreshAfter is the share of a token's lifetime after which it is refreshed in the background
+	tokenRefreshAfter = 0.75
+	// tokenRefreshRetry delays the next background refresh after a failed one
+	tokenRefreshRetry = 10 * time.Second
+	// tokenRetention keeps a token known to the cache after it expired, so requests still sent with it
+	// by callers that held on to it are retried with the current token
+	tokenRetention = 15 * time.Minute
+)
+
+// tokens is shared by every Client, so all services reuse the same token per cluster
+var tokens = newTokenCache()
+
+type cachedToken struct {
+	response  TokenResponse
+	expiresAt time.Time
+	refreshAt time.Time
+	// validUntil is when Keycloak stops accepting the token
+	validUntil time.Time
+	fetch      func() (*TokenResponse, error)
+}
+
+type tokenFetch struct {
+	done  chan struct{}
+	entry *cachedToken
+	err   error
+}
+
+// tokenCache holds client credentials tokens keyed by token endpoint, client and secret.
+// Concurrent requests for the same key share one call to the token endpoint.
+type tokenCache struct {
+	mu       sync.Mutex
+	entries  map[string]*cachedToken
+	byToken  map[string]*issuedToken // access token -> issuing entry, to retry requests Keycloak rejected
+	inflight map[string]*tokenFetch
+}
+
+// issuedToken remembers which key a token was issued for. Superseded tokens stay here until
+// tokenRetention after they expired, since callers may still hold them.
+type issuedToken struct {
+	key   string
+	entry *cachedToken
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		entries:  make(map[string]*cachedToken),
+		byToken:  make(map[string]*issuedToken),
+		inflight: make(map[string]*tokenFetch),
+	}
+}
+
+// GetCachedClientCredentialsToken returns a cached client credentials token while it is valid and fetches one otherwise.
+// ExpiresIn is the remaining lifetime of the returned token.
+// Use GetClientCredentialsToken when a new token is needed, e.g. to show or test one.
+func (c *Client) GetCachedClientCredentialsToken(baseURL, realm, clientID, clientSecret string) (*TokenResponse, error) {
+	secretHash := sha256.Sum256([]byte(clientSecret))
+	key := strings.Join([]string{baseURL, realm, clientID, hex.EncodeToString(secretHash[:])}, "|")
+
+	return tokens.get(key, func() (*TokenResponse, error) {
+		return c.GetClientCredentialsToken(baseURL, realm, clientID, clientSecret)
+	})
+}
+
+func (tc *tokenCache) get(key string, fetch func() (*TokenResponse, error)) (*TokenResponse, error) {
+	tc.mu.Lock()
+	now := time.Now()
+	if entry := tc.entries[key]; entry != nil && now.Before(entry.expiresAt) {
+		if now.After(entry.refreshAt) && tc.inflight[key] == nil {
+			tc.startFetch(key, fetch)
+		}
+		tc.mu.Unlock()
+		return entry.current(now), nil
+	}
+
+	f := tc.inflight[key]
+	if f == nil {
+		f = tc.startFetch(key, fetch)
+	}
+	tc.mu.Unlock()
+
+	<-f.done
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.entry.current(time.Now()), nil
+}
+
+// startFetch requests a new token in the background. tc.mu must be held.
+func (tc *tokenCache) startFetch(key string, fetch func() (*TokenResponse, error)) *tokenFetch {
+	f := &tokenFetch{done: make(chan struct{})}
+	tc.inflight[key] = f
+
+	go func() {
+		started := time.Now()
+		resp, err := fetch()
+
+		tc.mu.Lock()
+		delete(tc.inflight, key)
+		if err != nil {
+			f.err = err
+			if entry := tc.entries[key]; entry != nil {
+				entry.refreshAt = time.Now().Add(tokenRefreshRetry)
+			}
+		} else {
+			lifetime := time.Duration(resp.ExpiresIn) * time.Second
+			f.entry = &cachedToken{
+				response:   *resp,
+				expiresAt:  started.Add(lifetime - tokenExpiryMargin),
+				refreshAt:  started.Add(time.Duration(float64(lifetime) * tokenRefreshAfter)),
+				validUntil: started.Add(lifetime),
+				fetch:      fetch,
+			}
+			tc.entries[key] = f.entry
+			tc.byToken[resp.AccessToken] = &issuedToken{key: key, entry: f.entry}
+			tc.removeExpired(time.Now())
+		}
+		tc.mu.Unlock()
+		close(f.done)
+	}()
+
+	return f
+}
+
+// invalidate handles a token Keycloak rejected. A rejected current token is dropped from the cache;
+// a superseded one leaves the current token in place. It returns the key and fetch function the token
+// was issued with, or false when the token did not come from the cache.
+func (tc *tokenCache) invalidate(accessToken string) (string, func() (*TokenResponse, error), bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	issued, ok := tc.byToken[accessToken]
+	if !ok {
+		return "", nil, false
+	}
+	if tc.entries[issued.key] == issued.entry {
+		delete(tc.entries, issued.key)
+	}
+	return issued.key, issued.entry.fetch, true
+}
+
+// removeExpired drops entries of clusters that are no longer used, e.g. after a secret changed,
+// and forgets tokens that expired more than tokenRetention ago. tc.mu must be held.
+func (tc *tokenCache) removeExpired(now time.Time) {
+	for key, entry := range tc.entries {
+		if now.After(entry.expiresAt) {
+			delete(tc.entries, key)
+		}
+	}
+	for accessToken, issued := range tc.byToken {
+		if now.After(issued.entry.validUntil.Add(tokenRetention)) {
+			delete(tc.byToken, accessToken)
+		}
+	}
+}
+
+func (t *cachedToken) current(now time.Time) *TokenResponse {
+	resp := t.response
+	resp.ExpiresIn = int(t.expiresAt.Add(tokenExpiryMargin).Sub(now).Seconds())
+	return &resp
+}
+
+// tokenTransport invalidates cached tokens Keycloak answers with 401 and retries the request once with a new token
+type tokenTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	accessToken := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	key, fetch, ok := tokens.invalidate(accessToken)
+	if !ok || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+
+	fresh, err := tokens.get(key, fetch)
+	if err != nil {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+fresh.AccessToken)
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
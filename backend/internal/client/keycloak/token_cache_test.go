@@ -0,0 +1,288 @@
+package keycloak
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingFetch returns a fetch function that issues token-1, token-2, ... and counts its calls
+func countingFetch(calls *int32) func() (*TokenResponse, error) {
+	return func() (*TokenResponse, error) {
+		n := atomic.AddInt32(calls, 1)
+		return &TokenResponse{AccessToken: fmt.Sprintf("token-%d", n), ExpiresIn: 300}, nil
+	}
+}
+
+// waitForFetch waits until no fetch of key is in flight
+func waitForFetch(t *testing.T, tc *tokenCache, key string) {
+	t.Helper()
+	tc.mu.Lock()
+	f := tc.inflight[key]
+	tc.mu.Unlock()
+	if f == nil {
+		return
+	}
+	select {
+	case <-f.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("background fetch did not finish")
+	}
+}
+
+func TestTokenCacheSingleFlight(t *testing.T) {
+	tc := newTokenCache()
+	release := make(chan struct{})
+	var calls int32
+	fetch := func() (*TokenResponse, error) {
+		<-release
+		return countingFetch(&calls)()
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	results := make([]string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := tc.get("key", fetch)
+			if err != nil {
+				t.Errorf("get: %v", err)
+				return
+			}
+			results[i] = resp.AccessToken
+		}(i)
+	}
+
+	// Let every caller reach the cache before the token endpoint answers
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("token endpoint called %d times, want 1", calls)
+	}
+	for i, token := range results {
+		if token != "token-1" {
+			t.Errorf("caller %d got %q, want token-1", i, token)
+		}
+	}
+}
+
+func TestTokenCacheReusesValidToken(t *testing.T) {
+	tc := newTokenCache()
+	var calls int32
+
+	first, err := tc.get("key", countingFetch(&calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tc.get("key", countingFetch(&calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 1 || first.AccessToken != second.AccessToken {
+		t.Fatalf("got %q and %q with %d fetches, want one cached token", first.AccessToken, second.AccessToken, calls)
+	}
+	if second.ExpiresIn <= 0 || second.ExpiresIn > 300 {
+		t.Errorf("ExpiresIn = %d, want the remaining lifetime", second.ExpiresIn)
+	}
+}
+
+func TestTokenCacheSeparatesKeys(t *testing.T) {
+	tc := newTokenCache()
+	var calls int32
+
+	a, _ := tc.get("a", countingFetch(&calls))
+	b, _ := tc.get("b", countingFetch(&calls))
+	if calls != 2 || a.AccessToken == b.AccessToken {
+		t.Fatalf("keys share a token: %q, %q", a.AccessToken, b.AccessToken)
+	}
+}
+
+func TestTokenCacheFetchError(t *testing.T) {
+	tc := newTokenCache()
+	failure := errors.New("unauthorized client")
+
+	_, err := tc.get("key", func() (*TokenResponse, error) { return nil, failure })
+	if !errors.Is(err, failure) {
+		t.Fatalf("err = %v, want %v", err, failure)
+	}
+
+	// A failed fetch is not cached
+	var calls int32
+	resp, err := tc.get("key", countingFetch(&calls))
+	if err != nil || resp.AccessToken != "token-1" {
+		t.Fatalf("got %v, %v after a failed fetch", resp, err)
+	}
+}
+
+func TestTokenCacheRefreshesInBackground(t *testing.T) {
+	tc := newTokenCache()
+	var calls int32
+	fetch := countingFetch(&calls)
+
+	if _, err := tc.get("key", fetch); err != nil {
+		t.Fatal(err)
+	}
+	tc.mu.Lock()
+	tc.entries["key"].refreshAt = time.Now().Add(-time.Second)
+	tc.mu.Unlock()
+
+	// The still valid token is returned while the new one is fetched
+	resp, err := tc.get("key", fetch)
+	if err != nil || resp.AccessToken != "token-1" {
+		t.Fatalf("got %v, %v during refresh, want token-1", resp, err)
+	}
+	waitForFetch(t, tc, "key")
+
+	resp, _ = tc.get("key", fetch)
+	if resp.AccessToken != "token-2" || calls != 2 {
+		t.Fatalf("got %q after %d fetches, want token-2 after 2", resp.AccessToken, calls)
+	}
+}
+
+func TestTokenCacheFetchesExpiredToken(t *testing.T) {
+	tc := newTokenCache()
+	var calls int32
+	fetch := countingFetch(&calls)
+
+	tc.get("key", fetch)
+	tc.mu.Lock()
+	tc.entries["key"].expiresAt = time.Now().Add(-time.Second)
+	tc.mu.Unlock()
+
+	resp, err := tc.get("key", fetch)
+	if err != nil || resp.AccessToken != "token-2" {
+		t.Fatalf("got %v, %v, want a new token once the cached one expired", resp, err)
+	}
+}
+
+func TestTokenCacheInvalidate(t *testing.T) {
+	tc := newTokenCache()
+	var calls int32
+	fetch := countingFetch(&calls)
+
+	tc.get("key", fetch)
+	tc.mu.Lock()
+	tc.entries["key"].refreshAt = time.Now().Add(-time.Second)
+	tc.mu.Unlock()
+	tc.get("key", fetch)
+	waitForFetch(t, tc, "key")
+
+	// A superseded token still maps to its key and leaves the current token cached
+	key, refetch, ok := tc.invalidate("token-1")
+	if !ok || key != "key" || refetch == nil {
+		t.Fatalf("invalidate(token-1) = %q, %v, want the key of the superseded token", key, ok)
+	}
+	if resp, _ := tc.get("key", fetch); resp.AccessToken != "token-2" || calls != 2 {
+		t.Fatalf("got %q after %d fetches, want the current token-2 kept", resp.AccessToken, calls)
+	}
+
+	// A rejected current token is dropped
+	if _, _, ok := tc.invalidate("token-2"); !ok {
+		t.Fatal("invalidate(token-2) did not find the current token")
+	}
+	if resp, _ := tc.get("key", fetch); resp.AccessToken != "token-3" {
+		t.Fatalf("got %q, want a new token after the current one was rejected", resp.AccessToken)
+	}
+
+	if _, _, ok := tc.invalidate("unknown"); ok {
+		t.Error("invalidate accepted a token the cache never issued")
+	}
+}
+
+func TestTokenCacheForgetsTokensAfterRetention(t *testing.T) {
+	tc := newTokenCache()
+	var calls int32
+	fetch := countingFetch(&calls)
+
+	tc.get("key", fetch)
+	tc.mu.Lock()
+	tc.byToken["token-1"].entry.validUntil = time.Now().Add(-tokenRetention - time.Second)
+	tc.removeExpired(time.Now())
+	_, known := tc.byToken["token-1"]
+	tc.mu.Unlock()
+
+	if known {
+		t.Fatal("token is still known after its retention ended")
+	}
+}
+
+func TestTokenTransportRetriesSupersededToken(t *testing.T) {
+	saved := tokens
+	tokens = newTokenCache()
+	defer func() { tokens = saved }()
+
+	var calls int32
+	fetch := countingFetch(&calls)
+	tokens.get("key", fetch)
+	tokens.mu.Lock()
+	tokens.entries["key"].refreshAt = time.Now().Add(-time.Second)
+	tokens.mu.Unlock()
+	tokens.get("key", fetch)
+	waitForFetch(t, tokens, "key")
+
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &tokenTransport{base: http.DefaultTransport}}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Authorization", "Bearer token-1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want the retry with the current token to succeed", resp.StatusCode)
+	}
+	if len(seen) != 2 || seen[1] != "Bearer token-2" {
+		t.Fatalf("requests = %v, want a retry with token-2", seen)
+	}
+	if calls != 2 {
+		t.Errorf("token endpoint called %d times, want the current token reused", calls)
+	}
+}
+
+func TestTokenTransportPassesUnknownTokens(t *testing.T) {
+	saved := tokens
+	tokens = newTokenCache()
+	defer func() { tokens = saved }()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &tokenTransport{base: http.DefaultTransport}}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Authorization", "Bearer not-cached")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized || requests != 1 {
+		t.Fatalf("status %d after %d requests, want the 401 passed through without a retry", resp.StatusCode, requests)
+	}
+}
@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+
+	// AuditLocalsKey is the fiber locals key handlers use to add an AuditChange to the request's audit event
+	AuditLocalsKey = "audit_change"
+)
+
+// AuditEvent records one mutating call: who did what to which object, and how it ended
+type AuditEvent struct {
+	ID             int64           `json:"id"`
+	ActorID        *int            `json:"actor_id,omitempty"`
+	ActorUsername  string          `json:"actor_username,omitempty"`
+	Action         string          `json:"action"` // Route of the call (e.g. "POST /api/sync/bulk") or a service action (e.g. "job.bulk_sync")
+	Method         string          `json:"method,omitempty"`
+	Path           string          `json:"path,omitempty"`
+	ClusterID      *int            `json:"cluster_id,omitempty"`
+	ClusterName    string          `json:"cluster_name,omitempty"`
+	Realm          string          `json:"realm,omitempty"`
+	ObjectType     string          `json:"object_type,omitempty"`
+	ObjectName     string          `json:"object_name,omitempty"`
+	RequestSummary json.RawMessage `json:"request_summary,omitempty"` // Request body with secrets redacted and large values shortened
+	Outcome        string          `json:"outcome"`
+	StatusCode     int             `json:"status_code,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Before         json.RawMessage `json:"before,omitempty"`
+	After          json.RawMessage `json:"after,omitempty"`
+	IPAddress      string          `json:"ip_address,omitempty"`
+	DurationMs     int             `json:"duration_ms"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// AuditChange is what a handler adds to the audit event of its request: the changed object
+// and, where it is cheap to load, its state before and after the change
+type AuditChange struct {
+	ObjectType string
+	ObjectName string
+	Before     interface{}
+	After      interface{}
+}
+
+// AuditFilter selects audit events; zero values do not filter
+type AuditFilter struct {
+	ActorID    int
+	Actor      string // Username, matched case-insensitively
+	Action     string // Substring of the action
+	ClusterID  int
+	ObjectType string
+	ObjectName string // Substring of the object name
+	Outcome    string
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+	Offset     int
+}
+
+type AuditEventPage struct {
+	Total  int           `json:"total"`
+	Events []*AuditEvent `json:"events"`
+}
@@ -0,0 +1,57 @@
+package domain
+
+import "time"
+
+// Bulk sync selections
+const (
+	BulkSelectMissing   = "missing_in_destination"
+	BulkSelectDifferent = "different_config"
+	BulkSelectExplicit  = "explicit"
+)
+
+// BulkSyncItem identifies a single object to sync
+type BulkSyncItem struct {
+	ObjectType string `json:"object_type"` // "role", "client", "group", "user"
+	Name       string `json:"name"`        // role name, clientId, group path or username
+}
+
+// BulkSyncRequest selects many diff items to sync from source to destination in one run
+type BulkSyncRequest struct {
+	SourceClusterID      int            `json:"source_cluster_id" validate:"required"`
+	DestinationClusterID int            `json:"destination_cluster_id" validate:"required"`
+	Selection            string         `json:"selection" validate:"required,oneof=missing_in_destination different_config explicit"`
+	ObjectTypes          []string       `json:"object_types,omitempty"` // Limits a diff based selection, empty means all four types
+	Items                []BulkSyncItem `json:"items,omitempty"`        // Used when selection is "explicit"
+	RemoveExtra          bool           `json:"remove_extra,omitempty"` // Remove role mappings, composites and group memberships the source does not have
+	OperationID          string         `json:"operation_id,omitempty"` // Tags the run's events on the event stream; generated when empty
+}
+
+// BulkSyncItemResult is the outcome of syncing one item
+type BulkSyncItemResult struct {
+	ObjectType   string            `json:"object_type"`
+	Name         string            `json:"name"`
+	Status       string            `json:"status"` // "success", "failed", "unchanged"
+	Steps        []SyncStepResult  `json:"steps,omitempty"`
+	Dependencies []SyncDependency  `json:"dependencies,omitempty"` // Prerequisites created for this item
+	Changes      []SyncFieldChange `json:"changes,omitempty"`      // Fields changed on an existing destination object
+	Error        string            `json:"error,omitempty"`
+}
+
+type BulkSyncSummary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Unchanged int `json:"unchanged"`
+}
+
+// BulkSyncResult reports every item of a bulk sync run and a final summary
+type BulkSyncResult struct {
+	OperationID          string               `json:"operation_id"`
+	SourceClusterID      int                  `json:"source_cluster_id"`
+	DestinationClusterID int                  `json:"destination_cluster_id"`
+	Selection            string               `json:"selection"`
+	Items                []BulkSyncItemResult `json:"items"`
+	Summary              BulkSyncSummary      `json:"summary"`
+	StartedAt            time.Time            `json:"started_at"`
+	FinishedAt           time.Time            `json:"finished_at"`
+}
@@ -8,10 +8,12 @@ type Cluster struct {
 	BaseURL         string               `json:"base_url"`
 	Realm           string               `json:"realm"`
 	ClientID        string               `json:"client_id"`        // Always "multi-manage"
-	ClientSecret    string               `json:"client_secret"`   // Service account secret
+	ClientSecret    string               `json:"-"`               // Service account secret to store on create or update; never loaded or returned, tokens read it through the "db" secret store
+	SecretRef       string               `json:"secret_ref,omitempty"` // Reference to the secret in an external store, e.g. "vault:keycloak/prod#client_secret"; empty keeps it encrypted in the database
 	GroupName       *string              `json:"group_name,omitempty"`
 	MetricsEndpoint *string              `json:"metrics_endpoint,omitempty"`
 	EnvironmentTags []*EnvironmentTag    `json:"environment_tags,omitempty"`
+	SecretRotatedAt *time.Time           `json:"secret_rotated_at,omitempty"` // Last rotation of the client secret
 	CreatedAt       time.Time            `json:"created_at"`
 	UpdatedAt       time.Time            `json:"updated_at"`
 }
@@ -25,6 +27,9 @@ type CreateClusterRequest struct {
 	MasterPassword  string  `json:"master_password" validate:"required"`
 	GroupName       *string `json:"group_name,omitempty"`
 	MetricsEndpoint *string `json:"metrics_endpoint,omitempty"`
+	// SecretRef reads the service account secret from an external store instead of the database.
+	// Left out on update it stays as it is; an empty string moves the secret back into the database.
+	SecretRef       *string `json:"secret_ref,omitempty"`
 }
 
 type ClusterHealth struct {
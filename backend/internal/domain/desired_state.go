@@ -0,0 +1,23 @@
+package domain
+
+// DesiredState is a declarative description of a realm, kept as YAML or JSON in git.
+// Only the sections present in the document are reconciled; a missing section leaves that part of the realm alone.
+type DesiredState struct {
+	Realm        string                   `json:"realm,omitempty"` // When set, the target cluster must manage this realm
+	Roles        []Role                   `json:"roles,omitempty"`
+	Clients      []map[string]interface{} `json:"clients,omitempty"`      // Client representations; "roles" lists the client role names
+	ClientScopes []map[string]interface{} `json:"clientScopes,omitempty"` // Client scope representations with their protocolMappers
+	Groups       []GroupDetail            `json:"groups,omitempty"`       // Paths may be left out, they follow from the nesting
+}
+
+// DesiredStateResult reports how a cluster was reconciled with a desired-state document
+type DesiredStateResult struct {
+	ClusterID    int              `json:"cluster_id"`
+	DryRun       bool             `json:"dry_run"`
+	Prune        bool             `json:"prune"`
+	Status       string           `json:"status"` // "unchanged", "planned", "applied", "failed"
+	Diff         *CompareResult   `json:"diff"`
+	Steps        []SyncPlanStep   `json:"steps"`
+	Dependencies []SyncDependency `json:"dependencies,omitempty"`
+	Results      []SyncStepResult `json:"results,omitempty"`
+}
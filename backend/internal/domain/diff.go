@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// DiffSource is one side of a comparison. Exactly one of ClusterID, SnapshotID or Export is set.
+type DiffSource struct {
+	ClusterID  int                    `json:"cluster_id,omitempty"`
+	At         *time.Time             `json:"at,omitempty"` // With ClusterID, compares the snapshot that was current at this time instead of the live realm
+	SnapshotID int                    `json:"snapshot_id,omitempty"`
+	Export     map[string]interface{} `json:"export,omitempty"` // Keycloak realm export (roles, clients, groups, users)
+}
+
+type CompareRequest struct {
+	Source      DiffSource `json:"source"`
+	Destination DiffSource `json:"destination"`
+	ObjectTypes []string   `json:"object_types,omitempty"` // role, client, group, user; empty compares all of them
+}
+
+// CompareResult holds the diffs of the compared object types
+type CompareResult struct {
+	Roles   []RoleDiff   `json:"roles,omitempty"`
+	Clients []ClientDiff `json:"clients,omitempty"`
+	Groups  []GroupDiff  `json:"groups,omitempty"`
+	Users   []UserDiff   `json:"users,omitempty"`
+}
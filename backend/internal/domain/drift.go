@@ -0,0 +1,63 @@
+package domain
+
+import "time"
+
+const (
+	DriftRunStatusRunning = "running"
+	DriftRunStatusSuccess = "success"
+	DriftRunStatusFailed  = "failed"
+)
+
+// ClusterPair is a source/destination pair whose diff is computed periodically to detect drift
+type ClusterPair struct {
+	ID                   int        `json:"id"`
+	Name                 string     `json:"name"`
+	SourceClusterID      int        `json:"source_cluster_id"`
+	DestinationClusterID int        `json:"destination_cluster_id"`
+	IntervalMinutes      int        `json:"interval_minutes"`
+	Enabled              bool       `json:"enabled"`
+	LastRunAt            *time.Time `json:"last_run_at,omitempty"`
+	LastRun              *DriftRun  `json:"last_run,omitempty"` // Latest finished run without its items
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+type CreateClusterPairRequest struct {
+	Name                 string `json:"name" validate:"required"`
+	SourceClusterID      int    `json:"source_cluster_id" validate:"required"`
+	DestinationClusterID int    `json:"destination_cluster_id" validate:"required"`
+	IntervalMinutes      int    `json:"interval_minutes"` // Defaults to 60
+	Enabled              *bool  `json:"enabled,omitempty"` // Defaults to true
+}
+
+// DriftItem is one object that differs between the clusters of a pair
+type DriftItem struct {
+	ObjectType  string   `json:"object_type"` // "role", "client", "group", "user"
+	Name        string   `json:"name"`
+	Status      string   `json:"status"` // "missing_in_destination", "missing_in_source", "different_config"
+	Differences []string `json:"differences,omitempty"`
+}
+
+// DriftRun stores the result of one diff of a cluster pair
+type DriftRun struct {
+	ID         int            `json:"id"`
+	PairID     int            `json:"pair_id"`
+	Status     string         `json:"status"`
+	DriftCount int            `json:"drift_count"`
+	Counts     map[string]int `json:"counts"` // Drifted objects per object type
+	Items      []DriftItem    `json:"items,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+// DriftChanges compares the latest run of a pair with the last run before a point in time
+type DriftChanges struct {
+	PairID      int         `json:"pair_id"`
+	Since       time.Time   `json:"since"`
+	BaselineRun *DriftRun   `json:"baseline_run,omitempty"` // Nil when the pair has no run before Since
+	LatestRun   *DriftRun   `json:"latest_run"`
+	Appeared    []DriftItem `json:"appeared"` // Drifted now, not at the baseline
+	Resolved    []DriftItem `json:"resolved"` // Drifted at the baseline, not anymore
+	Changed     []DriftItem `json:"changed"`  // Drifted at both, but with a different status or differences
+}
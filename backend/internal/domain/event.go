@@ -0,0 +1,35 @@
+package domain
+
+import "time"
+
+const (
+	EventStarted   = "started"
+	EventProgress  = "progress"
+	EventItem      = "item"
+	EventCompleted = "completed"
+
+	OperationBulkSync    = "bulk_sync"
+	OperationRealmImport = "realm_import"
+	OperationUserImport  = "user_import"
+	OperationUserExport  = "user_export"
+	OperationDriftScan   = "drift_scan"
+)
+
+// Event reports the progress of a long operation to the clients of the event stream.
+// All events of one run share an OperationID.
+type Event struct {
+	ID          int64       `json:"id"`
+	Type        string      `json:"type"`      // started, progress, item, completed
+	Operation   string      `json:"operation"` // bulk_sync, realm_import, user_import, user_export, drift_scan or a job type
+	OperationID string      `json:"operation_id"`
+	ClusterIDs  []int       `json:"cluster_ids,omitempty"`
+	Done        int         `json:"done,omitempty"`
+	Total       int         `json:"total,omitempty"` // 0 while unknown
+	Item        string      `json:"item,omitempty"`  // The object an item event is about, e.g. "client 'web'"
+	Status      string      `json:"status,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Data        interface{} `json:"data,omitempty"`
+	Time        time.Time   `json:"time"`
+	// Permission is required to receive the event; it is the permission of the routes that start the operation
+	Permission string `json:"-"`
+}
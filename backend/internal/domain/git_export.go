@@ -0,0 +1,20 @@
+package domain
+
+import "time"
+
+// GitExportResult describes one run of the git exporter
+type GitExportResult struct {
+	StartedAt time.Time                `json:"started_at"`
+	Committed bool                     `json:"committed"` // False when no cluster's configuration changed
+	Commit    string                   `json:"commit,omitempty"`
+	Clusters  []GitExportClusterResult `json:"clusters"`
+}
+
+// GitExportClusterResult is the export of one cluster. A failed cluster keeps its previously exported files.
+type GitExportClusterResult struct {
+	ClusterID int    `json:"cluster_id"`
+	Name      string `json:"name"`
+	Directory string `json:"directory"`
+	Changed   bool   `json:"changed"`
+	Error     string `json:"error,omitempty"`
+}
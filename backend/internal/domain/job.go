@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+
+	JobTypeBulkSync             = "bulk_sync"
+	JobTypeRealmImport          = "realm_import"
+	JobTypeFederationSync       = "federation_sync"
+	JobTypeUserExport           = "user_export"
+	JobTypeSecretRotation       = "secret_rotation"
+	JobTypeClientSecretRotation = "client_secret_rotation"
+
+	JobLogInfo  = "info"
+	JobLogWarn  = "warn"
+	JobLogError = "error"
+)
+
+// Job is a long operation that runs in the background and survives server restarts
+type Job struct {
+	ID              int             `json:"id"`
+	Type            string          `json:"type"`
+	Status          string          `json:"status"`
+	Payload         json.RawMessage `json:"payload,omitempty"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	ProgressDone    int             `json:"progress_done"`
+	ProgressTotal   int             `json:"progress_total"` // 0 while unknown
+	Attempts        int             `json:"attempts"`
+	MaxAttempts     int             `json:"max_attempts"`
+	CancelRequested bool            `json:"cancel_requested"`
+	RunAfter        time.Time       `json:"run_after"`  // Retries wait until this time
+	HasOutput       bool            `json:"has_output"` // A file (e.g. an export) can be downloaded from /jobs/:id/output
+	CreatedBy       *int            `json:"created_by,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	StartedAt       *time.Time      `json:"started_at,omitempty"`
+	FinishedAt      *time.Time      `json:"finished_at,omitempty"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+}
+
+// Finished tells whether the job reached a final state
+func (j *Job) Finished() bool {
+	return j.Status == JobStatusSucceeded || j.Status == JobStatusFailed || j.Status == JobStatusCancelled
+}
+
+type JobLog struct {
+	ID        int       `json:"id"`
+	JobID     int       `json:"job_id"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RealmImportJobPayload is the input of a realm_import job
+type RealmImportJobPayload struct {
+	ClusterID       int             `json:"cluster_id"`
+	SourceClusterID int             `json:"source_cluster_id,omitempty"`
+	RealmConfig     json.RawMessage `json:"realm_config"`
+}
+
+// FederationSyncJobPayload is the input of a federation_sync job
+type FederationSyncJobPayload struct {
+	ClusterID  int    `json:"cluster_id"`
+	Realm      string `json:"realm,omitempty"`
+	ProviderID string `json:"provider_id"`
+	Action     string `json:"action"`
+}
+
+// UserExportJobPayload is the input of a user_export job
+type UserExportJobPayload struct {
+	ClusterID int `json:"cluster_id"`
+}
+
+// SecretRotationJobPayload is the input of a secret_rotation job
+type SecretRotationJobPayload struct {
+	ClusterIDs []int `json:"cluster_ids,omitempty"`
+	TagID      *int  `json:"tag_id,omitempty"`
+}
+
+// ClientSecretRotationJobPayload is the input of a client_secret_rotation job
+type ClientSecretRotationJobPayload struct {
+	ClientID           string `json:"client_id"`
+	Sink               string `json:"sink"`
+	InvalidatePrevious bool   `json:"invalidate_previous,omitempty"`
+	DeliverOnly        bool   `json:"deliver_only,omitempty"`
+	ClusterIDs         []int  `json:"cluster_ids,omitempty"`
+	TagID              *int   `json:"tag_id,omitempty"`
+}
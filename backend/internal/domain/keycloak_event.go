@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Kinds of Keycloak events
+const (
+	KeycloakEventKindLogin = "login" // User events from /events (logins, token refreshes, errors, ...)
+	KeycloakEventKindAdmin = "admin" // Admin events from /admin-events (changes made through the admin API or console)
+)
+
+// KeycloakEventKinds lists the kinds the collector pulls from every cluster
+var KeycloakEventKinds = []string{KeycloakEventKindLogin, KeycloakEventKindAdmin}
+
+// KeycloakEvent is a login or admin event collected from a cluster, normalized across both kinds.
+// For admin events the user, client and IP are those of the admin who made the change.
+type KeycloakEvent struct {
+	ID           int64           `json:"id"`
+	ClusterID    int             `json:"cluster_id"`
+	ClusterName  string          `json:"cluster_name,omitempty"`
+	Realm        string          `json:"realm"`
+	Kind         string          `json:"kind"`
+	EventKey     string          `json:"event_key"` // Keycloak's event ID, or a hash of the event for versions without one
+	Time         time.Time       `json:"time"`
+	Type         string          `json:"type"` // Event type (e.g. LOGIN_ERROR) or admin operation (e.g. UPDATE)
+	UserID       string          `json:"user_id,omitempty"`
+	Username     string          `json:"username,omitempty"`
+	ClientID     string          `json:"client_id,omitempty"`
+	IPAddress    string          `json:"ip_address,omitempty"`
+	SessionID    string          `json:"session_id,omitempty"`
+	ResourceType string          `json:"resource_type,omitempty"`
+	ResourcePath string          `json:"resource_path,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Details      json.RawMessage `json:"details,omitempty"` // Event details, or the representation of an admin event with secrets redacted
+	CollectedAt  time.Time       `json:"collected_at"`
+}
+
+// KeycloakEventFilter selects collected events; zero values do not filter
+type KeycloakEventFilter struct {
+	ClusterID int
+	Kind      string
+	User      string // User ID, or a substring of the username
+	Client    string
+	IPAddress string
+	Type      string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
+
+type KeycloakEventPage struct {
+	Total  int              `json:"total"`
+	Events []*KeycloakEvent `json:"events"`
+}
+
+// KeycloakEventCheckpoint is how far the events of one kind have been collected from a cluster
+type KeycloakEventCheckpoint struct {
+	ClusterID       int        `json:"cluster_id"`
+	ClusterName     string     `json:"cluster_name,omitempty"`
+	Kind            string     `json:"kind"`
+	LastEventTime   *time.Time `json:"last_event_time,omitempty"` // Time of the newest collected event
+	LastCollectedAt *time.Time `json:"last_collected_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	Collected       int        `json:"collected"` // Events stored by the last collection
+}
+
+// CollectKeycloakEventsResult is the outcome of collecting the events of one cluster
+type CollectKeycloakEventsResult struct {
+	ClusterID   int                        `json:"cluster_id"`
+	Checkpoints []*KeycloakEventCheckpoint `json:"checkpoints"`
+}
+
+// RealmEventsConfig is Keycloak's event settings of a realm
+type RealmEventsConfig struct {
+	EventsEnabled             bool     `json:"eventsEnabled"`
+	EventsExpiration          *int64   `json:"eventsExpiration,omitempty"` // Seconds
+	EventsListeners           []string `json:"eventsListeners"`
+	EnabledEventTypes         []string `json:"enabledEventTypes"`
+	AdminEventsEnabled        bool     `json:"adminEventsEnabled"`
+	AdminEventsDetailsEnabled bool     `json:"adminEventsDetailsEnabled"`
+}
+
+// UpdateRealmEventsConfigRequest changes the event settings of a realm; omitted fields are left unchanged
+type UpdateRealmEventsConfigRequest struct {
+	EventsEnabled             *bool    `json:"events_enabled,omitempty"`
+	EventsExpiration          *int64   `json:"events_expiration,omitempty"`
+	EventsListeners           []string `json:"events_listeners,omitempty"`
+	EnabledEventTypes         []string `json:"enabled_event_types,omitempty"`
+	AdminEventsEnabled        *bool    `json:"admin_events_enabled,omitempty"`
+	AdminEventsDetailsEnabled *bool    `json:"admin_events_details_enabled,omitempty"`
+}
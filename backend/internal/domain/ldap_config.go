@@ -8,7 +8,8 @@ type LDAPConfig struct {
 	Enabled             bool                   `json:"enabled"`
 	ServerURL           string                 `json:"server_url"`
 	BindDN              string                 `json:"bind_dn"`
-	BindPassword        string                 `json:"bind_password,omitempty"` // Omit from JSON responses
+	BindPassword        string                 `json:"-"`                     // Never returned by the API
+	BindPasswordRef     string                 `json:"bind_password_ref,omitempty"` // Reference to the bind password in an external store; empty keeps it encrypted in the database
 	UserSearchBase      string                 `json:"user_search_base"`
 	UserSearchFilter    string                 `json:"user_search_filter"`
 	GroupSearchBase     string                 `json:"group_search_base,omitempty"`
@@ -43,6 +44,10 @@ type UpdateLDAPConfigRequest struct {
 	ServerURL         string `json:"server_url" validate:"required"`
 	BindDN            string `json:"bind_dn" validate:"required"`
 	BindPassword      string `json:"bind_password"`
+	// BindPasswordRef reads the bind password from an external store instead of the database, e.g.
+	// "vault:ldap/app#password". Left out it stays as it is; an empty string moves the password back
+	// into the database, which then needs bind_password.
+	BindPasswordRef   *string `json:"bind_password_ref,omitempty"`
 	UserSearchBase    string `json:"user_search_base" validate:"required"`
 	UserSearchFilter  string `json:"user_search_filter"`
 	GroupSearchBase   string `json:"group_search_base"`
@@ -0,0 +1,16 @@
+package domain
+
+// RestoreRequest selects what to restore from a snapshot into the cluster it was taken from
+type RestoreRequest struct {
+	ObjectType  string `json:"object_type" validate:"required,oneof=role client group user"`
+	ObjectName  string `json:"object_name,omitempty"`  // Empty restores every object of the type that was deleted or changed since the snapshot
+	RemoveExtra bool   `json:"remove_extra,omitempty"` // Remove role mappings, composites and group memberships the snapshot does not have
+}
+
+// RestorePreview is a pending restore: the snapshot compared with the live realm and the plan that reverts it.
+// The plan is applied through the sync plan endpoints.
+type RestorePreview struct {
+	Snapshot *RealmSnapshot `json:"snapshot"`
+	Diff     *CompareResult `json:"diff"`           // Limited to the restored objects
+	Plan     *SyncPlan      `json:"plan,omitempty"` // Not set when the live realm already matches the snapshot
+}
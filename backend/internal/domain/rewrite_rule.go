@@ -0,0 +1,54 @@
+package domain
+
+import "time"
+
+// RewriteRule replaces environment specific values (hosts, URLs, attribute values) while objects
+// are copied from a source cluster to a destination cluster by sync or import.
+// A rule is scoped to a cluster pair and/or an environment tag pair; unset scope fields match any cluster.
+type RewriteRule struct {
+	ID                   int       `json:"id"`
+	Name                 string    `json:"name"`
+	Description          string    `json:"description,omitempty"`
+	SourceClusterID      *int      `json:"source_cluster_id,omitempty"`
+	DestinationClusterID *int      `json:"destination_cluster_id,omitempty"`
+	SourceTagID          *int      `json:"source_tag_id,omitempty"`
+	DestinationTagID     *int      `json:"destination_tag_id,omitempty"`
+	ObjectType           string    `json:"object_type"` // "client", "user", "group"
+	Field                string    `json:"field"`       // e.g. "redirectUris", "rootUrl", "attributes.post.logout.redirect.uris" or "attributes.*"
+	Match                string    `json:"match"`
+	Replace              string    `json:"replace"`
+	IsRegex              bool      `json:"is_regex"` // Match is a regular expression and Replace may reference groups ($1)
+	Priority             int       `json:"priority"` // Lower priorities are applied first
+	Enabled              bool      `json:"enabled"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+type CreateRewriteRuleRequest struct {
+	Name                 string `json:"name" validate:"required"`
+	Description          string `json:"description,omitempty"`
+	SourceClusterID      *int   `json:"source_cluster_id,omitempty"`
+	DestinationClusterID *int   `json:"destination_cluster_id,omitempty"`
+	SourceTagID          *int   `json:"source_tag_id,omitempty"`
+	DestinationTagID     *int   `json:"destination_tag_id,omitempty"`
+	ObjectType           string `json:"object_type" validate:"required"`
+	Field                string `json:"field" validate:"required"`
+	Match                string `json:"match" validate:"required"`
+	Replace              string `json:"replace"`
+	IsRegex              bool   `json:"is_regex"`
+	Priority             int    `json:"priority"`
+	Enabled              *bool  `json:"enabled,omitempty"` // Defaults to true
+}
+
+// RewritePreviewRequest applies the rules of a cluster pair to a single representation without storing anything
+type RewritePreviewRequest struct {
+	SourceClusterID      int                    `json:"source_cluster_id"`
+	DestinationClusterID int                    `json:"destination_cluster_id"`
+	ObjectType           string                 `json:"object_type"`
+	Representation       map[string]interface{} `json:"representation"`
+}
+
+type RewritePreviewResult struct {
+	Representation map[string]interface{} `json:"representation"`
+	Rewrites       []SyncFieldChange      `json:"rewrites"`
+}
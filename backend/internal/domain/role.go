@@ -4,19 +4,30 @@ import "time"
 
 // Role represents a Keycloak role (different from AppRole)
 type Role struct {
-	ID          string `json:"id,omitempty"`
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
-	Composite   bool   `json:"composite,omitempty"`
+	ID          string              `json:"id,omitempty"`
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Composite   bool                `json:"composite,omitempty"`
+	Attributes  map[string][]string `json:"attributes,omitempty"`
+	Composites  *RoleComposites     `json:"composites,omitempty"` // Only filled by detailed role fetches
+}
+
+// RoleComposites lists the direct children of a composite role, in the same shape Keycloak uses in realm exports
+type RoleComposites struct {
+	Realm  []string            `json:"realm,omitempty"`
+	Client map[string][]string `json:"client,omitempty"` // clientId -> roles
 }
 
 // RoleDiff represents differences between roles in two clusters
 type RoleDiff struct {
-	Role        Role   `json:"role"`
-	Source      string `json:"source"`
-	Destination string `json:"destination"`
-	Status      string `json:"status"` // "missing_in_destination", "missing_in_source", "different_config"
-	Side        string `json:"side"`   // "source" or "destination" - which side this diff is about
+	Role             Role                   `json:"role"`
+	Source           string                 `json:"source"`
+	Destination      string                 `json:"destination"`
+	Status           string                 `json:"status"` // "missing_in_destination", "missing_in_source", "different_config"
+	Side             string                 `json:"side"`   // "source" or "destination" - which side this diff is about
+	Differences      []string               `json:"differences,omitempty"`
+	SourceValue      map[string]interface{} `json:"sourceValue,omitempty"`
+	DestinationValue map[string]interface{} `json:"destinationValue,omitempty"`
 }
 
 // Permission represents a system permission
@@ -27,26 +38,64 @@ type Permission struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// Scopes of an application role: the clusters its permissions apply to
+const (
+	RoleScopeAll      = "all"      // Every cluster
+	RoleScopeClusters = "clusters" // The clusters in ScopeClusterIDs
+	RoleScopeTags     = "tags"     // The clusters that carry one of the environment tags in ScopeTagIDs
+)
+
+// ClusterPermissions are the permissions that apply to a cluster. A role with a scope grants them only on
+// the clusters of its scope and does not grant any other permission, such as managing roles or app users.
+var ClusterPermissions = map[string]bool{
+	"view_clusters":       true,
+	"view_cluster_detail": true,
+	"update_cluster":      true,
+	"delete_cluster":      true,
+	"view_diff":           true,
+	"sync_items":          true,
+}
+
 // AppRole represents an application role (different from Keycloak Role)
 type AppRole struct {
-	ID          int          `json:"id"`
-	Name        string       `json:"name"`
-	Description string       `json:"description,omitempty"`
-	Permissions []Permission `json:"permissions,omitempty"`
-	CreatedAt   time.Time    `json:"created_at"`
-	UpdatedAt   time.Time   `json:"updated_at"`
+	ID              int          `json:"id"`
+	Name            string       `json:"name"`
+	Description     string       `json:"description,omitempty"`
+	Permissions     []Permission `json:"permissions,omitempty"`
+	Scope           string       `json:"scope"`
+	ScopeClusterIDs []int        `json:"scope_cluster_ids,omitempty"`
+	ScopeTagIDs     []int        `json:"scope_tag_ids,omitempty"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+}
+
+// PermissionGrant tells on which clusters a user holds a permission through their roles
+type PermissionGrant struct {
+	AllClusters bool
+	ClusterIDs  map[int]bool
+}
+
+// Allows tells whether the grant covers a cluster
+func (g *PermissionGrant) Allows(clusterID int) bool {
+	return g != nil && (g.AllClusters || g.ClusterIDs[clusterID])
 }
 
 type CreateRoleRequest struct {
-	Name        string   `json:"name" validate:"required,min=3,max=100"`
-	Description string   `json:"description,omitempty"`
-	PermissionIDs []int  `json:"permission_ids,omitempty"`
+	Name            string `json:"name" validate:"required,min=3,max=100"`
+	Description     string `json:"description,omitempty"`
+	PermissionIDs   []int  `json:"permission_ids,omitempty"`
+	Scope           string `json:"scope,omitempty"` // Defaults to all
+	ScopeClusterIDs []int  `json:"scope_cluster_ids,omitempty"`
+	ScopeTagIDs     []int  `json:"scope_tag_ids,omitempty"`
 }
 
 type UpdateRoleRequest struct {
-	Name        string   `json:"name,omitempty"`
-	Description string   `json:"description,omitempty"`
-	PermissionIDs []int  `json:"permission_ids,omitempty"`
+	Name            string `json:"name,omitempty"`
+	Description     string `json:"description,omitempty"`
+	PermissionIDs   []int  `json:"permission_ids,omitempty"`
+	Scope           string `json:"scope,omitempty"` // Empty keeps the scope; otherwise the cluster and tag IDs are replaced too
+	ScopeClusterIDs []int  `json:"scope_cluster_ids,omitempty"`
+	ScopeTagIDs     []int  `json:"scope_tag_ids,omitempty"`
 }
 
 type AssignRoleRequest struct {
@@ -0,0 +1,56 @@
+package domain
+
+import "time"
+
+const (
+	SecretRotationSucceeded = "succeeded"
+	SecretRotationFailed    = "failed"
+	SecretRotationSkipped   = "skipped"
+
+	SecretRotationManual    = "manual"
+	SecretRotationScheduled = "scheduled"
+)
+
+// SecretRotation records one rotation of a client secret. Skipped rotations are only reported, not stored.
+type SecretRotation struct {
+	ID          int    `json:"id"`
+	ClusterID   int    `json:"cluster_id"`
+	ClusterName string `json:"cluster_name,omitempty"`
+	ClientID    string `json:"client_id"`
+	Status      string `json:"status"`
+	Trigger     string `json:"trigger"` // manual or scheduled
+	Error       string `json:"error,omitempty"`
+	RotatedBy   *int   `json:"rotated_by,omitempty"`
+	// Sink is where the new secret of an application client was delivered to
+	Sink string `json:"sink,omitempty"`
+	// PreviousSecretExpiresAt is set while Keycloak still accepts the previous secret (dual-secret rotation)
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	CreatedAt               time.Time  `json:"created_at"`
+}
+
+// RotateClusterSecretsRequest selects the clusters whose multi-manage client secret is rotated:
+// the listed clusters or all clusters with the environment tag
+type RotateClusterSecretsRequest struct {
+	ClusterIDs []int `json:"cluster_ids"`
+	TagID      *int  `json:"tag_id"`
+}
+
+type RotateClusterSecretsResult struct {
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Skipped   int               `json:"skipped"`
+	Rotations []*SecretRotation `json:"rotations"`
+}
+
+// RotateClientSecretRequest regenerates the secret of an application client and delivers it to a sink
+// instead of returning it. ClusterIDs and TagID select the clusters when several are rotated at once.
+type RotateClientSecretRequest struct {
+	ClientID string `json:"client_id"`
+	Sink     string `json:"sink"` // file, webhook or vault, as configured on the server
+	// InvalidatePrevious ends a dual-secret grace period once the new secret was delivered
+	InvalidatePrevious bool `json:"invalidate_previous"`
+	// DeliverOnly delivers the current secret again without regenerating it, e.g. after a failed delivery
+	DeliverOnly bool  `json:"deliver_only"`
+	ClusterIDs  []int `json:"cluster_ids,omitempty"`
+	TagID       *int  `json:"tag_id,omitempty"`
+}
@@ -0,0 +1,57 @@
+package domain
+
+import "time"
+
+const (
+	SnapshotSectionRealm         = "realm"
+	SnapshotSectionRoles         = "roles"
+	SnapshotSectionClients       = "clients"
+	SnapshotSectionClientExports = "client_exports"
+	SnapshotSectionGroups        = "groups"
+	SnapshotSectionUsers         = "users"
+
+	SnapshotTriggerScheduled = "scheduled"
+	SnapshotTriggerManual    = "manual"
+)
+
+// SnapshotSections lists every section stored in a snapshot
+var SnapshotSections = []string{
+	SnapshotSectionRealm,
+	SnapshotSectionRoles,
+	SnapshotSectionClients,
+	SnapshotSectionClientExports,
+	SnapshotSectionGroups,
+	SnapshotSectionUsers,
+}
+
+// RealmSnapshot is a versioned, point-in-time record of a cluster's realm.
+// Each section is stored once per distinct content and referenced by its sha256 hash.
+type RealmSnapshot struct {
+	ID             int               `json:"id"`
+	ClusterID      int               `json:"cluster_id"`
+	Realm          string            `json:"realm"`
+	Version        int               `json:"version"`
+	ContentHash    string            `json:"content_hash"`
+	Sections       map[string]string `json:"sections"` // section name -> blob hash
+	Counts         map[string]int    `json:"counts"`
+	Trigger        string            `json:"trigger"`
+	CreatedBy      *int              `json:"created_by,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	LastVerifiedAt time.Time         `json:"last_verified_at"` // Last time the realm was found unchanged
+}
+
+// RealmSnapshotContent is the stored state of a realm
+type RealmSnapshotContent struct {
+	Realm         map[string]interface{}   `json:"realm,omitempty"`
+	Roles         []Role                   `json:"roles,omitempty"`
+	Clients       []ClientDetail           `json:"clients,omitempty"`
+	ClientExports []map[string]interface{} `json:"client_exports,omitempty"` // Full client representations
+	Groups        []GroupDetail            `json:"groups,omitempty"`
+	Users         []UserDetail             `json:"users,omitempty"`
+}
+
+// TakeSnapshotResult tells whether a new version was stored or the latest one was still current
+type TakeSnapshotResult struct {
+	Snapshot *RealmSnapshot `json:"snapshot"`
+	Created  bool           `json:"created"`
+}
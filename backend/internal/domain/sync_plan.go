@@ -0,0 +1,117 @@
+package domain
+
+import "time"
+
+// Sync plan step actions
+const (
+	SyncActionCreateRole              = "create_role"
+	SyncActionUpdateRole              = "update_role"
+	SyncActionAddRoleComposites       = "add_role_composites"
+	SyncActionRemoveRoleComposites    = "remove_role_composites"
+	SyncActionImportClient            = "import_client"
+	SyncActionCreateClientRole        = "create_client_role"
+	SyncActionAddClientRoleComposites = "add_client_role_composites"
+	SyncActionCreateClientScope       = "create_client_scope"
+	SyncActionCreateScopeMapper       = "create_scope_mapper"
+	SyncActionAssignClientScopes      = "assign_client_scopes"
+	SyncActionCreateGroup             = "create_group"
+	SyncActionUpdateGroup             = "update_group"
+	SyncActionAssignGroupRoles        = "assign_group_realm_roles"
+	SyncActionAssignGroupClientRoles  = "assign_group_client_roles"
+	SyncActionRemoveGroupRoles        = "remove_group_realm_roles"
+	SyncActionRemoveGroupClientRoles  = "remove_group_client_roles"
+	SyncActionCreateUser              = "create_user"
+	SyncActionUpdateUser              = "update_user"
+	SyncActionAssignUserRoles         = "assign_user_realm_roles"
+	SyncActionAssignUserClientRoles   = "assign_user_client_roles"
+	SyncActionRemoveUserRoles         = "remove_user_realm_roles"
+	SyncActionRemoveUserClientRoles   = "remove_user_client_roles"
+	SyncActionAddUserToGroup          = "add_user_to_group"
+	SyncActionRemoveUserFromGroup     = "remove_user_from_group"
+	SyncActionDeleteRole              = "delete_role"
+	SyncActionDeleteClient            = "delete_client"
+	SyncActionDeleteGroup             = "delete_group"
+)
+
+// Sync plan statuses
+const (
+	SyncPlanStatusPending  = "pending"
+	SyncPlanStatusApplying = "applying"
+	SyncPlanStatusApplied  = "applied"
+	SyncPlanStatusFailed   = "failed"
+)
+
+// SyncPlanStep describes a single Admin REST call that a sync would make against the destination cluster
+type SyncPlanStep struct {
+	Order       int                    `json:"order"`
+	Action      string                 `json:"action"`
+	Method      string                 `json:"method"`
+	Path        string                 `json:"path"`
+	Target      string                 `json:"target"`           // Object the call acts on (role name, clientId, scope name, ...)
+	Parent      string                 `json:"parent,omitempty"` // Owning object, e.g. the clientId of a client role or the scope of a mapper
+	Description string                 `json:"description"`
+	Optional    bool                   `json:"optional,omitempty"`    // Failure is reported as a warning and does not stop the plan
+	RequiredBy  string                 `json:"required_by,omitempty"` // Set on prerequisite steps pulled in by another object, e.g. "user:alice"
+	Payload     map[string]interface{} `json:"payload,omitempty"`
+	Changes     []SyncFieldChange      `json:"changes,omitempty"`     // Fields of an existing destination object this step changes
+	Rewrites    []SyncFieldChange      `json:"rewrites,omitempty"`    // Source values replaced by rewrite rules before they were put in the payload
+	CopySecret  bool                   `json:"copy_secret,omitempty"` // The client secret is left out of the payload and read from the source when the step is applied
+}
+
+// SyncFieldChange describes one field of an existing destination object that a sync changes
+type SyncFieldChange struct {
+	Object string      `json:"object"` // e.g. "user:alice", "group:/staff"
+	Field  string      `json:"field"`
+	From   interface{} `json:"from"`
+	To     interface{} `json:"to"`
+}
+
+// SyncResult reports what a direct sync of one object did
+type SyncResult struct {
+	Dependencies []SyncDependency  `json:"dependencies,omitempty"`
+	Changes      []SyncFieldChange `json:"changes,omitempty"`
+	Steps        []SyncStepResult  `json:"steps"`
+}
+
+// SyncDependency is a prerequisite object that a sync creates because the requested object references it
+type SyncDependency struct {
+	ObjectType string `json:"object_type"` // "role", "client", "client_role", "group"
+	Name       string `json:"name"`
+	RequiredBy string `json:"required_by"`
+}
+
+// SyncStepResult is the outcome of executing a single plan step
+type SyncStepResult struct {
+	Order  int    `json:"order"`
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Status string `json:"status"` // "success", "failed", "skipped"
+	Error  string `json:"error,omitempty"`
+}
+
+// SyncPlan is a reviewable list of changes a sync would make in the destination cluster
+type SyncPlan struct {
+	ID                   int              `json:"id"`
+	SourceClusterID      int              `json:"source_cluster_id"`
+	SourceSnapshotID     *int             `json:"source_snapshot_id,omitempty"` // Set on restore plans, whose source is a stored snapshot
+	DestinationClusterID int              `json:"destination_cluster_id"`
+	ObjectType           string           `json:"object_type"` // "role", "client", "group", "user"
+	ObjectName           string           `json:"object_name"`
+	Steps                []SyncPlanStep   `json:"steps"`
+	Dependencies         []SyncDependency `json:"dependencies,omitempty"` // Missing prerequisites the plan creates first
+	Status               string           `json:"status"`                 // "pending", "applying", "applied", "failed"
+	Results              []SyncStepResult `json:"results,omitempty"`
+	CreatedBy            *int             `json:"created_by,omitempty"`
+	AppliedBy            *int             `json:"applied_by,omitempty"`
+	CreatedAt            time.Time        `json:"created_at"`
+	AppliedAt            *time.Time       `json:"applied_at,omitempty"`
+}
+
+// CreateSyncPlanRequest represents a request to compute a sync plan without applying it
+type CreateSyncPlanRequest struct {
+	SourceClusterID      int    `json:"source_cluster_id" validate:"required"`
+	DestinationClusterID int    `json:"destination_cluster_id" validate:"required"`
+	ObjectType           string `json:"object_type" validate:"required,oneof=role client group user"`
+	ObjectName           string `json:"object_name" validate:"required"`
+	RemoveExtra          bool   `json:"remove_extra,omitempty"` // Remove role mappings, composites and group memberships the source does not have
+}
@@ -0,0 +1,45 @@
+package domain
+
+const (
+	UserImportCreated = "created"
+	UserImportUpdated = "updated"
+	UserImportFailed  = "failed"
+	UserImportValid   = "valid" // Validation-only runs
+)
+
+// UserImportResult reports an NDJSON or CSV user import record by record
+type UserImportResult struct {
+	ValidateOnly bool               `json:"validate_only,omitempty"`
+	Rejected     bool               `json:"rejected,omitempty"` // CSV rows failed validation, so nothing was imported
+	Total        int                `json:"total"`
+	Valid        int                `json:"valid,omitempty"`
+	Created      int                `json:"created"`
+	Updated      int                `json:"updated"`
+	Failed       int                `json:"failed"`
+	Records      []UserImportRecord `json:"records"`
+	Rewrites     []SyncFieldChange  `json:"rewrites,omitempty"`
+}
+
+// UserImportRecord is the outcome of one line of an NDJSON import or one row of a CSV import
+type UserImportRecord struct {
+	Line     int    `json:"line"`
+	Username string `json:"username,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// UserCSVMapping maps CSV columns to user fields.
+// Fields are username, email, firstName, lastName, enabled, emailVerified, attributes.<name>,
+// groups, realmRoles, requiredActions and password.
+type UserCSVMapping struct {
+	Columns           []UserCSVColumn `json:"columns,omitempty"`            // Empty uses the header names as field names
+	Delimiter         string          `json:"delimiter,omitempty"`          // Default ","
+	ListSeparator     string          `json:"list_separator,omitempty"`     // Separates groups, roles, actions and attribute values in one cell; default ";"
+	PasswordPermanent bool            `json:"password_permanent,omitempty"` // Passwords are temporary unless set
+	RequiredActions   []string        `json:"required_actions,omitempty"`   // Added to every imported user
+}
+
+type UserCSVColumn struct {
+	Header string `json:"header"`
+	Field  string `json:"field"`
+}
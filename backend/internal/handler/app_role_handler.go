@@ -76,10 +76,12 @@ func (h *AppRoleHandler) UpdateRole(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	before, _ := h.service.GetRoleByID(id)
 	role, err := h.service.UpdateRole(id, &req)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
+	auditChange(c, "app-roles", role.Name, before, role)
 	return c.JSON(role)
 }
 
@@ -89,10 +91,14 @@ func (h *AppRoleHandler) DeleteRole(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid role ID"})
 	}
 
+	before, _ := h.service.GetRoleByID(id)
 	err = h.service.DeleteRole(id)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
+	if before != nil {
+		auditChange(c, "app-roles", before.Name, before, nil)
+	}
 	return c.Status(200).JSON(fiber.Map{"message": "Role deleted successfully"})
 }
 
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+)
+
+type BulkSyncHandler struct {
+	service        *service.BulkSyncService
+	jobService     *service.JobService
+	appRoleService *service.AppRoleService
+}
+
+func NewBulkSyncHandler(service *service.BulkSyncService, appRoleService *service.AppRoleService) *BulkSyncHandler {
+	return &BulkSyncHandler{service: service, appRoleService: appRoleService}
+}
+
+// SetJobService enables running bulk syncs as background jobs
+func (h *BulkSyncHandler) SetJobService(jobService *service.JobService) {
+	h.jobService = jobService
+}
+
+// Run syncs a whole diff selection from source to destination and returns per-item results.
+// With async=true the sync runs as a background job and the job is returned instead.
+func (h *BulkSyncHandler) Run(c *fiber.Ctx) error {
+	var req domain.BulkSyncRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	
+	if req.SourceClusterID == 0 || req.DestinationClusterID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "source_cluster_id and destination_cluster_id are required"})
+	}
+	if req.SourceClusterID == req.DestinationClusterID {
+		return c.Status(400).JSON(fiber.Map{"error": "source and destination clusters must be different"})
+	}
+	switch req.Selection {
+	case domain.BulkSelectMissing, domain.BulkSelectDifferent:
+	case domain.BulkSelectExplicit:
+		if len(req.Items) == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "items are required for explicit selection"})
+		}
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "selection must be one of missing_in_destination, different_config, explicit"})
+	}
+	if err := requireClusterPermission(c, h.appRoleService, "sync_items", req.SourceClusterID, req.DestinationClusterID); err != nil {
+		return err
+	}
+	
+	if c.QueryBool("async") {
+		return submitJob(c, h.jobService, domain.JobTypeBulkSync, req)
+	}
+	
+	result, err := h.service.Run(req)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	
+	return c.JSON(result)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+)
+
+// requireClusterPermission checks a permission on clusters a request names outside the :id route parameter,
+// e.g. in its body or through a stored sync plan, snapshot or drift pair. A non-nil result is the error response.
+func requireClusterPermission(c *fiber.Ctx, roleService *service.AppRoleService, permissionName string, clusterIDs ...int) error {
+	user, ok := c.Locals("user").(*domain.User)
+	if !ok || user == nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	err := roleService.CheckClusterPermission(user.ID, permissionName, clusterIDs...)
+	if errors.Is(err, service.ErrClusterPermissionDenied) {
+		return fiber.NewError(fiber.StatusForbidden, err.Error())
+	}
+	return err
+}
+
+// permissionGrant returns the clusters the current user holds a permission on, to filter lists by them
+func permissionGrant(c *fiber.Ctx, roleService *service.AppRoleService, permissionName string) (*domain.PermissionGrant, error) {
+	user, ok := c.Locals("user").(*domain.User)
+	if !ok || user == nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	grant, err := roleService.GetPermissionGrant(user.ID, permissionName)
+	if err != nil {
+		return nil, err
+	}
+	if grant == nil {
+		grant = &domain.PermissionGrant{}
+	}
+	return grant, nil
+}
+
+// grantedClusterIDs lists the clusters of a grant that does not cover all clusters, in ID order
+func grantedClusterIDs(grant *domain.PermissionGrant) []int {
+	ids := make([]int, 0, len(grant.ClusterIDs))
+	for id := range grant.ClusterIDs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
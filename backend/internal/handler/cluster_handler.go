@@ -1,18 +1,21 @@
 package handler
 
 import (
+	"errors"
 	"github.com/gofiber/fiber/v2"
 	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/service"
 	"strconv"
+	"strings"
 )
 
 type ClusterHandler struct {
-	service *service.ClusterService
+	service        *service.ClusterService
+	appRoleService *service.AppRoleService
 }
 
-func NewClusterHandler(service *service.ClusterService) *ClusterHandler {
-	return &ClusterHandler{service: service}
+func NewClusterHandler(service *service.ClusterService, appRoleService *service.AppRoleService) *ClusterHandler {
+	return &ClusterHandler{service: service, appRoleService: appRoleService}
 }
 
 func (h *ClusterHandler) Create(c *fiber.Ctx) error {
@@ -27,19 +30,34 @@ func (h *ClusterHandler) Create(c *fiber.Ctx) error {
 	
 	cluster, err := h.service.Create(req)
 	if err != nil {
+		if strings.HasPrefix(err.Error(), "invalid") {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	
 	return c.Status(201).JSON(cluster)
 }
 
+// GetAll lists the clusters the user may view
 func (h *ClusterHandler) GetAll(c *fiber.Ctx) error {
+	grant, err := permissionGrant(c, h.appRoleService, "view_clusters")
+	if err != nil {
+		return err
+	}
+	
 	clusters, err := h.service.GetAll()
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	
-	return c.JSON(clusters)
+	visible := make([]*domain.Cluster, 0, len(clusters))
+	for _, cluster := range clusters {
+		if grant.Allows(cluster.ID) {
+			visible = append(visible, cluster)
+		}
+	}
+	return c.JSON(visible)
 }
 
 func (h *ClusterHandler) GetByID(c *fiber.Ctx) error {
@@ -75,11 +93,19 @@ func (h *ClusterHandler) Update(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Missing required fields: name, base_url, master_username, master_password"})
 	}
 	
+	before, _ := h.service.GetByID(id)
 	cluster, err := h.service.Update(id, req)
 	if err != nil {
+		if errors.Is(err, service.ErrSecretRotationInProgress) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		if strings.HasPrefix(err.Error(), "invalid") {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	
+	auditChange(c, "clusters", cluster.Name, before, cluster)
 	return c.JSON(cluster)
 }
 
@@ -89,9 +115,13 @@ func (h *ClusterHandler) Delete(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid cluster ID"})
 	}
 	
+	before, _ := h.service.GetByID(id)
 	if err := h.service.Delete(id); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	if before != nil {
+		auditChange(c, "clusters", before.Name, before, nil)
+	}
 	
 	return c.Status(204).Send(nil)
 }
@@ -315,6 +345,24 @@ func (h *ClusterHandler) Search(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid search_type. Must be 'user', 'client', or 'role'"})
 	}
 	
+	// Without cluster_ids a user with a scoped role searches the clusters of the scope
+	if len(req.ClusterIDs) > 0 {
+		if err := requireClusterPermission(c, h.appRoleService, "view_clusters", req.ClusterIDs...); err != nil {
+			return err
+		}
+	} else {
+		grant, err := permissionGrant(c, h.appRoleService, "view_clusters")
+		if err != nil {
+			return err
+		}
+		if !grant.AllClusters {
+			req.ClusterIDs = grantedClusterIDs(grant)
+			if len(req.ClusterIDs) == 0 {
+				return c.JSON(domain.SearchResponse{Query: req.Query, SearchType: req.SearchType, Results: []domain.SearchResult{}})
+			}
+		}
+	}
+	
 	result, err := h.service.Search(req)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/service"
+)
+
+type DesiredStateHandler struct {
+	service *service.DesiredStateService
+}
+
+func NewDesiredStateHandler(service *service.DesiredStateService) *DesiredStateHandler {
+	return &DesiredStateHandler{service: service}
+}
+
+// Apply reconciles a cluster with the desired-state document in the request body (YAML or JSON).
+// dry_run=true only returns the diff and the planned steps; prune=true also deletes unlisted objects.
+func (h *DesiredStateHandler) Apply(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid cluster ID"})
+	}
+
+	state, err := service.ParseDesiredState(c.Body())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result, err := h.service.Apply(clusterID, state, c.QueryBool("prune"), c.QueryBool("dry_run"))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if strings.HasPrefix(err.Error(), "every ") || strings.HasPrefix(err.Error(), "document is for realm") {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if result.Status == "failed" {
+		return c.Status(500).JSON(result)
+	}
+	return c.JSON(result)
+}
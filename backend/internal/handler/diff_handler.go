@@ -2,16 +2,19 @@ package handler
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/service"
 	"strconv"
+	"strings"
 )
 
 type DiffHandler struct {
-	service *service.DiffService
+	service        *service.DiffService
+	appRoleService *service.AppRoleService
 }
 
-func NewDiffHandler(service *service.DiffService) *DiffHandler {
-	return &DiffHandler{service: service}
+func NewDiffHandler(service *service.DiffService, appRoleService *service.AppRoleService) *DiffHandler {
+	return &DiffHandler{service: service, appRoleService: appRoleService}
 }
 
 func (h *DiffHandler) GetRoleDiff(c *fiber.Ctx) error {
@@ -90,3 +93,43 @@ func (h *DiffHandler) GetUserDiff(c *fiber.Ctx) error {
 	return c.JSON(diffs)
 }
 
+
+// Compare diffs two sides that are each a live cluster, a stored snapshot or an uploaded realm export
+func (h *DiffHandler) Compare(c *fiber.Ctx) error {
+	var req domain.CompareRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if err := service.ValidateDiffSource(req.Source); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "source: " + err.Error()})
+	}
+	if err := service.ValidateDiffSource(req.Destination); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "destination: " + err.Error()})
+	}
+
+	// Both sides are checked, also when they are snapshots of a cluster
+	var clusterIDs []int
+	for _, src := range []domain.DiffSource{req.Source, req.Destination} {
+		clusterID, err := h.service.DiffSourceClusterID(src)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		clusterIDs = append(clusterIDs, clusterID)
+	}
+	if err := requireClusterPermission(c, h.appRoleService, "view_diff", clusterIDs...); err != nil {
+		return err
+	}
+
+	result, err := h.service.Compare(req)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(result)
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+)
+
+type DriftHandler struct {
+	service        *service.DriftService
+	appRoleService *service.AppRoleService
+}
+
+func NewDriftHandler(service *service.DriftService, appRoleService *service.AppRoleService) *DriftHandler {
+	return &DriftHandler{service: service, appRoleService: appRoleService}
+}
+
+// GetPairs lists the cluster pairs the user may diff with the drift count of their latest run
+func (h *DriftHandler) GetPairs(c *fiber.Ctx) error {
+	grant, err := permissionGrant(c, h.appRoleService, "view_diff")
+	if err != nil {
+		return err
+	}
+
+	pairs, err := h.service.GetPairs()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	visible := make([]*domain.ClusterPair, 0, len(pairs))
+	for _, pair := range pairs {
+		if grant.Allows(pair.SourceClusterID) && grant.Allows(pair.DestinationClusterID) {
+			visible = append(visible, pair)
+		}
+	}
+	return c.JSON(visible)
+}
+
+func (h *DriftHandler) GetPair(c *fiber.Ctx) error {
+	pair, err := h.findPair(c, c.Params("id"))
+	if err != nil || pair == nil {
+		return err
+	}
+	return c.JSON(pair)
+}
+
+func (h *DriftHandler) CreatePair(c *fiber.Ctx) error {
+	var req domain.CreateClusterPairRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	pair, err := h.service.CreatePair(req)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(201).JSON(pair)
+}
+
+func (h *DriftHandler) UpdatePair(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid pair ID"})
+	}
+
+	var req domain.CreateClusterPairRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	pair, err := h.service.UpdatePair(id, req)
+	if err != nil {
+		if err.Error() == "cluster pair not found" {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(pair)
+}
+
+func (h *DriftHandler) DeletePair(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid pair ID"})
+	}
+
+	if err := h.service.DeletePair(id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(204).Send(nil)
+}
+
+// RunPair diffs a pair immediately
+func (h *DriftHandler) RunPair(c *fiber.Ctx) error {
+	pair, err := h.findPair(c, c.Params("id"))
+	if err != nil || pair == nil {
+		return err
+	}
+
+	run, err := h.service.RunPair(pair.ID)
+	if err != nil {
+		if err.Error() == "cluster pair not found" {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(run)
+}
+
+func (h *DriftHandler) GetRuns(c *fiber.Ctx) error {
+	pair, err := h.findPair(c, c.Params("id"))
+	if err != nil || pair == nil {
+		return err
+	}
+
+	runs, err := h.service.GetRuns(pair.ID, c.QueryInt("limit", 50))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(runs)
+}
+
+func (h *DriftHandler) GetRun(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("runId"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid run ID"})
+	}
+
+	run, err := h.service.GetRun(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if run == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Drift run not found"})
+	}
+	if pair, err := h.findPair(c, strconv.Itoa(run.PairID)); err != nil || pair == nil {
+		return err
+	}
+	return c.JSON(run)
+}
+
+// GetChanges reports what drifted since a point in time.
+// since accepts an RFC 3339 timestamp or a duration such as "24h" and defaults to 24 hours ago.
+func (h *DriftHandler) GetChanges(c *fiber.Ctx) error {
+	pair, err := h.findPair(c, c.Params("id"))
+	if err != nil || pair == nil {
+		return err
+	}
+
+	since := time.Now().Add(-24 * time.Hour)
+	if value := c.Query("since"); value != "" {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			since = t
+		} else if d, err := time.ParseDuration(value); err == nil {
+			since = time.Now().Add(-d)
+		} else {
+			return c.Status(400).JSON(fiber.Map{"error": "since must be an RFC 3339 timestamp or a duration like 24h"})
+		}
+	}
+
+	changes, err := h.service.GetChanges(pair.ID, since)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(changes)
+}
+
+// findPair loads a cluster pair the user may diff both clusters of and writes the error response when it cannot
+func (h *DriftHandler) findPair(c *fiber.Ctx, value string) (*domain.ClusterPair, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid pair ID"})
+	}
+
+	pair, err := h.service.GetPair(id)
+	if err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if pair == nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Cluster pair not found"})
+	}
+	if err := requireClusterPermission(c, h.appRoleService, "view_diff", pair.SourceClusterID, pair.DestinationClusterID); err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
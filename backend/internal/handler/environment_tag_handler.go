@@ -68,11 +68,13 @@ func (h *EnvironmentTagHandler) Update(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 	
+	before, _ := h.service.GetByID(id)
 	tag, err := h.service.Update(id, req)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	
+	auditChange(c, "environment-tags", tag.Name, before, tag)
 	return c.JSON(tag)
 }
 
@@ -82,9 +84,13 @@ func (h *EnvironmentTagHandler) Delete(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid tag ID"})
 	}
 	
+	before, _ := h.service.GetByID(id)
 	if err := h.service.Delete(id); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	if before != nil {
+		auditChange(c, "environment-tags", before.Name, before, nil)
+	}
 	
 	return c.Status(204).Send(nil)
 }
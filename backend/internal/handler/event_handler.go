@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+)
+
+const (
+	// eventKeepAliveInterval keeps idle streams open through proxies
+	eventKeepAliveInterval = 15 * time.Second
+	// eventPermissionRefresh is how often a stream re-reads the caller's permissions
+	eventPermissionRefresh = time.Minute
+)
+
+type EventHandler struct {
+	service        *service.EventService
+	appRoleService *service.AppRoleService
+}
+
+func NewEventHandler(service *service.EventService, appRoleService *service.AppRoleService) *EventHandler {
+	return &EventHandler{service: service, appRoleService: appRoleService}
+}
+
+// Stream sends the progress events of long operations as server-sent events.
+// Only events of operations the caller has the permission to start or view, on all of their clusters, are sent.
+// operation, operation_id and cluster_id filter the events; Last-Event-ID (or last_event_id) resends missed ones.
+func (h *EventHandler) Stream(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*domain.User)
+	if !ok || user == nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Authentication required"})
+	}
+
+	grants, err := h.appRoleService.GetUserGrants(user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check permissions"})
+	}
+
+	// The stream outlives the handler, so the query values are copied out of the request buffer
+	filter := eventFilter{
+		operation:   strings.Clone(c.Query("operation")),
+		operationID: strings.Clone(c.Query("operation_id")),
+		clusterID:   c.QueryInt("cluster_id", 0),
+	}
+
+	lastEventID, _ := strconv.ParseInt(c.Get("Last-Event-ID", c.Query("last_event_id")), 10, 64)
+	sub, missed := h.service.Subscribe(lastEventID)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+
+		send := func(event domain.Event) bool {
+			if !eventAllowed(grants[event.Permission], event) || !filter.matches(event) {
+				return true
+			}
+			writeSSEEvent(w, event)
+			return w.Flush() == nil
+		}
+
+		for _, event := range missed {
+			if !send(event) {
+				return
+			}
+		}
+		// Tell the client the stream is open, so it can start the operation it wants to follow
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		keepAlive := time.NewTicker(eventKeepAliveInterval)
+		defer keepAlive.Stop()
+		refresh := time.NewTicker(eventPermissionRefresh)
+		defer refresh.Stop()
+
+		for {
+			select {
+			case event, ok := <-sub.Events:
+				if !ok {
+					// The stream fell behind; the client reconnects with Last-Event-ID and catches up
+					return
+				}
+				if !send(event) {
+					return
+				}
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": ping\n\n")
+				if err := w.Flush(); err != nil {
+					return
+				}
+			case <-refresh.C:
+				updated, err := h.appRoleService.GetUserGrants(user.ID)
+				if err != nil {
+					log.Printf("Event stream: failed to refresh permissions of user %d: %v", user.ID, err)
+					continue
+				}
+				grants = updated
+			}
+		}
+	})
+	return nil
+}
+
+// eventAllowed tells whether a grant of the event's permission covers every cluster the event is about
+func eventAllowed(grant *domain.PermissionGrant, event domain.Event) bool {
+	if grant == nil {
+		return false
+	}
+	for _, clusterID := range event.ClusterIDs {
+		if !grant.Allows(clusterID) {
+			return false
+		}
+	}
+	return true
+}
+
+type eventFilter struct {
+	operation   string
+	operationID string
+	clusterID   int
+}
+
+func (f eventFilter) matches(event domain.Event) bool {
+	if f.operation != "" && f.operation != event.Operation {
+		return false
+	}
+	if f.operationID != "" && f.operationID != event.OperationID {
+		return false
+	}
+	if f.clusterID != 0 {
+		for _, id := range event.ClusterIDs {
+			if id == f.clusterID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// writeSSEEvent writes an event with its ID, so reconnecting clients can resume after it
+func writeSSEEvent(w *bufio.Writer, event domain.Event) {
+	fmt.Fprintf(w, "id: %d\n", event.ID)
+	writeSSE(w, event.Type, event)
+}
@@ -1,13 +1,21 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/service"
-	"strconv"
 )
 
 type ExportImportHandler struct {
-	service *service.ExportImportService
+	service    *service.ExportImportService
+	jobService *service.JobService
 }
 
 func NewExportImportHandler(service *service.ExportImportService) *ExportImportHandler {
@@ -16,6 +24,11 @@ func NewExportImportHandler(service *service.ExportImportService) *ExportImportH
 	}
 }
 
+// SetJobService enables running realm imports and user exports as background jobs
+func (h *ExportImportHandler) SetJobService(jobService *service.JobService) {
+	h.jobService = jobService
+}
+
 // ExportRealm exports realm configuration
 func (h *ExportImportHandler) ExportRealm(c *fiber.Ctx) error {
 	clusterID, err := strconv.Atoi(c.Params("id"))
@@ -33,7 +46,8 @@ func (h *ExportImportHandler) ExportRealm(c *fiber.Ctx) error {
 	return c.Send(jsonData)
 }
 
-// ImportRealm imports realm configuration
+// ImportRealm imports realm configuration.
+// With async=true the import runs as a background job and the job is returned instead.
 func (h *ExportImportHandler) ImportRealm(c *fiber.Ctx) error {
 	clusterID, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
@@ -48,11 +62,24 @@ func (h *ExportImportHandler) ImportRealm(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 	}
 
-	if err := h.service.ImportRealm(clusterID, []byte(req.RealmConfig)); err != nil {
+	if c.QueryBool("async") {
+		if !json.Valid([]byte(req.RealmConfig)) {
+			return c.Status(400).JSON(fiber.Map{"error": "realmConfig is not valid JSON"})
+		}
+		return submitJob(c, h.jobService, domain.JobTypeRealmImport, domain.RealmImportJobPayload{
+			ClusterID:       clusterID,
+			SourceClusterID: c.QueryInt("source", 0),
+			RealmConfig:     json.RawMessage(req.RealmConfig),
+		})
+	}
+
+	// source is the cluster the file was exported from; it selects the rewrite rules to apply
+	rewrites, err := h.service.ImportRealm(clusterID, c.QueryInt("source", 0), []byte(req.RealmConfig))
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.JSON(fiber.Map{"message": "realm imported successfully"})
+	return c.JSON(fiber.Map{"message": "realm imported successfully", "rewrites": rewrites})
 }
 
 // ExportUsers exports all users
@@ -87,11 +114,149 @@ func (h *ExportImportHandler) ImportUsers(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 	}
 
-	if err := h.service.ImportUsers(clusterID, []byte(req.Users)); err != nil {
+	rewrites, err := h.service.ImportUsers(clusterID, c.QueryInt("source", 0), []byte(req.Users))
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.JSON(fiber.Map{"message": "users imported successfully"})
+	return c.JSON(fiber.Map{"message": "users imported successfully", "rewrites": rewrites})
+}
+
+// ExportUsersNDJSON streams all users as newline-delimited JSON
+func (h *ExportImportHandler) ExportUsersNDJSON(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid cluster id"})
+	}
+
+	write, err := h.service.ExportUsersNDJSON(clusterID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set("Content-Type", "application/x-ndjson")
+	c.Set("Content-Disposition", "attachment; filename=users-export.ndjson")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		write(w)
+		w.Flush()
+	})
+	return nil
+}
+
+// StartUsersExport exports all users in a background job. The gzipped NDJSON file is downloaded
+// from the job output once the job succeeded.
+func (h *ExportImportHandler) StartUsersExport(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid cluster id"})
+	}
+
+	return submitJob(c, h.jobService, domain.JobTypeUserExport, domain.UserExportJobPayload{ClusterID: clusterID})
+}
+
+// ImportUsersNDJSON imports users from a newline-delimited JSON body, one user per line.
+// The body is read as it arrives instead of being buffered.
+func (h *ExportImportHandler) ImportUsersNDJSON(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid cluster id"})
+	}
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	result, err := h.service.ImportUsersNDJSON(clusterID, c.QueryInt("source", 0), body)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(result)
+}
+
+// ExportUsersCSV streams all users as CSV. columns selects the user fields (default username, email,
+// firstName, lastName, enabled, groups, realmRoles) and separator joins list values (default ";").
+func (h *ExportImportHandler) ExportUsersCSV(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid cluster id"})
+	}
+
+	var fields []string
+	if columns := c.Query("columns"); columns != "" {
+		for _, field := range strings.Split(columns, ",") {
+			fields = append(fields, strings.TrimSpace(field))
+		}
+	}
+
+	write, err := h.service.ExportUsersCSV(clusterID, fields, c.Query("separator"))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if strings.HasPrefix(err.Error(), "invalid") {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set("Content-Type", "text/csv")
+	c.Set("Content-Disposition", "attachment; filename=users-export.csv")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		write(w)
+		w.Flush()
+	})
+	return nil
+}
+
+// ImportUsersCSV imports users from a CSV file upload (multipart field "file") with an optional
+// column mapping (multipart field "mapping", JSON). With validate_only=true rows are only validated.
+// Nothing is imported when any row is invalid.
+func (h *ExportImportHandler) ImportUsersCSV(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid cluster id"})
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "file is required"})
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "failed to read file"})
+	}
+	defer file.Close()
+
+	var mapping domain.UserCSVMapping
+	if value := c.FormValue("mapping"); value != "" {
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid mapping"})
+		}
+	}
+
+	result, err := h.service.ImportUsersCSV(clusterID, file, mapping, c.QueryBool("validate_only", false))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if strings.HasPrefix(err.Error(), "invalid") {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if result.Rejected {
+		return c.Status(422).JSON(result)
+	}
+	return c.JSON(result)
 }
 
 // ExportClients exports all clients
@@ -126,10 +291,11 @@ func (h *ExportImportHandler) ImportClients(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid request body"})
 	}
 
-	if err := h.service.ImportClients(clusterID, []byte(req.Clients)); err != nil {
+	rewrites, err := h.service.ImportClients(clusterID, c.QueryInt("source", 0), []byte(req.Clients))
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.JSON(fiber.Map{"message": "clients imported successfully"})
+	return c.JSON(fiber.Map{"message": "clients imported successfully", "rewrites": rewrites})
 }
 
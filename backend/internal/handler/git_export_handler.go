@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/service"
+)
+
+type GitExportHandler struct {
+	service *service.GitExportService
+}
+
+func NewGitExportHandler(service *service.GitExportService) *GitExportHandler {
+	return &GitExportHandler{service: service}
+}
+
+// Run exports every cluster into the git repository now instead of waiting for the next scheduled run
+func (h *GitExportHandler) Run(c *fiber.Ctx) error {
+	result, err := h.service.Export()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(result)
+}
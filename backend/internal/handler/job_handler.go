@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+)
+
+// jobStreamPollInterval is how often a job stream checks the job for changes
+const jobStreamPollInterval = time.Second
+
+type JobHandler struct {
+	service        *service.JobService
+	appRoleService *service.AppRoleService
+}
+
+func NewJobHandler(service *service.JobService, appRoleService *service.AppRoleService) *JobHandler {
+	return &JobHandler{service: service, appRoleService: appRoleService}
+}
+
+// GetJobs lists the most recent jobs, optionally filtered by status and type.
+// Users whose permission is scoped to some clusters only see the jobs they started.
+func (h *JobHandler) GetJobs(c *fiber.Ctx) error {
+	grant, err := permissionGrant(c, h.appRoleService, "view_cluster_detail")
+	if err != nil {
+		return err
+	}
+
+	jobs, err := h.service.GetJobs(c.Query("status"), c.Query("type"), c.QueryInt("limit", 100))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if grant.AllClusters {
+		return c.JSON(jobs)
+	}
+
+	userID := currentUserID(c)
+	visible := make([]*domain.Job, 0, len(jobs))
+	for _, job := range jobs {
+		if job.CreatedBy != nil && userID != nil && *job.CreatedBy == *userID {
+			visible = append(visible, job)
+		}
+	}
+	return c.JSON(visible)
+}
+
+func (h *JobHandler) GetJob(c *fiber.Ctx) error {
+	job, err := h.findJob(c, "view_cluster_detail")
+	if err != nil || job == nil {
+		return err
+	}
+	return c.JSON(job)
+}
+
+// GetLogs returns the log lines of a job; after skips the lines up to that log ID
+func (h *JobHandler) GetLogs(c *fiber.Ctx) error {
+	job, err := h.findJob(c, "view_cluster_detail")
+	if err != nil || job == nil {
+		return err
+	}
+
+	logs, err := h.service.GetLogs(job.ID, c.QueryInt("after", 0))
+	if err != nil {
+		return jobError(c, err)
+	}
+	return c.JSON(logs)
+}
+
+// Stream sends the job as server-sent events: a "job" event whenever its status or progress changes,
+// a "log" event per log line, and ends after the job finished
+func (h *JobHandler) Stream(c *fiber.Ctx) error {
+	job, err := h.findJob(c, "view_cluster_detail")
+	if err != nil || job == nil {
+		return err
+	}
+	id := job.ID
+	afterLogID := c.QueryInt("after", 0)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var lastUpdate time.Time
+		for {
+			logs, err := h.service.GetLogs(id, afterLogID)
+			if err != nil {
+				writeSSE(w, "error", fiber.Map{"error": err.Error()})
+				w.Flush()
+				return
+			}
+			for _, entry := range logs {
+				writeSSE(w, "log", entry)
+				afterLogID = entry.ID
+			}
+			if !job.UpdatedAt.Equal(lastUpdate) {
+				writeSSE(w, "job", job)
+				lastUpdate = job.UpdatedAt
+			}
+			// A failed flush means the client went away
+			if err := w.Flush(); err != nil || job.Finished() {
+				return
+			}
+
+			time.Sleep(jobStreamPollInterval)
+			if job, err = h.service.GetJob(id); err != nil {
+				writeSSE(w, "error", fiber.Map{"error": err.Error()})
+				w.Flush()
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// GetOutput downloads the file a job produced, e.g. a user export
+func (h *JobHandler) GetOutput(c *fiber.Ctx) error {
+	job, err := h.findJob(c, "view_cluster_detail")
+	if err != nil || job == nil {
+		return err
+	}
+	id := job.ID
+	if job.Status != domain.JobStatusSucceeded {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("job is %s", job.Status)})
+	}
+
+	contentType, size, err := h.service.GetOutput(id)
+	if err != nil {
+		return jobError(c, err)
+	}
+
+	filename := fmt.Sprintf("job-%d", id)
+	if job.Type == domain.JobTypeUserExport {
+		filename = fmt.Sprintf("users-export-%d.ndjson.gz", id)
+	}
+	c.Set("Content-Type", contentType)
+	c.Set("Content-Disposition", "attachment; filename="+filename)
+
+	// The file is sent chunk by chunk as it is read from the database
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := h.service.WriteOutput(id, w); err != nil {
+			log.Printf("Failed to send the output of job %d: %v", id, err)
+		}
+		w.Flush()
+	})
+	c.Context().Response.Header.SetContentLength(int(size))
+	return nil
+}
+
+// Cancel cancels a queued job right away; a running job stops after its current step
+func (h *JobHandler) Cancel(c *fiber.Ctx) error {
+	job, err := h.findJob(c, "sync_items")
+	if err != nil || job == nil {
+		return err
+	}
+
+	job, err = h.service.Cancel(job.ID)
+	if err != nil {
+		return jobError(c, err)
+	}
+	return c.JSON(job)
+}
+
+// Retry queues a failed or cancelled job again
+func (h *JobHandler) Retry(c *fiber.Ctx) error {
+	job, err := h.findJob(c, "sync_items")
+	if err != nil || job == nil {
+		return err
+	}
+
+	job, err = h.service.Retry(job.ID)
+	if err != nil {
+		return jobError(c, err)
+	}
+	return c.JSON(job)
+}
+
+// findJob loads the job of the :id parameter and writes the error response when it cannot. A job may touch any
+// cluster, so users whose permission is scoped to some clusters only reach the jobs they started.
+func (h *JobHandler) findJob(c *fiber.Ctx, permissionName string) (*domain.Job, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid job ID"})
+	}
+
+	job, err := h.service.GetJob(id)
+	if err != nil {
+		return nil, jobError(c, err)
+	}
+
+	grant, err := permissionGrant(c, h.appRoleService, permissionName)
+	if err != nil {
+		return nil, err
+	}
+	userID := currentUserID(c)
+	if !grant.AllClusters && (job.CreatedBy == nil || userID == nil || *job.CreatedBy != *userID) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "insufficient permissions: the job was started by another user"})
+	}
+
+	return job, nil
+}
+
+func jobError(c *fiber.Ctx, err error) error {
+	if strings.Contains(err.Error(), "not found") {
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+	if strings.HasPrefix(err.Error(), "invalid state") {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// submitJob queues an operation as a background job and answers 202 with the job to poll
+func submitJob(c *fiber.Ctx, jobs *service.JobService, jobType string, payload interface{}) error {
+	if jobs == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "background jobs are not available"})
+	}
+
+	job, err := jobs.Submit(jobType, payload, currentUserID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set("Location", fmt.Sprintf("/api/jobs/%d", job.ID))
+	return c.Status(202).JSON(job)
+}
+
+// writeSSE writes one server-sent event with a JSON payload
+func writeSSE(w *bufio.Writer, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+)
+
+type KeycloakEventHandler struct {
+	service        *service.KeycloakEventService
+	appRoleService *service.AppRoleService
+}
+
+func NewKeycloakEventHandler(service *service.KeycloakEventService, appRoleService *service.AppRoleService) *KeycloakEventHandler {
+	return &KeycloakEventHandler{service: service, appRoleService: appRoleService}
+}
+
+// Search returns a page of the collected events of all clusters, newest first.
+// Users whose view_cluster_detail permission is scoped must pass cluster_id.
+// Filters: cluster_id, kind (login or admin), user (user ID or username), client, ip, type, from, to (RFC 3339), limit and offset.
+func (h *KeycloakEventHandler) Search(c *fiber.Ctx) error {
+	filter := domain.KeycloakEventFilter{
+		ClusterID: c.QueryInt("cluster_id", 0),
+		Kind:      c.Query("kind"),
+		User:      c.Query("user"),
+		Client:    c.Query("client"),
+		IPAddress: c.Query("ip"),
+		Type:      c.Query("type"),
+		Limit:     c.QueryInt("limit", 100),
+		Offset:    c.QueryInt("offset", 0),
+	}
+	// Users with a scoped role search one cluster at a time
+	grant, err := permissionGrant(c, h.appRoleService, "view_cluster_detail")
+	if err != nil {
+		return err
+	}
+	if !grant.AllClusters {
+		if filter.ClusterID == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "cluster_id is required"})
+		}
+		if err := requireClusterPermission(c, h.appRoleService, "view_cluster_detail", filter.ClusterID); err != nil {
+			return err
+		}
+	}
+	if filter.Kind != "" && filter.Kind != domain.KeycloakEventKindLogin && filter.Kind != domain.KeycloakEventKindAdmin {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid kind, expected login or admin"})
+	}
+	for key, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(key)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid " + key + " time, expected RFC 3339"})
+		}
+		*target = &t
+	}
+
+	page, err := h.service.Search(filter)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(page)
+}
+
+// GetCheckpoints shows how far the events of every cluster have been collected and the last collection errors
+func (h *KeycloakEventHandler) GetCheckpoints(c *fiber.Ctx) error {
+	grant, err := permissionGrant(c, h.appRoleService, "view_cluster_detail")
+	if err != nil {
+		return err
+	}
+
+	checkpoints, err := h.service.GetCheckpoints()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	visible := make([]*domain.KeycloakEventCheckpoint, 0, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		if grant.Allows(checkpoint.ClusterID) {
+			visible = append(visible, checkpoint)
+		}
+	}
+	return c.JSON(visible)
+}
+
+// Collect pulls the new events of a cluster right away
+func (h *KeycloakEventHandler) Collect(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid cluster ID"})
+	}
+
+	result, err := h.service.Collect(clusterID)
+	if err != nil {
+		return keycloakEventError(c, err)
+	}
+	return c.JSON(result)
+}
+
+func (h *KeycloakEventHandler) GetConfig(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid cluster ID"})
+	}
+
+	config, err := h.service.GetEventsConfig(clusterID)
+	if err != nil {
+		return keycloakEventError(c, err)
+	}
+	return c.JSON(config)
+}
+
+// UpdateConfig enables or disables login and admin events on a cluster's realm
+func (h *KeycloakEventHandler) UpdateConfig(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid cluster ID"})
+	}
+
+	var req domain.UpdateRealmEventsConfigRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	before, _ := h.service.GetEventsConfig(clusterID)
+	config, err := h.service.UpdateEventsConfig(clusterID, req)
+	if err != nil {
+		return keycloakEventError(c, err)
+	}
+
+	auditChange(c, "events-config", "", before, config)
+	return c.JSON(config)
+}
+
+func keycloakEventError(c *fiber.Ctx, err error) error {
+	if strings.Contains(err.Error(), "not found") {
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+	if strings.HasPrefix(err.Error(), "invalid") {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
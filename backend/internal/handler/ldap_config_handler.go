@@ -36,11 +36,13 @@ func (h *LDAPConfigHandler) Update(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Server URL, Bind DN, and User Search Base are required"})
 	}
 
+	before, _ := h.service.Get()
 	config, err := h.service.Update(&req)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	auditChange(c, "ldap-config", config.ServerURL, before, config)
 	return c.JSON(config)
 }
 
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+	"strconv"
+)
+
+type RewriteRuleHandler struct {
+	service *service.RewriteRuleService
+}
+
+func NewRewriteRuleHandler(service *service.RewriteRuleService) *RewriteRuleHandler {
+	return &RewriteRuleHandler{service: service}
+}
+
+func (h *RewriteRuleHandler) GetAll(c *fiber.Ctx) error {
+	rules, err := h.service.GetAll()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(rules)
+}
+
+func (h *RewriteRuleHandler) GetByID(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid rule ID"})
+	}
+
+	rule, err := h.service.GetByID(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if rule == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Rewrite rule not found"})
+	}
+	return c.JSON(rule)
+}
+
+func (h *RewriteRuleHandler) Create(c *fiber.Ctx) error {
+	var req domain.CreateRewriteRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	rule, err := h.service.Create(req)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(201).JSON(rule)
+}
+
+func (h *RewriteRuleHandler) Update(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid rule ID"})
+	}
+
+	var req domain.CreateRewriteRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	before, _ := h.service.GetByID(id)
+	rule, err := h.service.Update(id, req)
+	if err != nil {
+		if err.Error() == "rewrite rule not found" {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	auditChange(c, "rewrite-rules", rule.Name, before, rule)
+	return c.JSON(rule)
+}
+
+func (h *RewriteRuleHandler) Delete(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid rule ID"})
+	}
+
+	before, _ := h.service.GetByID(id)
+	if err := h.service.Delete(id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if before != nil {
+		auditChange(c, "rewrite-rules", before.Name, before, nil)
+	}
+
+	return c.Status(204).Send(nil)
+}
+
+// Preview shows how the rules of a cluster pair would rewrite a client, user or group representation
+func (h *RewriteRuleHandler) Preview(c *fiber.Ctx) error {
+	var req domain.RewritePreviewRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if req.DestinationClusterID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "destination_cluster_id is required"})
+	}
+
+	result, err := h.service.Preview(req)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(result)
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+)
+
+type SecretRotationHandler struct {
+	service        *service.SecretRotationService
+	jobService     *service.JobService
+	appRoleService *service.AppRoleService
+}
+
+func NewSecretRotationHandler(service *service.SecretRotationService, jobService *service.JobService, appRoleService *service.AppRoleService) *SecretRotationHandler {
+	return &SecretRotationHandler{service: service, jobService: jobService, appRoleService: appRoleService}
+}
+
+// RotateCluster rotates the multi-manage client secret of one cluster and returns the rotation record
+func (h *SecretRotationHandler) RotateCluster(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid cluster ID"})
+	}
+
+	rotation, err := h.service.RotateCluster(clusterID, currentUserID(c))
+	if err != nil {
+		return secretRotationError(c, err)
+	}
+
+	switch rotation.Status {
+	case domain.SecretRotationSkipped:
+		return c.Status(409).JSON(fiber.Map{"error": rotation.Error, "rotation": rotation})
+	case domain.SecretRotationFailed:
+		return c.Status(502).JSON(fiber.Map{"error": rotation.Error, "rotation": rotation})
+	}
+	return c.JSON(rotation)
+}
+
+// RotateClusters rotates the secrets of the listed clusters or of all clusters with an environment tag in a background job
+func (h *SecretRotationHandler) RotateClusters(c *fiber.Ctx) error {
+	var req domain.RotateClusterSecretsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	clusterIDs, err := h.service.ValidateRequest(req)
+	if err != nil {
+		return secretRotationError(c, err)
+	}
+	if err := requireClusterPermission(c, h.appRoleService, "update_cluster", clusterIDs...); err != nil {
+		return err
+	}
+
+	return submitJob(c, h.jobService, domain.JobTypeSecretRotation, domain.SecretRotationJobPayload{
+		ClusterIDs: req.ClusterIDs,
+		TagID:      req.TagID,
+	})
+}
+
+// RotateClientSecret regenerates the secret of an application client on one cluster and delivers it to a sink.
+// The secret is not part of the response.
+func (h *SecretRotationHandler) RotateClientSecret(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid cluster ID"})
+	}
+
+	var req domain.RotateClientSecretRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	rotation, err := h.service.RotateClientSecret(clusterID, req, currentUserID(c))
+	if err != nil {
+		return secretRotationError(c, err)
+	}
+	if rotation.Status == domain.SecretRotationFailed {
+		return c.Status(502).JSON(fiber.Map{"error": rotation.Error, "rotation": rotation})
+	}
+	return c.JSON(rotation)
+}
+
+// RotateClientSecrets rotates the secret of an application client on the listed clusters or on all
+// clusters with an environment tag in a background job
+func (h *SecretRotationHandler) RotateClientSecrets(c *fiber.Ctx) error {
+	var req domain.RotateClientSecretRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	clusterIDs, err := h.service.ValidateClientRequest(req)
+	if err != nil {
+		return secretRotationError(c, err)
+	}
+	if err := requireClusterPermission(c, h.appRoleService, "update_cluster", clusterIDs...); err != nil {
+		return err
+	}
+
+	return submitJob(c, h.jobService, domain.JobTypeClientSecretRotation, domain.ClientSecretRotationJobPayload{
+		ClientID:           req.ClientID,
+		Sink:               req.Sink,
+		InvalidatePrevious: req.InvalidatePrevious,
+		DeliverOnly:        req.DeliverOnly,
+		ClusterIDs:         req.ClusterIDs,
+		TagID:              req.TagID,
+	})
+}
+
+// GetRotations lists the latest rotations, optionally of one cluster (cluster_id) and client (client_id)
+func (h *SecretRotationHandler) GetRotations(c *fiber.Ctx) error {
+	grant, err := permissionGrant(c, h.appRoleService, "view_cluster_detail")
+	if err != nil {
+		return err
+	}
+
+	rotations, err := h.service.GetRotations(c.QueryInt("cluster_id", 0), c.Query("client_id"), c.QueryInt("limit", 100))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	visible := make([]*domain.SecretRotation, 0, len(rotations))
+	for _, rotation := range rotations {
+		if grant.Allows(rotation.ClusterID) {
+			visible = append(visible, rotation)
+		}
+	}
+	return c.JSON(visible)
+}
+
+func secretRotationError(c *fiber.Ctx, err error) error {
+	if strings.Contains(err.Error(), "not found") {
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+	if strings.HasPrefix(err.Error(), "invalid") {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
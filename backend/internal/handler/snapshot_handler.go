@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+)
+
+type SnapshotHandler struct {
+	service        *service.SnapshotService
+	restoreService *service.RestoreService
+	appRoleService *service.AppRoleService
+}
+
+func NewSnapshotHandler(service *service.SnapshotService, restoreService *service.RestoreService, appRoleService *service.AppRoleService) *SnapshotHandler {
+	return &SnapshotHandler{service: service, restoreService: restoreService, appRoleService: appRoleService}
+}
+
+// ListSnapshots lists snapshots, optionally of one cluster.
+// With cluster_id and at (RFC 3339 timestamp or YYYY-MM-DD) it returns the snapshot that was current at that time.
+func (h *SnapshotHandler) ListSnapshots(c *fiber.Ctx) error {
+	clusterID := c.QueryInt("cluster_id", 0)
+	grant, err := permissionGrant(c, h.appRoleService, "view_cluster_detail")
+	if err != nil {
+		return err
+	}
+	if clusterID != 0 {
+		if err := requireClusterPermission(c, h.appRoleService, "view_cluster_detail", clusterID); err != nil {
+			return err
+		}
+	}
+
+	if at := c.Query("at"); at != "" {
+		if clusterID == 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "cluster_id is required with at"})
+		}
+		t, err := parseSnapshotTime(at)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "at must be an RFC 3339 timestamp or a date (YYYY-MM-DD)"})
+		}
+
+		snapshot, err := h.service.GetSnapshotAt(clusterID, t)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if snapshot == nil {
+			return c.Status(404).JSON(fiber.Map{"error": "No snapshot of the cluster exists at that time"})
+		}
+		return c.JSON(snapshot)
+	}
+
+	snapshots, err := h.service.ListSnapshots(clusterID, c.QueryInt("limit", 100))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	visible := make([]*domain.RealmSnapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if grant.Allows(snapshot.ClusterID) {
+			visible = append(visible, snapshot)
+		}
+	}
+	return c.JSON(visible)
+}
+
+// TakeSnapshot snapshots a cluster's realm now
+func (h *SnapshotHandler) TakeSnapshot(c *fiber.Ctx) error {
+	clusterID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid cluster ID"})
+	}
+
+	result, err := h.service.TakeSnapshot(clusterID, domain.SnapshotTriggerManual, currentUserID(c))
+	if err != nil {
+		if err.Error() == "cluster not found" {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if result.Created {
+		return c.Status(201).JSON(result)
+	}
+	return c.JSON(result)
+}
+
+// GetSnapshot returns a snapshot with its content.
+// sections limits the content (comma separated); content=false returns only the metadata.
+func (h *SnapshotHandler) GetSnapshot(c *fiber.Ctx) error {
+	snapshot, err := h.findSnapshot(c)
+	if err != nil || snapshot == nil {
+		return err
+	}
+
+	if !c.QueryBool("content", true) {
+		return c.JSON(fiber.Map{"snapshot": snapshot})
+	}
+
+	var sections []string
+	if value := c.Query("sections"); value != "" {
+		sections = strings.Split(value, ",")
+	}
+
+	content, err := h.service.GetSnapshotContent(snapshot, sections)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"snapshot": snapshot, "content": content})
+}
+
+// GetSnapshotSection downloads the raw JSON of one snapshot section
+func (h *SnapshotHandler) GetSnapshotSection(c *fiber.Ctx) error {
+	snapshot, err := h.findSnapshot(c)
+	if err != nil || snapshot == nil {
+		return err
+	}
+
+	section := c.Params("section")
+	data, err := h.service.GetSnapshotSection(snapshot, section)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "unknown snapshot section") {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	c.Set("Content-Type", "application/json")
+	c.Set("Content-Disposition", "attachment; filename=snapshot-"+strconv.Itoa(snapshot.ID)+"-"+section+".json")
+	return c.Send(data)
+}
+
+func (h *SnapshotHandler) DeleteSnapshot(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid snapshot ID"})
+	}
+
+	if err := h.service.DeleteSnapshot(id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(204).Send(nil)
+}
+
+// RestoreSnapshot plans restoring one object, or every changed object of a type, from a snapshot.
+// The response contains the pre-restore diff and a pending sync plan to review and apply.
+func (h *SnapshotHandler) RestoreSnapshot(c *fiber.Ctx) error {
+	snapshot, err := h.findSnapshot(c)
+	if err != nil || snapshot == nil {
+		return err
+	}
+	// A restore changes the cluster the snapshot was taken from
+	if err := requireClusterPermission(c, h.appRoleService, "sync_items", snapshot.ClusterID); err != nil {
+		return err
+	}
+
+	var req domain.RestoreRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.ObjectType == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "object_type is required"})
+	}
+
+	preview, err := h.restoreService.PlanRestore(snapshot.ID, req, currentUserID(c))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if strings.HasPrefix(err.Error(), "unsupported object type") {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if preview.Plan != nil {
+		return c.Status(201).JSON(preview)
+	}
+	return c.JSON(preview)
+}
+
+// findSnapshot loads the snapshot of the :id parameter and writes the error response when it cannot
+func (h *SnapshotHandler) findSnapshot(c *fiber.Ctx) (*domain.RealmSnapshot, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid snapshot ID"})
+	}
+
+	snapshot, err := h.service.GetSnapshot(id)
+	if err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if snapshot == nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "Snapshot not found"})
+	}
+	if err := requireClusterPermission(c, h.appRoleService, "view_cluster_detail", snapshot.ClusterID); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// parseSnapshotTime accepts an RFC 3339 timestamp or a date, which means the end of that day
+func parseSnapshotTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Add(24*time.Hour - time.Nanosecond), nil
+}
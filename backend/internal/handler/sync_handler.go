@@ -2,17 +2,21 @@ package handler
 
 import (
 	"strconv"
+	"strings"
+	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/service"
 	"github.com/gofiber/fiber/v2"
 )
 
 type SyncHandler struct {
-	service *service.SyncService
+	service        *service.SyncService
+	appRoleService *service.AppRoleService
 }
 
-func NewSyncHandler(service *service.SyncService) *SyncHandler {
+func NewSyncHandler(service *service.SyncService, appRoleService *service.AppRoleService) *SyncHandler {
 	return &SyncHandler{
-		service: service,
+		service:        service,
+		appRoleService: appRoleService,
 	}
 }
 
@@ -33,11 +37,12 @@ func (h *SyncHandler) SyncRole(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "roleName is required"})
 	}
 	
-	if err := h.service.SyncRole(sourceID, destinationID, roleName); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	result, err := h.service.SyncRole(sourceID, destinationID, roleName, c.QueryBool("removeExtra"))
+	if err != nil {
+		return c.Status(500).JSON(syncResponse(fiber.Map{"error": err.Error()}, result))
 	}
 	
-	return c.JSON(fiber.Map{"message": "Role synced successfully"})
+	return c.JSON(syncResponse(fiber.Map{"message": "Role synced successfully"}, result))
 }
 
 // SyncClient syncs a client from source to destination
@@ -57,11 +62,12 @@ func (h *SyncHandler) SyncClient(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "clientId is required"})
 	}
 	
-	if err := h.service.SyncClient(sourceID, destinationID, clientID); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	result, err := h.service.SyncClient(sourceID, destinationID, clientID, c.QueryBool("removeExtra"))
+	if err != nil {
+		return c.Status(500).JSON(syncResponse(fiber.Map{"error": err.Error()}, result))
 	}
 	
-	return c.JSON(fiber.Map{"message": "Client synced successfully"})
+	return c.JSON(syncResponse(fiber.Map{"message": "Client synced successfully"}, result))
 }
 
 // SyncGroup syncs a group from source to destination
@@ -81,11 +87,12 @@ func (h *SyncHandler) SyncGroup(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "groupPath is required"})
 	}
 	
-	if err := h.service.SyncGroup(sourceID, destinationID, groupPath); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	result, err := h.service.SyncGroup(sourceID, destinationID, groupPath, c.QueryBool("removeExtra"))
+	if err != nil {
+		return c.Status(500).JSON(syncResponse(fiber.Map{"error": err.Error()}, result))
 	}
 	
-	return c.JSON(fiber.Map{"message": "Group synced successfully"})
+	return c.JSON(syncResponse(fiber.Map{"message": "Group synced successfully"}, result))
 }
 
 // SyncUser syncs a user from source to destination
@@ -105,10 +112,133 @@ func (h *SyncHandler) SyncUser(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "username is required"})
 	}
 	
-	if err := h.service.SyncUser(sourceID, destinationID, username); err != nil {
+	result, err := h.service.SyncUser(sourceID, destinationID, username, c.QueryBool("removeExtra"))
+	if err != nil {
+		return c.Status(500).JSON(syncResponse(fiber.Map{"error": err.Error()}, result))
+	}
+	
+	return c.JSON(syncResponse(fiber.Map{"message": "User synced successfully"}, result))
+}
+
+
+// CreatePlan computes a sync plan without changing the destination cluster
+func (h *SyncHandler) CreatePlan(c *fiber.Ctx) error {
+	var req domain.CreateSyncPlanRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	
+	if req.SourceClusterID == 0 || req.DestinationClusterID == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "source_cluster_id and destination_cluster_id are required"})
+	}
+	if req.ObjectType != "role" && req.ObjectType != "client" && req.ObjectType != "group" && req.ObjectType != "user" {
+		return c.Status(400).JSON(fiber.Map{"error": "object_type must be one of role, client, group, user"})
+	}
+	if req.ObjectName == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "object_name is required"})
+	}
+	if err := requireClusterPermission(c, h.appRoleService, "sync_items", req.SourceClusterID, req.DestinationClusterID); err != nil {
+		return err
+	}
+	
+	plan, err := h.service.CreatePlan(req, currentUserID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	
+	return c.Status(201).JSON(plan)
+}
+
+// ListPlans returns the most recent sync plans between clusters the user may sync
+func (h *SyncHandler) ListPlans(c *fiber.Ctx) error {
+	grant, err := permissionGrant(c, h.appRoleService, "sync_items")
+	if err != nil {
+		return err
+	}
+	
+	plans, err := h.service.ListPlans(c.QueryInt("limit", 100))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	
+	visible := make([]*domain.SyncPlan, 0, len(plans))
+	for _, plan := range plans {
+		if grant.Allows(plan.SourceClusterID) && grant.Allows(plan.DestinationClusterID) {
+			visible = append(visible, plan)
+		}
+	}
+	return c.JSON(visible)
+}
+
+// GetPlan returns a single sync plan with its steps and, once applied, its results
+func (h *SyncHandler) GetPlan(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid plan ID"})
+	}
+	
+	plan, err := h.service.GetPlan(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if plan == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Sync plan not found"})
+	}
+	if err := requireClusterPermission(c, h.appRoleService, "sync_items", plan.SourceClusterID, plan.DestinationClusterID); err != nil {
+		return err
+	}
+	
+	return c.JSON(plan)
+}
+
+// ApplyPlan executes a previously reviewed sync plan
+func (h *SyncHandler) ApplyPlan(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid plan ID"})
+	}
+	
+	stored, err := h.service.GetPlan(id)
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
+	if stored == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Sync plan not found"})
+	}
+	if err := requireClusterPermission(c, h.appRoleService, "sync_items", stored.SourceClusterID, stored.DestinationClusterID); err != nil {
+		return err
+	}
 	
-	return c.JSON(fiber.Map{"message": "User synced successfully"})
+	plan, err := h.service.ApplyPlan(id, currentUserID(c))
+	if err != nil {
+		if err.Error() == "sync plan not found" {
+			return c.Status(404).JSON(fiber.Map{"error": "Sync plan not found"})
+		}
+		if strings.Contains(err.Error(), "cannot be applied") || strings.Contains(err.Error(), "already being applied") {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	
+	return c.JSON(plan)
 }
 
+// currentUserID returns the ID of the authenticated user, if any
+func currentUserID(c *fiber.Ctx) *int {
+	user, ok := c.Locals("user").(*domain.User)
+	if !ok || user == nil {
+		return nil
+	}
+	return &user.ID
+}
+
+// syncResponse adds the dependencies, field changes and step results of a direct sync to a response body
+func syncResponse(body fiber.Map, result *domain.SyncResult) fiber.Map {
+	if result == nil {
+		return body
+	}
+	body["dependencies"] = result.Dependencies
+	body["changes"] = result.Changes
+	body["steps"] = result.Steps
+	return body
+}
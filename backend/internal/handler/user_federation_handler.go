@@ -9,13 +9,19 @@ import (
 )
 
 type UserFederationHandler struct {
-	service *service.UserFederationService
+	service    *service.UserFederationService
+	jobService *service.JobService
 }
 
 func NewUserFederationHandler(service *service.UserFederationService) *UserFederationHandler {
 	return &UserFederationHandler{service: service}
 }
 
+// SetJobService enables running federation syncs as background jobs
+func (h *UserFederationHandler) SetJobService(jobService *service.JobService) {
+	h.jobService = jobService
+}
+
 // GetUserFederationProviders gets all user federation providers for a realm
 func (h *UserFederationHandler) GetUserFederationProviders(c *fiber.Ctx) error {
 	clusterID, err := strconv.Atoi(c.Params("id"))
@@ -185,11 +191,22 @@ func (h *UserFederationHandler) SyncUserFederation(c *fiber.Ctx) error {
 
 	realm := c.Query("realm", "")
 
-	if err := h.service.SyncUserFederation(clusterID, realm, providerID, req); err != nil {
+	// A full sync of a large directory can take longer than a request, async=true runs it as a background job
+	if c.QueryBool("async") {
+		return submitJob(c, h.jobService, domain.JobTypeFederationSync, domain.FederationSyncJobPayload{
+			ClusterID:  clusterID,
+			Realm:      realm,
+			ProviderID: providerID,
+			Action:     req.Action,
+		})
+	}
+
+	result, err := h.service.SyncUserFederation(clusterID, realm, providerID, req)
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.JSON(fiber.Map{"message": "Sync started successfully"})
+	return c.JSON(fiber.Map{"message": "Sync started successfully", "result": result})
 }
 
 // TestLDAPConnection tests LDAP connection with just URL
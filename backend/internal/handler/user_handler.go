@@ -62,11 +62,13 @@ func (h *UserHandler) Update(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	before, _ := h.service.GetUserByID(id)
 	user, err := h.service.UpdateUser(id, &req)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	auditChange(c, "users", user.Username, before, user)
 	return c.JSON(user)
 }
 
@@ -76,10 +78,14 @@ func (h *UserHandler) Delete(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
+	before, _ := h.service.GetUserByID(id)
 	err = h.service.DeleteUser(id)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
+	if before != nil {
+		auditChange(c, "users", before.Username, before, nil)
+	}
 
 	return c.Status(204).Send(nil)
 }
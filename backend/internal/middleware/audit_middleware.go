@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/service"
+)
+
+// auditMaxBody is the largest JSON body summarized in the audit log; larger bodies are only described
+const auditMaxBody = 256 * 1024
+
+// auditNameKeys are the body fields that name the changed object, in order of preference
+var auditNameKeys = []string{"username", "clientId", "client_id", "object_name", "name", "path", "role_name", "roleName", "group_name", "groupName", "alias", "user_id", "userId"}
+
+// AuditMiddleware records every mutating API call (anything but GET, HEAD and OPTIONS) in the audit log
+// with its actor, target, redacted request summary and outcome. It runs outside the auth middleware,
+// so failed logins and calls rejected for missing permissions are recorded as well.
+func AuditMiddleware(auditService *service.AuditService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				status = e.Code
+			}
+		}
+
+		// A call rejected by a middleware (e.g. a missing permission) has not reached its route
+		action := c.Method() + " " + c.Route().Path
+		if c.Route().Method == "USE" {
+			action = c.Method() + " " + c.Path()
+		}
+
+		event := &domain.AuditEvent{
+			Action:     action,
+			Method:     c.Method(),
+			Path:       c.Path(),
+			StatusCode: status,
+			Outcome:    domain.AuditOutcomeSuccess,
+			IPAddress:  c.IP(),
+			DurationMs: int(time.Since(start).Milliseconds()),
+			CreatedAt:  start,
+		}
+		if user, ok := c.Locals("user").(*domain.User); ok && user != nil {
+			event.ActorID = &user.ID
+			event.ActorUsername = user.Username
+		}
+		if status >= 400 {
+			event.Outcome = domain.AuditOutcomeFailure
+			event.Error = auditResponseError(c, err)
+		}
+
+		body := auditRequestBody(c)
+		event.RequestSummary = service.AuditJSON(body)
+		auditTarget(c, event, body)
+
+		if change, ok := c.Locals(domain.AuditLocalsKey).(*domain.AuditChange); ok && change != nil {
+			if change.ObjectType != "" {
+				event.ObjectType = change.ObjectType
+			}
+			if change.ObjectName != "" {
+				event.ObjectName = change.ObjectName
+			}
+			event.Before = service.AuditJSON(change.Before)
+			event.After = service.AuditJSON(change.After)
+		}
+
+		auditService.Record(event)
+		return err
+	}
+}
+
+// auditRequestBody decodes the request body for the summary. Streamed and large bodies are not read,
+// only described, so auditing never buffers an upload the handler streamed.
+func auditRequestBody(c *fiber.Ctx) interface{} {
+	contentType := strings.ToLower(string(c.Request().Header.ContentType()))
+	size := c.Request().Header.ContentLength()
+
+	switch {
+	case strings.HasPrefix(contentType, fiber.MIMEApplicationJSON):
+		if size < 0 || size > auditMaxBody {
+			return fiber.Map{"content_type": contentType, "size": size}
+		}
+		var body interface{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return fiber.Map{"content_type": contentType, "size": size}
+		}
+		return body
+	case strings.HasPrefix(contentType, fiber.MIMEMultipartForm):
+		form, err := c.MultipartForm()
+		if err != nil {
+			return fiber.Map{"content_type": "multipart/form-data", "size": size}
+		}
+		summary := fiber.Map{}
+		for key, values := range form.Value {
+			summary[key] = strings.Join(values, ",")
+		}
+		for key, files := range form.File {
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Filename+" ("+strconv.FormatInt(file.Size, 10)+" bytes)")
+			}
+			summary[key] = names
+		}
+		return summary
+	case contentType == "" && size <= 0:
+		return nil
+	default:
+		return fiber.Map{"content_type": contentType, "size": size}
+	}
+}
+
+// auditTarget fills in the cluster and object a call worked on from its route and body
+func auditTarget(c *fiber.Ctx, event *domain.AuditEvent, body interface{}) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(c.Route().Path, "/api"), "/"), "/")
+	fields, _ := body.(map[string]interface{})
+
+	// Cluster routes contain clusters/:id, e.g. /clusters/:id/users/create or /export-import/clusters/:id/realm/import
+	clusterIndex := -1
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] == "clusters" && segments[i+1] == ":id" {
+			clusterIndex = i + 1
+			break
+		}
+	}
+
+	if clusterIndex >= 0 {
+		if id, err := strconv.Atoi(c.Params("id")); err == nil {
+			event.ClusterID = &id
+		}
+	} else if id := auditIntField(fields, "destination_cluster_id", "cluster_id"); id != 0 {
+		// Syncs change the destination cluster
+		event.ClusterID = &id
+	}
+	if realm := c.Query("realm"); realm != "" {
+		event.Realm = realm
+	}
+
+	// The object type is the first route segment, or on cluster routes the first one after the cluster
+	event.ObjectType = segments[0]
+	if clusterIndex >= 0 {
+		event.ObjectType = "clusters"
+		for _, segment := range segments[clusterIndex+1:] {
+			if !strings.HasPrefix(segment, ":") {
+				event.ObjectType = segment
+				break
+			}
+		}
+	}
+	if objectType, ok := fields["object_type"].(string); ok && objectType != "" {
+		event.ObjectType = objectType
+	}
+
+	for _, key := range auditNameKeys {
+		if value, ok := fields[key]; ok && value != nil && value != "" {
+			event.ObjectName = auditString(value)
+			return
+		}
+	}
+	for _, param := range c.Route().Params {
+		if param == "id" && clusterIndex >= 0 {
+			continue
+		}
+		if value := c.Params(param); value != "" {
+			event.ObjectName = value
+			return
+		}
+	}
+}
+
+func auditIntField(fields map[string]interface{}, keys ...string) int {
+	for _, key := range keys {
+		if value, ok := fields[key].(float64); ok && value != 0 {
+			return int(value)
+		}
+	}
+	return 0
+}
+
+func auditString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// auditResponseError reads the error message of a failed call from its JSON response
+func auditResponseError(c *fiber.Ctx, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	var response struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(c.Response().Body(), &response) == nil && response.Error != "" {
+		return response.Error
+	}
+	return strconv.Itoa(c.Response().StatusCode())
+}
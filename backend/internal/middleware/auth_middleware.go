@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"errors"
+	"strconv"
+
 	"github.com/gofiber/fiber/v2"
 	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/service"
@@ -30,6 +33,19 @@ func AuthMiddleware(authService *service.AuthService) fiber.Handler {
 	}
 }
 
+// QueryTokenMiddleware accepts the token as access_token query parameter, for clients such as the
+// browser's EventSource that cannot send an Authorization header. It must run before AuthMiddleware.
+func QueryTokenMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			if token := c.Query("access_token"); token != "" {
+				c.Request().Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		return c.Next()
+	}
+}
+
 // AdminMiddleware ensures the user has admin role (backward compatibility)
 func AdminMiddleware(roleService *service.AppRoleService) fiber.Handler {
 	return PermissionMiddleware(roleService, "manage_roles")
@@ -62,3 +78,55 @@ func PermissionMiddleware(roleService *service.AppRoleService, permissionName st
 	}
 }
 
+
+// ClusterPermissionMiddleware checks a permission on the cluster of the :id route parameter.
+// A role scoped to clusters or environment tags only grants its permissions on those clusters.
+func ClusterPermissionMiddleware(roleService *service.AppRoleService, permissionName string) fiber.Handler {
+	return clusterPermissionMiddleware(roleService, permissionName, func(c *fiber.Ctx) ([]int, error) {
+		id, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return nil, errors.New("Invalid cluster ID")
+		}
+		return []int{id}, nil
+	})
+}
+
+// QueryClusterPermissionMiddleware checks a permission on every cluster named in the given query parameters,
+// e.g. both source and destination of a sync
+func QueryClusterPermissionMiddleware(roleService *service.AppRoleService, permissionName string, params ...string) fiber.Handler {
+	return clusterPermissionMiddleware(roleService, permissionName, func(c *fiber.Ctx) ([]int, error) {
+		ids := make([]int, 0, len(params))
+		for _, param := range params {
+			id, err := strconv.Atoi(c.Query(param))
+			if err != nil {
+				return nil, errors.New("Invalid " + param + " cluster ID")
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	})
+}
+
+func clusterPermissionMiddleware(roleService *service.AppRoleService, permissionName string, clusterIDs func(c *fiber.Ctx) ([]int, error)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		appUser, ok := c.Locals("user").(*domain.User)
+		if !ok || appUser == nil {
+			return c.Status(401).JSON(fiber.Map{"error": "Authentication required"})
+		}
+
+		ids, err := clusterIDs(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		err = roleService.CheckClusterPermission(appUser.ID, permissionName, ids...)
+		if errors.Is(err, service.ErrClusterPermissionDenied) {
+			return c.Status(403).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to check permissions"})
+		}
+
+		return c.Next()
+	}
+}
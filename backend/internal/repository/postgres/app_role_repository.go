@@ -2,8 +2,11 @@ package postgres
 
 import (
 	"database/sql"
+	"fmt"
 	"keycloak-multi-manage/internal/domain"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type AppRoleRepository struct {
@@ -14,18 +17,26 @@ func NewAppRoleRepository(db *sql.DB) *AppRoleRepository {
 	return &AppRoleRepository{db: db}
 }
 
+// Create inserts a role with its permissions and scope in one transaction
 func (r *AppRoleRepository) Create(role *domain.AppRole) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO roles (name, description, created_at, updated_at)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO roles (name, description, scope, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id
 	`
 	
 	now := time.Now()
-	err := r.db.QueryRow(
+	err = tx.QueryRow(
 		query,
 		role.Name,
 		role.Description,
+		role.Scope,
 		now,
 		now,
 	).Scan(&role.ID)
@@ -34,23 +45,29 @@ func (r *AppRoleRepository) Create(role *domain.AppRole) error {
 		return err
 	}
 	
-	role.CreatedAt = now
-	role.UpdatedAt = now
-	
 	// Add permissions if provided
 	if len(role.Permissions) > 0 {
-		err = r.setRolePermissions(role.ID, role.Permissions)
+		err = setRolePermissions(tx, role.ID, role.Permissions)
 		if err != nil {
 			return err
 		}
 	}
 	
+	if err := setRoleScope(tx, role); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	
+	role.CreatedAt = now
+	role.UpdatedAt = now
 	return nil
 }
 
 func (r *AppRoleRepository) GetAll() ([]*domain.AppRole, error) {
 	query := `
-		SELECT id, name, description, created_at, updated_at
+		SELECT id, name, description, scope, created_at, updated_at
 		FROM roles
 		ORDER BY name
 	`
@@ -68,6 +85,7 @@ func (r *AppRoleRepository) GetAll() ([]*domain.AppRole, error) {
 			&role.ID,
 			&role.Name,
 			&role.Description,
+			&role.Scope,
 			&role.CreatedAt,
 			&role.UpdatedAt,
 		)
@@ -82,6 +100,10 @@ func (r *AppRoleRepository) GetAll() ([]*domain.AppRole, error) {
 		}
 		role.Permissions = permissions
 		
+		if err := r.loadRoleScope(role); err != nil {
+			return nil, err
+		}
+		
 		roles = append(roles, role)
 	}
 	
@@ -90,7 +112,7 @@ func (r *AppRoleRepository) GetAll() ([]*domain.AppRole, error) {
 
 func (r *AppRoleRepository) GetByID(id int) (*domain.AppRole, error) {
 	query := `
-		SELECT id, name, description, created_at, updated_at
+		SELECT id, name, description, scope, created_at, updated_at
 		FROM roles
 		WHERE id = $1
 	`
@@ -100,6 +122,7 @@ func (r *AppRoleRepository) GetByID(id int) (*domain.AppRole, error) {
 		&role.ID,
 		&role.Name,
 		&role.Description,
+		&role.Scope,
 		&role.CreatedAt,
 		&role.UpdatedAt,
 	)
@@ -118,12 +141,16 @@ func (r *AppRoleRepository) GetByID(id int) (*domain.AppRole, error) {
 	}
 	role.Permissions = permissions
 	
+	if err := r.loadRoleScope(role); err != nil {
+		return nil, err
+	}
+	
 	return role, nil
 }
 
 func (r *AppRoleRepository) GetByUserID(userID int) ([]*domain.AppRole, error) {
 	query := `
-		SELECT r.id, r.name, r.description, r.created_at, r.updated_at
+		SELECT r.id, r.name, r.description, r.scope, r.created_at, r.updated_at
 		FROM roles r
 		INNER JOIN user_roles ur ON r.id = ur.role_id
 		WHERE ur.user_id = $1
@@ -143,6 +170,7 @@ func (r *AppRoleRepository) GetByUserID(userID int) ([]*domain.AppRole, error) {
 			&role.ID,
 			&role.Name,
 			&role.Description,
+			&role.Scope,
 			&role.CreatedAt,
 			&role.UpdatedAt,
 		)
@@ -157,24 +185,36 @@ func (r *AppRoleRepository) GetByUserID(userID int) ([]*domain.AppRole, error) {
 		}
 		role.Permissions = permissions
 		
+		if err := r.loadRoleScope(role); err != nil {
+			return nil, err
+		}
+		
 		roles = append(roles, role)
 	}
 	
 	return roles, rows.Err()
 }
 
+// Update changes a role with its permissions and scope in one transaction
 func (r *AppRoleRepository) Update(role *domain.AppRole) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		UPDATE roles 
-		SET name = $1, description = $2, updated_at = $3
-		WHERE id = $4
+		SET name = $1, description = $2, scope = $3, updated_at = $4
+		WHERE id = $5
 	`
 	
 	now := time.Now()
-	_, err := r.db.Exec(
+	_, err = tx.Exec(
 		query,
 		role.Name,
 		role.Description,
+		role.Scope,
 		now,
 		role.ID,
 	)
@@ -183,16 +223,22 @@ func (r *AppRoleRepository) Update(role *domain.AppRole) error {
 		return err
 	}
 	
-	role.UpdatedAt = now
-	
 	// Update permissions if provided
 	if role.Permissions != nil {
-		err = r.setRolePermissions(role.ID, role.Permissions)
+		err = setRolePermissions(tx, role.ID, role.Permissions)
 		if err != nil {
 			return err
 		}
 	}
 	
+	if err := setRoleScope(tx, role); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	
+	role.UpdatedAt = now
 	return nil
 }
 
@@ -262,9 +308,9 @@ func (r *AppRoleRepository) getRolePermissions(roleID int) ([]domain.Permission,
 	return permissions, rows.Err()
 }
 
-func (r *AppRoleRepository) setRolePermissions(roleID int, permissions []domain.Permission) error {
+func setRolePermissions(tx *sql.Tx, roleID int, permissions []domain.Permission) error {
 	// Remove existing permissions
-	_, err := r.db.Exec("DELETE FROM role_permissions WHERE role_id = $1", roleID)
+	_, err := tx.Exec("DELETE FROM role_permissions WHERE role_id = $1", roleID)
 	if err != nil {
 		return err
 	}
@@ -272,7 +318,7 @@ func (r *AppRoleRepository) setRolePermissions(roleID int, permissions []domain.
 	// Add new permissions
 	if len(permissions) > 0 {
 		query := `INSERT INTO role_permissions (role_id, permission_id) VALUES ($1, $2)`
-		stmt, err := r.db.Prepare(query)
+		stmt, err := tx.Prepare(query)
 		if err != nil {
 			return err
 		}
@@ -289,3 +335,85 @@ func (r *AppRoleRepository) setRolePermissions(roleID int, permissions []domain.
 	return nil
 }
 
+
+// ValidateScope checks that the clusters and environment tags of a role's scope exist
+func (r *AppRoleRepository) ValidateScope(role *domain.AppRole) error {
+	var count int
+	if len(role.ScopeClusterIDs) > 0 {
+		if err := r.db.QueryRow(`SELECT COUNT(*) FROM clusters WHERE id = ANY($1)`, pq.Array(role.ScopeClusterIDs)).Scan(&count); err != nil {
+			return err
+		}
+		if count != len(uniqueIDs(role.ScopeClusterIDs)) {
+			return fmt.Errorf("scope_cluster_ids contains a cluster that does not exist")
+		}
+	}
+	if len(role.ScopeTagIDs) > 0 {
+		if err := r.db.QueryRow(`SELECT COUNT(*) FROM environment_tags WHERE id = ANY($1)`, pq.Array(role.ScopeTagIDs)).Scan(&count); err != nil {
+			return err
+		}
+		if count != len(uniqueIDs(role.ScopeTagIDs)) {
+			return fmt.Errorf("scope_tag_ids contains an environment tag that does not exist")
+		}
+	}
+	return nil
+}
+
+func uniqueIDs(ids []int) map[int]bool {
+	unique := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	return unique
+}
+
+// loadRoleScope loads the cluster and environment tag IDs of a role's scope
+func (r *AppRoleRepository) loadRoleScope(role *domain.AppRole) error {
+	var err error
+	role.ScopeClusterIDs, err = r.getIDs(`SELECT cluster_id FROM role_scope_clusters WHERE role_id = $1 ORDER BY cluster_id`, role.ID)
+	if err != nil {
+		return err
+	}
+	role.ScopeTagIDs, err = r.getIDs(`SELECT tag_id FROM role_scope_tags WHERE role_id = $1 ORDER BY tag_id`, role.ID)
+	return err
+}
+
+func (r *AppRoleRepository) getIDs(query string, roleID int) ([]int, error) {
+	rows, err := r.db.Query(query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// setRoleScope replaces the cluster and environment tag IDs of a role's scope in the role's transaction,
+// so a role never briefly covers no or other clusters
+func setRoleScope(tx *sql.Tx, role *domain.AppRole) error {
+	if _, err := tx.Exec("DELETE FROM role_scope_clusters WHERE role_id = $1", role.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM role_scope_tags WHERE role_id = $1", role.ID); err != nil {
+		return err
+	}
+	for _, clusterID := range role.ScopeClusterIDs {
+		if _, err := tx.Exec("INSERT INTO role_scope_clusters (role_id, cluster_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", role.ID, clusterID); err != nil {
+			return err
+		}
+	}
+	for _, tagID := range role.ScopeTagIDs {
+		if _, err := tx.Exec("INSERT INTO role_scope_tags (role_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", role.ID, tagID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"strings"
+)
+
+type AuditRepository struct {
+	db *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+func (r *AuditRepository) Create(event *domain.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (actor_id, actor_username, action, method, path, cluster_id, cluster_name, realm, object_type, object_name,
+		                          request_summary, outcome, status_code, error, before_state, after_state, ip_address, duration_ms, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+		RETURNING id
+	`
+
+	return r.db.QueryRow(
+		query,
+		event.ActorID,
+		nullString(event.ActorUsername),
+		event.Action,
+		nullString(event.Method),
+		nullString(event.Path),
+		event.ClusterID,
+		nullString(event.ClusterName),
+		nullString(event.Realm),
+		nullString(event.ObjectType),
+		nullString(event.ObjectName),
+		nullJSON(event.RequestSummary),
+		event.Outcome,
+		event.StatusCode,
+		nullString(event.Error),
+		nullJSON(event.Before),
+		nullJSON(event.After),
+		nullString(event.IPAddress),
+		event.DurationMs,
+		event.CreatedAt,
+	).Scan(&event.ID)
+}
+
+// Search returns one page of the events that match the filter, newest first, and the number of all matches
+func (r *AuditRepository) Search(filter domain.AuditFilter) ([]*domain.AuditEvent, int, error) {
+	where, args := auditConditions(filter)
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM audit_events`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`SELECT %s FROM audit_events%s ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`,
+		auditColumns, where, len(args)-1, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []*domain.AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, event)
+	}
+
+	return events, total, rows.Err()
+}
+
+// ForEach calls fn for every event that matches the filter, newest first, without loading them all at once
+func (r *AuditRepository) ForEach(filter domain.AuditFilter, fn func(*domain.AuditEvent) error) error {
+	where, args := auditConditions(filter)
+	rows, err := r.db.Query(`SELECT `+auditColumns+` FROM audit_events`+where+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+const auditColumns = `id, actor_id, actor_username, action, method, path, cluster_id, cluster_name, realm, object_type, object_name,
+		request_summary, outcome, status_code, error, before_state, after_state, ip_address, duration_ms, created_at`
+
+func auditConditions(filter domain.AuditFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ActorID != 0 {
+		add("actor_id = $%d", filter.ActorID)
+	}
+	if filter.Actor != "" {
+		add("LOWER(actor_username) = LOWER($%d)", filter.Actor)
+	}
+	if filter.Action != "" {
+		add("action ILIKE '%%' || $%d || '%%'", filter.Action)
+	}
+	if filter.ClusterID != 0 {
+		add("cluster_id = $%d", filter.ClusterID)
+	}
+	if filter.ObjectType != "" {
+		add("object_type = $%d", filter.ObjectType)
+	}
+	if filter.ObjectName != "" {
+		add("object_name ILIKE '%%' || $%d || '%%'", filter.ObjectName)
+	}
+	if filter.Outcome != "" {
+		add("outcome = $%d", filter.Outcome)
+	}
+	if filter.From != nil {
+		add("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("created_at < $%d", *filter.To)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func scanAuditEvent(row rowScanner) (*domain.AuditEvent, error) {
+	event := &domain.AuditEvent{}
+	var actorID, clusterID, statusCode, durationMs sql.NullInt64
+	var actorUsername, method, path, clusterName, realm, objectType, objectName, summary, errMsg, before, after, ip sql.NullString
+
+	err := row.Scan(
+		&event.ID,
+		&actorID,
+		&actorUsername,
+		&event.Action,
+		&method,
+		&path,
+		&clusterID,
+		&clusterName,
+		&realm,
+		&objectType,
+		&objectName,
+		&summary,
+		&event.Outcome,
+		&statusCode,
+		&errMsg,
+		&before,
+		&after,
+		&ip,
+		&durationMs,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	event.ActorID = nullIntPtr(actorID)
+	event.ActorUsername = actorUsername.String
+	event.Method = method.String
+	event.Path = path.String
+	event.ClusterID = nullIntPtr(clusterID)
+	event.ClusterName = clusterName.String
+	event.Realm = realm.String
+	event.ObjectType = objectType.String
+	event.ObjectName = objectName.String
+	event.StatusCode = int(statusCode.Int64)
+	event.Error = errMsg.String
+	event.IPAddress = ip.String
+	event.DurationMs = int(durationMs.Int64)
+	if summary.Valid {
+		event.RequestSummary = json.RawMessage(summary.String)
+	}
+	if before.Valid {
+		event.Before = json.RawMessage(before.String)
+	}
+	if after.Valid {
+		event.After = json.RawMessage(after.String)
+	}
+
+	return event, nil
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
@@ -2,43 +2,66 @@ package postgres
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/pkg/secrets"
 	"time"
 )
 
+// ErrSecretRotationInProgress is returned when a cluster's client secret cannot be written because a
+// rotation of it is in progress
+var ErrSecretRotationInProgress = errors.New("client secret rotation in progress, try again later")
+
+// ClusterRepository stores clusters; client secrets are encrypted with the keyring. They are written here
+// but not loaded with the cluster: they are read through SecretStore when a token is fetched.
+// Clusters with a secret reference keep no client secret in the database.
 type ClusterRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	keyring *secrets.Keyring
 }
 
-func NewClusterRepository(db *sql.DB) *ClusterRepository {
-	return &ClusterRepository{db: db}
+func NewClusterRepository(db *sql.DB, keyring *secrets.Keyring) *ClusterRepository {
+	return &ClusterRepository{db: db, keyring: keyring}
 }
 
 func (r *ClusterRepository) Create(cluster *domain.Cluster) error {
 	query := `
-		INSERT INTO clusters (name, base_url, realm, client_id, client_secret, group_name, metrics_endpoint, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id
+		INSERT INTO clusters (id, name, base_url, realm, client_id, client_secret, secret_ref, group_name, metrics_endpoint, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 	`
 	
+	// The secret is bound to the cluster's ID, so the ID is taken before the row is inserted
+	id, err := nextID(r.db, "clusters")
+	if err != nil {
+		return err
+	}
+	clientSecret, err := r.keyring.Encrypt(cluster.ClientSecret, clientSecretAAD(id))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt client secret: %w", err)
+	}
+	
 	now := time.Now()
-	err := r.db.QueryRow(
+	_, err = r.db.Exec(
 		query,
+		id,
 		cluster.Name,
 		cluster.BaseURL,
 		cluster.Realm,
 		cluster.ClientID,
-		cluster.ClientSecret,
+		clientSecret,
+		nullString(cluster.SecretRef),
 		cluster.GroupName,
 		cluster.MetricsEndpoint,
 		now,
 		now,
-	).Scan(&cluster.ID)
+	)
 	
 	if err != nil {
 		return err
 	}
 	
+	cluster.ID = id
 	cluster.CreatedAt = now
 	cluster.UpdatedAt = now
 	return nil
@@ -46,7 +69,7 @@ func (r *ClusterRepository) Create(cluster *domain.Cluster) error {
 
 func (r *ClusterRepository) GetAll() ([]*domain.Cluster, error) {
 	query := `
-		SELECT id, name, base_url, realm, client_id, client_secret, group_name, metrics_endpoint, created_at, updated_at
+		SELECT id, name, base_url, realm, client_id, secret_ref, group_name, metrics_endpoint, secret_rotated_at, created_at, updated_at
 		FROM clusters
 		ORDER BY COALESCE(group_name, ''), name
 	`
@@ -61,16 +84,17 @@ func (r *ClusterRepository) GetAll() ([]*domain.Cluster, error) {
 	for rows.Next() {
 		cluster := &domain.Cluster{}
 		var clientID sql.NullString
-		var clientSecret sql.NullString
+		var secretRef sql.NullString
 		err := rows.Scan(
 			&cluster.ID,
 			&cluster.Name,
 			&cluster.BaseURL,
 			&cluster.Realm,
 			&clientID,
-			&clientSecret,
+			&secretRef,
 			&cluster.GroupName,
 			&cluster.MetricsEndpoint,
+			&cluster.SecretRotatedAt,
 			&cluster.CreatedAt,
 			&cluster.UpdatedAt,
 		)
@@ -83,11 +107,7 @@ func (r *ClusterRepository) GetAll() ([]*domain.Cluster, error) {
 		} else {
 			cluster.ClientID = "multi-manage"
 		}
-		if clientSecret.Valid {
-			cluster.ClientSecret = clientSecret.String
-		} else {
-			cluster.ClientSecret = ""
-		}
+		cluster.SecretRef = secretRef.String
 		clusters = append(clusters, cluster)
 	}
 	
@@ -96,23 +116,24 @@ func (r *ClusterRepository) GetAll() ([]*domain.Cluster, error) {
 
 func (r *ClusterRepository) GetByID(id int) (*domain.Cluster, error) {
 	query := `
-		SELECT id, name, base_url, realm, client_id, client_secret, group_name, metrics_endpoint, created_at, updated_at
+		SELECT id, name, base_url, realm, client_id, secret_ref, group_name, metrics_endpoint, secret_rotated_at, created_at, updated_at
 		FROM clusters
 		WHERE id = $1
 	`
 	
 	cluster := &domain.Cluster{}
 	var clientID sql.NullString
-	var clientSecret sql.NullString
+	var secretRef sql.NullString
 	err := r.db.QueryRow(query, id).Scan(
 		&cluster.ID,
 		&cluster.Name,
 		&cluster.BaseURL,
 		&cluster.Realm,
 		&clientID,
-		&clientSecret,
+		&secretRef,
 		&cluster.GroupName,
 		&cluster.MetricsEndpoint,
+		&cluster.SecretRotatedAt,
 		&cluster.CreatedAt,
 		&cluster.UpdatedAt,
 	)
@@ -123,11 +144,6 @@ func (r *ClusterRepository) GetByID(id int) (*domain.Cluster, error) {
 	} else {
 		cluster.ClientID = "multi-manage"
 	}
-	if clientSecret.Valid {
-		cluster.ClientSecret = clientSecret.String
-	} else {
-		cluster.ClientSecret = ""
-	}
 	
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -136,24 +152,54 @@ func (r *ClusterRepository) GetByID(id int) (*domain.Cluster, error) {
 		return nil, err
 	}
 	
+	cluster.SecretRef = secretRef.String
+	
 	return cluster, nil
 }
 
-func (r *ClusterRepository) Update(cluster *domain.Cluster) error {
+// Update stores a cluster's settings. The client secret is written only with secretChanged, in a separate
+// statement that refuses to overwrite a secret a rotation holds the lease on: the rotation is about to replace
+// it in Keycloak, and the secret the caller read before may already be invalid.
+func (r *ClusterRepository) Update(cluster *domain.Cluster, secretChanged bool) error {
 	query := `
 		UPDATE clusters 
-		SET name = $1, base_url = $2, realm = $3, client_id = $4, client_secret = $5, group_name = $6, metrics_endpoint = $7, updated_at = $8
+		SET name = $1, base_url = $2, realm = $3, client_id = $4, secret_ref = $5, group_name = $6, metrics_endpoint = $7, updated_at = $8
 		WHERE id = $9
 	`
 	
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
 	now := time.Now()
-	_, err := r.db.Exec(
+	if secretChanged {
+		clientSecret, err := r.keyring.Encrypt(cluster.ClientSecret, clientSecretAAD(cluster.ID))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt client secret: %w", err)
+		}
+		result, err := tx.Exec(`
+			UPDATE clusters SET client_secret = $1
+			WHERE id = $2 AND (secret_rotation_lease_until IS NULL OR secret_rotation_lease_until < $3)
+		`, clientSecret, cluster.ID, now)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrSecretRotationInProgress
+		}
+	}
+	
+	_, err = tx.Exec(
 		query,
 		cluster.Name,
 		cluster.BaseURL,
 		cluster.Realm,
 		cluster.ClientID,
-		cluster.ClientSecret,
+		nullString(cluster.SecretRef),
 		cluster.GroupName,
 		cluster.MetricsEndpoint,
 		now,
@@ -163,6 +209,9 @@ func (r *ClusterRepository) Update(cluster *domain.Cluster) error {
 	if err != nil {
 		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 	
 	cluster.UpdatedAt = now
 	return nil
@@ -174,3 +223,27 @@ func (r *ClusterRepository) Delete(id int) error {
 	return err
 }
 
+// ReencryptSecrets encrypts the client secrets (and pending rotated secrets) that are still stored in plaintext
+// or not yet bound to their cluster and, with rotate, re-wraps the ones encrypted with an older key for the
+// active key. It returns the number of changed secrets.
+func (r *ClusterRepository) ReencryptSecrets(rotate bool) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	
+	changed := 0
+	for _, column := range []string{"client_secret", "pending_client_secret"} {
+		n, err := reencryptColumn(tx, r.keyring, "clusters", column, rotate)
+		if err != nil {
+			return 0, err
+		}
+		changed += n
+	}
+	
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
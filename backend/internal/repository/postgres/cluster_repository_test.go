@@ -0,0 +1,378 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/pkg/secrets"
+)
+
+// fakeClusters is a database/sql driver over an in-memory clusters table. It runs the simple UPDATE and
+// SELECT statements the cluster and secret rotation repositories send, WHERE clauses included, so their
+// interleavings can be replayed without a Postgres server.
+type fakeClusters struct {
+	mu        sync.Mutex
+	rows      map[int64]map[string]driver.Value
+	rotations int64
+}
+
+func (f *fakeClusters) Connect(context.Context) (driver.Conn, error) { return &fakeConn{f: f}, nil }
+func (f *fakeClusters) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	f        *fakeClusters
+	snapshot map[int64]map[string]driver.Value // rows as of the open transaction's start
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c: c, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.snapshot = copyRows(c.f.rows)
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.snapshot = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.rows, c.snapshot = c.snapshot, nil
+	return nil
+}
+
+func copyRows(rows map[int64]map[string]driver.Value) map[int64]map[string]driver.Value {
+	copied := make(map[int64]map[string]driver.Value, len(rows))
+	for id, row := range rows {
+		copied[id] = make(map[string]driver.Value, len(row))
+		for column, value := range row {
+			copied[id][column] = value
+		}
+	}
+	return copied
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, rows, err := s.run(args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	columns, rows, err := s.run(args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+// run executes the statement and returns the columns it selects or returns with the matched rows
+func (s *fakeStmt) run(args []driver.Value) ([]string, []map[string]driver.Value, error) {
+	f := s.c.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := s.query
+	switch {
+	case strings.HasPrefix(q, "INSERT INTO secret_rotations "):
+		f.rotations++
+		return []string{"id"}, []map[string]driver.Value{{"id": f.rotations}}, nil
+
+	case strings.HasPrefix(q, "SELECT "):
+		selected, where, ok := strings.Cut(strings.TrimPrefix(q, "SELECT "), " FROM clusters WHERE ")
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported query: %s", q)
+		}
+		matched, err := f.match(where, args)
+		return strings.Split(selected, ", "), matched, err
+
+	case strings.HasPrefix(q, "UPDATE clusters SET "):
+		set, rest, ok := strings.Cut(strings.TrimPrefix(q, "UPDATE clusters SET "), " WHERE ")
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported query: %s", q)
+		}
+		where, returning, _ := strings.Cut(rest, " RETURNING ")
+		matched, err := f.match(where, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range matched {
+			for _, assignment := range strings.Split(set, ", ") {
+				column, value, _ := strings.Cut(assignment, " = ")
+				if row[column], err = operand(value, row, args); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		var columns []string
+		if returning != "" {
+			columns = strings.Split(returning, ", ")
+		}
+		return columns, matched, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported query: %s", q)
+}
+
+func (f *fakeClusters) match(where string, args []driver.Value) ([]map[string]driver.Value, error) {
+	var matched []map[string]driver.Value
+	for _, row := range f.rows {
+		ok, err := condition(where, row, args)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, row)
+		}
+	}
+	return matched, nil
+}
+
+// condition evaluates the AND, OR, IS [NOT] NULL, = and < conditions of a WHERE clause
+func condition(expr string, row map[string]driver.Value, args []driver.Value) (bool, error) {
+	if parts := splitTopLevel(expr, " OR "); len(parts) > 1 {
+		for _, part := range parts {
+			if ok, err := condition(part, row, args); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	if parts := splitTopLevel(expr, " AND "); len(parts) > 1 {
+		for _, part := range parts {
+			if ok, err := condition(part, row, args); err != nil || !ok {
+				return ok, err
+			}
+		}
+		return true, nil
+	}
+	if strings.HasPrefix(expr, "(") && strings.HasSuffix(expr, ")") {
+		return condition(expr[1:len(expr)-1], row, args)
+	}
+	if column, ok := strings.CutSuffix(expr, " IS NOT NULL"); ok {
+		value, err := operand(column, row, args)
+		return value != nil, err
+	}
+	if column, ok := strings.CutSuffix(expr, " IS NULL"); ok {
+		value, err := operand(column, row, args)
+		return value == nil, err
+	}
+	for _, op := range []string{" = ", " < "} {
+		left, right, ok := strings.Cut(expr, op)
+		if !ok {
+			continue
+		}
+		a, err := operand(left, row, args)
+		if err != nil {
+			return false, err
+		}
+		b, err := operand(right, row, args)
+		if err != nil {
+			return false, err
+		}
+		if op == " = " {
+			return a == b, nil
+		}
+		at, aok := a.(time.Time)
+		bt, bok := b.(time.Time)
+		return aok && bok && at.Before(bt), nil
+	}
+	return false, fmt.Errorf("unsupported condition: %s", expr)
+}
+
+// operand returns the value of a parameter, NULL, COALESCE of columns or a column
+func operand(expr string, row map[string]driver.Value, args []driver.Value) (driver.Value, error) {
+	switch {
+	case strings.HasPrefix(expr, "$"):
+		n, err := strconv.Atoi(expr[1:])
+		if err != nil || n < 1 || n > len(args) {
+			return nil, fmt.Errorf("invalid parameter %s", expr)
+		}
+		return args[n-1], nil
+	case expr == "NULL":
+		return nil, nil
+	case strings.HasPrefix(expr, "COALESCE(") && strings.HasSuffix(expr, ")"):
+		for _, column := range strings.Split(expr[len("COALESCE("):len(expr)-1], ", ") {
+			if value, ok := row[column]; ok && value != nil {
+				return value, nil
+			}
+		}
+		return nil, nil
+	}
+	value, ok := row[expr]
+	if !ok {
+		return nil, fmt.Errorf("unknown column %s", expr)
+	}
+	return value, nil
+}
+
+func splitTopLevel(expr, sep string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 && strings.HasPrefix(expr[i:], sep) {
+			parts = append(parts, expr[start:i])
+			start = i + len(sep)
+		}
+	}
+	return append(parts, expr[start:])
+}
+
+type fakeRows struct {
+	columns []string
+	rows    []map[string]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	for i, column := range r.columns {
+		dest[i] = r.rows[0][column]
+	}
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// fakeClusterDB returns a database with cluster 1, whose client secret is "old"
+func fakeClusterDB(t *testing.T) (*sql.DB, *secrets.Keyring) {
+	t.Helper()
+	keyring, err := secrets.NewKeyring([]string{"k1"}, [][]byte{bytes.Repeat([]byte{1}, 32)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := keyring.Encrypt("old", clientSecretAAD(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Now().Add(-time.Hour)
+	db := sql.OpenDB(&fakeClusters{rows: map[int64]map[string]driver.Value{1: {
+		"id": int64(1), "name": "prod", "base_url": "https://kc", "realm": "master", "client_id": "multi-manage",
+		"client_secret": secret, "pending_client_secret": nil, "secret_ref": nil, "group_name": nil,
+		"metrics_endpoint": nil, "secret_rotated_at": nil, "secret_rotation_lease_until": nil,
+		"created_at": created, "updated_at": created,
+	}}})
+	t.Cleanup(func() { db.Close() })
+	return db, keyring
+}
+
+func TestClusterUpdateOverlappingRotation(t *testing.T) {
+	db, keyring := fakeClusterDB(t)
+	clusters := NewClusterRepository(db, keyring)
+	rotations := NewSecretRotationRepository(db, keyring)
+	store := NewSecretStore(db, keyring)
+
+	// An edit reads the cluster, then a rotation claims its secret
+	cluster, err := clusters.GetByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _, claimed, err := rotations.ClaimClientSecret(1, time.Now(), time.Minute)
+	if err != nil || !claimed || current != "old" {
+		t.Fatalf("ClaimClientSecret = %q, %v, %v", current, claimed, err)
+	}
+
+	// While Keycloak regenerates the secret, an edit of other settings is saved
+	cluster.Name = "renamed"
+	if err := clusters.Update(cluster, false); err != nil {
+		t.Fatalf("Update without a secret change: %v", err)
+	}
+
+	// An edit that replaced the secret is refused as a whole
+	edited := *cluster
+	edited.Name = "edited"
+	edited.ClientSecret = "edited"
+	if err := clusters.Update(&edited, true); !errors.Is(err, ErrSecretRotationInProgress) {
+		t.Fatalf("Update of the secret during a rotation = %v, want ErrSecretRotationInProgress", err)
+	}
+
+	rotation := &domain.SecretRotation{ClusterID: 1, ClientID: "multi-manage", Status: "success", Trigger: "manual"}
+	if err := rotations.CompleteClientSecretRotation(1, "rotated", rotation); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := clusters.GetByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := store.Get(context.Background(), "clusters/1")
+	if err != nil || secret != "rotated" || got.Name != "renamed" {
+		t.Fatalf("cluster has name %q and secret %q (%v), want the edited name and the rotated secret", got.Name, secret, err)
+	}
+
+	// Once the rotation released its lease, the secret can be replaced again
+	if err := clusters.Update(&edited, true); err != nil {
+		t.Fatalf("Update of the secret after the rotation: %v", err)
+	}
+	if secret, err := store.Get(context.Background(), "clusters/1"); err != nil || secret != "edited" {
+		t.Fatalf("stored secret = %q, %v, want the edited secret", secret, err)
+	}
+}
+
+func TestSecretStoreGet(t *testing.T) {
+	db, keyring := fakeClusterDB(t)
+	store := NewSecretStore(db, keyring)
+	ctx := context.Background()
+
+	if secret, err := store.Get(ctx, "clusters/1"); err != nil || secret != "old" {
+		t.Fatalf("Get(clusters/1) = %q, %v", secret, err)
+	}
+	if cluster, err := NewClusterRepository(db, keyring).GetByID(1); err != nil || cluster.ClientSecret != "" {
+		t.Errorf("GetByID loaded the client secret: %+v, %v", cluster, err)
+	}
+
+	if _, err := store.Get(ctx, "clusters/2"); !errors.Is(err, secrets.ErrNotFound) {
+		t.Errorf("Get of a missing cluster err = %v, want ErrNotFound", err)
+	}
+	for _, path := range []string{"clusters", "clusters/x", "users/1", "clusters/1/client_secret"} {
+		if _, err := store.Get(ctx, path); err == nil || !strings.Contains(err.Error(), "invalid database secret path") {
+			t.Errorf("Get(%q) err = %v, want an invalid path", path, err)
+		}
+	}
+
+	// A value moved to another row does not decrypt there
+	if _, err := db.Exec(`UPDATE clusters SET id = $1 WHERE id = $2`, 3, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "clusters/3"); err == nil {
+		t.Error("Get decrypted a secret bound to another cluster")
+	}
+}
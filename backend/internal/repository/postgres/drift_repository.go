@@ -0,0 +1,333 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"keycloak-multi-manage/internal/domain"
+	"time"
+)
+
+type DriftRepository struct {
+	db *sql.DB
+}
+
+func NewDriftRepository(db *sql.DB) *DriftRepository {
+	return &DriftRepository{db: db}
+}
+
+const clusterPairColumns = `id, name, source_cluster_id, destination_cluster_id, interval_minutes, enabled, last_run_at, created_at, updated_at`
+
+func (r *DriftRepository) GetPairs() ([]*domain.ClusterPair, error) {
+	query := `
+		SELECT ` + clusterPairColumns + `
+		FROM cluster_pairs
+		ORDER BY name
+	`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pairs []*domain.ClusterPair
+	for rows.Next() {
+		pair, err := scanClusterPair(rows)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, rows.Err()
+}
+
+func (r *DriftRepository) GetPair(id int) (*domain.ClusterPair, error) {
+	query := `
+		SELECT ` + clusterPairColumns + `
+		FROM cluster_pairs
+		WHERE id = $1
+	`
+
+	pair, err := scanClusterPair(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+func (r *DriftRepository) CreatePair(pair *domain.ClusterPair) error {
+	query := `
+		INSERT INTO cluster_pairs (name, source_cluster_id, destination_cluster_id, interval_minutes, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+
+	now := time.Now()
+	err := r.db.QueryRow(
+		query,
+		pair.Name,
+		pair.SourceClusterID,
+		pair.DestinationClusterID,
+		pair.IntervalMinutes,
+		pair.Enabled,
+		now,
+		now,
+	).Scan(&pair.ID)
+
+	if err != nil {
+		return err
+	}
+
+	pair.CreatedAt = now
+	pair.UpdatedAt = now
+	return nil
+}
+
+func (r *DriftRepository) UpdatePair(pair *domain.ClusterPair) error {
+	query := `
+		UPDATE cluster_pairs
+		SET name = $1, source_cluster_id = $2, destination_cluster_id = $3, interval_minutes = $4, enabled = $5, updated_at = $6
+		WHERE id = $7
+	`
+
+	now := time.Now()
+	_, err := r.db.Exec(
+		query,
+		pair.Name,
+		pair.SourceClusterID,
+		pair.DestinationClusterID,
+		pair.IntervalMinutes,
+		pair.Enabled,
+		now,
+		pair.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	pair.UpdatedAt = now
+	return nil
+}
+
+func (r *DriftRepository) DeletePair(id int) error {
+	_, err := r.db.Exec(`DELETE FROM cluster_pairs WHERE id = $1`, id)
+	return err
+}
+
+// ClaimDuePairs marks every enabled pair whose interval has elapsed as run now and returns them.
+// The update is atomic, so several backend instances never run the same pair twice.
+func (r *DriftRepository) ClaimDuePairs(now time.Time) ([]*domain.ClusterPair, error) {
+	query := `
+		UPDATE cluster_pairs
+		SET last_run_at = $1
+		WHERE enabled = TRUE
+		  AND (last_run_at IS NULL OR last_run_at + interval_minutes * INTERVAL '1 minute' <= $1)
+		RETURNING ` + clusterPairColumns
+
+	rows, err := r.db.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pairs []*domain.ClusterPair
+	for rows.Next() {
+		pair, err := scanClusterPair(rows)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, rows.Err()
+}
+
+// MarkPairRun records a run that was started outside the schedule
+func (r *DriftRepository) MarkPairRun(id int, now time.Time) error {
+	_, err := r.db.Exec(`UPDATE cluster_pairs SET last_run_at = $1 WHERE id = $2`, now, id)
+	return err
+}
+
+func (r *DriftRepository) CreateRun(run *domain.DriftRun) error {
+	query := `
+		INSERT INTO drift_runs (pair_id, status, started_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`
+	return r.db.QueryRow(query, run.PairID, run.Status, run.StartedAt).Scan(&run.ID)
+}
+
+func (r *DriftRepository) FinishRun(run *domain.DriftRun) error {
+	query := `
+		UPDATE drift_runs
+		SET status = $1, drift_count = $2, counts = $3, items = $4, error = $5, finished_at = $6
+		WHERE id = $7
+	`
+
+	countsJSON, err := json.Marshal(run.Counts)
+	if err != nil {
+		return err
+	}
+	itemsJSON, err := json.Marshal(run.Items)
+	if err != nil {
+		return err
+	}
+	if run.Items == nil {
+		itemsJSON = []byte("[]")
+	}
+
+	now := time.Now()
+	_, err = r.db.Exec(query, run.Status, run.DriftCount, string(countsJSON), string(itemsJSON), run.Error, now, run.ID)
+	if err != nil {
+		return err
+	}
+
+	run.FinishedAt = &now
+	return nil
+}
+
+// GetLatestRun returns the newest successful run of a pair started at or before the given time
+func (r *DriftRepository) GetLatestRun(pairID int, before time.Time) (*domain.DriftRun, error) {
+	query := `
+		SELECT id, pair_id, status, drift_count, counts, items, error, started_at, finished_at
+		FROM drift_runs
+		WHERE pair_id = $1 AND status = 'success' AND started_at <= $2
+		ORDER BY started_at DESC
+		LIMIT 1
+	`
+
+	run, err := scanDriftRun(r.db.QueryRow(query, pairID, before), true)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+func (r *DriftRepository) GetRun(id int) (*domain.DriftRun, error) {
+	query := `
+		SELECT id, pair_id, status, drift_count, counts, items, error, started_at, finished_at
+		FROM drift_runs
+		WHERE id = $1
+	`
+
+	run, err := scanDriftRun(r.db.QueryRow(query, id), true)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// GetRuns lists the recent runs of a pair without their items
+func (r *DriftRepository) GetRuns(pairID, limit int) ([]*domain.DriftRun, error) {
+	query := `
+		SELECT id, pair_id, status, drift_count, counts, '[]', error, started_at, finished_at
+		FROM drift_runs
+		WHERE pair_id = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(query, pairID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*domain.DriftRun
+	for rows.Next() {
+		run, err := scanDriftRun(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// DeleteRunsBefore removes runs older than the retention period
+func (r *DriftRepository) DeleteRunsBefore(t time.Time) (int64, error) {
+	res, err := r.db.Exec(`DELETE FROM drift_runs WHERE started_at < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanClusterPair(row rowScanner) (*domain.ClusterPair, error) {
+	pair := &domain.ClusterPair{}
+	var lastRunAt sql.NullTime
+
+	err := row.Scan(
+		&pair.ID,
+		&pair.Name,
+		&pair.SourceClusterID,
+		&pair.DestinationClusterID,
+		&pair.IntervalMinutes,
+		&pair.Enabled,
+		&lastRunAt,
+		&pair.CreatedAt,
+		&pair.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastRunAt.Valid {
+		pair.LastRunAt = &lastRunAt.Time
+	}
+
+	return pair, nil
+}
+
+func scanDriftRun(row rowScanner, withItems bool) (*domain.DriftRun, error) {
+	run := &domain.DriftRun{}
+	var countsJSON, itemsJSON string
+	var runError sql.NullString
+	var finishedAt sql.NullTime
+
+	err := row.Scan(
+		&run.ID,
+		&run.PairID,
+		&run.Status,
+		&run.DriftCount,
+		&countsJSON,
+		&itemsJSON,
+		&runError,
+		&run.StartedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(countsJSON), &run.Counts); err != nil {
+		return nil, err
+	}
+	if withItems {
+		if err := json.Unmarshal([]byte(itemsJSON), &run.Items); err != nil {
+			return nil, err
+		}
+	}
+	if runError.Valid {
+		run.Error = runError.String
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+
+	return run, nil
+}
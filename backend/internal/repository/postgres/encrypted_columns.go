@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"keycloak-multi-manage/pkg/secrets"
+)
+
+// Encrypted values are bound to the table, column and row they are stored in
+func clientSecretAAD(clusterID int) string {
+	return secrets.AAD("clusters", "client_secret", clusterID)
+}
+
+func pendingClientSecretAAD(clusterID int) string {
+	return secrets.AAD("clusters", "pending_client_secret", clusterID)
+}
+
+func bindPasswordAAD(configID int) string {
+	return secrets.AAD("ldap_config", "bind_password", configID)
+}
+
+// nextID takes the next ID of a table's serial column, for rows whose encrypted values need the ID before they are inserted
+func nextID(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, table string) (int, error) {
+	var id int
+	err := q.QueryRow(`SELECT nextval(pg_get_serial_sequence($1, 'id'))`, table).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate %s id: %w", table, err)
+	}
+	return id, nil
+}
+
+// reencryptColumn encrypts the plaintext and legacy values of an encrypted column within tx and, with rotate,
+// re-wraps the ones encrypted with an older key for the active key. It returns the number of changed values.
+func reencryptColumn(tx *sql.Tx, keyring *secrets.Keyring, table, column string, rotate bool) (int, error) {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT id, %[2]s FROM %[1]s WHERE %[2]s IS NOT NULL AND %[2]s <> '' FOR UPDATE`, table, column))
+	if err != nil {
+		return 0, err
+	}
+	stored := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stored[id] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for id, value := range stored {
+		if secrets.IsEncrypted(value) && (!rotate || !keyring.NeedsRotation(value)) {
+			continue
+		}
+		encrypted, err := keyring.Rotate(value, secrets.AAD(table, column, id))
+		if err != nil {
+			return 0, fmt.Errorf("failed to re-encrypt %s of %s %d: %w", column, table, id, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE id = $2`, table, column), encrypted, id); err != nil {
+			return 0, err
+		}
+		changed++
+	}
+	return changed, nil
+}
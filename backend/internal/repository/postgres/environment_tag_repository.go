@@ -219,3 +219,23 @@ func (r *EnvironmentTagRepository) RemoveAllTagsFromCluster(clusterID int) error
 	return err
 }
 
+
+// GetClusterIDsByTagID lists the clusters a tag is assigned to
+func (r *EnvironmentTagRepository) GetClusterIDsByTagID(tagID int) ([]int, error) {
+	rows, err := r.db.Query(`SELECT cluster_id FROM cluster_environment_tags WHERE tag_id = $1 ORDER BY cluster_id`, tagID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	var clusterIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		clusterIDs = append(clusterIDs, id)
+	}
+	
+	return clusterIDs, rows.Err()
+}
@@ -0,0 +1,420 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"strings"
+	"time"
+)
+
+type JobRepository struct {
+	db *sql.DB
+}
+
+func NewJobRepository(db *sql.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+const jobColumns = `id, type, status, payload, result, error, progress_done, progress_total, attempts, max_attempts,
+		       cancel_requested, run_after, output_content_type IS NOT NULL, created_by, created_at, started_at, finished_at, updated_at`
+
+func (r *JobRepository) Create(job *domain.Job) error {
+	query := `
+		INSERT INTO jobs (type, status, payload, max_attempts, run_after, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		RETURNING id
+	`
+
+	payload := job.Payload
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+
+	now := time.Now()
+	if job.RunAfter.IsZero() {
+		job.RunAfter = now
+	}
+	err := r.db.QueryRow(query, job.Type, job.Status, string(payload), job.MaxAttempts, job.RunAfter, job.CreatedBy, now).Scan(&job.ID)
+	if err != nil {
+		return err
+	}
+
+	job.Payload = payload
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	return nil
+}
+
+func (r *JobRepository) GetByID(id int) (*domain.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+
+	job, err := scanJob(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// List returns the most recent jobs, optionally filtered by status and type
+func (r *JobRepository) List(status, jobType string, limit int) ([]*domain.Job, error) {
+	var conditions []string
+	var args []interface{}
+	if status != "" {
+		args = append(args, status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if jobType != "" {
+		args = append(args, jobType)
+		conditions = append(conditions, fmt.Sprintf("type = $%d", len(args)))
+	}
+
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(` ORDER BY created_at DESC, id DESC LIMIT $%d`, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*domain.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// Claim moves the oldest due queued job into the running state for a worker.
+// SKIP LOCKED lets several workers, also of different server instances, claim jobs concurrently.
+func (r *JobRepository) Claim(workerID string) (*domain.Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running', locked_by = $1, attempts = attempts + 1, heartbeat_at = NOW(),
+		    started_at = COALESCE(started_at, NOW()), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = 'queued' AND run_after <= NOW()
+			ORDER BY run_after, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+
+	job, err := scanJob(r.db.QueryRow(query, workerID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Heartbeat stores the progress of a job the worker is running and tells whether cancelling it was requested.
+// owned is false when the job is no longer locked by the worker, e.g. because it was recovered as stale
+// and claimed again; the worker must then stop without storing anything.
+func (r *JobRepository) Heartbeat(id int, workerID string, done, total int) (cancelRequested, owned bool, err error) {
+	err = r.db.QueryRow(`
+		UPDATE jobs
+		SET progress_done = $1, progress_total = $2, heartbeat_at = NOW(), updated_at = NOW()
+		WHERE id = $3 AND locked_by = $4 AND status = 'running'
+		RETURNING cancel_requested
+	`, done, total, id, workerID).Scan(&cancelRequested)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return cancelRequested, true, nil
+}
+
+// Finish stores the final state of a job the worker is running. It returns false, and stores nothing,
+// when the job is no longer locked by the worker.
+func (r *JobRepository) Finish(id int, workerID string, status string, result json.RawMessage, errMsg string, done, total int) (bool, error) {
+	var resultValue interface{}
+	if len(result) > 0 {
+		resultValue = string(result)
+	}
+	var errValue interface{}
+	if errMsg != "" {
+		errValue = errMsg
+	}
+
+	res, err := r.db.Exec(`
+		UPDATE jobs
+		SET status = $1, result = $2, error = $3, progress_done = $4, progress_total = $5,
+		    locked_by = NULL, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $6 AND locked_by = $7
+	`, status, resultValue, errValue, done, total, id, workerID)
+	return rowsAffected(res, err)
+}
+
+// Requeue puts a failed attempt of a job the worker is running back into the queue to be retried after runAfter.
+// Like Finish it returns false when the job is no longer locked by the worker.
+func (r *JobRepository) Requeue(id int, workerID string, errMsg string, runAfter time.Time) (bool, error) {
+	res, err := r.db.Exec(`
+		UPDATE jobs
+		SET status = 'queued', error = $1, run_after = $2, locked_by = NULL, updated_at = NOW()
+		WHERE id = $3 AND locked_by = $4
+	`, errMsg, runAfter, id, workerID)
+	return rowsAffected(res, err)
+}
+
+func rowsAffected(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// RequestCancel cancels a queued job right away and flags a running one, whose worker stops at the next heartbeat
+func (r *JobRepository) RequestCancel(id int) (bool, error) {
+	res, err := r.db.Exec(`
+		UPDATE jobs
+		SET cancel_requested = TRUE,
+		    status = CASE WHEN status = 'queued' THEN 'cancelled' ELSE status END,
+		    finished_at = CASE WHEN status = 'queued' THEN NOW() ELSE finished_at END,
+		    updated_at = NOW()
+		WHERE id = $1 AND status IN ('queued', 'running')
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// Retry queues a failed or cancelled job again with a fresh set of attempts
+func (r *JobRepository) Retry(id int) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE jobs
+		SET status = 'queued', attempts = 0, cancel_requested = FALSE, error = NULL, result = NULL,
+		    progress_done = 0, progress_total = 0, output_content_type = NULL,
+		    run_after = NOW(), started_at = NULL, finished_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND status IN ('failed', 'cancelled')
+	`, id)
+	retried, err := rowsAffected(res, err)
+	if err != nil || !retried {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM job_output_chunks WHERE job_id = $1`, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RecoverStale requeues running jobs whose worker stopped sending heartbeats, e.g. because the server restarted.
+// Jobs that used up their attempts fail instead.
+func (r *JobRepository) RecoverStale(staleBefore time.Time) (int, error) {
+	res, err := r.db.Exec(`
+		UPDATE jobs
+		SET status = CASE
+		        WHEN cancel_requested THEN 'cancelled'
+		        WHEN attempts >= max_attempts THEN 'failed'
+		        ELSE 'queued'
+		    END,
+		    error = CASE
+		        WHEN cancel_requested THEN error
+		        ELSE 'worker stopped while the job was running'
+		    END,
+		    finished_at = CASE
+		        WHEN cancel_requested OR attempts >= max_attempts THEN NOW()
+		        ELSE NULL
+		    END,
+		    run_after = NOW(), locked_by = NULL, updated_at = NOW()
+		WHERE status = 'running' AND (heartbeat_at IS NULL OR heartbeat_at < $1)
+	`, staleBefore)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+func (r *JobRepository) AddLog(entry *domain.JobLog) error {
+	return r.db.QueryRow(`
+		INSERT INTO job_logs (job_id, level, message, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, entry.JobID, entry.Level, entry.Message, entry.CreatedAt).Scan(&entry.ID)
+}
+
+// GetLogs returns the log lines of a job written after the line afterID
+func (r *JobRepository) GetLogs(jobID, afterID, limit int) ([]domain.JobLog, error) {
+	rows, err := r.db.Query(`
+		SELECT id, job_id, level, message, created_at
+		FROM job_logs
+		WHERE job_id = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3
+	`, jobID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []domain.JobLog{}
+	for rows.Next() {
+		var entry domain.JobLog
+		if err := rows.Scan(&entry.ID, &entry.JobID, &entry.Level, &entry.Message, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		logs = append(logs, entry)
+	}
+
+	return logs, rows.Err()
+}
+
+// ResetOutput drops the output an earlier attempt of a job the worker is running started to write.
+// It returns false when the job is no longer locked by the worker.
+func (r *JobRepository) ResetOutput(id int, workerID string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE jobs SET output_content_type = NULL, updated_at = NOW() WHERE id = $1 AND locked_by = $2`, id, workerID)
+	owned, err := rowsAffected(res, err)
+	if err != nil || !owned {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM job_output_chunks WHERE job_id = $1`, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// AddOutputChunk appends a chunk to the output of a job the worker is running.
+// It returns false when the job is no longer locked by the worker.
+func (r *JobRepository) AddOutputChunk(id int, workerID string, seq int, data []byte) (bool, error) {
+	res, err := r.db.Exec(`
+		INSERT INTO job_output_chunks (job_id, seq, data)
+		SELECT id, $2, $3 FROM jobs WHERE id = $1 AND locked_by = $4
+	`, id, seq, data, workerID)
+	return rowsAffected(res, err)
+}
+
+// CompleteOutput marks the output of a job the worker is running as complete, which makes it downloadable.
+// It returns false when the job is no longer locked by the worker.
+func (r *JobRepository) CompleteOutput(id int, workerID string, contentType string) (bool, error) {
+	res, err := r.db.Exec(`UPDATE jobs SET output_content_type = $1, updated_at = NOW() WHERE id = $2 AND locked_by = $3`, contentType, id, workerID)
+	return rowsAffected(res, err)
+}
+
+// GetOutputInfo returns the content type and size of the file a job produced, or an empty content type if it has none
+func (r *JobRepository) GetOutputInfo(id int) (string, int64, error) {
+	var contentType sql.NullString
+	var size int64
+	err := r.db.QueryRow(`
+		SELECT output_content_type, COALESCE((SELECT SUM(octet_length(data)) FROM job_output_chunks WHERE job_id = jobs.id), 0)
+		FROM jobs WHERE id = $1
+	`, id).Scan(&contentType, &size)
+	if err == sql.ErrNoRows {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return contentType.String, size, nil
+}
+
+// ForEachOutputChunk reads the file a job produced chunk by chunk, in order
+func (r *JobRepository) ForEachOutputChunk(id int, fn func(data []byte) error) error {
+	rows, err := r.db.Query(`SELECT data FROM job_output_chunks WHERE job_id = $1 ORDER BY seq`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func scanJob(row rowScanner) (*domain.Job, error) {
+	job := &domain.Job{}
+	var payload string
+	var result, errMsg sql.NullString
+	var createdBy sql.NullInt64
+	var startedAt, finishedAt sql.NullTime
+
+	err := row.Scan(
+		&job.ID,
+		&job.Type,
+		&job.Status,
+		&payload,
+		&result,
+		&errMsg,
+		&job.ProgressDone,
+		&job.ProgressTotal,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.CancelRequested,
+		&job.RunAfter,
+		&job.HasOutput,
+		&createdBy,
+		&job.CreatedAt,
+		&startedAt,
+		&finishedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Payload = json.RawMessage(payload)
+	if result.Valid && result.String != "" {
+		job.Result = json.RawMessage(result.String)
+	}
+	job.Error = errMsg.String
+	job.CreatedBy = nullIntPtr(createdBy)
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	return job, nil
+}
@@ -0,0 +1,287 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"strings"
+	"time"
+)
+
+type KeycloakEventRepository struct {
+	db *sql.DB
+}
+
+func NewKeycloakEventRepository(db *sql.DB) *KeycloakEventRepository {
+	return &KeycloakEventRepository{db: db}
+}
+
+// Save stores events in one transaction. Events that were stored before are skipped;
+// the number of newly stored events is returned.
+func (r *KeycloakEventRepository) Save(events []*domain.KeycloakEvent) (int, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO keycloak_events (cluster_id, realm, kind, event_key, event_time, type, user_id, username, client_id,
+		                             ip_address, session_id, resource_type, resource_path, error, details, collected_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		ON CONFLICT (cluster_id, kind, event_key) DO NOTHING
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	stored := 0
+	for _, event := range events {
+		res, err := stmt.Exec(
+			event.ClusterID,
+			event.Realm,
+			event.Kind,
+			event.EventKey,
+			event.Time,
+			event.Type,
+			nullString(event.UserID),
+			nullString(event.Username),
+			nullString(event.ClientID),
+			nullString(event.IPAddress),
+			nullString(event.SessionID),
+			nullString(event.ResourceType),
+			nullString(event.ResourcePath),
+			nullString(event.Error),
+			nullJSON(event.Details),
+			event.CollectedAt,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to store event %s: %w", event.EventKey, err)
+		}
+		n, _ := res.RowsAffected()
+		stored += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return stored, nil
+}
+
+// Search returns one page of the events that match the filter, newest first, and the number of all matches
+func (r *KeycloakEventRepository) Search(filter domain.KeycloakEventFilter) ([]*domain.KeycloakEvent, int, error) {
+	where, args := keycloakEventConditions(filter)
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM keycloak_events e`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`
+		SELECT e.id, e.cluster_id, c.name, e.realm, e.kind, e.event_key, e.event_time, e.type, e.user_id, e.username, e.client_id,
+		       e.ip_address, e.session_id, e.resource_type, e.resource_path, e.error, e.details, e.collected_at
+		FROM keycloak_events e
+		LEFT JOIN clusters c ON c.id = e.cluster_id%s
+		ORDER BY e.event_time DESC, e.id DESC
+		LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []*domain.KeycloakEvent{}
+	for rows.Next() {
+		event := &domain.KeycloakEvent{}
+		var clusterName, userID, username, clientID, ip, sessionID, resourceType, resourcePath, errMsg, details sql.NullString
+		err := rows.Scan(
+			&event.ID,
+			&event.ClusterID,
+			&clusterName,
+			&event.Realm,
+			&event.Kind,
+			&event.EventKey,
+			&event.Time,
+			&event.Type,
+			&userID,
+			&username,
+			&clientID,
+			&ip,
+			&sessionID,
+			&resourceType,
+			&resourcePath,
+			&errMsg,
+			&details,
+			&event.CollectedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		event.ClusterName = clusterName.String
+		event.UserID = userID.String
+		event.Username = username.String
+		event.ClientID = clientID.String
+		event.IPAddress = ip.String
+		event.SessionID = sessionID.String
+		event.ResourceType = resourceType.String
+		event.ResourcePath = resourcePath.String
+		event.Error = errMsg.String
+		if details.Valid {
+			event.Details = json.RawMessage(details.String)
+		}
+		events = append(events, event)
+	}
+
+	return events, total, rows.Err()
+}
+
+func keycloakEventConditions(filter domain.KeycloakEventFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ClusterID != 0 {
+		add("e.cluster_id = $%d", filter.ClusterID)
+	}
+	if filter.Kind != "" {
+		add("e.kind = $%d", filter.Kind)
+	}
+	if filter.User != "" {
+		add("(e.user_id = $%[1]d OR e.username ILIKE '%%' || $%[1]d || '%%')", filter.User)
+	}
+	if filter.Client != "" {
+		add("e.client_id = $%d", filter.Client)
+	}
+	if filter.IPAddress != "" {
+		add("e.ip_address = $%d", filter.IPAddress)
+	}
+	if filter.Type != "" {
+		add("e.type = UPPER($%d)", filter.Type)
+	}
+	if filter.From != nil {
+		add("e.event_time >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("e.event_time < $%d", *filter.To)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// DeleteBefore deletes the events that happened before t
+func (r *KeycloakEventRepository) DeleteBefore(t time.Time) (int64, error) {
+	res, err := r.db.Exec(`DELETE FROM keycloak_events WHERE event_time < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ClaimCheckpoint returns the checkpoint of a cluster's events of one kind for a scheduled collection,
+// unless a collection was started within the last interval. Claiming keeps several server instances
+// from collecting the same events at once.
+func (r *KeycloakEventRepository) ClaimCheckpoint(clusterID int, kind string, now time.Time, interval time.Duration) (*domain.KeycloakEventCheckpoint, error) {
+	_, err := r.db.Exec(`
+		INSERT INTO keycloak_event_checkpoints (cluster_id, kind) VALUES ($1, $2)
+		ON CONFLICT (cluster_id, kind) DO NOTHING
+	`, clusterID, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE keycloak_event_checkpoints k
+		SET claimed_at = $3
+		WHERE k.cluster_id = $1 AND k.kind = $2 AND (k.claimed_at IS NULL OR k.claimed_at <= $4)
+		RETURNING ` + keycloakEventCheckpointColumns
+	checkpoint, err := scanKeycloakEventCheckpoint(r.db.QueryRow(query, clusterID, kind, now, now.Add(-interval)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return checkpoint, err
+}
+
+// SaveCheckpoint records the outcome of a collection
+func (r *KeycloakEventRepository) SaveCheckpoint(checkpoint *domain.KeycloakEventCheckpoint) error {
+	query := `
+		UPDATE keycloak_event_checkpoints
+		SET last_event_time = $3, last_collected_at = $4, last_error = $5, collected = $6
+		WHERE cluster_id = $1 AND kind = $2
+	`
+	_, err := r.db.Exec(
+		query,
+		checkpoint.ClusterID,
+		checkpoint.Kind,
+		checkpoint.LastEventTime,
+		checkpoint.LastCollectedAt,
+		nullString(checkpoint.LastError),
+		checkpoint.Collected,
+	)
+	return err
+}
+
+// GetCheckpoints lists the checkpoints of all clusters
+func (r *KeycloakEventRepository) GetCheckpoints() ([]*domain.KeycloakEventCheckpoint, error) {
+	rows, err := r.db.Query(`SELECT ` + keycloakEventCheckpointColumns + ` FROM keycloak_event_checkpoints k ORDER BY k.cluster_id, k.kind`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checkpoints := []*domain.KeycloakEventCheckpoint{}
+	for rows.Next() {
+		checkpoint, err := scanKeycloakEventCheckpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	return checkpoints, rows.Err()
+}
+
+const keycloakEventCheckpointColumns = `k.cluster_id, (SELECT name FROM clusters WHERE id = k.cluster_id), k.kind,
+		k.last_event_time, k.last_collected_at, k.last_error, k.collected`
+
+func scanKeycloakEventCheckpoint(row rowScanner) (*domain.KeycloakEventCheckpoint, error) {
+	checkpoint := &domain.KeycloakEventCheckpoint{}
+	var clusterName, lastError sql.NullString
+	var lastEventTime, lastCollectedAt sql.NullTime
+
+	err := row.Scan(
+		&checkpoint.ClusterID,
+		&clusterName,
+		&checkpoint.Kind,
+		&lastEventTime,
+		&lastCollectedAt,
+		&lastError,
+		&checkpoint.Collected,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint.ClusterName = clusterName.String
+	if lastEventTime.Valid {
+		checkpoint.LastEventTime = &lastEventTime.Time
+	}
+	if lastCollectedAt.Valid {
+		checkpoint.LastCollectedAt = &lastCollectedAt.Time
+	}
+	checkpoint.LastError = lastError.String
+	return checkpoint, nil
+}
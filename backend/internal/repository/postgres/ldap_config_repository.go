@@ -3,7 +3,9 @@ package postgres
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/pkg/secrets"
 	"time"
 )
 
@@ -14,17 +16,20 @@ func nullStringToString(ns sql.NullString) string {
 	return ""
 }
 
+// LDAPConfigRepository stores the LDAP configuration. The bind password is encrypted with the keyring and,
+// like a cluster's client secret, not loaded with the configuration: binds read it through SecretStore.
 type LDAPConfigRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	keyring *secrets.Keyring
 }
 
-func NewLDAPConfigRepository(db *sql.DB) *LDAPConfigRepository {
-	return &LDAPConfigRepository{db: db}
+func NewLDAPConfigRepository(db *sql.DB, keyring *secrets.Keyring) *LDAPConfigRepository {
+	return &LDAPConfigRepository{db: db, keyring: keyring}
 }
 
 func (r *LDAPConfigRepository) Get() (*domain.LDAPConfig, error) {
 	query := `
-		SELECT id, enabled, server_url, bind_dn, bind_password, user_search_base, 
+		SELECT id, enabled, server_url, bind_dn, bind_password_ref, user_search_base, 
 		       user_search_filter, group_search_base, group_search_filter, 
 		       use_ssl, use_tls, skip_verify, timeout_seconds, 
 		       certificate_pem, certificate_info, certificate_fingerprint,
@@ -36,7 +41,7 @@ func (r *LDAPConfigRepository) Get() (*domain.LDAPConfig, error) {
 
 	config := &domain.LDAPConfig{}
 	var groupSearchBase, groupSearchFilter sql.NullString
-	var userSearchFilter sql.NullString
+	var userSearchFilter, bindPasswordRef sql.NullString
 	var certPEM, certFingerprint sql.NullString
 	var certInfoJSON sql.NullString
 	
@@ -45,7 +50,7 @@ func (r *LDAPConfigRepository) Get() (*domain.LDAPConfig, error) {
 		&config.Enabled,
 		&config.ServerURL,
 		&config.BindDN,
-		&config.BindPassword,
+		&bindPasswordRef,
 		&config.UserSearchBase,
 		&userSearchFilter,
 		&groupSearchBase,
@@ -75,6 +80,7 @@ func (r *LDAPConfigRepository) Get() (*domain.LDAPConfig, error) {
 	}
 
 	// Convert NULL strings to empty strings
+	config.BindPasswordRef = nullStringToString(bindPasswordRef)
 	config.UserSearchFilter = nullStringToString(userSearchFilter)
 	if config.UserSearchFilter == "" {
 		config.UserSearchFilter = "(uid={0})"
@@ -106,14 +112,23 @@ func (r *LDAPConfigRepository) Update(req *domain.UpdateLDAPConfigRequest) (*dom
 	now := time.Now()
 	var config *domain.LDAPConfig
 
+	// A password in an external store is not kept here
+	bindPasswordRef := existing.BindPasswordRef
+	if req.BindPasswordRef != nil {
+		bindPasswordRef = *req.BindPasswordRef
+	}
+	password := req.BindPassword
+	if bindPasswordRef != "" {
+		password = ""
+	}
+
 	if existing.ID == 0 {
 		// Insert new config
 		query := `
-			INSERT INTO ldap_config (enabled, server_url, bind_dn, bind_password, user_search_base,
+			INSERT INTO ldap_config (id, enabled, server_url, bind_dn, bind_password, bind_password_ref, user_search_base,
 			                         user_search_filter, group_search_base, group_search_filter,
 			                         use_ssl, use_tls, skip_verify, timeout_seconds, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
-			RETURNING id
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 		`
 
 		userSearchFilter := req.UserSearchFilter
@@ -126,13 +141,24 @@ func (r *LDAPConfigRepository) Update(req *domain.UpdateLDAPConfigRequest) (*dom
 			timeoutSeconds = 10
 		}
 
-		var id int
-		err := r.db.QueryRow(
+		// The password is bound to the config's ID, so the ID is taken before the row is inserted
+		id, err := nextID(r.db, "ldap_config")
+		if err != nil {
+			return nil, err
+		}
+		bindPassword, err := r.keyring.Encrypt(password, bindPasswordAAD(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt LDAP bind password: %w", err)
+		}
+
+		_, err = r.db.Exec(
 			query,
+			id,
 			req.Enabled,
 			req.ServerURL,
 			req.BindDN,
-			req.BindPassword,
+			bindPassword,
+			nullString(bindPasswordRef),
 			req.UserSearchBase,
 			userSearchFilter,
 			req.GroupSearchBase,
@@ -143,7 +169,7 @@ func (r *LDAPConfigRepository) Update(req *domain.UpdateLDAPConfigRequest) (*dom
 			timeoutSeconds,
 			now,
 			now,
-		).Scan(&id)
+		)
 
 		if err != nil {
 			return nil, err
@@ -154,7 +180,7 @@ func (r *LDAPConfigRepository) Update(req *domain.UpdateLDAPConfigRequest) (*dom
 			Enabled:          req.Enabled,
 			ServerURL:        req.ServerURL,
 			BindDN:           req.BindDN,
-			BindPassword:     req.BindPassword,
+			BindPasswordRef:  bindPasswordRef,
 			UserSearchBase:   req.UserSearchBase,
 			UserSearchFilter: userSearchFilter,
 			GroupSearchBase:   req.GroupSearchBase,
@@ -168,14 +194,6 @@ func (r *LDAPConfigRepository) Update(req *domain.UpdateLDAPConfigRequest) (*dom
 		}
 	} else {
 		// Update existing config
-		// If password is empty, keep the existing one
-		var password string
-		if req.BindPassword != "" {
-			password = req.BindPassword
-		} else {
-			password = existing.BindPassword
-		}
-
 		userSearchFilter := req.UserSearchFilter
 		if userSearchFilter == "" {
 			userSearchFilter = existing.UserSearchFilter
@@ -194,19 +212,30 @@ func (r *LDAPConfigRepository) Update(req *domain.UpdateLDAPConfigRequest) (*dom
 
 		query := `
 			UPDATE ldap_config 
-			SET enabled = $1, server_url = $2, bind_dn = $3, bind_password = $4,
-			    user_search_base = $5, user_search_filter = $6, group_search_base = $7,
-			    group_search_filter = $8, use_ssl = $9, use_tls = $10, skip_verify = $11,
-			    timeout_seconds = $12, updated_at = $13
-			WHERE id = $14
+			SET enabled = $1, server_url = $2, bind_dn = $3, bind_password = COALESCE($4, bind_password),
+			    bind_password_ref = $5, user_search_base = $6, user_search_filter = $7, group_search_base = $8,
+			    group_search_filter = $9, use_ssl = $10, use_tls = $11, skip_verify = $12,
+			    timeout_seconds = $13, updated_at = $14
+			WHERE id = $15
 		`
 
-		_, err := r.db.Exec(
+		// If password is empty, keep the existing one, unless it moved to an external store
+		var bindPassword interface{}
+		if password != "" || bindPasswordRef != "" {
+			encrypted, err := r.keyring.Encrypt(password, bindPasswordAAD(existing.ID))
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt LDAP bind password: %w", err)
+			}
+			bindPassword = encrypted
+		}
+
+		_, err = r.db.Exec(
 			query,
 			req.Enabled,
 			req.ServerURL,
 			req.BindDN,
-			password,
+			bindPassword,
+			nullString(bindPasswordRef),
 			req.UserSearchBase,
 			userSearchFilter,
 			req.GroupSearchBase,
@@ -228,7 +257,7 @@ func (r *LDAPConfigRepository) Update(req *domain.UpdateLDAPConfigRequest) (*dom
 			Enabled:          req.Enabled,
 			ServerURL:        req.ServerURL,
 			BindDN:           req.BindDN,
-			BindPassword:     password,
+			BindPasswordRef:  bindPasswordRef,
 			UserSearchBase:   req.UserSearchBase,
 			UserSearchFilter: userSearchFilter,
 			GroupSearchBase:   req.GroupSearchBase,
@@ -242,8 +271,6 @@ func (r *LDAPConfigRepository) Update(req *domain.UpdateLDAPConfigRequest) (*dom
 		}
 	}
 
-	// Clear password from response
-	config.BindPassword = ""
 	return config, nil
 }
 
@@ -307,3 +334,22 @@ func (r *LDAPConfigRepository) DeleteCertificate() error {
 	return err
 }
 
+// ReencryptSecrets encrypts a bind password that is still stored in plaintext or not yet bound to its config
+// and, with rotate, re-wraps one encrypted with an older key for the active key. It returns the number of changed configs.
+func (r *LDAPConfigRepository) ReencryptSecrets(rotate bool) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	changed, err := reencryptColumn(tx, r.keyring, "ldap_config", "bind_password", rotate)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
@@ -103,17 +103,51 @@ func (r *PermissionRepository) GetByUserID(userID int) ([]*domain.Permission, er
 	return permissions, rows.Err()
 }
 
-func (r *PermissionRepository) HasPermission(userID int, permissionName string) (bool, error) {
+// GetUserGrants returns the permissions of a user with the clusters they apply to. Roles scoped to clusters
+// or environment tags contribute their clusters; a role with the all scope covers every cluster.
+func (r *PermissionRepository) GetUserGrants(userID int) (map[string]*domain.PermissionGrant, error) {
 	query := `
-		SELECT COUNT(*) > 0
-		FROM permissions p
-		INNER JOIN role_permissions rp ON p.id = rp.permission_id
-		INNER JOIN user_roles ur ON rp.role_id = ur.role_id
-		WHERE ur.user_id = $1 AND p.name = $2
+		SELECT p.name, r.scope = 'all', s.cluster_id
+		FROM user_roles ur
+		INNER JOIN roles r ON r.id = ur.role_id
+		INNER JOIN role_permissions rp ON rp.role_id = r.id
+		INNER JOIN permissions p ON p.id = rp.permission_id
+		LEFT JOIN (
+			SELECT role_id, cluster_id FROM role_scope_clusters
+			UNION
+			SELECT st.role_id, ct.cluster_id
+			FROM role_scope_tags st
+			INNER JOIN cluster_environment_tags ct ON ct.tag_id = st.tag_id
+		) s ON s.role_id = r.id AND r.scope <> 'all'
+		WHERE ur.user_id = $1
 	`
 	
-	var hasPermission bool
-	err := r.db.QueryRow(query, userID, permissionName).Scan(&hasPermission)
-	return hasPermission, err
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	grants := make(map[string]*domain.PermissionGrant)
+	for rows.Next() {
+		var name string
+		var allClusters bool
+		var clusterID sql.NullInt64
+		if err := rows.Scan(&name, &allClusters, &clusterID); err != nil {
+			return nil, err
+		}
+		
+		grant, ok := grants[name]
+		if !ok {
+			grant = &domain.PermissionGrant{ClusterIDs: make(map[int]bool)}
+			grants[name] = grant
+		}
+		if allClusters {
+			grant.AllClusters = true
+		} else if clusterID.Valid {
+			grant.ClusterIDs[int(clusterID.Int64)] = true
+		}
+	}
+	
+	return grants, rows.Err()
 }
-
@@ -0,0 +1,199 @@
+package postgres
+
+import (
+	"database/sql"
+	"keycloak-multi-manage/internal/domain"
+	"time"
+)
+
+type RewriteRuleRepository struct {
+	db *sql.DB
+}
+
+func NewRewriteRuleRepository(db *sql.DB) *RewriteRuleRepository {
+	return &RewriteRuleRepository{db: db}
+}
+
+const rewriteRuleColumns = `id, name, description, source_cluster_id, destination_cluster_id, source_tag_id, destination_tag_id,
+		       object_type, field, match_value, replace_value, is_regex, priority, enabled, created_at, updated_at`
+
+func (r *RewriteRuleRepository) GetAll() ([]*domain.RewriteRule, error) {
+	query := `
+		SELECT ` + rewriteRuleColumns + `
+		FROM rewrite_rules
+		ORDER BY priority, id
+	`
+	return r.query(query)
+}
+
+// GetEnabled returns the enabled rules in the order they are applied
+func (r *RewriteRuleRepository) GetEnabled() ([]*domain.RewriteRule, error) {
+	query := `
+		SELECT ` + rewriteRuleColumns + `
+		FROM rewrite_rules
+		WHERE enabled = TRUE
+		ORDER BY priority, id
+	`
+	return r.query(query)
+}
+
+func (r *RewriteRuleRepository) GetByID(id int) (*domain.RewriteRule, error) {
+	query := `
+		SELECT ` + rewriteRuleColumns + `
+		FROM rewrite_rules
+		WHERE id = $1
+	`
+
+	rule, err := scanRewriteRule(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+func (r *RewriteRuleRepository) Create(rule *domain.RewriteRule) error {
+	query := `
+		INSERT INTO rewrite_rules (name, description, source_cluster_id, destination_cluster_id, source_tag_id, destination_tag_id,
+		                           object_type, field, match_value, replace_value, is_regex, priority, enabled, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id
+	`
+
+	now := time.Now()
+	err := r.db.QueryRow(
+		query,
+		rule.Name,
+		rule.Description,
+		rule.SourceClusterID,
+		rule.DestinationClusterID,
+		rule.SourceTagID,
+		rule.DestinationTagID,
+		rule.ObjectType,
+		rule.Field,
+		rule.Match,
+		rule.Replace,
+		rule.IsRegex,
+		rule.Priority,
+		rule.Enabled,
+		now,
+		now,
+	).Scan(&rule.ID)
+
+	if err != nil {
+		return err
+	}
+
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	return nil
+}
+
+func (r *RewriteRuleRepository) Update(rule *domain.RewriteRule) error {
+	query := `
+		UPDATE rewrite_rules
+		SET name = $1, description = $2, source_cluster_id = $3, destination_cluster_id = $4, source_tag_id = $5,
+		    destination_tag_id = $6, object_type = $7, field = $8, match_value = $9, replace_value = $10,
+		    is_regex = $11, priority = $12, enabled = $13, updated_at = $14
+		WHERE id = $15
+	`
+
+	now := time.Now()
+	_, err := r.db.Exec(
+		query,
+		rule.Name,
+		rule.Description,
+		rule.SourceClusterID,
+		rule.DestinationClusterID,
+		rule.SourceTagID,
+		rule.DestinationTagID,
+		rule.ObjectType,
+		rule.Field,
+		rule.Match,
+		rule.Replace,
+		rule.IsRegex,
+		rule.Priority,
+		rule.Enabled,
+		now,
+		rule.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rule.UpdatedAt = now
+	return nil
+}
+
+func (r *RewriteRuleRepository) Delete(id int) error {
+	_, err := r.db.Exec(`DELETE FROM rewrite_rules WHERE id = $1`, id)
+	return err
+}
+
+func (r *RewriteRuleRepository) query(query string, args ...interface{}) ([]*domain.RewriteRule, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*domain.RewriteRule
+	for rows.Next() {
+		rule, err := scanRewriteRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func scanRewriteRule(row rowScanner) (*domain.RewriteRule, error) {
+	rule := &domain.RewriteRule{}
+	var description sql.NullString
+	var sourceClusterID, destinationClusterID, sourceTagID, destinationTagID sql.NullInt64
+
+	err := row.Scan(
+		&rule.ID,
+		&rule.Name,
+		&description,
+		&sourceClusterID,
+		&destinationClusterID,
+		&sourceTagID,
+		&destinationTagID,
+		&rule.ObjectType,
+		&rule.Field,
+		&rule.Match,
+		&rule.Replace,
+		&rule.IsRegex,
+		&rule.Priority,
+		&rule.Enabled,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if description.Valid {
+		rule.Description = description.String
+	}
+	rule.SourceClusterID = nullIntPtr(sourceClusterID)
+	rule.DestinationClusterID = nullIntPtr(destinationClusterID)
+	rule.SourceTagID = nullIntPtr(sourceTagID)
+	rule.DestinationTagID = nullIntPtr(destinationTagID)
+
+	return rule, nil
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
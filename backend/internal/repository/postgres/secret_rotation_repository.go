@@ -0,0 +1,208 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/pkg/secrets"
+	"time"
+)
+
+// SecretRotationRepository stores rotated cluster client secrets and the rotation history
+type SecretRotationRepository struct {
+	db      *sql.DB
+	keyring *secrets.Keyring
+}
+
+func NewSecretRotationRepository(db *sql.DB, keyring *secrets.Keyring) *SecretRotationRepository {
+	return &SecretRotationRepository{db: db, keyring: keyring}
+}
+
+// ClaimClientSecret claims a cluster's client secret for rotation until the lease runs out, so one rotation
+// of it runs at a time across server instances without a transaction being held while Keycloak is called.
+// Clusters last rotated (or created) at or after rotatedBefore are left alone, unless a pending secret of an
+// earlier rotation still has to be stored. It returns the current and the pending secret, and false when the
+// cluster was left alone or is being rotated.
+func (r *SecretRotationRepository) ClaimClientSecret(clusterID int, rotatedBefore time.Time, lease time.Duration) (string, string, bool, error) {
+	now := time.Now()
+	var stored, pending sql.NullString
+	err := r.db.QueryRow(`
+		UPDATE clusters SET secret_rotation_lease_until = $3
+		WHERE id = $1
+		  AND (COALESCE(secret_rotated_at, created_at) < $2 OR pending_client_secret IS NOT NULL)
+		  AND (secret_rotation_lease_until IS NULL OR secret_rotation_lease_until < $4)
+		RETURNING client_secret, pending_client_secret
+	`, clusterID, rotatedBefore, now.Add(lease), now).Scan(&stored, &pending)
+	if err == sql.ErrNoRows {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+
+	current, err := r.keyring.Decrypt(stored.String, clientSecretAAD(clusterID))
+	if err != nil {
+		r.releaseClientSecret(clusterID)
+		return "", "", false, fmt.Errorf("failed to decrypt client secret: %w", err)
+	}
+	pendingSecret, err := r.keyring.Decrypt(pending.String, pendingClientSecretAAD(clusterID))
+	if err != nil {
+		r.releaseClientSecret(clusterID)
+		return "", "", false, fmt.Errorf("failed to decrypt pending client secret: %w", err)
+	}
+	return current, pendingSecret, true, nil
+}
+
+// SetPendingClientSecret keeps a secret Keycloak just regenerated, so it is not lost when storing it
+// as the cluster's client secret fails later on
+func (r *SecretRotationRepository) SetPendingClientSecret(clusterID int, secret string) error {
+	encrypted, err := r.keyring.Encrypt(secret, pendingClientSecretAAD(clusterID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt client secret: %w", err)
+	}
+	_, err = r.db.Exec(`UPDATE clusters SET pending_client_secret = $1 WHERE id = $2`, encrypted, clusterID)
+	return err
+}
+
+// CompleteClientSecretRotation stores the secret a rotation produced, if any, together with the rotation
+// record in one transaction and releases the cluster's claim
+func (r *SecretRotationRepository) CompleteClientSecretRotation(clusterID int, secret string, rotation *domain.SecretRotation) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if secret != "" {
+		encrypted, err := r.keyring.Encrypt(secret, clientSecretAAD(clusterID))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt client secret: %w", err)
+		}
+		_, err = tx.Exec(`
+			UPDATE clusters
+			SET client_secret = $1, pending_client_secret = NULL, secret_rotated_at = $2, updated_at = $2
+			WHERE id = $3
+		`, encrypted, now, clusterID)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE clusters SET secret_rotation_lease_until = NULL WHERE id = $1`, clusterID); err != nil {
+		return err
+	}
+
+	rotation.CreatedAt = now
+	if err := insertSecretRotation(tx, rotation); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// releaseClientSecret gives up a claim early; if that fails too, the lease runs out on its own
+func (r *SecretRotationRepository) releaseClientSecret(clusterID int) {
+	r.db.Exec(`UPDATE clusters SET secret_rotation_lease_until = NULL WHERE id = $1`, clusterID)
+}
+
+// Create records a rotation that changed no secret stored here, such as one of an application client
+func (r *SecretRotationRepository) Create(rotation *domain.SecretRotation) error {
+	rotation.CreatedAt = time.Now()
+	return insertSecretRotation(r.db, rotation)
+}
+
+// insertSecretRotation inserts a rotation record with the database or within a transaction
+func insertSecretRotation(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, rotation *domain.SecretRotation) error {
+	return q.QueryRow(`
+		INSERT INTO secret_rotations (cluster_id, client_id, status, trigger, error, rotated_by, sink, previous_secret_expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`,
+		rotation.ClusterID,
+		rotation.ClientID,
+		rotation.Status,
+		rotation.Trigger,
+		nullString(rotation.Error),
+		rotation.RotatedBy,
+		nullString(rotation.Sink),
+		rotation.PreviousSecretExpiresAt,
+		rotation.CreatedAt,
+	).Scan(&rotation.ID)
+}
+
+// GetDueClusterIDs lists the clusters with a client secret in the database that was last rotated
+// (or, if never, created) before t, or that have a pending secret to store
+func (r *SecretRotationRepository) GetDueClusterIDs(t time.Time) ([]int, error) {
+	rows, err := r.db.Query(`
+		SELECT id FROM clusters
+		WHERE COALESCE(secret_ref, '') = ''
+		  AND (COALESCE(secret_rotated_at, created_at) < $1 OR pending_client_secret IS NOT NULL)
+		ORDER BY id
+	`, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetRotations lists the latest rotations, newest first. clusterID 0 and an empty clientID match all.
+func (r *SecretRotationRepository) GetRotations(clusterID int, clientID string, limit int) ([]*domain.SecretRotation, error) {
+	rows, err := r.db.Query(`
+		SELECT s.id, s.cluster_id, c.name, s.client_id, s.status, s.trigger, s.error, s.rotated_by, s.sink,
+		       s.previous_secret_expires_at, s.created_at
+		FROM secret_rotations s
+		LEFT JOIN clusters c ON c.id = s.cluster_id
+		WHERE ($1 = 0 OR s.cluster_id = $1) AND ($2 = '' OR s.client_id = $2)
+		ORDER BY s.created_at DESC, s.id DESC
+		LIMIT $3
+	`, clusterID, clientID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rotations := []*domain.SecretRotation{}
+	for rows.Next() {
+		rotation := &domain.SecretRotation{}
+		var clusterName, errMsg, sink sql.NullString
+		var rotatedBy sql.NullInt64
+		var previousExpiresAt sql.NullTime
+		err := rows.Scan(
+			&rotation.ID,
+			&rotation.ClusterID,
+			&clusterName,
+			&rotation.ClientID,
+			&rotation.Status,
+			&rotation.Trigger,
+			&errMsg,
+			&rotatedBy,
+			&sink,
+			&previousExpiresAt,
+			&rotation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rotation.ClusterName = clusterName.String
+		rotation.Error = errMsg.String
+		rotation.RotatedBy = nullIntPtr(rotatedBy)
+		rotation.Sink = sink.String
+		if previousExpiresAt.Valid {
+			rotation.PreviousSecretExpiresAt = &previousExpiresAt.Time
+		}
+		rotations = append(rotations, rotation)
+	}
+	return rotations, rows.Err()
+}
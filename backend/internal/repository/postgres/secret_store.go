@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"keycloak-multi-manage/pkg/secrets"
+	"strconv"
+	"strings"
+)
+
+// storedSecretColumns are the encrypted columns SecretStore reads, by table
+var storedSecretColumns = map[string]string{
+	"clusters":    "client_secret",
+	"ldap_config": "bind_password",
+}
+
+// SecretStore is the secrets.Store of the secrets kept encrypted with the keyring in the database, registered
+// under secrets.DatabaseScheme. Paths name the record, e.g. "clusters/12" for a cluster's client secret or
+// "ldap_config/1" for the LDAP bind password. The value is read and decrypted on every call, so a rotated
+// secret is used from the next call on.
+type SecretStore struct {
+	db      *sql.DB
+	keyring *secrets.Keyring
+}
+
+func NewSecretStore(db *sql.DB, keyring *secrets.Keyring) *SecretStore {
+	return &SecretStore{db: db, keyring: keyring}
+}
+
+func (s *SecretStore) Get(ctx context.Context, path string) (string, error) {
+	table, idText, _ := strings.Cut(path, "/")
+	column, ok := storedSecretColumns[table]
+	id, err := strconv.Atoi(idText)
+	if !ok || err != nil {
+		return "", fmt.Errorf("invalid database secret path %q, expected clusters/<id> or ldap_config/<id>", path)
+	}
+
+	var stored sql.NullString
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT %s FROM %s WHERE id = $1`, column, table), id).Scan(&stored)
+	if err == sql.ErrNoRows || (err == nil && stored.String == "") {
+		return "", secrets.ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := s.keyring.Decrypt(stored.String, secrets.AAD(table, column, id))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s.%s: %w", table, column, err)
+	}
+	return secret, nil
+}
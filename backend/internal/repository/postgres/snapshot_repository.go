@@ -0,0 +1,209 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"keycloak-multi-manage/internal/domain"
+	"time"
+)
+
+type SnapshotRepository struct {
+	db *sql.DB
+}
+
+func NewSnapshotRepository(db *sql.DB) *SnapshotRepository {
+	return &SnapshotRepository{db: db}
+}
+
+const realmSnapshotColumns = `id, cluster_id, realm, version, content_hash, sections, counts, trigger, created_by, created_at, last_verified_at`
+
+// SaveBlob stores compressed content under its hash; content that is already stored is kept as is
+func (r *SnapshotRepository) SaveBlob(hash string, content []byte, size int) error {
+	query := `
+		INSERT INTO snapshot_blobs (hash, content, size, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (hash) DO NOTHING
+	`
+	_, err := r.db.Exec(query, hash, content, size, time.Now())
+	return err
+}
+
+func (r *SnapshotRepository) GetBlob(hash string) ([]byte, error) {
+	var content []byte
+	err := r.db.QueryRow(`SELECT content FROM snapshot_blobs WHERE hash = $1`, hash).Scan(&content)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// Create stores a snapshot as the next version of its cluster
+func (r *SnapshotRepository) Create(snapshot *domain.RealmSnapshot) error {
+	query := `
+		INSERT INTO realm_snapshots (cluster_id, realm, version, content_hash, sections, counts, trigger, created_by, created_at, last_verified_at)
+		SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4, $5, $6, $7, $8, $8
+		FROM realm_snapshots
+		WHERE cluster_id = $1
+		RETURNING id, version
+	`
+
+	sectionsJSON, err := json.Marshal(snapshot.Sections)
+	if err != nil {
+		return err
+	}
+	countsJSON, err := json.Marshal(snapshot.Counts)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = r.db.QueryRow(
+		query,
+		snapshot.ClusterID,
+		snapshot.Realm,
+		snapshot.ContentHash,
+		string(sectionsJSON),
+		string(countsJSON),
+		snapshot.Trigger,
+		snapshot.CreatedBy,
+		now,
+	).Scan(&snapshot.ID, &snapshot.Version)
+
+	if err != nil {
+		return err
+	}
+
+	snapshot.CreatedAt = now
+	snapshot.LastVerifiedAt = now
+	return nil
+}
+
+// MarkVerified records that the realm still matched the snapshot at the given time
+func (r *SnapshotRepository) MarkVerified(id int, t time.Time) error {
+	_, err := r.db.Exec(`UPDATE realm_snapshots SET last_verified_at = $1 WHERE id = $2`, t, id)
+	return err
+}
+
+func (r *SnapshotRepository) GetByID(id int) (*domain.RealmSnapshot, error) {
+	query := `
+		SELECT ` + realmSnapshotColumns + `
+		FROM realm_snapshots
+		WHERE id = $1
+	`
+
+	snapshot, err := scanRealmSnapshot(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// GetAt returns the snapshot of a cluster that was current at the given time
+func (r *SnapshotRepository) GetAt(clusterID int, at time.Time) (*domain.RealmSnapshot, error) {
+	query := `
+		SELECT ` + realmSnapshotColumns + `
+		FROM realm_snapshots
+		WHERE cluster_id = $1 AND created_at <= $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	snapshot, err := scanRealmSnapshot(r.db.QueryRow(query, clusterID, at))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// GetRecent lists snapshots, newest first. A clusterID of 0 lists every cluster.
+func (r *SnapshotRepository) GetRecent(clusterID, limit int) ([]*domain.RealmSnapshot, error) {
+	query := `
+		SELECT ` + realmSnapshotColumns + `
+		FROM realm_snapshots
+		WHERE ($1 = 0 OR cluster_id = $1)
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(query, clusterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []*domain.RealmSnapshot
+	for rows.Next() {
+		snapshot, err := scanRealmSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+func (r *SnapshotRepository) Delete(id int) error {
+	_, err := r.db.Exec(`DELETE FROM realm_snapshots WHERE id = $1`, id)
+	return err
+}
+
+// DeleteUnreferencedBlobs removes blobs that no snapshot points to anymore
+func (r *SnapshotRepository) DeleteUnreferencedBlobs() (int64, error) {
+	query := `
+		DELETE FROM snapshot_blobs b
+		WHERE NOT EXISTS (
+			SELECT 1 FROM realm_snapshots s, jsonb_each_text(s.sections) section
+			WHERE section.value = b.hash
+		)
+	`
+	res, err := r.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanRealmSnapshot(row rowScanner) (*domain.RealmSnapshot, error) {
+	snapshot := &domain.RealmSnapshot{}
+	var sectionsJSON, countsJSON string
+	var createdBy sql.NullInt64
+
+	err := row.Scan(
+		&snapshot.ID,
+		&snapshot.ClusterID,
+		&snapshot.Realm,
+		&snapshot.Version,
+		&snapshot.ContentHash,
+		&sectionsJSON,
+		&countsJSON,
+		&snapshot.Trigger,
+		&createdBy,
+		&snapshot.CreatedAt,
+		&snapshot.LastVerifiedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(sectionsJSON), &snapshot.Sections); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(countsJSON), &snapshot.Counts); err != nil {
+		return nil, err
+	}
+	snapshot.CreatedBy = nullIntPtr(createdBy)
+
+	return snapshot, nil
+}
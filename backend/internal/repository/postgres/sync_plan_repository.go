@@ -0,0 +1,199 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"keycloak-multi-manage/internal/domain"
+	"time"
+)
+
+type SyncPlanRepository struct {
+	db *sql.DB
+}
+
+func NewSyncPlanRepository(db *sql.DB) *SyncPlanRepository {
+	return &SyncPlanRepository{db: db}
+}
+
+func (r *SyncPlanRepository) Create(plan *domain.SyncPlan) error {
+	query := `
+		INSERT INTO sync_plans (source_cluster_id, source_snapshot_id, destination_cluster_id, object_type, object_name, steps, dependencies, status, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`
+
+	stepsJSON, err := json.Marshal(plan.Steps)
+	if err != nil {
+		return err
+	}
+
+	depsJSON, err := json.Marshal(plan.Dependencies)
+	if err != nil {
+		return err
+	}
+	if plan.Dependencies == nil {
+		depsJSON = []byte("[]")
+	}
+
+	now := time.Now()
+	err = r.db.QueryRow(
+		query,
+		plan.SourceClusterID,
+		plan.SourceSnapshotID,
+		plan.DestinationClusterID,
+		plan.ObjectType,
+		plan.ObjectName,
+		string(stepsJSON),
+		string(depsJSON),
+		plan.Status,
+		plan.CreatedBy,
+		now,
+	).Scan(&plan.ID)
+
+	if err != nil {
+		return err
+	}
+
+	plan.CreatedAt = now
+	return nil
+}
+
+func (r *SyncPlanRepository) GetByID(id int) (*domain.SyncPlan, error) {
+	query := `
+		SELECT id, source_cluster_id, source_snapshot_id, destination_cluster_id, object_type, object_name, steps, dependencies, status, results,
+		       created_by, applied_by, created_at, applied_at
+		FROM sync_plans
+		WHERE id = $1
+	`
+
+	plan, err := scanSyncPlan(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+func (r *SyncPlanRepository) GetRecent(limit int) ([]*domain.SyncPlan, error) {
+	query := `
+		SELECT id, source_cluster_id, source_snapshot_id, destination_cluster_id, object_type, object_name, steps, dependencies, status, results,
+		       created_by, applied_by, created_at, applied_at
+		FROM sync_plans
+		ORDER BY created_at DESC
+		LIMIT $1
+	`
+
+	rows, err := r.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []*domain.SyncPlan
+	for rows.Next() {
+		plan, err := scanSyncPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, rows.Err()
+}
+
+// MarkApplied stores the execution results of a plan claimed with ClaimForApply
+func (r *SyncPlanRepository) MarkApplied(plan *domain.SyncPlan) error {
+	query := `
+		UPDATE sync_plans
+		SET status = $1, results = $2, applied_by = $3, applied_at = $4
+		WHERE id = $5
+	`
+
+	resultsJSON, err := json.Marshal(plan.Results)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = r.db.Exec(query, plan.Status, string(resultsJSON), plan.AppliedBy, now, plan.ID)
+	if err != nil {
+		return err
+	}
+
+	plan.AppliedAt = &now
+	return nil
+}
+
+// ClaimForApply moves a pending plan into the applying state so concurrent apply requests cannot both run it
+func (r *SyncPlanRepository) ClaimForApply(id int) (bool, error) {
+	res, err := r.db.Exec(`UPDATE sync_plans SET status = 'applying' WHERE id = $1 AND status = 'pending'`, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSyncPlan(row rowScanner) (*domain.SyncPlan, error) {
+	plan := &domain.SyncPlan{}
+	var stepsJSON, depsJSON string
+	var resultsJSON sql.NullString
+	var sourceSnapshotID, createdBy, appliedBy sql.NullInt64
+	var appliedAt sql.NullTime
+
+	err := row.Scan(
+		&plan.ID,
+		&plan.SourceClusterID,
+		&sourceSnapshotID,
+		&plan.DestinationClusterID,
+		&plan.ObjectType,
+		&plan.ObjectName,
+		&stepsJSON,
+		&depsJSON,
+		&plan.Status,
+		&resultsJSON,
+		&createdBy,
+		&appliedBy,
+		&plan.CreatedAt,
+		&appliedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(stepsJSON), &plan.Steps); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(depsJSON), &plan.Dependencies); err != nil {
+		return nil, err
+	}
+	if resultsJSON.Valid && resultsJSON.String != "" {
+		if err := json.Unmarshal([]byte(resultsJSON.String), &plan.Results); err != nil {
+			return nil, err
+		}
+	}
+	plan.SourceSnapshotID = nullIntPtr(sourceSnapshotID)
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		plan.CreatedBy = &id
+	}
+	if appliedBy.Valid {
+		id := int(appliedBy.Int64)
+		plan.AppliedBy = &id
+	}
+	if appliedAt.Valid {
+		plan.AppliedAt = &appliedAt.Time
+	}
+
+	return plan, nil
+}
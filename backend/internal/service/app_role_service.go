@@ -2,10 +2,14 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/repository/postgres"
 )
 
+// ErrClusterPermissionDenied is returned when a user lacks a permission on a cluster, e.g. because their role is scoped to other clusters
+var ErrClusterPermissionDenied = errors.New("insufficient permissions")
+
 type AppRoleService struct {
 	roleRepo       *postgres.AppRoleRepository
 	permissionRepo *postgres.PermissionRepository
@@ -69,6 +73,12 @@ func (s *AppRoleService) CreateRole(req *domain.CreateRoleRequest) (*domain.AppR
 		Description: req.Description,
 		Permissions: permissions,
 	}
+	if err := setRoleScope(role, req.Scope, req.ScopeClusterIDs, req.ScopeTagIDs); err != nil {
+		return nil, err
+	}
+	if err := s.roleRepo.ValidateScope(role); err != nil {
+		return nil, err
+	}
 
 	err = s.roleRepo.Create(role)
 	if err != nil {
@@ -128,6 +138,19 @@ func (s *AppRoleService) UpdateRole(id int, req *domain.UpdateRoleRequest) (*dom
 		role.Permissions = permissions
 	}
 
+	// Update scope if provided
+	if req.Scope != "" {
+		if role.Name == "admin" && req.Scope != domain.RoleScopeAll {
+			return nil, errors.New("the admin role must keep the all scope")
+		}
+		if err := setRoleScope(role, req.Scope, req.ScopeClusterIDs, req.ScopeTagIDs); err != nil {
+			return nil, err
+		}
+		if err := s.roleRepo.ValidateScope(role); err != nil {
+			return nil, err
+		}
+	}
+
 	err = s.roleRepo.Update(role)
 	if err != nil {
 		return nil, err
@@ -171,7 +194,74 @@ func (s *AppRoleService) GetUserPermissions(userID int) ([]*domain.Permission, e
 	return s.permissionRepo.GetByUserID(userID)
 }
 
+// GetUserGrants returns the permissions of a user with the clusters they hold them on.
+// Roles with a scope only contribute cluster permissions.
+func (s *AppRoleService) GetUserGrants(userID int) (map[string]*domain.PermissionGrant, error) {
+	grants, err := s.permissionRepo.GetUserGrants(userID)
+	if err != nil {
+		return nil, err
+	}
+	for name, grant := range grants {
+		if !grant.AllClusters && !domain.ClusterPermissions[name] {
+			delete(grants, name)
+		}
+	}
+	return grants, nil
+}
+
+// HasPermission tells whether the user holds a permission on at least one cluster, or at all for permissions
+// that do not apply to a cluster. Routes of a single cluster check HasClusterPermission as well.
 func (s *AppRoleService) HasPermission(userID int, permissionName string) (bool, error) {
-	return s.permissionRepo.HasPermission(userID, permissionName)
+	grants, err := s.GetUserGrants(userID)
+	if err != nil {
+		return false, err
+	}
+	return grants[permissionName] != nil, nil
+}
+
+// CheckClusterPermission returns ErrClusterPermissionDenied unless the user holds the permission on every given
+// cluster. Zero IDs, e.g. of a diff side that is an uploaded export, are skipped.
+func (s *AppRoleService) CheckClusterPermission(userID int, permissionName string, clusterIDs ...int) error {
+	grants, err := s.GetUserGrants(userID)
+	if err != nil {
+		return fmt.Errorf("failed to check permissions: %w", err)
+	}
+	grant := grants[permissionName]
+	for _, clusterID := range clusterIDs {
+		if clusterID != 0 && !grant.Allows(clusterID) {
+			return fmt.Errorf("%w: %s is not granted on cluster %d", ErrClusterPermissionDenied, permissionName, clusterID)
+		}
+	}
+	return nil
+}
+
+// GetPermissionGrant returns on which clusters the user holds a permission, e.g. to filter a list; nil when on none
+func (s *AppRoleService) GetPermissionGrant(userID int, permissionName string) (*domain.PermissionGrant, error) {
+	grants, err := s.GetUserGrants(userID)
+	if err != nil {
+		return nil, err
+	}
+	return grants[permissionName], nil
+}
+
+// setRoleScope validates a scope and sets it with its cluster or environment tag IDs on a role
+func setRoleScope(role *domain.AppRole, scope string, clusterIDs, tagIDs []int) error {
+	switch scope {
+	case "", domain.RoleScopeAll:
+		role.Scope, role.ScopeClusterIDs, role.ScopeTagIDs = domain.RoleScopeAll, nil, nil
+	case domain.RoleScopeClusters:
+		if len(clusterIDs) == 0 {
+			return errors.New("scope_cluster_ids is required with the clusters scope")
+		}
+		role.Scope, role.ScopeClusterIDs, role.ScopeTagIDs = scope, clusterIDs, nil
+	case domain.RoleScopeTags:
+		if len(tagIDs) == 0 {
+			return errors.New("scope_tag_ids is required with the tags scope")
+		}
+		role.Scope, role.ScopeClusterIDs, role.ScopeTagIDs = scope, nil, tagIDs
+	default:
+		return fmt.Errorf("invalid scope %s, must be one of all, clusters, tags", scope)
+	}
+	return nil
 }
 
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/repository/postgres"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// auditRedacted replaces secret values in audit records
+	auditRedacted = "[REDACTED]"
+	// auditMaxString is the longest string value kept in a request summary or state snapshot; longer ones are omitted
+	auditMaxString = 1024
+	// auditMaxItems is the most list entries kept in a request summary or state snapshot
+	auditMaxItems = 100
+)
+
+// auditSecretKeys are parts of field names whose values are never written to the audit log
+var auditSecretKeys = []string{"password", "secret", "token", "credential", "privatekey", "private_key", "apikey", "api_key"}
+
+// AuditService keeps the application audit log
+type AuditService struct {
+	auditRepo   *postgres.AuditRepository
+	clusterRepo *postgres.ClusterRepository
+	userRepo    *postgres.UserRepository
+}
+
+func NewAuditService(auditRepo *postgres.AuditRepository, clusterRepo *postgres.ClusterRepository, userRepo *postgres.UserRepository) *AuditService {
+	return &AuditService{auditRepo: auditRepo, clusterRepo: clusterRepo, userRepo: userRepo}
+}
+
+// Record stores an audit event. The actor's username and the cluster's name and realm are filled in from their IDs.
+// A failure is logged rather than returned, since the audited operation already happened.
+// A nil *AuditService records nothing, so services do not need to check for one.
+func (s *AuditService) Record(event *domain.AuditEvent) {
+	if s == nil {
+		return
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if event.ActorID != nil && event.ActorUsername == "" {
+		if user, err := s.userRepo.GetByID(*event.ActorID); err == nil && user != nil {
+			event.ActorUsername = user.Username
+		}
+	}
+	if event.ClusterID != nil && (event.ClusterName == "" || event.Realm == "") {
+		if cluster, err := s.clusterRepo.GetByID(*event.ClusterID); err == nil && cluster != nil {
+			if event.ClusterName == "" {
+				event.ClusterName = cluster.Name
+			}
+			if event.Realm == "" {
+				event.Realm = cluster.Realm
+			}
+		}
+	}
+
+	if err := s.auditRepo.Create(event); err != nil {
+		log.Printf("Audit: failed to record %s by %s: %v", event.Action, event.ActorUsername, err)
+	}
+}
+
+func (s *AuditService) Search(filter domain.AuditFilter) (*domain.AuditEventPage, error) {
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	events, total, err := s.auditRepo.Search(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search audit events: %w", err)
+	}
+	return &domain.AuditEventPage{Total: total, Events: events}, nil
+}
+
+// auditCSVHeader lists the columns of the CSV export
+var auditCSVHeader = []string{
+	"id", "created_at", "actor_id", "actor_username", "action", "method", "path", "cluster_id", "cluster_name", "realm",
+	"object_type", "object_name", "outcome", "status_code", "error", "ip_address", "duration_ms", "request_summary", "before", "after",
+}
+
+// ExportCSV writes every event that matches the filter as CSV, newest first
+func (s *AuditService) ExportCSV(filter domain.AuditFilter, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write(auditCSVHeader)
+
+	err := s.auditRepo.ForEach(filter, func(event *domain.AuditEvent) error {
+		actorID, clusterID := "", ""
+		if event.ActorID != nil {
+			actorID = strconv.Itoa(*event.ActorID)
+		}
+		if event.ClusterID != nil {
+			clusterID = strconv.Itoa(*event.ClusterID)
+		}
+		return writer.Write([]string{
+			strconv.FormatInt(event.ID, 10),
+			event.CreatedAt.UTC().Format(time.RFC3339),
+			actorID,
+			event.ActorUsername,
+			event.Action,
+			event.Method,
+			event.Path,
+			clusterID,
+			event.ClusterName,
+			event.Realm,
+			event.ObjectType,
+			event.ObjectName,
+			event.Outcome,
+			strconv.Itoa(event.StatusCode),
+			event.Error,
+			event.IPAddress,
+			strconv.Itoa(event.DurationMs),
+			string(event.RequestSummary),
+			string(event.Before),
+			string(event.After),
+		})
+	})
+	if err != nil {
+		writer.Write([]string{"error: " + err.Error()})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// AuditJSON converts a value into a redacted JSON document for the audit log
+func AuditJSON(value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+
+	// A typed nil (e.g. a snapshot that could not be loaded) marshals to null
+	data, err := json.Marshal(value)
+	if err != nil || string(data) == "null" {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil
+	}
+
+	redacted, err := json.Marshal(RedactAuditValue(decoded))
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// RedactAuditValue replaces the values of secret fields and shortens long strings and lists of a decoded JSON value
+func RedactAuditValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if isAuditSecretKey(key) {
+				if item != nil && item != "" {
+					out[key] = auditRedacted
+				} else {
+					out[key] = item
+				}
+				continue
+			}
+			out[key] = RedactAuditValue(item)
+		}
+		return out
+	case []interface{}:
+		n := len(v)
+		if n > auditMaxItems {
+			n = auditMaxItems
+		}
+		out := make([]interface{}, 0, n+1)
+		for _, item := range v[:n] {
+			out = append(out, RedactAuditValue(item))
+		}
+		if len(v) > n {
+			out = append(out, fmt.Sprintf("... %d more", len(v)-n))
+		}
+		return out
+	case string:
+		// JSON documents sent as strings (e.g. a realm export) can hold secrets themselves
+		if trimmed := strings.TrimSpace(v); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var decoded interface{}
+			if json.Unmarshal([]byte(trimmed), &decoded) == nil {
+				v = ""
+				if data, err := json.Marshal(RedactAuditValue(decoded)); err == nil {
+					v = string(data)
+				}
+			}
+		}
+		// A cut-off value could end inside something sensitive, so long values are left out entirely
+		if len(v) > auditMaxString {
+			return fmt.Sprintf("(%d bytes omitted)", len(v))
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func isAuditSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range auditSecretKeys {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
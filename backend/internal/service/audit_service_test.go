@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactAuditValue(t *testing.T) {
+	longList := make([]interface{}, auditMaxItems+5)
+	for i := range longList {
+		longList[i] = float64(i)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{
+			name:  "plain fields",
+			value: `{"name": "app", "enabled": true, "count": 3, "tags": ["a", "b"]}`,
+			want:  `{"name": "app", "enabled": true, "count": 3, "tags": ["a", "b"]}`,
+		},
+		{
+			name:  "secret fields in any case",
+			value: `{"password": "p", "clientSecret": "s", "refresh_token": "t", "PrivateKey": "k", "apiKey": "a"}`,
+			want:  `{"password": "[REDACTED]", "clientSecret": "[REDACTED]", "refresh_token": "[REDACTED]", "PrivateKey": "[REDACTED]", "apiKey": "[REDACTED]"}`,
+		},
+		{
+			name:  "empty secrets stay empty",
+			value: `{"password": "", "secret": null}`,
+			want:  `{"password": "", "secret": null}`,
+		},
+		{
+			name:  "non-string secrets",
+			value: `{"credentials": [{"type": "password", "value": "p"}], "bindCredential": ["x"]}`,
+			want:  `{"credentials": "[REDACTED]", "bindCredential": "[REDACTED]"}`,
+		},
+		{
+			name:  "nested objects and lists",
+			value: `{"config": {"bindDn": "cn=admin", "bindPassword": "p"}, "users": [{"username": "a", "password": "p"}]}`,
+			want:  `{"config": {"bindDn": "cn=admin", "bindPassword": "[REDACTED]"}, "users": [{"username": "a", "password": "[REDACTED]"}]}`,
+		},
+		{
+			name:  "JSON document in a string",
+			value: `{"content": "{\"clients\": [{\"clientId\": \"app\", \"secret\": \"s\"}]}"}`,
+			want:  `{"content": "{\"clients\":[{\"clientId\":\"app\",\"secret\":\"[REDACTED]\"}]}"}`,
+		},
+		{
+			name:  "string that only looks like JSON",
+			value: `{"note": "{not json"}`,
+			want:  `{"note": "{not json"}`,
+		},
+		{
+			name:  "long string",
+			value: fmt.Sprintf(`{"content": %q}`, strings.Repeat("x", auditMaxString+1)),
+			want:  fmt.Sprintf(`{"content": "(%d bytes omitted)"}`, auditMaxString+1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value, want interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if got := RedactAuditValue(value); !reflect.DeepEqual(got, want) {
+				gotJSON, _ := json.Marshal(got)
+				t.Errorf("RedactAuditValue = %s\nwant %s", gotJSON, tt.want)
+			}
+		})
+	}
+
+	t.Run("long list", func(t *testing.T) {
+		got := RedactAuditValue(longList).([]interface{})
+		if len(got) != auditMaxItems+1 || got[auditMaxItems] != "... 5 more" {
+			t.Errorf("got %d items ending in %v, want %d and a note", len(got), got[len(got)-1], auditMaxItems)
+		}
+	})
+}
+
+func TestAuditJSON(t *testing.T) {
+	type request struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	var missing *request
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"nil", nil, ""},
+		{"typed nil", missing, ""},
+		{"struct", request{Name: "ldap", Password: "p"}, `{"name":"ldap","password":"[REDACTED]"}`},
+		{"map", map[string]interface{}{"token": "t"}, `{"token":"[REDACTED]"}`},
+		{"unmarshalable", func() {}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(AuditJSON(tt.value)); got != tt.want {
+				t.Errorf("AuditJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/repository/postgres"
+	"keycloak-multi-manage/pkg/secrets"
 )
 
 type AuthService struct {
@@ -18,10 +19,11 @@ type AuthService struct {
 	appRoleRepo       *postgres.AppRoleRepository
 	ldapConfigRepo    *postgres.LDAPConfigRepository
 	certificateService *CertificateService
+	secretResolver    *secrets.Resolver
 	jwtSecret         []byte
 }
 
-func NewAuthService(userRepo *postgres.UserRepository, appRoleRepo *postgres.AppRoleRepository, ldapConfigRepo *postgres.LDAPConfigRepository, certService *CertificateService) *AuthService {
+func NewAuthService(userRepo *postgres.UserRepository, appRoleRepo *postgres.AppRoleRepository, ldapConfigRepo *postgres.LDAPConfigRepository, certService *CertificateService, secretResolver *secrets.Resolver) *AuthService {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		secret = "your-secret-key-change-in-production" // Default secret, should be set via env
@@ -31,6 +33,7 @@ func NewAuthService(userRepo *postgres.UserRepository, appRoleRepo *postgres.App
 		appRoleRepo:       appRoleRepo,
 		ldapConfigRepo:    ldapConfigRepo,
 		certificateService: certService,
+		secretResolver:    secretResolver,
 		jwtSecret:         []byte(secret),
 	}
 }
@@ -142,7 +145,7 @@ func (s *AuthService) Login(req *domain.LoginRequest) (*domain.AuthResponse, err
 			return nil, errors.New("LDAP authentication is not enabled")
 		}
 
-		ldapService := NewLDAPService(ldapConfig, s.certificateService)
+		ldapService := NewLDAPService(ldapConfig, s.certificateService, s.secretResolver)
 		ldapUser, err := ldapService.Authenticate(req.Username, req.Password)
 		if err != nil {
 			return nil, fmt.Errorf("LDAP authentication failed: %w", err)
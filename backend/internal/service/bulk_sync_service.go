@@ -0,0 +1,280 @@
+package service
+
+import (
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"time"
+)
+
+// bulkSyncOrder makes prerequisites (roles, clients) exist before the objects that reference them
+var bulkSyncOrder = []string{"role", "client", "group", "user"}
+
+type BulkSyncService struct {
+	syncService *SyncService
+	diffService *DiffService
+	events      *EventService
+}
+
+func NewBulkSyncService(syncService *SyncService, diffService *DiffService) *BulkSyncService {
+	return &BulkSyncService{
+		syncService: syncService,
+		diffService: diffService,
+	}
+}
+
+// SetEventService publishes the progress of bulk syncs to the event stream
+func (s *BulkSyncService) SetEventService(events *EventService) {
+	s.events = events
+}
+
+// Run syncs every selected item from source to destination in one pass.
+// Tokens and source listings are fetched once and shared by all items; a failed item does not stop the run.
+func (s *BulkSyncService) Run(req domain.BulkSyncRequest) (*domain.BulkSyncResult, error) {
+	return s.run(req, nil)
+}
+
+// RunJob runs a bulk sync submitted as a job. Progress and the outcome of every item go to the job log,
+// and cancelling the job stops the run before the next item.
+func (s *BulkSyncService) RunJob(job *JobContext) (interface{}, error) {
+	var req domain.BulkSyncRequest
+	if err := job.Decode(&req); err != nil {
+		return nil, err
+	}
+	result, err := s.run(req, job)
+	if err != nil {
+		return nil, err
+	}
+	if done, total := job.progress(); done < total {
+		return result, ErrJobCancelled
+	}
+	return result, nil
+}
+
+// run syncs the items of a request. In a job, the job reports start and completion on the event stream
+// and its ID tags the item events.
+func (s *BulkSyncService) run(req domain.BulkSyncRequest, job *JobContext) (*domain.BulkSyncResult, error) {
+	operationID := req.OperationID
+	if job != nil {
+		operationID = jobOperationID(job.Job.ID)
+	}
+	events := s.events.operation(domain.OperationBulkSync, operationID, "sync_items", req.SourceClusterID, req.DestinationClusterID)
+
+	result, err := s.syncItems(req, job, events)
+	if job == nil {
+		if err != nil {
+			events.completed("failed", err, nil)
+		} else {
+			events.completed("completed", nil, result.Summary)
+		}
+	}
+	return result, err
+}
+
+func (s *BulkSyncService) syncItems(req domain.BulkSyncRequest, job *JobContext, events *operationEvents) (*domain.BulkSyncResult, error) {
+	result := &domain.BulkSyncResult{
+		OperationID:          events.operationID,
+		SourceClusterID:      req.SourceClusterID,
+		DestinationClusterID: req.DestinationClusterID,
+		Selection:            req.Selection,
+		Items:                []domain.BulkSyncItemResult{},
+		StartedAt:            time.Now(),
+	}
+
+	items, err := s.selectItems(req)
+	if err != nil {
+		return nil, err
+	}
+
+	source, dest, err := s.syncService.getSyncTargets(req.SourceClusterID, req.DestinationClusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	if job == nil {
+		events.started(len(items))
+	} else {
+		job.SetTotal(len(items))
+		job.Logf(domain.JobLogInfo, "Syncing %d items from cluster %d to cluster %d", len(items), req.SourceClusterID, req.DestinationClusterID)
+	}
+
+	for _, item := range items {
+		if job != nil && job.Cancelled() {
+			break
+		}
+
+		itemResult := s.syncItem(source, dest, item, req.RemoveExtra)
+		result.Items = append(result.Items, itemResult)
+
+		events.item(len(result.Items), len(items), fmt.Sprintf("%s '%s'", item.ObjectType, item.Name), itemResult.Status, itemResult.Error)
+		if job != nil {
+			job.Advance(1)
+			if itemResult.Status == "failed" {
+				job.Logf(domain.JobLogError, "%s '%s': failed: %s", item.ObjectType, item.Name, itemResult.Error)
+			} else {
+				job.Logf(domain.JobLogInfo, "%s '%s': %s", item.ObjectType, item.Name, itemResult.Status)
+			}
+		}
+
+		switch itemResult.Status {
+		case "success":
+			result.Summary.Succeeded++
+		case "unchanged":
+			result.Summary.Unchanged++
+		default:
+			result.Summary.Failed++
+		}
+	}
+
+	result.Summary.Total = len(result.Items)
+	result.FinishedAt = time.Now()
+	return result, nil
+}
+
+func (s *BulkSyncService) syncItem(source, dest *syncTarget, item domain.BulkSyncItem, removeExtra bool) domain.BulkSyncItemResult {
+	itemResult := domain.BulkSyncItemResult{
+		ObjectType: item.ObjectType,
+		Name:       item.Name,
+	}
+
+	if err := s.syncService.refreshToken(source); err != nil {
+		itemResult.Status = "failed"
+		itemResult.Error = err.Error()
+		return itemResult
+	}
+	if err := s.syncService.refreshToken(dest); err != nil {
+		itemResult.Status = "failed"
+		itemResult.Error = err.Error()
+		return itemResult
+	}
+
+	steps, deps, err := s.syncService.buildSteps(source, dest, item.ObjectType, item.Name, removeExtra)
+	if err != nil {
+		itemResult.Status = "failed"
+		itemResult.Error = err.Error()
+		return itemResult
+	}
+	itemResult.Dependencies = deps
+	itemResult.Changes = collectChanges(steps)
+	if len(steps) == 0 {
+		itemResult.Status = "unchanged"
+		return itemResult
+	}
+
+	stepResults, ok := s.syncService.executeSteps(source, dest, steps)
+	itemResult.Steps = stepResults
+	if !ok {
+		itemResult.Status = "failed"
+		itemResult.Error = stepError(stepResults).Error()
+		return itemResult
+	}
+
+	itemResult.Status = "success"
+	return itemResult
+}
+
+// selectItems resolves the request selection into a de-duplicated list ordered by object type
+func (s *BulkSyncService) selectItems(req domain.BulkSyncRequest) ([]domain.BulkSyncItem, error) {
+	var selected []domain.BulkSyncItem
+
+	switch req.Selection {
+	case domain.BulkSelectExplicit:
+		for _, item := range req.Items {
+			if !isSyncObjectType(item.ObjectType) {
+				return nil, fmt.Errorf("unsupported object type: %s", item.ObjectType)
+			}
+			if item.Name == "" {
+				return nil, fmt.Errorf("item name is required")
+			}
+		}
+		selected = req.Items
+	case domain.BulkSelectMissing, domain.BulkSelectDifferent:
+		objectTypes := req.ObjectTypes
+		if len(objectTypes) == 0 {
+			objectTypes = bulkSyncOrder
+		}
+		for _, objectType := range objectTypes {
+			if !isSyncObjectType(objectType) {
+				return nil, fmt.Errorf("unsupported object type: %s", objectType)
+			}
+			items, err := s.diffItems(req.SourceClusterID, req.DestinationClusterID, objectType, req.Selection)
+			if err != nil {
+				return nil, err
+			}
+			selected = append(selected, items...)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported selection: %s", req.Selection)
+	}
+
+	seen := make(map[domain.BulkSyncItem]bool)
+	var ordered []domain.BulkSyncItem
+	for _, objectType := range bulkSyncOrder {
+		for _, item := range selected {
+			if item.ObjectType == objectType && !seen[item] {
+				seen[item] = true
+				ordered = append(ordered, item)
+			}
+		}
+	}
+
+	return ordered, nil
+}
+
+// diffItems returns the names of all objects of one type whose diff status matches
+func (s *BulkSyncService) diffItems(sourceClusterID, destinationClusterID int, objectType, status string) ([]domain.BulkSyncItem, error) {
+	var items []domain.BulkSyncItem
+
+	switch objectType {
+	case "role":
+		diffs, err := s.diffService.GetRoleDiff(sourceClusterID, destinationClusterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get role diff: %w", err)
+		}
+		for _, d := range diffs {
+			if d.Status == status {
+				items = append(items, domain.BulkSyncItem{ObjectType: objectType, Name: d.Role.Name})
+			}
+		}
+	case "client":
+		diffs, err := s.diffService.GetClientDiff(sourceClusterID, destinationClusterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get client diff: %w", err)
+		}
+		for _, d := range diffs {
+			if d.Status == status {
+				items = append(items, domain.BulkSyncItem{ObjectType: objectType, Name: d.Client.ClientID})
+			}
+		}
+	case "group":
+		diffs, err := s.diffService.GetGroupDiff(sourceClusterID, destinationClusterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get group diff: %w", err)
+		}
+		for _, d := range diffs {
+			if d.Status == status {
+				items = append(items, domain.BulkSyncItem{ObjectType: objectType, Name: d.Group.Path})
+			}
+		}
+	case "user":
+		diffs, err := s.diffService.GetUserDiff(sourceClusterID, destinationClusterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user diff: %w", err)
+		}
+		for _, d := range diffs {
+			if d.Status == status {
+				items = append(items, domain.BulkSyncItem{ObjectType: objectType, Name: d.User.Username})
+			}
+		}
+	}
+
+	return items, nil
+}
+
+func isSyncObjectType(objectType string) bool {
+	for _, t := range bulkSyncOrder {
+		if t == objectType {
+			return true
+		}
+	}
+	return false
+}
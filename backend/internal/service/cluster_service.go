@@ -7,6 +7,9 @@ import (
 	"keycloak-multi-manage/internal/repository/postgres"
 )
 
+// ErrSecretRotationInProgress is returned by Update when it would replace a client secret that is being rotated
+var ErrSecretRotationInProgress = postgres.ErrSecretRotationInProgress
+
 type ClusterService struct {
 	repo            *postgres.ClusterRepository
 	keycloakClient  *keycloak.Client
@@ -29,6 +32,15 @@ func (s *ClusterService) Create(req domain.CreateClusterRequest) (*domain.Cluste
 	if req.Realm == "" {
 		req.Realm = "master"
 	}
+	secretRef := ""
+	if req.SecretRef != nil {
+		secretRef = *req.SecretRef
+	}
+	if secretRef != "" {
+		if err := s.keycloakClient.ValidateSecretRef(secretRef); err != nil {
+			return nil, err
+		}
+	}
 	
 	// 1. Get master realm admin token
 	masterToken, err := s.keycloakClient.GetAccessToken(
@@ -51,13 +63,22 @@ func (s *ClusterService) Create(req domain.CreateClusterRequest) (*domain.Cluste
 		return nil, fmt.Errorf("failed to setup realm client: %w", err)
 	}
 	
-	// 3. Create cluster with client credentials
+	// 3. A secret from an external store replaces the generated one and is not stored
+	if secretRef != "" {
+		if err := s.applySecretRef(req.BaseURL, req.Realm, masterToken, secretRef); err != nil {
+			return nil, err
+		}
+		clientSecret = ""
+	}
+	
+	// 4. Create cluster with client credentials
 	cluster := &domain.Cluster{
 		Name:            req.Name,
 		BaseURL:         req.BaseURL,
 		Realm:           req.Realm,
 		ClientID:        "multi-manage",
 		ClientSecret:    clientSecret,
+		SecretRef:       secretRef,
 		GroupName:       req.GroupName,
 		MetricsEndpoint: req.MetricsEndpoint,
 	}
@@ -121,7 +142,19 @@ func (s *ClusterService) Update(id int, req domain.CreateClusterRequest) (*domai
 	realmChanged := cluster.Realm != req.Realm
 	baseURLChanged := cluster.BaseURL != req.BaseURL
 	
-	if realmChanged || baseURLChanged {
+	secretRef := cluster.SecretRef
+	if req.SecretRef != nil {
+		secretRef = *req.SecretRef
+	}
+	secretRefChanged := secretRef != cluster.SecretRef
+	if secretRefChanged && secretRef != "" {
+		if err := s.keycloakClient.ValidateSecretRef(secretRef); err != nil {
+			return nil, err
+		}
+	}
+	
+	secretChanged := false
+	if realmChanged || baseURLChanged || secretRefChanged {
 		if req.Realm == "" {
 			req.Realm = "master"
 		}
@@ -137,18 +170,31 @@ func (s *ClusterService) Update(id int, req domain.CreateClusterRequest) (*domai
 			return nil, fmt.Errorf("failed to authenticate with master realm: %w", err)
 		}
 		
-		// Setup client in the new realm
-		clientSecret, err := s.keycloakClient.SetupRealmClient(
-			req.BaseURL,
-			req.Realm,
-			masterToken,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to setup realm client: %w", err)
+		// Setup client in the new realm. Without a secret reference the client's secret
+		// is stored again, also when the cluster stops using an external store.
+		if realmChanged || baseURLChanged || secretRef == "" {
+			clientSecret, err := s.keycloakClient.SetupRealmClient(
+				req.BaseURL,
+				req.Realm,
+				masterToken,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to setup realm client: %w", err)
+			}
+			
+			cluster.ClientSecret = clientSecret
+			secretChanged = true
 		}
 		
-		cluster.ClientSecret = clientSecret
+		if secretRef != "" {
+			if err := s.applySecretRef(req.BaseURL, req.Realm, masterToken, secretRef); err != nil {
+				return nil, err
+			}
+			cluster.ClientSecret = ""
+			secretChanged = true
+		}
 	}
+	cluster.SecretRef = secretRef
 	
 	cluster.Name = req.Name
 	cluster.BaseURL = req.BaseURL
@@ -160,13 +206,32 @@ func (s *ClusterService) Update(id int, req domain.CreateClusterRequest) (*domai
 	cluster.GroupName = req.GroupName
 	cluster.MetricsEndpoint = req.MetricsEndpoint
 	
-	if err := s.repo.Update(cluster); err != nil {
+	// The secret is only written when it was replaced above, so an edit that overlaps a rotation
+	// does not store back the secret the rotation is replacing
+	if err := s.repo.Update(cluster, secretChanged); err != nil {
 		return nil, err
 	}
 	
 	return cluster, nil
 }
 
+// applySecretRef sets the secret of the multi-manage client to the one a secret reference points to
+// and checks that Keycloak accepts it, so the external store stays the only copy we rely on
+func (s *ClusterService) applySecretRef(baseURL, realm, masterToken, secretRef string) error {
+	secret, err := s.keycloakClient.ResolveSecret(secretRef)
+	if err != nil {
+		return fmt.Errorf("failed to resolve secret_ref: %w", err)
+	}
+	
+	if err := s.keycloakClient.SetClientSecret(baseURL, realm, masterToken, "multi-manage", secret); err != nil {
+		return fmt.Errorf("failed to set client secret from secret_ref: %w", err)
+	}
+	if _, err := s.keycloakClient.GetClientCredentialsToken(baseURL, realm, "multi-manage", secret); err != nil {
+		return fmt.Errorf("failed to get a token with the secret from secret_ref: %w", err)
+	}
+	return nil
+}
+
 func (s *ClusterService) Delete(id int) error {
 	return s.repo.Delete(id)
 }
@@ -209,12 +274,7 @@ func (s *ClusterService) HealthCheck(id int) (*domain.ClusterHealth, error) {
 
 // getClusterAccessToken gets access token for a cluster using client credentials
 func (s *ClusterService) getClusterAccessToken(cluster *domain.Cluster) (string, error) {
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,374 @@
+package service
+
+import (
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// builtinClients are created by Keycloak in every realm and are never pruned
+var builtinClients = map[string]bool{
+	"account":                true,
+	"account-console":        true,
+	"admin-cli":              true,
+	"broker":                 true,
+	"realm-management":       true,
+	"security-admin-console": true,
+}
+
+// builtinRealmRoles are created by Keycloak in every realm and are never pruned
+var builtinRealmRoles = map[string]bool{
+	"offline_access":    true,
+	"uma_authorization": true,
+}
+
+// DesiredStateService reconciles a cluster's realm with a declarative desired-state document
+type DesiredStateService struct {
+	syncService *SyncService
+}
+
+func NewDesiredStateService(syncService *SyncService) *DesiredStateService {
+	return &DesiredStateService{syncService: syncService}
+}
+
+// ParseDesiredState reads a desired-state document. JSON documents are accepted as well, since JSON is valid YAML.
+func ParseDesiredState(data []byte) (*domain.DesiredState, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid desired-state document: %w", err)
+	}
+
+	var state domain.DesiredState
+	if err := decodeExportValue(raw, &state); err != nil {
+		return nil, fmt.Errorf("invalid desired-state document: %w", err)
+	}
+
+	for i := range state.Roles {
+		if state.Roles[i].Composites != nil {
+			state.Roles[i].Composite = true
+		}
+	}
+	for i := range state.Groups {
+		setGroupPaths(&state.Groups[i], "")
+	}
+
+	return &state, nil
+}
+
+// Apply diffs the realm of a cluster with the document and creates or updates everything that differs.
+// With prune, objects of a managed section that the document does not list are deleted; Keycloak's
+// built-in roles and clients and the cluster's own management client are always kept.
+// A dry run only returns the diff and the planned steps.
+func (s *DesiredStateService) Apply(clusterID int, state *domain.DesiredState, prune, dryRun bool) (*domain.DesiredStateResult, error) {
+	if err := validateDesiredState(state); err != nil {
+		return nil, err
+	}
+
+	dest, err := s.syncService.getSyncTarget(clusterID, "destination")
+	if err != nil {
+		return nil, err
+	}
+	if state.Realm != "" && state.Realm != dest.cluster.Realm {
+		return nil, fmt.Errorf("document is for realm '%s' but the cluster manages realm '%s'", state.Realm, dest.cluster.Realm)
+	}
+
+	source := desiredStateTarget(dest.cluster, state)
+	live, err := s.liveState(dest, state)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &domain.DesiredStateResult{
+		ClusterID: clusterID,
+		DryRun:    dryRun,
+		Prune:     prune,
+		Diff:      &domain.CompareResult{},
+		Steps:     []domain.SyncPlanStep{},
+	}
+
+	// Steps of all sections are planned together so shared prerequisites are planned once
+	var items []domain.BulkSyncItem
+	if state.Roles != nil {
+		result.Diff.Roles = diffRoles(source.roles, live.Roles)
+		for _, role := range state.Roles {
+			items = append(items, domain.BulkSyncItem{ObjectType: "role", Name: role.Name})
+		}
+	}
+	if state.ClientScopes != nil {
+		for _, scope := range state.ClientScopes {
+			name, _ := scope["name"].(string)
+			items = append(items, domain.BulkSyncItem{ObjectType: "client_scope", Name: name})
+		}
+	}
+	if state.Clients != nil {
+		result.Diff.Clients = diffClients(source.clientDetails, live.Clients, nil)
+		for _, client := range s.changedClients(state, result.Diff.Clients, live.ClientExports) {
+			items = append(items, domain.BulkSyncItem{ObjectType: "client", Name: client})
+		}
+	}
+	if state.Groups != nil {
+		result.Diff.Groups = diffGroups(source.groups, live.Groups)
+		for _, path := range groupPaths(state.Groups) {
+			items = append(items, domain.BulkSyncItem{ObjectType: "group", Name: path})
+		}
+	}
+
+	// Without prune, role mappings, composites and memberships the document does not list are kept
+	steps, deps, err := s.syncService.buildStepsForItems(source, dest, items, prune)
+	if err != nil {
+		return nil, err
+	}
+	if prune {
+		steps = append(steps, pruneSteps(dest.cluster, state, live)...)
+		for i := range steps {
+			steps[i].Order = i + 1
+		}
+	}
+	result.Steps = steps
+	result.Dependencies = deps
+
+	if len(steps) == 0 {
+		result.Status = "unchanged"
+		return result, nil
+	}
+	if dryRun {
+		result.Status = "planned"
+		return result, nil
+	}
+
+	results, ok := s.syncService.executeSteps(source, dest, steps)
+	result.Results = results
+	if ok {
+		result.Status = "applied"
+	} else {
+		result.Status = "failed"
+	}
+
+	return result, nil
+}
+
+// liveState loads the managed sections of the cluster's realm
+func (s *DesiredStateService) liveState(dest *syncTarget, state *domain.DesiredState) (*domain.RealmSnapshotContent, error) {
+	kc := s.syncService.keycloakClient
+	baseURL, realm := dest.cluster.BaseURL, dest.cluster.Realm
+	live := &domain.RealmSnapshotContent{}
+	var err error
+
+	if state.Roles != nil {
+		if live.Roles, err = kc.GetRoleDetails(baseURL, realm, dest.token); err != nil {
+			return nil, fmt.Errorf("failed to get roles: %w", err)
+		}
+	}
+	if state.Clients != nil {
+		if live.Clients, err = kc.GetClientDetails(baseURL, realm, dest.token); err != nil {
+			return nil, fmt.Errorf("failed to get clients: %w", err)
+		}
+		if live.ClientExports, err = kc.ExportClients(baseURL, realm, dest.token); err != nil {
+			return nil, fmt.Errorf("failed to export clients: %w", err)
+		}
+	}
+	if state.Groups != nil {
+		if live.Groups, err = kc.GetGroupDetails(baseURL, realm, dest.token); err != nil {
+			return nil, fmt.Errorf("failed to get groups: %w", err)
+		}
+	}
+
+	return live, nil
+}
+
+// changedClients returns the clients of the document that are missing in the realm or differ from it.
+// Besides the diff, every field the document sets is compared with the live representation.
+func (s *DesiredStateService) changedClients(state *domain.DesiredState, diffs []domain.ClientDiff, liveExports []map[string]interface{}) []string {
+	differs := make(map[string]bool)
+	for _, d := range diffs {
+		if d.Status != "missing_in_source" {
+			differs[d.Client.ClientID] = true
+		}
+	}
+
+	liveByClientID := make(map[string]map[string]interface{})
+	for _, client := range liveExports {
+		if clientID, ok := client["clientId"].(string); ok {
+			liveByClientID[clientID] = client
+		}
+	}
+
+	var changed []string
+	for _, client := range state.Clients {
+		clientID, _ := client["clientId"].(string)
+		live := liveByClientID[clientID]
+		if differs[clientID] || live == nil {
+			changed = append(changed, clientID)
+			continue
+		}
+		for key, value := range client {
+			if key == "id" || key == "roles" {
+				continue
+			}
+			if !reflect.DeepEqual(value, live[key]) {
+				changed = append(changed, clientID)
+				break
+			}
+		}
+	}
+
+	return changed
+}
+
+// desiredStateTarget turns a document into a preloaded sync source
+func desiredStateTarget(cluster *domain.Cluster, state *domain.DesiredState) *syncTarget {
+	source := &syncTarget{
+		cluster:       cluster,
+		preloaded:     true,
+		roles:         append([]domain.Role{}, state.Roles...),
+		clients:       []map[string]interface{}{},
+		clientDetails: []domain.ClientDetail{},
+		groups:        append([]domain.GroupDetail{}, state.Groups...),
+		users:         []domain.UserDetail{},
+		clientScopes:  make(map[string]map[string]interface{}),
+	}
+
+	for _, client := range state.Clients {
+		payload := make(map[string]interface{}, len(client))
+		for k, v := range client {
+			if k != "roles" {
+				payload[k] = v
+			}
+		}
+		source.clients = append(source.clients, payload)
+
+		var detail domain.ClientDetail
+		// The client was already decoded from JSON, so it always converts
+		_ = decodeExportValue(payload, &detail)
+		if roles, ok := client["roles"].([]interface{}); ok {
+			for _, role := range roles {
+				if name, ok := role.(string); ok {
+					detail.ClientRoles = append(detail.ClientRoles, name)
+				}
+			}
+		}
+		source.clientDetails = append(source.clientDetails, detail)
+	}
+
+	for _, scope := range state.ClientScopes {
+		if name, ok := scope["name"].(string); ok {
+			source.clientScopes[name] = scope
+		}
+	}
+
+	return source
+}
+
+// pruneSteps plans deleting the objects of the managed sections that the document does not list
+func pruneSteps(cluster *domain.Cluster, state *domain.DesiredState, live *domain.RealmSnapshotContent) []domain.SyncPlanStep {
+	var steps []domain.SyncPlanStep
+
+	if state.Roles != nil {
+		desired := make(map[string]bool)
+		for _, role := range state.Roles {
+			desired[role.Name] = true
+		}
+		for _, role := range live.Roles {
+			if desired[role.Name] || builtinRealmRoles[role.Name] || isDefaultRealmRole(role.Name) {
+				continue
+			}
+			steps = append(steps, domain.SyncPlanStep{
+				Action:      domain.SyncActionDeleteRole,
+				Method:      "DELETE",
+				Path:        fmt.Sprintf("/admin/realms/%s/roles/%s", cluster.Realm, role.Name),
+				Target:      role.Name,
+				Description: fmt.Sprintf("Delete role '%s', which the document does not list", role.Name),
+			})
+		}
+	}
+
+	if state.Clients != nil {
+		desired := make(map[string]bool)
+		for _, client := range state.Clients {
+			clientID, _ := client["clientId"].(string)
+			desired[clientID] = true
+		}
+		for _, client := range live.Clients {
+			if desired[client.ClientID] || builtinClients[client.ClientID] || client.ClientID == cluster.ClientID {
+				continue
+			}
+			steps = append(steps, domain.SyncPlanStep{
+				Action:      domain.SyncActionDeleteClient,
+				Method:      "DELETE",
+				Path:        fmt.Sprintf("/admin/realms/%s/clients/%s", cluster.Realm, client.ID),
+				Target:      client.ClientID,
+				Description: fmt.Sprintf("Delete client '%s', which the document does not list", client.ClientID),
+			})
+		}
+	}
+
+	if state.Groups != nil {
+		desired := make(map[string]bool)
+		for _, path := range groupPaths(state.Groups) {
+			desired[path] = true
+		}
+		// Deleting a group deletes its subgroups, so only the topmost undesired group of a branch is deleted
+		for _, path := range groupPaths(live.Groups) {
+			parent := parentGroupPath(path)
+			if desired[path] || (parent != "" && !desired[parent]) {
+				continue
+			}
+			steps = append(steps, domain.SyncPlanStep{
+				Action:      domain.SyncActionDeleteGroup,
+				Method:      "DELETE",
+				Path:        fmt.Sprintf("/admin/realms/%s/groups/{id of %s}", cluster.Realm, path),
+				Target:      path,
+				Description: fmt.Sprintf("Delete group '%s' and its subgroups, which the document does not list", path),
+			})
+		}
+	}
+
+	return steps
+}
+
+func validateDesiredState(state *domain.DesiredState) error {
+	for _, role := range state.Roles {
+		if role.Name == "" {
+			return fmt.Errorf("every role needs a name")
+		}
+	}
+	for _, client := range state.Clients {
+		if clientID, _ := client["clientId"].(string); clientID == "" {
+			return fmt.Errorf("every client needs a clientId")
+		}
+	}
+	for _, scope := range state.ClientScopes {
+		if name, _ := scope["name"].(string); name == "" {
+			return fmt.Errorf("every client scope needs a name")
+		}
+	}
+	for _, path := range groupPaths(state.Groups) {
+		if strings.HasSuffix(path, "/") {
+			return fmt.Errorf("every group needs a name")
+		}
+	}
+	return nil
+}
+
+// setGroupPaths fills in the paths a document left out from the group nesting
+func setGroupPaths(group *domain.GroupDetail, parentPath string) {
+	if group.Path == "" {
+		group.Path = parentPath + "/" + group.Name
+	}
+	for i := range group.SubGroups {
+		setGroupPaths(&group.SubGroups[i], group.Path)
+	}
+}
+
+// groupPaths lists the paths of a group tree, parents before their children
+func groupPaths(groups []domain.GroupDetail) []string {
+	var paths []string
+	for _, group := range groups {
+		paths = append(paths, group.Path)
+		paths = append(paths, groupPaths(group.SubGroups)...)
+	}
+	return paths
+}
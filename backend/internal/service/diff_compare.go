@@ -0,0 +1,298 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"time"
+)
+
+// diffObjectSections maps the compared object types to the snapshot sections they are read from
+var diffObjectSections = map[string]string{
+	"role":   domain.SnapshotSectionRoles,
+	"client": domain.SnapshotSectionClients,
+	"group":  domain.SnapshotSectionGroups,
+	"user":   domain.SnapshotSectionUsers,
+}
+
+// Compare diffs two sides that are each a live cluster, a stored snapshot or an uploaded realm export.
+// The result uses the same diff types as the cluster to cluster endpoints.
+func (s *DiffService) Compare(req domain.CompareRequest) (*domain.CompareResult, error) {
+	objectTypes := req.ObjectTypes
+	if len(objectTypes) == 0 {
+		objectTypes = driftObjectTypes
+	}
+	for _, objectType := range objectTypes {
+		if _, ok := diffObjectSections[objectType]; !ok {
+			return nil, fmt.Errorf("unsupported object type: %s", objectType)
+		}
+	}
+
+	source, sourceClusterID, err := s.loadDiffSource(req.Source, objectTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load source: %w", err)
+	}
+	destination, _, err := s.loadDiffSource(req.Destination, objectTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load destination: %w", err)
+	}
+
+	// Snapshots and realm exports hold masked secrets; masking the other side the same way
+	// compares them as equal instead of reporting every secret as changed
+	if !isLiveDiffSource(req.Source) || !isLiveDiffSource(req.Destination) {
+		if err := maskContentSecrets(source); err != nil {
+			return nil, err
+		}
+		if err := maskContentSecrets(destination); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rewrite rules describe what sync would write, so they only apply against a live destination
+	if isLiveDiffSource(req.Destination) {
+		rewriter, err := s.rewriterFor(sourceClusterID, req.Destination.ClusterID)
+		if err != nil {
+			return nil, err
+		}
+		if err := rewriteDiffContent(rewriter, source); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &domain.CompareResult{}
+	for _, objectType := range objectTypes {
+		switch objectType {
+		case "role":
+			result.Roles = diffRoles(source.Roles, destination.Roles)
+		case "client":
+			// Scope mappers are not part of stored content and can only be compared between live clusters
+			var compareScopes func(source, dest domain.ClientDetail) ([]string, map[string]interface{}, map[string]interface{})
+			if isLiveDiffSource(req.Source) && isLiveDiffSource(req.Destination) {
+				compareScopes = func(sourceClient, destClient domain.ClientDetail) ([]string, map[string]interface{}, map[string]interface{}) {
+					return s.compareScopeMappers(req.Source.ClusterID, req.Destination.ClusterID, sourceClient, destClient)
+				}
+			}
+			result.Clients = diffClients(source.Clients, destination.Clients, compareScopes)
+		case "group":
+			result.Groups = diffGroups(source.Groups, destination.Groups)
+		case "user":
+			result.Users = diffUsers(source.Users, destination.Users)
+		}
+	}
+
+	return result, nil
+}
+
+// ValidateDiffSource checks that exactly one kind of source is given
+func ValidateDiffSource(src domain.DiffSource) error {
+	kinds := 0
+	if src.ClusterID != 0 {
+		kinds++
+	}
+	if src.SnapshotID != 0 {
+		kinds++
+	}
+	if src.Export != nil {
+		kinds++
+	}
+	if kinds != 1 {
+		return fmt.Errorf("exactly one of cluster_id, snapshot_id or export is required")
+	}
+	if src.At != nil && src.ClusterID == 0 {
+		return fmt.Errorf("at can only be used with cluster_id")
+	}
+	return nil
+}
+
+// DiffSourceClusterID returns the cluster a diff side reads, live or through a snapshot, or 0 for an uploaded export
+func (s *DiffService) DiffSourceClusterID(src domain.DiffSource) (int, error) {
+	if src.SnapshotID == 0 {
+		return src.ClusterID, nil
+	}
+	if s.snapshotService == nil {
+		return 0, fmt.Errorf("realm snapshots are not available")
+	}
+	snapshot, err := s.snapshotService.GetSnapshot(src.SnapshotID)
+	if err != nil {
+		return 0, err
+	}
+	if snapshot == nil {
+		return 0, fmt.Errorf("snapshot not found")
+	}
+	return snapshot.ClusterID, nil
+}
+
+func isLiveDiffSource(src domain.DiffSource) bool {
+	return src.ClusterID != 0 && src.At == nil
+}
+
+// loadDiffSource reads the compared object types of one side.
+// It also returns the cluster the content belongs to, or 0 for an uploaded export.
+func (s *DiffService) loadDiffSource(src domain.DiffSource, objectTypes []string) (*domain.RealmSnapshotContent, int, error) {
+	if err := ValidateDiffSource(src); err != nil {
+		return nil, 0, err
+	}
+
+	if src.Export != nil {
+		content, err := realmExportContent(src.Export, objectTypes)
+		return content, 0, err
+	}
+
+	if src.SnapshotID == 0 && src.At == nil {
+		content, err := s.loadCluster(src.ClusterID, objectTypes)
+		return content, src.ClusterID, err
+	}
+
+	if s.snapshotService == nil {
+		return nil, 0, fmt.Errorf("realm snapshots are not available")
+	}
+
+	var snapshot *domain.RealmSnapshot
+	var err error
+	if src.SnapshotID != 0 {
+		snapshot, err = s.snapshotService.GetSnapshot(src.SnapshotID)
+		if err == nil && snapshot == nil {
+			err = fmt.Errorf("snapshot not found")
+		}
+	} else {
+		snapshot, err = s.snapshotService.GetSnapshotAt(src.ClusterID, *src.At)
+		if err == nil && snapshot == nil {
+			err = fmt.Errorf("snapshot of cluster %d at %s not found", src.ClusterID, src.At.Format(time.RFC3339))
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var sections []string
+	for _, objectType := range objectTypes {
+		sections = append(sections, diffObjectSections[objectType])
+	}
+	content, err := s.snapshotService.GetSnapshotContent(snapshot, sections)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return content, snapshot.ClusterID, nil
+}
+
+func (s *DiffService) loadCluster(clusterID int, objectTypes []string) (*domain.RealmSnapshotContent, error) {
+	content := &domain.RealmSnapshotContent{}
+	var err error
+
+	for _, objectType := range objectTypes {
+		switch objectType {
+		case "role":
+			content.Roles, err = s.roleService.GetRoleDetails(clusterID)
+		case "client":
+			content.Clients, err = s.clusterService.GetClientDetails(clusterID)
+		case "group":
+			content.Groups, err = s.clusterService.GetGroupDetails(clusterID)
+		case "user":
+			content.Users, err = s.clusterService.GetUserDetails(clusterID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return content, nil
+}
+
+// realmExportContent reads the compared object types from a Keycloak realm export.
+// A missing section is an error rather than an empty list, so partial exports do not show everything as missing.
+func realmExportContent(export map[string]interface{}, objectTypes []string) (*domain.RealmSnapshotContent, error) {
+	content := &domain.RealmSnapshotContent{}
+	exportRoles, _ := export["roles"].(map[string]interface{})
+
+	for _, objectType := range objectTypes {
+		switch objectType {
+		case "role":
+			if exportRoles["realm"] == nil {
+				return nil, fmt.Errorf("realm export does not contain realm roles")
+			}
+			if err := decodeExportValue(exportRoles["realm"], &content.Roles); err != nil {
+				return nil, fmt.Errorf("failed to read realm roles: %w", err)
+			}
+		case "client":
+			if export["clients"] == nil {
+				return nil, fmt.Errorf("realm export does not contain clients")
+			}
+			if err := decodeExportValue(export["clients"], &content.Clients); err != nil {
+				return nil, fmt.Errorf("failed to read clients: %w", err)
+			}
+
+			// Client roles are exported under roles.client instead of on the client
+			var clientRoles map[string][]domain.Role
+			if exportRoles["client"] != nil {
+				if err := decodeExportValue(exportRoles["client"], &clientRoles); err != nil {
+					return nil, fmt.Errorf("failed to read client roles: %w", err)
+				}
+			}
+			for i := range content.Clients {
+				content.Clients[i].ClientRoles = nil
+				for _, role := range clientRoles[content.Clients[i].ClientID] {
+					content.Clients[i].ClientRoles = append(content.Clients[i].ClientRoles, role.Name)
+				}
+			}
+		case "group":
+			if export["groups"] == nil {
+				return nil, fmt.Errorf("realm export does not contain groups")
+			}
+			if err := decodeExportValue(export["groups"], &content.Groups); err != nil {
+				return nil, fmt.Errorf("failed to read groups: %w", err)
+			}
+		case "user":
+			if export["users"] == nil {
+				return nil, fmt.Errorf("realm export does not contain users")
+			}
+			if err := decodeExportValue(export["users"], &content.Users); err != nil {
+				return nil, fmt.Errorf("failed to read users: %w", err)
+			}
+
+			var links []struct {
+				FederationLink string `json:"federationLink"`
+			}
+			if err := decodeExportValue(export["users"], &links); err != nil {
+				return nil, fmt.Errorf("failed to read users: %w", err)
+			}
+			for i := range content.Users {
+				content.Users[i].Origin = "local"
+				if links[i].FederationLink != "" {
+					content.Users[i].Origin = "federation"
+				}
+			}
+		}
+	}
+
+	return content, nil
+}
+
+// decodeExportValue converts a decoded JSON value into a typed structure
+func decodeExportValue(value interface{}, target interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// rewriteDiffContent applies rewrite rules to the source side the same way the cluster diffs do
+func rewriteDiffContent(rewriter *Rewriter, content *domain.RealmSnapshotContent) error {
+	for i := range content.Clients {
+		if _, err := rewriter.ApplyTo("client", content.Clients[i].ClientID, &content.Clients[i]); err != nil {
+			return err
+		}
+	}
+	for i := range content.Groups {
+		if _, err := rewriter.ApplyTo("group", content.Groups[i].Path, &content.Groups[i]); err != nil {
+			return err
+		}
+	}
+	for i := range content.Users {
+		if _, err := rewriter.ApplyTo("user", content.Users[i].Username, &content.Users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 type DiffService struct {
 	roleService    *RoleService
 	clusterService *ClusterService
+	rewriteService *RewriteRuleService
+	snapshotService *SnapshotService
 }
 
 func NewDiffService(roleService *RoleService, clusterService *ClusterService) *DiffService {
@@ -19,17 +21,39 @@ func NewDiffService(roleService *RoleService, clusterService *ClusterService) *D
 	}
 }
 
+// SetRewriteService makes client, group and user diffs compare source values the way sync would write them
+func (s *DiffService) SetRewriteService(rewriteService *RewriteRuleService) {
+	s.rewriteService = rewriteService
+}
+
+// SetSnapshotService lets Compare read stored realm snapshots
+func (s *DiffService) SetSnapshotService(snapshotService *SnapshotService) {
+	s.snapshotService = snapshotService
+}
+
+func (s *DiffService) rewriterFor(sourceClusterID, destinationClusterID int) (*Rewriter, error) {
+	if s.rewriteService == nil {
+		return nil, nil
+	}
+	return s.rewriteService.RewriterFor(sourceClusterID, destinationClusterID)
+}
+
 func (s *DiffService) GetRoleDiff(sourceClusterID, destinationClusterID int) ([]domain.RoleDiff, error) {
-	sourceRoles, err := s.roleService.GetRoles(sourceClusterID)
+	sourceRoles, err := s.roleService.GetRoleDetails(sourceClusterID)
 	if err != nil {
 		return nil, err
 	}
 	
-	destinationRoles, err := s.roleService.GetRoles(destinationClusterID)
+	destinationRoles, err := s.roleService.GetRoleDetails(destinationClusterID)
 	if err != nil {
 		return nil, err
 	}
 	
+	return diffRoles(sourceRoles, destinationRoles), nil
+}
+
+// diffRoles compares two role lists by name
+func diffRoles(sourceRoles, destinationRoles []domain.Role) []domain.RoleDiff {
 	// Create maps for quick lookup
 	sourceRoleMap := make(map[string]domain.Role)
 	destRoleMap := make(map[string]domain.Role)
@@ -43,9 +67,10 @@ func (s *DiffService) GetRoleDiff(sourceClusterID, destinationClusterID int) ([]
 	
 	var diffs []domain.RoleDiff
 	
-	// Find roles that exist in source but not in destination
+	// Find roles that exist in source but not in destination, or differ in configuration
 	for _, role := range sourceRoles {
-		if _, exists := destRoleMap[role.Name]; !exists {
+		destRole, exists := destRoleMap[role.Name]
+		if !exists {
 			diffs = append(diffs, domain.RoleDiff{
 				Role:        role,
 				Source:      "source",
@@ -53,6 +78,21 @@ func (s *DiffService) GetRoleDiff(sourceClusterID, destinationClusterID int) ([]
 				Status:      "missing_in_destination",
 				Side:        "source",
 			})
+			continue
+		}
+		
+		differences, sourceVals, destVals := compareRoleConfigsDetailed(role, destRole)
+		if len(differences) > 0 {
+			diffs = append(diffs, domain.RoleDiff{
+				Role:             role,
+				Source:           "source",
+				Destination:      "destination",
+				Status:           "different_config",
+				Side:             "both",
+				Differences:      differences,
+				SourceValue:      sourceVals,
+				DestinationValue: destVals,
+			})
 		}
 	}
 	
@@ -69,7 +109,47 @@ func (s *DiffService) GetRoleDiff(sourceClusterID, destinationClusterID int) ([]
 		}
 	}
 	
-	return diffs, nil
+	return diffs
+}
+
+func compareRoleConfigsDetailed(source, dest domain.Role) ([]string, map[string]interface{}, map[string]interface{}) {
+	var differences []string
+	sourceVals := make(map[string]interface{})
+	destVals := make(map[string]interface{})
+	
+	if source.Description != dest.Description {
+		differences = append(differences, "description")
+		sourceVals["description"] = source.Description
+		destVals["description"] = dest.Description
+	}
+	if !equalAttributes(source.Attributes, dest.Attributes) {
+		differences = append(differences, "attributes")
+		sourceVals["attributes"] = source.Attributes
+		destVals["attributes"] = dest.Attributes
+	}
+	
+	sourceComposites := roleComposites(source)
+	destComposites := roleComposites(dest)
+	if !equalStringSlices(sourceComposites.Realm, destComposites.Realm) {
+		differences = append(differences, "composites.realm")
+		sourceVals["composites.realm"] = sourceComposites.Realm
+		destVals["composites.realm"] = destComposites.Realm
+	}
+	if !equalClientRoles(sourceComposites.Client, destComposites.Client) {
+		differences = append(differences, "composites.client")
+		sourceVals["composites.client"] = sourceComposites.Client
+		destVals["composites.client"] = destComposites.Client
+	}
+	
+	return differences, sourceVals, destVals
+}
+
+// roleComposites returns the composite children of a role, treating non-composite roles as having none
+func roleComposites(role domain.Role) domain.RoleComposites {
+	if role.Composites == nil {
+		return domain.RoleComposites{}
+	}
+	return *role.Composites
 }
 
 func (s *DiffService) GetClientDiff(sourceClusterID, destinationClusterID int) ([]domain.ClientDiff, error) {
@@ -83,6 +163,24 @@ func (s *DiffService) GetClientDiff(sourceClusterID, destinationClusterID int) (
 		return nil, err
 	}
 	
+	rewriter, err := s.rewriterFor(sourceClusterID, destinationClusterID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sourceClients {
+		if _, err := rewriter.ApplyTo("client", sourceClients[i].ClientID, &sourceClients[i]); err != nil {
+			return nil, err
+		}
+	}
+	
+	return diffClients(sourceClients, destinationClients, func(source, dest domain.ClientDetail) ([]string, map[string]interface{}, map[string]interface{}) {
+		return s.compareScopeMappers(sourceClusterID, destinationClusterID, source, dest)
+	}), nil
+}
+
+// diffClients compares two client lists by clientId.
+// compareScopes adds protocol mapper differences of shared scopes; it is nil when the clusters cannot be queried.
+func diffClients(sourceClients, destinationClients []domain.ClientDetail, compareScopes func(source, dest domain.ClientDetail) ([]string, map[string]interface{}, map[string]interface{})) []domain.ClientDiff {
 	// Create a map of destination clients by ClientID
 	destClientMap := make(map[string]domain.ClientDetail)
 	for _, client := range destinationClients {
@@ -115,19 +213,16 @@ func (s *DiffService) GetClientDiff(sourceClusterID, destinationClusterID int) (
 			differences, sourceVals, destVals := compareClientConfigsDetailed(sourceClient, destClient)
 			
 			// Check scope mapper differences for common scopes
-			scopeMapperDiffs, scopeMapperSourceVals, scopeMapperDestVals := s.compareScopeMappers(
-				sourceClusterID,
-				destinationClusterID,
-				sourceClient,
-				destClient,
-			)
-			if len(scopeMapperDiffs) > 0 {
-				differences = append(differences, scopeMapperDiffs...)
-				for k, v := range scopeMapperSourceVals {
-					sourceVals[k] = v
-				}
-				for k, v := range scopeMapperDestVals {
-					destVals[k] = v
+			if compareScopes != nil {
+				scopeMapperDiffs, scopeMapperSourceVals, scopeMapperDestVals := compareScopes(sourceClient, destClient)
+				if len(scopeMapperDiffs) > 0 {
+					differences = append(differences, scopeMapperDiffs...)
+					for k, v := range scopeMapperSourceVals {
+						sourceVals[k] = v
+					}
+					for k, v := range scopeMapperDestVals {
+						destVals[k] = v
+					}
 				}
 			}
 			
@@ -159,7 +254,7 @@ func (s *DiffService) GetClientDiff(sourceClusterID, destinationClusterID int) (
 		}
 	}
 	
-	return diffs, nil
+	return diffs
 }
 
 func compareClientConfigs(source, dest domain.ClientDetail) []string {
@@ -237,6 +332,21 @@ func (s *DiffService) GetGroupDiff(sourceClusterID, destinationClusterID int) ([
 		return nil, err
 	}
 	
+	rewriter, err := s.rewriterFor(sourceClusterID, destinationClusterID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sourceGroups {
+		if _, err := rewriter.ApplyTo("group", sourceGroups[i].Path, &sourceGroups[i]); err != nil {
+			return nil, err
+		}
+	}
+	
+	return diffGroups(sourceGroups, destinationGroups), nil
+}
+
+// diffGroups compares two group lists by path
+func diffGroups(sourceGroups, destinationGroups []domain.GroupDetail) []domain.GroupDiff {
 	// Create a map of destination groups by path
 	destGroupMap := make(map[string]domain.GroupDetail)
 	for _, group := range destinationGroups {
@@ -295,7 +405,7 @@ func (s *DiffService) GetGroupDiff(sourceClusterID, destinationClusterID int) ([
 		}
 	}
 	
-	return diffs, nil
+	return diffs
 }
 
 func compareGroupConfigs(source, dest domain.GroupDetail) []string {
@@ -338,6 +448,21 @@ func (s *DiffService) GetUserDiff(sourceClusterID, destinationClusterID int) ([]
 		return nil, err
 	}
 	
+	rewriter, err := s.rewriterFor(sourceClusterID, destinationClusterID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sourceUsers {
+		if _, err := rewriter.ApplyTo("user", sourceUsers[i].Username, &sourceUsers[i]); err != nil {
+			return nil, err
+		}
+	}
+	
+	return diffUsers(sourceUsers, destinationUsers), nil
+}
+
+// diffUsers compares two user lists by username
+func diffUsers(sourceUsers, destinationUsers []domain.UserDetail) []domain.UserDiff {
 	// Create a map of destination users by username
 	destUserMap := make(map[string]domain.UserDetail)
 	for _, user := range destinationUsers {
@@ -396,7 +521,7 @@ func (s *DiffService) GetUserDiff(sourceClusterID, destinationClusterID int) ([]
 		}
 	}
 	
-	return diffs, nil
+	return diffs
 }
 
 func compareUserConfigs(source, dest domain.UserDetail) []string {
@@ -458,23 +583,13 @@ func (s *DiffService) compareScopeMappers(sourceClusterID, destClusterID int, so
 	// Get access tokens using keycloak client
 	keycloakClient := keycloak.NewClient()
 	
-	sourceTokenResp, err := keycloakClient.GetClientCredentialsToken(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceCluster.ClientID,
-		sourceCluster.ClientSecret,
-	)
+	sourceTokenResp, err := keycloakClient.GetClusterToken(sourceCluster)
 	if err != nil {
 		return differences, sourceVals, destVals
 	}
 	sourceToken := sourceTokenResp.AccessToken
 	
-	destTokenResp, err := keycloakClient.GetClientCredentialsToken(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destCluster.ClientID,
-		destCluster.ClientSecret,
-	)
+	destTokenResp, err := keycloakClient.GetClusterToken(destCluster)
 	if err != nil {
 		return differences, sourceVals, destVals
 	}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"keycloak-multi-manage/internal/domain"
+)
+
+func TestDiffRoles(t *testing.T) {
+	source := []domain.Role{
+		{Name: "same", Description: "d"},
+		{Name: "only-source"},
+		{Name: "described", Description: "new"},
+		{Name: "attributed", Attributes: map[string][]string{"team": {"a"}}},
+		compositeRole("composite", "same"),
+	}
+	dest := []domain.Role{
+		{Name: "same", Description: "d"},
+		{Name: "only-dest"},
+		{Name: "described", Description: "old"},
+		{Name: "attributed", Attributes: map[string][]string{"team": {"b"}}},
+		compositeRole("composite", "only-dest"),
+	}
+
+	got := make(map[string]domain.RoleDiff)
+	for _, diff := range diffRoles(source, dest) {
+		got[diff.Role.Name] = diff
+	}
+
+	tests := []struct {
+		role        string
+		status      string
+		differences []string
+	}{
+		{"only-source", "missing_in_destination", nil},
+		{"only-dest", "missing_in_source", nil},
+		{"described", "different_config", []string{"description"}},
+		{"attributed", "different_config", []string{"attributes"}},
+		{"composite", "different_config", []string{"composites.realm"}},
+	}
+
+	for _, tt := range tests {
+		diff, ok := got[tt.role]
+		if !ok {
+			t.Errorf("no diff for role '%s'", tt.role)
+			continue
+		}
+		if diff.Status != tt.status || !reflect.DeepEqual(diff.Differences, tt.differences) {
+			t.Errorf("role '%s': status %s, differences %v, want %s, %v", tt.role, diff.Status, diff.Differences, tt.status, tt.differences)
+		}
+	}
+	if _, ok := got["same"]; ok {
+		t.Error("identical roles reported as different")
+	}
+	if len(got) != len(tests) {
+		t.Errorf("got %d diffs, want %d", len(got), len(tests))
+	}
+}
+
+func TestCompareRoleConfigsDetailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		source domain.Role
+		dest   domain.Role
+		want   []string
+	}{
+		{"equal", domain.Role{Name: "r"}, domain.Role{Name: "r"}, nil},
+		{"no composites on either side", domain.Role{Name: "r", Composites: &domain.RoleComposites{}}, domain.Role{Name: "r"}, nil},
+		{"realm composites in another order", compositeRole("r", "a", "b"), compositeRole("r", "b", "a"), nil},
+		{
+			"client composites",
+			domain.Role{Name: "r", Composites: &domain.RoleComposites{Client: map[string][]string{"app": {"viewer"}}}},
+			domain.Role{Name: "r", Composites: &domain.RoleComposites{Client: map[string][]string{"app": {"editor"}}}},
+			[]string{"composites.client"},
+		},
+		{
+			"several fields",
+			domain.Role{Name: "r", Description: "x", Attributes: map[string][]string{"k": {"v"}}},
+			domain.Role{Name: "r"},
+			[]string{"description", "attributes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			differences, sourceVals, destVals := compareRoleConfigsDetailed(tt.source, tt.dest)
+			if !reflect.DeepEqual(differences, tt.want) {
+				t.Fatalf("differences = %v, want %v", differences, tt.want)
+			}
+			if len(sourceVals) != len(tt.want) || len(destVals) != len(tt.want) {
+				t.Errorf("got values for %d and %d fields, want %d", len(sourceVals), len(destVals), len(tt.want))
+			}
+		})
+	}
+}
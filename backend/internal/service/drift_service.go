@@ -0,0 +1,359 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/repository/postgres"
+	"log"
+	"time"
+)
+
+// driftRetention is how long drift runs are kept
+const driftRetention = 30 * 24 * time.Hour
+
+var driftObjectTypes = []string{"role", "client", "group", "user"}
+
+type DriftService struct {
+	driftRepo   *postgres.DriftRepository
+	clusterRepo *postgres.ClusterRepository
+	diffService *DiffService
+	events      *EventService
+}
+
+func NewDriftService(driftRepo *postgres.DriftRepository, clusterRepo *postgres.ClusterRepository, diffService *DiffService) *DriftService {
+	return &DriftService{
+		driftRepo:   driftRepo,
+		clusterRepo: clusterRepo,
+		diffService: diffService,
+	}
+}
+
+// SetEventService publishes the progress of drift scans to the event stream
+func (s *DriftService) SetEventService(events *EventService) {
+	s.events = events
+}
+
+// StartScheduler checks for due cluster pairs every tick in the background.
+// Each pair is diffed when its own interval has elapsed.
+func (s *DriftService) StartScheduler(tick time.Duration) {
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			s.runDuePairs()
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *DriftService) runDuePairs() {
+	now := time.Now()
+	pairs, err := s.driftRepo.ClaimDuePairs(now)
+	if err != nil {
+		log.Printf("Drift detection: failed to get due cluster pairs: %v", err)
+		return
+	}
+
+	for _, pair := range pairs {
+		run := s.runPair(pair, now)
+		if run.Status == domain.DriftRunStatusFailed {
+			log.Printf("Drift detection: pair '%s' failed: %s", pair.Name, run.Error)
+		} else if run.DriftCount > 0 {
+			log.Printf("Drift detection: pair '%s' has %d drifted objects", pair.Name, run.DriftCount)
+		}
+	}
+
+	if _, err := s.driftRepo.DeleteRunsBefore(now.Add(-driftRetention)); err != nil {
+		log.Printf("Drift detection: failed to delete old runs: %v", err)
+	}
+}
+
+func (s *DriftService) GetPairs() ([]*domain.ClusterPair, error) {
+	pairs, err := s.driftRepo.GetPairs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pair := range pairs {
+		if err := s.attachLastRun(pair); err != nil {
+			return nil, err
+		}
+	}
+
+	return pairs, nil
+}
+
+func (s *DriftService) GetPair(id int) (*domain.ClusterPair, error) {
+	pair, err := s.driftRepo.GetPair(id)
+	if err != nil || pair == nil {
+		return pair, err
+	}
+
+	if err := s.attachLastRun(pair); err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+func (s *DriftService) CreatePair(req domain.CreateClusterPairRequest) (*domain.ClusterPair, error) {
+	pair := &domain.ClusterPair{}
+	applyClusterPairRequest(pair, req)
+
+	if err := s.validatePair(pair); err != nil {
+		return nil, err
+	}
+	if err := s.driftRepo.CreatePair(pair); err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+func (s *DriftService) UpdatePair(id int, req domain.CreateClusterPairRequest) (*domain.ClusterPair, error) {
+	pair, err := s.driftRepo.GetPair(id)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("cluster pair not found")
+	}
+
+	applyClusterPairRequest(pair, req)
+	if err := s.validatePair(pair); err != nil {
+		return nil, err
+	}
+	if err := s.driftRepo.UpdatePair(pair); err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+func (s *DriftService) DeletePair(id int) error {
+	return s.driftRepo.DeletePair(id)
+}
+
+// RunPair diffs a pair right away, outside its schedule
+func (s *DriftService) RunPair(id int) (*domain.DriftRun, error) {
+	pair, err := s.driftRepo.GetPair(id)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("cluster pair not found")
+	}
+
+	now := time.Now()
+	if err := s.driftRepo.MarkPairRun(pair.ID, now); err != nil {
+		return nil, err
+	}
+
+	return s.runPair(pair, now), nil
+}
+
+func (s *DriftService) GetRuns(pairID, limit int) ([]*domain.DriftRun, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	return s.driftRepo.GetRuns(pairID, limit)
+}
+
+func (s *DriftService) GetRun(id int) (*domain.DriftRun, error) {
+	return s.driftRepo.GetRun(id)
+}
+
+// GetChanges reports what drifted between the last run at or before since and the latest run
+func (s *DriftService) GetChanges(pairID int, since time.Time) (*domain.DriftChanges, error) {
+	latest, err := s.driftRepo.GetLatestRun(pairID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("cluster pair has no successful drift run")
+	}
+
+	baseline, err := s.driftRepo.GetLatestRun(pairID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := &domain.DriftChanges{
+		PairID:    pairID,
+		Since:     since,
+		LatestRun: latest,
+		Appeared:  []domain.DriftItem{},
+		Resolved:  []domain.DriftItem{},
+		Changed:   []domain.DriftItem{},
+	}
+
+	baselineItems := make(map[string]domain.DriftItem)
+	if baseline != nil {
+		for _, item := range baseline.Items {
+			baselineItems[item.ObjectType+":"+item.Name] = item
+		}
+	}
+
+	latestItems := make(map[string]bool)
+	for _, item := range latest.Items {
+		key := item.ObjectType + ":" + item.Name
+		latestItems[key] = true
+
+		before, existed := baselineItems[key]
+		if !existed {
+			changes.Appeared = append(changes.Appeared, item)
+		} else if before.Status != item.Status || !equalStringSlices(before.Differences, item.Differences) {
+			changes.Changed = append(changes.Changed, item)
+		}
+	}
+
+	if baseline != nil {
+		for _, item := range baseline.Items {
+			if !latestItems[item.ObjectType+":"+item.Name] {
+				changes.Resolved = append(changes.Resolved, item)
+			}
+		}
+
+		// The runs are returned as summaries, the item lists are already split above
+		baseline.Items = nil
+		changes.BaselineRun = baseline
+	}
+	latest.Items = nil
+
+	return changes, nil
+}
+
+// runPair diffs every object type of a pair and stores the run
+func (s *DriftService) runPair(pair *domain.ClusterPair, startedAt time.Time) *domain.DriftRun {
+	run := &domain.DriftRun{
+		PairID:    pair.ID,
+		Status:    domain.DriftRunStatusRunning,
+		Counts:    make(map[string]int),
+		StartedAt: startedAt,
+	}
+
+	if err := s.driftRepo.CreateRun(run); err != nil {
+		run.Status = domain.DriftRunStatusFailed
+		run.Error = fmt.Sprintf("failed to save drift run: %v", err)
+		return run
+	}
+
+	events := s.events.operation(domain.OperationDriftScan, fmt.Sprintf("drift-run-%d", run.ID), "view_diff", pair.SourceClusterID, pair.DestinationClusterID)
+	events.started(len(driftObjectTypes))
+
+	for i, objectType := range driftObjectTypes {
+		items, err := s.driftItems(pair.SourceClusterID, pair.DestinationClusterID, objectType)
+		if err != nil {
+			run.Status = domain.DriftRunStatusFailed
+			run.Error = err.Error()
+			events.item(i+1, len(driftObjectTypes), objectType, "failed", err.Error())
+			break
+		}
+		run.Items = append(run.Items, items...)
+		run.Counts[objectType] = len(items)
+		events.item(i+1, len(driftObjectTypes), objectType, fmt.Sprintf("%d drifted", len(items)), "")
+	}
+
+	if run.Status != domain.DriftRunStatusFailed {
+		run.Status = domain.DriftRunStatusSuccess
+	}
+	run.DriftCount = len(run.Items)
+
+	if err := s.driftRepo.FinishRun(run); err != nil {
+		log.Printf("Drift detection: failed to save run %d of pair '%s': %v", run.ID, pair.Name, err)
+	}
+
+	summary := map[string]interface{}{"pair_id": pair.ID, "run_id": run.ID, "drift_count": run.DriftCount, "counts": run.Counts}
+	if run.Status == domain.DriftRunStatusFailed {
+		events.completed(run.Status, errors.New(run.Error), summary)
+	} else {
+		events.completed(run.Status, nil, summary)
+	}
+
+	return run
+}
+
+// driftItems returns every object of one type that differs between the clusters
+func (s *DriftService) driftItems(sourceClusterID, destinationClusterID int, objectType string) ([]domain.DriftItem, error) {
+	var items []domain.DriftItem
+
+	switch objectType {
+	case "role":
+		diffs, err := s.diffService.GetRoleDiff(sourceClusterID, destinationClusterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get role diff: %w", err)
+		}
+		for _, d := range diffs {
+			items = append(items, domain.DriftItem{ObjectType: objectType, Name: d.Role.Name, Status: d.Status, Differences: d.Differences})
+		}
+	case "client":
+		diffs, err := s.diffService.GetClientDiff(sourceClusterID, destinationClusterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get client diff: %w", err)
+		}
+		for _, d := range diffs {
+			items = append(items, domain.DriftItem{ObjectType: objectType, Name: d.Client.ClientID, Status: d.Status, Differences: d.Differences})
+		}
+	case "group":
+		diffs, err := s.diffService.GetGroupDiff(sourceClusterID, destinationClusterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get group diff: %w", err)
+		}
+		for _, d := range diffs {
+			items = append(items, domain.DriftItem{ObjectType: objectType, Name: d.Group.Path, Status: d.Status, Differences: d.Differences})
+		}
+	case "user":
+		diffs, err := s.diffService.GetUserDiff(sourceClusterID, destinationClusterID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user diff: %w", err)
+		}
+		for _, d := range diffs {
+			items = append(items, domain.DriftItem{ObjectType: objectType, Name: d.User.Username, Status: d.Status, Differences: d.Differences})
+		}
+	}
+
+	return items, nil
+}
+
+func (s *DriftService) attachLastRun(pair *domain.ClusterPair) error {
+	runs, err := s.driftRepo.GetRuns(pair.ID, 1)
+	if err != nil {
+		return err
+	}
+	if len(runs) > 0 {
+		pair.LastRun = runs[0]
+	}
+	return nil
+}
+
+func (s *DriftService) validatePair(pair *domain.ClusterPair) error {
+	if pair.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if pair.SourceClusterID == pair.DestinationClusterID {
+		return fmt.Errorf("source and destination cluster must be different")
+	}
+	for _, clusterID := range []int{pair.SourceClusterID, pair.DestinationClusterID} {
+		cluster, err := s.clusterRepo.GetByID(clusterID)
+		if err != nil {
+			return fmt.Errorf("failed to get cluster: %w", err)
+		}
+		if cluster == nil {
+			return fmt.Errorf("cluster %d not found", clusterID)
+		}
+	}
+	return nil
+}
+
+func applyClusterPairRequest(pair *domain.ClusterPair, req domain.CreateClusterPairRequest) {
+	pair.Name = req.Name
+	pair.SourceClusterID = req.SourceClusterID
+	pair.DestinationClusterID = req.DestinationClusterID
+	pair.IntervalMinutes = req.IntervalMinutes
+	if pair.IntervalMinutes <= 0 {
+		pair.IntervalMinutes = 60
+	}
+	pair.Enabled = req.Enabled == nil || *req.Enabled
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// eventHistorySize is how many recent events are kept for clients that reconnect with Last-Event-ID
+	eventHistorySize = 1000
+	// eventSubscriberBuffer is how many events a subscriber may fall behind before it is disconnected
+	eventSubscriberBuffer = 256
+)
+
+var operationSeq int64
+
+// newOperationID returns an ID for an operation run that is not a job
+func newOperationID(operation string) string {
+	return fmt.Sprintf("%s-%d-%d", operation, time.Now().Unix(), atomic.AddInt64(&operationSeq, 1))
+}
+
+// EventService fans out the progress events of long operations to the clients of the event stream.
+// Events live only in memory; a nil *EventService drops everything, so publishers do not need to check for one.
+type EventService struct {
+	mu          sync.Mutex
+	nextID      int64
+	history     []domain.Event
+	subscribers map[*EventSubscription]bool
+}
+
+func NewEventService() *EventService {
+	return &EventService{subscribers: make(map[*EventSubscription]bool)}
+}
+
+// EventSubscription receives the events published after it was created
+type EventSubscription struct {
+	Events  <-chan domain.Event
+	events  chan domain.Event
+	service *EventService
+}
+
+// Publish assigns the event an ID and sends it to every subscriber.
+// A subscriber that fell too far behind is closed; its client reconnects and catches up from the history.
+func (s *EventService) Publish(event domain.Event) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	event.ID = s.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	s.history = append(s.history, event)
+	if len(s.history) > eventHistorySize {
+		s.history = s.history[len(s.history)-eventHistorySize:]
+	}
+
+	for sub := range s.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(s.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Subscribe starts receiving events. With lastEventID, the kept events after it are returned to be sent first.
+func (s *EventService) Subscribe(lastEventID int64) (*EventSubscription, []domain.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var missed []domain.Event
+	if lastEventID > 0 {
+		for _, event := range s.history {
+			if event.ID > lastEventID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	events := make(chan domain.Event, eventSubscriberBuffer)
+	sub := &EventSubscription{Events: events, events: events, service: s}
+	s.subscribers[sub] = true
+	return sub, missed
+}
+
+// Close stops the subscription
+func (sub *EventSubscription) Close() {
+	s := sub.service
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.subscribers[sub] {
+		delete(s.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// operationEvents publishes the events of one operation run
+type operationEvents struct {
+	service     *EventService
+	operation   string
+	operationID string
+	permission  string
+	clusterIDs  []int
+}
+
+func (s *EventService) operation(operation, operationID, permission string, clusterIDs ...int) *operationEvents {
+	if operationID == "" {
+		operationID = newOperationID(operation)
+	}
+	return &operationEvents{
+		service:     s,
+		operation:   operation,
+		operationID: operationID,
+		permission:  permission,
+		clusterIDs:  clusterIDs,
+	}
+}
+
+func (o *operationEvents) publish(event domain.Event) {
+	event.Operation = o.operation
+	event.OperationID = o.operationID
+	event.Permission = o.permission
+	event.ClusterIDs = o.clusterIDs
+	o.service.Publish(event)
+}
+
+func (o *operationEvents) started(total int) {
+	o.publish(domain.Event{Type: domain.EventStarted, Total: total})
+}
+
+func (o *operationEvents) progress(done, total int) {
+	o.publish(domain.Event{Type: domain.EventProgress, Done: done, Total: total})
+}
+
+// item reports the outcome of one item of the operation
+func (o *operationEvents) item(done, total int, item, status, errMsg string) {
+	o.publish(domain.Event{Type: domain.EventItem, Done: done, Total: total, Item: item, Status: status, Error: errMsg})
+}
+
+// completed reports the end of the operation; err is nil when it succeeded
+func (o *operationEvents) completed(status string, err error, data interface{}) {
+	event := domain.Event{Type: domain.EventCompleted, Status: status, Data: data}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	o.publish(event)
+}
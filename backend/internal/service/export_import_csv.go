@@ -0,0 +1,605 @@
+package service
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"keycloak-multi-manage/internal/domain"
+	"strconv"
+	"strings"
+)
+
+// csvUserFields are the user fields a CSV column can map to, besides attributes.<name>
+var csvUserFields = map[string]bool{
+	"username":        true,
+	"email":           true,
+	"firstName":       true,
+	"lastName":        true,
+	"enabled":         true,
+	"emailVerified":   true,
+	"groups":          true,
+	"realmRoles":      true,
+	"requiredActions": true,
+	"password":        true,
+}
+
+// defaultCSVExportFields are exported when no columns are requested
+var defaultCSVExportFields = []string{"username", "email", "firstName", "lastName", "enabled", "groups", "realmRoles"}
+
+// csvFormulaPrefixes start cells that spreadsheets evaluate as formulas. Exported cells starting with
+// one of them are prefixed with a single quote, which the import strips again.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvUser is one validated CSV row
+type csvUser struct {
+	row             int
+	username        string
+	profile         map[string]string // email, firstName, lastName
+	enabled         *bool
+	emailVerified   *bool
+	attributes      map[string][]string
+	groups          []string // paths
+	groupIDs        []string
+	realmRoles      []string
+	requiredActions []string
+	password        string
+}
+
+// ImportUsersCSV validates every row of a CSV file against the mapping and the realm's groups, realm roles
+// and required actions. Nothing is written unless all rows are valid; with validateOnly the
+// validation result is returned either way. Existing users are updated: mapped fields are overwritten,
+// attributes merged, and groups, roles and required actions added.
+func (s *ExportImportService) ImportUsersCSV(clusterID int, r io.Reader, mapping domain.UserCSVMapping, validateOnly bool) (*domain.UserImportResult, error) {
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if mapping.Delimiter != "" {
+		reader.Comma = []rune(mapping.Delimiter)[0]
+	}
+	separator := mapping.ListSeparator
+	if separator == "" {
+		separator = ";"
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: failed to read header: %w", err)
+	}
+	fields, err := csvColumnFields(header, mapping.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+	token := tokenResp.AccessToken
+
+	realm, err := s.loadCSVRealmData(cluster, token)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &domain.UserImportResult{ValidateOnly: validateOnly, Records: []domain.UserImportRecord{}}
+	var users []*csvUser
+	seen := make(map[string]int)
+
+	for row := 2; ; row++ {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		result.Total++
+		record := domain.UserImportRecord{Line: row}
+		var user *csvUser
+		var errs []string
+		if err != nil {
+			errs = []string{fmt.Sprintf("invalid CSV: %v", err)}
+		} else {
+			user, errs = parseCSVUser(row, values, fields, separator, mapping, realm)
+		}
+
+		if user != nil {
+			record.Username = user.username
+			if first, ok := seen[user.username]; ok {
+				errs = append(errs, fmt.Sprintf("username is already used in row %d", first))
+			} else {
+				seen[user.username] = row
+			}
+		}
+
+		if len(errs) > 0 {
+			record.Status = domain.UserImportFailed
+			record.Error = strings.Join(errs, "; ")
+			result.Failed++
+		} else {
+			record.Status = domain.UserImportValid
+			result.Valid++
+			users = append(users, user)
+		}
+		result.Records = append(result.Records, record)
+	}
+
+	if validateOnly {
+		return result, nil
+	}
+	if result.Failed > 0 {
+		result.Rejected = true
+		return result, nil
+	}
+
+	events := s.events.operation(domain.OperationUserImport, "", "sync_items", clusterID)
+	events.started(len(users))
+
+	result.Valid = 0
+	for i, user := range users {
+		record := &result.Records[user.row-2]
+		created, err := s.importCSVUser(cluster, user, mapping)
+		switch {
+		case err != nil:
+			record.Status = domain.UserImportFailed
+			record.Error = err.Error()
+			result.Failed++
+		case created:
+			record.Status = domain.UserImportCreated
+			result.Created++
+		default:
+			record.Status = domain.UserImportUpdated
+			result.Updated++
+		}
+		events.item(i+1, len(users), fmt.Sprintf("row %d: user '%s'", user.row, user.username), record.Status, record.Error)
+	}
+
+	events.completed("completed", nil, userImportSummary(result))
+	return result, nil
+}
+
+// csvRealmData holds what the rows are validated against
+type csvRealmData struct {
+	groupIDs        map[string]string // path -> id
+	realmRoles      map[string]bool
+	requiredActions map[string]bool
+}
+
+func (s *ExportImportService) loadCSVRealmData(cluster *domain.Cluster, token string) (*csvRealmData, error) {
+	data := &csvRealmData{
+		groupIDs:        make(map[string]string),
+		realmRoles:      make(map[string]bool),
+		requiredActions: make(map[string]bool),
+	}
+
+	groups, err := s.keycloakClient.GetGroups(cluster.BaseURL, cluster.Realm, token, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+	collectGroupIDs(groups, data.groupIDs)
+
+	roles, err := s.keycloakClient.GetRoles(cluster.BaseURL, cluster.Realm, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+	for _, role := range roles {
+		data.realmRoles[role.Name] = true
+	}
+
+	actions, err := s.keycloakClient.GetRequiredActions(cluster.BaseURL, cluster.Realm, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get required actions: %w", err)
+	}
+	for _, action := range actions {
+		data.requiredActions[action] = true
+	}
+
+	return data, nil
+}
+
+func collectGroupIDs(groups []map[string]interface{}, ids map[string]string) {
+	for _, group := range groups {
+		path, _ := group["path"].(string)
+		id, _ := group["id"].(string)
+		ids[path] = id
+
+		subGroups, _ := group["subGroups"].([]interface{})
+		var children []map[string]interface{}
+		for _, sg := range subGroups {
+			if child, ok := sg.(map[string]interface{}); ok {
+				children = append(children, child)
+			}
+		}
+		collectGroupIDs(children, ids)
+	}
+}
+
+// csvColumnFields returns the user field of every CSV column, or "" for columns that are not imported
+func csvColumnFields(header []string, columns []domain.UserCSVColumn) ([]string, error) {
+	fields := make([]string, len(header))
+	if len(columns) == 0 {
+		for i, name := range header {
+			fields[i] = strings.TrimSpace(name)
+			if err := validateCSVField(fields[i]); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		byHeader := make(map[string]string, len(columns))
+		for _, column := range columns {
+			if err := validateCSVField(column.Field); err != nil {
+				return nil, err
+			}
+			byHeader[column.Header] = column.Field
+		}
+		found := make(map[string]bool)
+		for i, name := range header {
+			if field, ok := byHeader[strings.TrimSpace(name)]; ok {
+				fields[i] = field
+				found[strings.TrimSpace(name)] = true
+			}
+		}
+		for _, column := range columns {
+			if !found[column.Header] {
+				return nil, fmt.Errorf("invalid mapping: column '%s' is not in the CSV header", column.Header)
+			}
+		}
+	}
+
+	for _, field := range fields {
+		if field == "username" {
+			return fields, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid mapping: no column is mapped to username")
+}
+
+func validateCSVField(field string) error {
+	if csvUserFields[field] || (strings.HasPrefix(field, "attributes.") && len(field) > len("attributes.")) {
+		return nil
+	}
+	return fmt.Errorf("invalid mapping: unknown user field '%s'", field)
+}
+
+func parseCSVUser(row int, values, fields []string, separator string, mapping domain.UserCSVMapping, realm *csvRealmData) (*csvUser, []string) {
+	var errs []string
+	if len(values) != len(fields) {
+		errs = append(errs, fmt.Sprintf("row has %d columns, the header has %d", len(values), len(fields)))
+	}
+
+	user := &csvUser{
+		row:             row,
+		profile:         make(map[string]string),
+		attributes:      make(map[string][]string),
+		requiredActions: append([]string{}, mapping.RequiredActions...),
+	}
+
+	for i, field := range fields {
+		if field == "" || i >= len(values) {
+			continue
+		}
+		value := unescapeCSVCell(strings.TrimSpace(values[i]))
+
+		switch {
+		case field == "username":
+			// Keycloak stores usernames in lower case
+			user.username = strings.ToLower(value)
+		case field == "email", field == "firstName", field == "lastName":
+			if value != "" {
+				user.profile[field] = value
+			}
+		case field == "enabled", field == "emailVerified":
+			if value == "" {
+				continue
+			}
+			parsed, err := parseCSVBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", field, err))
+				continue
+			}
+			if field == "enabled" {
+				user.enabled = &parsed
+			} else {
+				user.emailVerified = &parsed
+			}
+		case field == "groups":
+			for _, path := range splitCSVList(value, separator) {
+				if !strings.HasPrefix(path, "/") {
+					path = "/" + path
+				}
+				user.groups = append(user.groups, path)
+			}
+		case field == "realmRoles":
+			user.realmRoles = append(user.realmRoles, splitCSVList(value, separator)...)
+		case field == "requiredActions":
+			user.requiredActions = append(user.requiredActions, splitCSVList(value, separator)...)
+		case field == "password":
+			user.password = values[i]
+		default:
+			if list := splitCSVList(value, separator); len(list) > 0 {
+				name := strings.TrimPrefix(field, "attributes.")
+				user.attributes[name] = append(user.attributes[name], list...)
+			}
+		}
+	}
+
+	if user.username == "" {
+		errs = append(errs, "username is required")
+	}
+	if email := user.profile["email"]; email != "" {
+		if at := strings.Index(email, "@"); at < 1 || at != strings.LastIndex(email, "@") || at == len(email)-1 {
+			errs = append(errs, fmt.Sprintf("email '%s' is not valid", email))
+		}
+	}
+	for _, path := range user.groups {
+		if id, ok := realm.groupIDs[path]; ok {
+			user.groupIDs = append(user.groupIDs, id)
+		} else {
+			errs = append(errs, fmt.Sprintf("group '%s' does not exist", path))
+		}
+	}
+	for _, role := range user.realmRoles {
+		if !realm.realmRoles[role] {
+			errs = append(errs, fmt.Sprintf("realm role '%s' does not exist", role))
+		}
+	}
+	for _, action := range user.requiredActions {
+		if !realm.requiredActions[action] {
+			errs = append(errs, fmt.Sprintf("required action '%s' does not exist or is disabled", action))
+		}
+	}
+	return user, errs
+}
+
+// importCSVUser creates or updates one validated user. It reports whether the user was created.
+func (s *ExportImportService) importCSVUser(cluster *domain.Cluster, user *csvUser, mapping domain.UserCSVMapping) (bool, error) {
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return false, fmt.Errorf("failed to get access token: %w", err)
+	}
+	token := tokenResp.AccessToken
+	kc := s.keycloakClient
+
+	userID, err := kc.FindUserIDByUsername(cluster.BaseURL, cluster.Realm, token, user.username)
+	if err != nil {
+		return false, err
+	}
+	created := userID == ""
+
+	if created {
+		rep := map[string]interface{}{
+			"username":        user.username,
+			"enabled":         user.enabled == nil || *user.enabled,
+			"attributes":      user.attributes,
+			"requiredActions": uniqueStrings(user.requiredActions),
+		}
+		for field, value := range user.profile {
+			rep[field] = value
+		}
+		if user.emailVerified != nil {
+			rep["emailVerified"] = *user.emailVerified
+		}
+		if _, err := kc.ImportUser(cluster.BaseURL, cluster.Realm, token, rep); err != nil {
+			return false, err
+		}
+		if userID, err = kc.FindUserIDByUsername(cluster.BaseURL, cluster.Realm, token, user.username); err != nil {
+			return false, err
+		}
+		if userID == "" {
+			return false, fmt.Errorf("user %s was created but could not be found", user.username)
+		}
+	} else {
+		existing, err := kc.GetUserDetail(cluster.BaseURL, cluster.Realm, token, userID)
+		if err != nil {
+			return false, err
+		}
+		if value, ok := user.profile["email"]; ok {
+			existing.Email = value
+		}
+		if value, ok := user.profile["firstName"]; ok {
+			existing.FirstName = value
+		}
+		if value, ok := user.profile["lastName"]; ok {
+			existing.LastName = value
+		}
+		if user.enabled != nil {
+			existing.Enabled = *user.enabled
+		}
+		if existing.Attributes == nil {
+			existing.Attributes = make(map[string][]string)
+		}
+		for name, values := range user.attributes {
+			existing.Attributes[name] = values
+		}
+		existing.RequiredActions = uniqueStrings(append(existing.RequiredActions, user.requiredActions...))
+		if err := kc.UpdateUser(cluster.BaseURL, cluster.Realm, token, userID, *existing); err != nil {
+			return false, err
+		}
+	}
+
+	if user.password != "" {
+		if err := kc.ResetUserPassword(cluster.BaseURL, cluster.Realm, token, userID, user.password, !mapping.PasswordPermanent); err != nil {
+			return created, err
+		}
+	}
+	for _, groupID := range user.groupIDs {
+		if err := kc.AddUserToGroup(cluster.BaseURL, cluster.Realm, token, userID, groupID); err != nil {
+			return created, err
+		}
+	}
+	if len(user.realmRoles) > 0 {
+		if err := kc.AssignRealmRolesToUser(cluster.BaseURL, cluster.Realm, token, userID, user.realmRoles); err != nil {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
+
+// ExportUsersCSV prepares a streaming CSV export of all users with the given fields as columns.
+// Groups and realm roles are looked up per user, so they are only fetched when requested.
+func (s *ExportImportService) ExportUsersCSV(clusterID int, fields []string, separator string) (func(w io.Writer), error) {
+	if len(fields) == 0 {
+		fields = defaultCSVExportFields
+	}
+	if separator == "" {
+		separator = ";"
+	}
+	needsDetail := false
+	for _, field := range fields {
+		if field == "password" {
+			return nil, fmt.Errorf("invalid columns: passwords cannot be exported")
+		}
+		if err := validateCSVField(field); err != nil {
+			return nil, err
+		}
+		if field == "groups" || field == "realmRoles" {
+			needsDetail = true
+		}
+	}
+
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+
+	if _, err := s.keycloakClient.GetClusterToken(cluster); err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	return func(w io.Writer) {
+		events := s.events.operation(domain.OperationUserExport, "", "view_cluster_detail", clusterID)
+		events.started(0)
+		exported := 0
+
+		writer := csv.NewWriter(w)
+		writer.Write(fields)
+
+		// Pages and user details each take the cluster's current token, as large realms outlive a single one
+		token := s.keycloakClient.ClusterTokenSource(cluster)
+		err := s.keycloakClient.ForEachUser(cluster.BaseURL, cluster.Realm, token, func(users []map[string]interface{}) error {
+			for _, user := range users {
+				var detail *domain.UserDetail
+				if needsDetail {
+					accessToken, err := token()
+					if err != nil {
+						return err
+					}
+					id, _ := user["id"].(string)
+					if detail, err = s.keycloakClient.GetUserDetail(cluster.BaseURL, cluster.Realm, accessToken, id); err != nil {
+						return err
+					}
+				}
+				if err := writer.Write(csvUserRow(user, detail, fields, separator)); err != nil {
+					return err
+				}
+			}
+			writer.Flush()
+			exported += len(users)
+			events.progress(exported, 0)
+			return writer.Error()
+		})
+		if err != nil {
+			writer.Write([]string{"error: " + err.Error()})
+			events.completed("failed", err, nil)
+		} else {
+			events.completed("completed", nil, map[string]int{"users": exported})
+		}
+		writer.Flush()
+	}, nil
+}
+
+func csvUserRow(user map[string]interface{}, detail *domain.UserDetail, fields []string, separator string) []string {
+	row := make([]string, len(fields))
+	for i, field := range fields {
+		switch {
+		case field == "groups":
+			row[i] = strings.Join(detail.Groups, separator)
+		case field == "realmRoles":
+			row[i] = strings.Join(detail.RealmRoles, separator)
+		case field == "requiredActions":
+			actions, _ := user["requiredActions"].([]interface{})
+			row[i] = joinCSVValues(actions, separator)
+		case strings.HasPrefix(field, "attributes."):
+			attributes, _ := user["attributes"].(map[string]interface{})
+			values, _ := attributes[strings.TrimPrefix(field, "attributes.")].([]interface{})
+			row[i] = joinCSVValues(values, separator)
+		default:
+			switch value := user[field].(type) {
+			case string:
+				row[i] = value
+			case bool:
+				row[i] = strconv.FormatBool(value)
+			}
+		}
+		row[i] = escapeCSVCell(row[i])
+	}
+	return row
+}
+
+// escapeCSVCell keeps spreadsheets from running a cell as a formula
+func escapeCSVCell(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// unescapeCSVCell reverses escapeCSVCell, so exported files can be imported unchanged
+func unescapeCSVCell(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+func joinCSVValues(values []interface{}, separator string) string {
+	var parts []string
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, separator)
+}
+
+func splitCSVList(value, separator string) []string {
+	var list []string
+	for _, item := range strings.Split(value, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parseCSVBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "y", "1":
+		return true, nil
+	case "false", "no", "n", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("'%s' is not a boolean", value)
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
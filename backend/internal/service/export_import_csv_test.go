@@ -0,0 +1,256 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"keycloak-multi-manage/internal/domain"
+)
+
+func testCSVRealm() *csvRealmData {
+	return &csvRealmData{
+		groupIDs:        map[string]string{"/staff": "g1", "/staff/it": "g2"},
+		realmRoles:      map[string]bool{"user": true, "auditor": true},
+		requiredActions: map[string]bool{"VERIFY_EMAIL": true, "UPDATE_PASSWORD": true},
+	}
+}
+
+func TestCSVColumnFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		columns []domain.UserCSVColumn
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "header names",
+			header: []string{"username", " email ", "attributes.department"},
+			want:   []string{"username", "email", "attributes.department"},
+		},
+		{
+			name:    "unknown header field",
+			header:  []string{"username", "phone"},
+			wantErr: "unknown user field 'phone'",
+		},
+		{
+			name:    "mapping",
+			header:  []string{"Login", "Mail", "Notes"},
+			columns: []domain.UserCSVColumn{{Header: "Login", Field: "username"}, {Header: "Mail", Field: "email"}},
+			want:    []string{"username", "email", ""},
+		},
+		{
+			name:    "mapped column missing",
+			header:  []string{"Login"},
+			columns: []domain.UserCSVColumn{{Header: "Login", Field: "username"}, {Header: "Mail", Field: "email"}},
+			wantErr: "column 'Mail' is not in the CSV header",
+		},
+		{
+			name:    "mapping to unknown field",
+			header:  []string{"Login"},
+			columns: []domain.UserCSVColumn{{Header: "Login", Field: "login"}},
+			wantErr: "unknown user field 'login'",
+		},
+		{
+			name:    "no username",
+			header:  []string{"email"},
+			wantErr: "no column is mapped to username",
+		},
+		{
+			name:    "empty attribute name",
+			header:  []string{"username", "attributes."},
+			wantErr: "unknown user field 'attributes.'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := csvColumnFields(tt.header, tt.columns)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fields, tt.want) {
+				t.Errorf("fields = %q, want %q", fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCSVUser(t *testing.T) {
+	fields := []string{"username", "email", "enabled", "groups", "realmRoles", "requiredActions", "attributes.department", "password"}
+
+	tests := []struct {
+		name     string
+		values   []string
+		mapping  domain.UserCSVMapping
+		wantErrs []string
+		check    func(t *testing.T, user *csvUser)
+	}{
+		{
+			name:   "valid row",
+			values: []string{"Alice", "alice@example.com", "yes", "staff;/staff/it", "user; auditor", "VERIFY_EMAIL", "IT;Ops", " secret "},
+			check: func(t *testing.T, user *csvUser) {
+				if user.username != "alice" {
+					t.Errorf("username = %q, want it in lower case", user.username)
+				}
+				if user.profile["email"] != "alice@example.com" {
+					t.Errorf("email = %q", user.profile["email"])
+				}
+				if user.enabled == nil || !*user.enabled {
+					t.Error("enabled was not parsed")
+				}
+				if !reflect.DeepEqual(user.groupIDs, []string{"g1", "g2"}) {
+					t.Errorf("groupIDs = %v", user.groupIDs)
+				}
+				if !reflect.DeepEqual(user.realmRoles, []string{"user", "auditor"}) {
+					t.Errorf("realmRoles = %v", user.realmRoles)
+				}
+				if !reflect.DeepEqual(user.attributes["department"], []string{"IT", "Ops"}) {
+					t.Errorf("attributes = %v", user.attributes)
+				}
+				if user.password != " secret " {
+					t.Errorf("password = %q, want it untrimmed", user.password)
+				}
+			},
+		},
+		{
+			name:   "empty optional cells",
+			values: []string{"bob", "", "", "", "", "", "", ""},
+			check: func(t *testing.T, user *csvUser) {
+				if user.enabled != nil || len(user.groups) > 0 || len(user.attributes) > 0 || user.password != "" {
+					t.Errorf("empty cells were imported: %+v", user)
+				}
+			},
+		},
+		{
+			name:    "required actions from the mapping",
+			values:  []string{"carol", "", "", "", "", "VERIFY_EMAIL", "", ""},
+			mapping: domain.UserCSVMapping{RequiredActions: []string{"UPDATE_PASSWORD"}},
+			check: func(t *testing.T, user *csvUser) {
+				if !reflect.DeepEqual(user.requiredActions, []string{"UPDATE_PASSWORD", "VERIFY_EMAIL"}) {
+					t.Errorf("requiredActions = %v", user.requiredActions)
+				}
+			},
+		},
+		{
+			name:   "escaped formula cells",
+			values: []string{"dave", "", "", "", "", "", "'-ops;'=x", ""},
+			check: func(t *testing.T, user *csvUser) {
+				if !reflect.DeepEqual(user.attributes["department"], []string{"-ops", "'=x"}) {
+					t.Errorf("attributes = %v, want the export's quote removed from the cell", user.attributes)
+				}
+			},
+		},
+		{
+			name:     "missing username",
+			values:   []string{"", "x@example.com", "", "", "", "", "", ""},
+			wantErrs: []string{"username is required"},
+		},
+		{
+			name:     "invalid values",
+			values:   []string{"erin", "erin.example.com", "maybe", "/missing", "admin", "CONFIGURE_TOTP", "", ""},
+			wantErrs: []string{"enabled: 'maybe' is not a boolean", "email 'erin.example.com' is not valid", "group '/missing' does not exist", "realm role 'admin' does not exist", "required action 'CONFIGURE_TOTP' does not exist or is disabled"},
+		},
+		{
+			name:     "email with two @",
+			values:   []string{"frank", "a@b@c", "", "", "", "", "", ""},
+			wantErrs: []string{"email 'a@b@c' is not valid"},
+		},
+		{
+			name:     "short row",
+			values:   []string{"grace", "grace@example.com"},
+			wantErrs: []string{"row has 2 columns, the header has 8"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, errs := parseCSVUser(2, tt.values, fields, ";", tt.mapping, testCSVRealm())
+			if !reflect.DeepEqual(errs, tt.wantErrs) {
+				t.Fatalf("errs = %q, want %q", errs, tt.wantErrs)
+			}
+			if tt.check != nil {
+				tt.check(t, user)
+			}
+		})
+	}
+}
+
+func TestParseCSVBool(t *testing.T) {
+	for _, value := range []string{"true", "TRUE", "yes", "y", "1"} {
+		if parsed, err := parseCSVBool(value); err != nil || !parsed {
+			t.Errorf("parseCSVBool(%q) = %v, %v, want true", value, parsed, err)
+		}
+	}
+	for _, value := range []string{"false", "No", "n", "0"} {
+		if parsed, err := parseCSVBool(value); err != nil || parsed {
+			t.Errorf("parseCSVBool(%q) = %v, %v, want false", value, parsed, err)
+		}
+	}
+	if _, err := parseCSVBool("on"); err == nil {
+		t.Error("parseCSVBool accepted \"on\"")
+	}
+}
+
+func TestSplitCSVList(t *testing.T) {
+	got := splitCSVList(" a ; ;b;", ";")
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("splitCSVList = %q", got)
+	}
+	if got := splitCSVList("", ";"); got != nil {
+		t.Errorf("splitCSVList of an empty cell = %q, want nil", got)
+	}
+}
+
+func TestCSVUserRow(t *testing.T) {
+	user := map[string]interface{}{
+		"username":        "alice",
+		"email":           "=HYPERLINK(\"http://evil\")",
+		"enabled":         true,
+		"firstName":       "@mention",
+		"lastName":        "-Smith",
+		"requiredActions": []interface{}{"VERIFY_EMAIL"},
+		"attributes":      map[string]interface{}{"department": []interface{}{"+IT", "Ops"}},
+	}
+	detail := &domain.UserDetail{Groups: []string{"/staff", "/staff/it"}, RealmRoles: []string{"user"}}
+	fields := []string{"username", "email", "enabled", "firstName", "lastName", "requiredActions", "attributes.department", "attributes.missing", "groups", "realmRoles"}
+
+	got := csvUserRow(user, detail, fields, "|")
+	want := []string{"alice", "'=HYPERLINK(\"http://evil\")", "true", "'@mention", "'-Smith", "VERIFY_EMAIL", "'+IT|Ops", "", "/staff|/staff/it", "user"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("row = %q\nwant  %q", got, want)
+	}
+}
+
+func TestCSVCellEscaping(t *testing.T) {
+	tests := []struct {
+		value   string
+		escaped string
+	}{
+		{"plain", "plain"},
+		{"", ""},
+		{"=1+1", "'=1+1"},
+		{"+49 30 1234", "'+49 30 1234"},
+		{"-5", "'-5"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"a=b", "a=b"},
+		{"'quoted", "'quoted"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeCSVCell(tt.value); got != tt.escaped {
+			t.Errorf("escapeCSVCell(%q) = %q, want %q", tt.value, got, tt.escaped)
+		}
+		if got := unescapeCSVCell(tt.escaped); got != tt.value {
+			t.Errorf("unescapeCSVCell(%q) = %q, want %q", tt.escaped, got, tt.value)
+		}
+	}
+}
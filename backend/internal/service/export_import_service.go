@@ -1,15 +1,23 @@
 package service
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"keycloak-multi-manage/internal/client/keycloak"
+	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/repository/postgres"
+	"log"
 )
 
 type ExportImportService struct {
 	clusterRepo    *postgres.ClusterRepository
 	keycloakClient *keycloak.Client
+	rewriteService *RewriteRuleService
+	events         *EventService
 }
 
 func NewExportImportService(clusterRepo *postgres.ClusterRepository) *ExportImportService {
@@ -19,6 +27,16 @@ func NewExportImportService(clusterRepo *postgres.ClusterRepository) *ExportImpo
 	}
 }
 
+// SetRewriteService enables the environment specific rewrite rules for imports
+func (s *ExportImportService) SetRewriteService(rewriteService *RewriteRuleService) {
+	s.rewriteService = rewriteService
+}
+
+// SetEventService publishes the progress of imports and exports to the event stream
+func (s *ExportImportService) SetEventService(events *EventService) {
+	s.events = events
+}
+
 // ExportRealm exports realm configuration as JSON
 func (s *ExportImportService) ExportRealm(clusterID int) ([]byte, error) {
 	cluster, err := s.clusterRepo.GetByID(clusterID)
@@ -29,12 +47,7 @@ func (s *ExportImportService) ExportRealm(clusterID int) ([]byte, error) {
 		return nil, fmt.Errorf("cluster not found")
 	}
 
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -53,41 +66,78 @@ func (s *ExportImportService) ExportRealm(clusterID int) ([]byte, error) {
 	return jsonData, nil
 }
 
-// ImportRealm imports realm configuration from JSON
-func (s *ExportImportService) ImportRealm(clusterID int, realmConfigJSON []byte) error {
+// ImportRealm imports realm configuration from JSON.
+// sourceClusterID names the cluster the export came from (0 if unknown) and selects the rewrite rules.
+func (s *ExportImportService) ImportRealm(clusterID, sourceClusterID int, realmConfigJSON []byte) ([]domain.SyncFieldChange, error) {
+	events := s.events.operation(domain.OperationRealmImport, "", "sync_items", clusterID)
+	events.started(1)
+
+	rewrites, err := s.importRealm(clusterID, sourceClusterID, realmConfigJSON)
+	if err != nil {
+		events.completed("failed", err, nil)
+		return nil, err
+	}
+
+	events.completed("completed", nil, nil)
+	return rewrites, nil
+}
+
+func (s *ExportImportService) importRealm(clusterID, sourceClusterID int, realmConfigJSON []byte) ([]domain.SyncFieldChange, error) {
 	cluster, err := s.clusterRepo.GetByID(clusterID)
 	if err != nil {
-		return fmt.Errorf("failed to get cluster: %w", err)
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
 	}
 	if cluster == nil {
-		return fmt.Errorf("cluster not found")
+		return nil, fmt.Errorf("cluster not found")
 	}
 
 	var realmConfig map[string]interface{}
 	if err := json.Unmarshal(realmConfigJSON, &realmConfig); err != nil {
-		return fmt.Errorf("failed to unmarshal realm config: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal realm config: %w", err)
+	}
+
+	rewriter, err := s.rewriterFor(sourceClusterID, clusterID)
+	if err != nil {
+		return nil, err
 	}
+	rewrites := rewriteRealmConfig(rewriter, realmConfig)
 
 	// Use master realm for admin operations - we need master realm admin credentials for import
 	// This is a limitation: import requires master realm admin, not service account
 	// For now, we'll use the service account from the target realm
 	// TODO: This might need master realm admin credentials passed separately
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
-		return fmt.Errorf("failed to get access token: %w", err)
+		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
 	token := tokenResp.AccessToken
 
 	if err := s.keycloakClient.ImportRealm(cluster.BaseURL, token, realmConfig); err != nil {
-		return fmt.Errorf("failed to import realm: %w", err)
+		return nil, fmt.Errorf("failed to import realm: %w", err)
+	}
+
+	return rewrites, nil
+}
+
+// ImportRealmJob imports a realm configuration in a job, so large realms are not cut off by request timeouts
+func (s *ExportImportService) ImportRealmJob(job *JobContext) (interface{}, error) {
+	var payload domain.RealmImportJobPayload
+	if err := job.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	job.SetTotal(1)
+	job.Logf(domain.JobLogInfo, "Importing realm into cluster %d (%d bytes)", payload.ClusterID, len(payload.RealmConfig))
+	rewrites, err := s.importRealm(payload.ClusterID, payload.SourceClusterID, payload.RealmConfig)
+	if err != nil {
+		return nil, err
+	}
+	job.Advance(1)
+	if len(rewrites) > 0 {
+		job.Logf(domain.JobLogInfo, "Applied %d rewrites", len(rewrites))
 	}
 
-	return nil
+	return map[string]interface{}{"rewrites": rewrites}, nil
 }
 
 // ExportUsers exports all users as JSON
@@ -100,12 +150,7 @@ func (s *ExportImportService) ExportUsers(clusterID int) ([]byte, error) {
 		return nil, fmt.Errorf("cluster not found")
 	}
 
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -124,37 +169,258 @@ func (s *ExportImportService) ExportUsers(clusterID int) ([]byte, error) {
 	return jsonData, nil
 }
 
-// ImportUsers imports users from JSON
-func (s *ExportImportService) ImportUsers(clusterID int, usersJSON []byte) error {
+// ImportUsers imports users from JSON after applying the rewrite rules of the source/destination pair
+func (s *ExportImportService) ImportUsers(clusterID, sourceClusterID int, usersJSON []byte) ([]domain.SyncFieldChange, error) {
 	cluster, err := s.clusterRepo.GetByID(clusterID)
 	if err != nil {
-		return fmt.Errorf("failed to get cluster: %w", err)
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
 	}
 	if cluster == nil {
-		return fmt.Errorf("cluster not found")
+		return nil, fmt.Errorf("cluster not found")
 	}
 
 	var users []map[string]interface{}
 	if err := json.Unmarshal(usersJSON, &users); err != nil {
-		return fmt.Errorf("failed to unmarshal users: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal users: %w", err)
+	}
+
+	rewriter, err := s.rewriterFor(sourceClusterID, clusterID)
+	if err != nil {
+		return nil, err
 	}
+	rewrites := rewriteRepresentations(rewriter, "user", users)
 
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
-		return fmt.Errorf("failed to get access token: %w", err)
+		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
 	token := tokenResp.AccessToken
 
 	if err := s.keycloakClient.ImportUsers(cluster.BaseURL, cluster.Realm, token, users); err != nil {
-		return fmt.Errorf("failed to import users: %w", err)
+		return nil, fmt.Errorf("failed to import users: %w", err)
+	}
+
+	return rewrites, nil
+}
+
+// ExportUsersNDJSON prepares a streaming export of all users with one JSON object per line.
+// Cluster and token errors are returned before anything is written; the returned function then fetches
+// and writes the users page by page, each with the cluster's current token. If fetching fails midway,
+// the last line is an {"error": ...} object.
+func (s *ExportImportService) ExportUsersNDJSON(clusterID int) (func(w io.Writer), error) {
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+
+	if _, err := s.keycloakClient.GetClusterToken(cluster); err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	return func(w io.Writer) {
+		events := s.events.operation(domain.OperationUserExport, "", "view_cluster_detail", clusterID)
+		events.started(0)
+		exported := 0
+		err := s.writeUsersNDJSON(cluster, w, func(n int) error {
+			exported += n
+			events.progress(exported, 0)
+			return nil
+		})
+		if err != nil {
+			log.Printf("NDJSON user export of cluster %s failed: %v", cluster.Name, err)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			events.completed("failed", err, nil)
+			return
+		}
+		events.completed("completed", nil, map[string]int{"users": exported})
+	}, nil
+}
+
+// writeUsersNDJSON writes every user of the cluster's realm as one JSON line, a page at a time.
+// onPage, if set, is called with the number of users of each written page and stops the export by returning an error.
+func (s *ExportImportService) writeUsersNDJSON(cluster *domain.Cluster, w io.Writer, onPage func(n int) error) error {
+	encoder := json.NewEncoder(w)
+	return s.keycloakClient.ForEachUser(cluster.BaseURL, cluster.Realm, s.keycloakClient.ClusterTokenSource(cluster), func(users []map[string]interface{}) error {
+		for _, user := range users {
+			if err := encoder.Encode(user); err != nil {
+				return err
+			}
+		}
+		if f, ok := w.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+		if onPage != nil {
+			return onPage(len(users))
+		}
+		return nil
+	})
+}
+
+// ExportUsersJob exports all users as gzipped NDJSON in a job; the file is the job output.
+// Every page is fetched with the cluster's current token, so the export can run longer than a token's lifetime.
+func (s *ExportImportService) ExportUsersJob(job *JobContext) (interface{}, error) {
+	var payload domain.UserExportJobPayload
+	if err := job.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	cluster, err := s.clusterRepo.GetByID(payload.ClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	// The count is only used for progress; the export itself pages until the realm has no more users
+	if total, err := s.keycloakClient.GetUsersCount(cluster.BaseURL, cluster.Realm, tokenResp.AccessToken); err == nil {
+		job.SetTotal(total)
+	}
+	job.Logf(domain.JobLogInfo, "Exporting users of cluster %s", cluster.Name)
+
+	// The file is stored in chunks while it is written, so large realms are never held in memory
+	output, err := job.OutputWriter("application/gzip")
+	if err != nil {
+		return nil, err
+	}
+	zw := gzip.NewWriter(output)
+	exported := 0
+	err = s.writeUsersNDJSON(cluster, zw, func(n int) error {
+		exported += n
+		job.Advance(n)
+		if job.Cancelled() {
+			return ErrJobCancelled
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress export: %w", err)
+	}
+	if err := output.Close(); err != nil {
+		return nil, err
+	}
+	job.Logf(domain.JobLogInfo, "Exported %d users (%d bytes compressed)", exported, output.Size())
+
+	return map[string]interface{}{"cluster_id": cluster.ID, "users": exported}, nil
+}
+
+// ImportUsersNDJSON imports users from an NDJSON stream one line at a time, so uploads of any size
+// are never held in memory. Existing users are looked up by username and updated.
+// A failing record does not stop the import; every record's outcome is in the result.
+func (s *ExportImportService) ImportUsersNDJSON(clusterID, sourceClusterID int, r io.Reader) (*domain.UserImportResult, error) {
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+
+	rewriter, err := s.rewriterFor(sourceClusterID, clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &domain.UserImportResult{Records: []domain.UserImportRecord{}}
+	reader := bufio.NewReader(r)
+
+	// The total is unknown until the stream ends
+	events := s.events.operation(domain.OperationUserImport, "", "sync_items", clusterID)
+	events.started(0)
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			err := fmt.Errorf("failed to read line %d: %w", lineNumber, readErr)
+			events.completed("failed", err, nil)
+			return nil, err
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			record := s.importUserLine(cluster, rewriter, line, result)
+			record.Line = lineNumber
+			result.Total++
+			switch record.Status {
+			case domain.UserImportCreated:
+				result.Created++
+			case domain.UserImportUpdated:
+				result.Updated++
+			default:
+				result.Failed++
+			}
+			result.Records = append(result.Records, record)
+			events.item(result.Total, 0, fmt.Sprintf("line %d: user '%s'", lineNumber, record.Username), record.Status, record.Error)
+		}
+
+		if readErr == io.EOF {
+			events.completed("completed", nil, userImportSummary(result))
+			return result, nil
+		}
+	}
+}
+
+// userImportSummary is the data of the completion event of a user import
+func userImportSummary(result *domain.UserImportResult) map[string]interface{} {
+	return map[string]interface{}{
+		"total":    result.Total,
+		"created":  result.Created,
+		"updated":  result.Updated,
+		"failed":   result.Failed,
+		"rejected": result.Rejected,
 	}
+}
+
+func (s *ExportImportService) importUserLine(cluster *domain.Cluster, rewriter *Rewriter, line []byte, result *domain.UserImportResult) domain.UserImportRecord {
+	var user map[string]interface{}
+	if err := json.Unmarshal(line, &user); err != nil {
+		return domain.UserImportRecord{Status: domain.UserImportFailed, Error: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+
+	username, _ := user["username"].(string)
+	record := domain.UserImportRecord{Username: username}
+	if username == "" {
+		record.Status = domain.UserImportFailed
+		record.Error = "username is required"
+		if message, ok := user["error"].(string); ok {
+			record.Error = "export ended with an error: " + message
+		}
+		return record
+	}
+
+	result.Rewrites = append(result.Rewrites, rewriter.Apply("user", username, user)...)
 
-	return nil
+	// The cached token stays valid over imports that run longer than a token's lifetime
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		record.Status = domain.UserImportFailed
+		record.Error = fmt.Sprintf("failed to get access token: %v", err)
+		return record
+	}
+
+	created, err := s.keycloakClient.ImportUser(cluster.BaseURL, cluster.Realm, tokenResp.AccessToken, user)
+	switch {
+	case err != nil:
+		record.Status = domain.UserImportFailed
+		record.Error = err.Error()
+	case created:
+		record.Status = domain.UserImportCreated
+	default:
+		record.Status = domain.UserImportUpdated
+	}
+	return record
 }
 
 // ExportClients exports all clients as JSON
@@ -167,12 +433,7 @@ func (s *ExportImportService) ExportClients(clusterID int) ([]byte, error) {
 		return nil, fmt.Errorf("cluster not found")
 	}
 
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -191,36 +452,90 @@ func (s *ExportImportService) ExportClients(clusterID int) ([]byte, error) {
 	return jsonData, nil
 }
 
-// ImportClients imports clients from JSON
-func (s *ExportImportService) ImportClients(clusterID int, clientsJSON []byte) error {
+// ImportClients imports clients from JSON after applying the rewrite rules of the source/destination pair
+func (s *ExportImportService) ImportClients(clusterID, sourceClusterID int, clientsJSON []byte) ([]domain.SyncFieldChange, error) {
 	cluster, err := s.clusterRepo.GetByID(clusterID)
 	if err != nil {
-		return fmt.Errorf("failed to get cluster: %w", err)
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
 	}
 	if cluster == nil {
-		return fmt.Errorf("cluster not found")
+		return nil, fmt.Errorf("cluster not found")
 	}
 
 	var clients []map[string]interface{}
 	if err := json.Unmarshal(clientsJSON, &clients); err != nil {
-		return fmt.Errorf("failed to unmarshal clients: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal clients: %w", err)
 	}
 
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	rewriter, err := s.rewriterFor(sourceClusterID, clusterID)
 	if err != nil {
-		return fmt.Errorf("failed to get access token: %w", err)
+		return nil, err
+	}
+	rewrites := rewriteRepresentations(rewriter, "client", clients)
+
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
 	token := tokenResp.AccessToken
 
 	if err := s.keycloakClient.ImportClients(cluster.BaseURL, cluster.Realm, token, clients); err != nil {
-		return fmt.Errorf("failed to import clients: %w", err)
+		return nil, fmt.Errorf("failed to import clients: %w", err)
+	}
+
+	return rewrites, nil
+}
+
+
+func (s *ExportImportService) rewriterFor(sourceClusterID, clusterID int) (*Rewriter, error) {
+	if s.rewriteService == nil {
+		return nil, nil
+	}
+	return s.rewriteService.RewriterFor(sourceClusterID, clusterID)
+}
+
+// rewriteRepresentations applies rewrite rules to every client or user representation of an import
+func rewriteRepresentations(rewriter *Rewriter, objectType string, representations []map[string]interface{}) []domain.SyncFieldChange {
+	var rewrites []domain.SyncFieldChange
+	for _, rep := range representations {
+		name, _ := rep[representationNameField(objectType)].(string)
+		rewrites = append(rewrites, rewriter.Apply(objectType, name, rep)...)
 	}
+	return rewrites
+}
 
-	return nil
+// rewriteRealmConfig applies rewrite rules to the clients, users and (nested) groups of a realm export
+func rewriteRealmConfig(rewriter *Rewriter, realmConfig map[string]interface{}) []domain.SyncFieldChange {
+	var rewrites []domain.SyncFieldChange
+	for _, objectType := range []string{"client", "user"} {
+		items, _ := realmConfig[objectType+"s"].([]interface{})
+		for _, item := range items {
+			if rep, ok := item.(map[string]interface{}); ok {
+				rewrites = append(rewrites, rewriteRepresentations(rewriter, objectType, []map[string]interface{}{rep})...)
+			}
+		}
+	}
+
+	groups, _ := realmConfig["groups"].([]interface{})
+	return append(rewrites, rewriteGroupTree(rewriter, groups, "")...)
 }
 
+func rewriteGroupTree(rewriter *Rewriter, groups []interface{}, parentPath string) []domain.SyncFieldChange {
+	var rewrites []domain.SyncFieldChange
+	for _, item := range groups {
+		group, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := group["name"].(string)
+		path, _ := group["path"].(string)
+		if path == "" {
+			path = parentPath + "/" + name
+		}
+		rewrites = append(rewrites, rewriter.Apply("group", path, group)...)
+
+		subGroups, _ := group["subGroups"].([]interface{})
+		rewrites = append(rewrites, rewriteGroupTree(rewriter, subGroups, path)...)
+	}
+	return rewrites
+}
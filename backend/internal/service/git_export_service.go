@@ -0,0 +1,304 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"keycloak-multi-manage/internal/client/keycloak"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/repository/postgres"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maskedSecret replaces secret values in exported files, the same placeholder Keycloak uses
+const maskedSecret = "**********"
+
+// secretKeys are the representation fields whose values are never written to the repository
+var secretKeys = map[string]bool{
+	"secret":         true,
+	"clientsecret":   true,
+	"password":       true,
+	"bindcredential": true,
+	"privatekey":     true,
+	"credentials":    true,
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// GitExportService writes the configuration of every cluster into a local git repository and commits changes,
+// so the repository history is an audit trail of realm changes
+type GitExportService struct {
+	clusterRepo    *postgres.ClusterRepository
+	keycloakClient *keycloak.Client
+	repoPath       string
+	mu             sync.Mutex
+}
+
+func NewGitExportService(clusterRepo *postgres.ClusterRepository, repoPath string) *GitExportService {
+	return &GitExportService{
+		clusterRepo:    clusterRepo,
+		keycloakClient: keycloak.NewClient(),
+		repoPath:       repoPath,
+	}
+}
+
+// StartScheduler exports every cluster on the given interval
+func (s *GitExportService) StartScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := s.Export(); err != nil {
+				log.Printf("Git export: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Export writes each cluster's realm, clients, roles, groups and user federation providers into
+// one directory per cluster and commits when anything changed.
+// Directories of clusters that were removed are deleted.
+func (s *GitExportService) Export() (*domain.GitExportResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.initRepository(); err != nil {
+		return nil, err
+	}
+
+	clusters, err := s.clusterRepo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clusters: %w", err)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].ID < clusters[j].ID })
+
+	result := &domain.GitExportResult{StartedAt: time.Now(), Clusters: []domain.GitExportClusterResult{}}
+	directories := make(map[string]bool)
+
+	for _, cluster := range clusters {
+		// Cluster names are unique, but two names can map to the same directory
+		dir := clusterDirectory(cluster.Name)
+		if directories[dir] {
+			dir = fmt.Sprintf("%s-%d", dir, cluster.ID)
+		}
+		directories[dir] = true
+
+		clusterResult := domain.GitExportClusterResult{ClusterID: cluster.ID, Name: cluster.Name, Directory: dir}
+		if err := s.exportCluster(cluster, filepath.Join(s.repoPath, dir)); err != nil {
+			log.Printf("Git export: failed to export cluster %s: %v", cluster.Name, err)
+			clusterResult.Error = err.Error()
+		}
+		result.Clusters = append(result.Clusters, clusterResult)
+	}
+
+	if err := s.removeStaleDirectories(directories); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.git("add", "-A"); err != nil {
+		return nil, err
+	}
+	status, err := s.git("status", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(status) == "" {
+		return result, nil
+	}
+
+	changedDirs := make(map[string]bool)
+	for _, line := range strings.Split(status, "\n") {
+		if len(line) > 3 {
+			path := strings.Trim(line[3:], "\"")
+			changedDirs[strings.SplitN(path, "/", 2)[0]] = true
+		}
+	}
+
+	var changed []string
+	for i := range result.Clusters {
+		if changedDirs[result.Clusters[i].Directory] {
+			result.Clusters[i].Changed = true
+			changed = append(changed, result.Clusters[i].Name)
+		}
+	}
+
+	message := "Update realm configuration"
+	if len(changed) > 0 {
+		message = fmt.Sprintf("Update realm configuration of %s", strings.Join(changed, ", "))
+	}
+	if _, err := s.git("-c", "user.name=Keycloak Multi-Manage", "-c", "user.email=multi-manage@localhost",
+		"commit", "-q", "-m", message); err != nil {
+		return nil, err
+	}
+
+	result.Committed = true
+	commit, err := s.git("rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	result.Commit = strings.TrimSpace(commit)
+
+	return result, nil
+}
+
+func (s *GitExportService) exportCluster(cluster *domain.Cluster, dir string) error {
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+	token := tokenResp.AccessToken
+
+	// Everything is fetched before anything is written, so a failing cluster keeps its previous export
+	files := make(map[string]interface{})
+	if files["realm.json"], err = s.keycloakClient.ExportRealm(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return fmt.Errorf("failed to export realm: %w", err)
+	}
+	if files["clients.json"], err = s.keycloakClient.ExportClients(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return fmt.Errorf("failed to export clients: %w", err)
+	}
+	if files["roles.json"], err = s.keycloakClient.GetRoleDetails(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return fmt.Errorf("failed to get roles: %w", err)
+	}
+	if files["groups.json"], err = s.keycloakClient.GetGroupDetails(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return fmt.Errorf("failed to get groups: %w", err)
+	}
+	if files["federation.json"], err = s.keycloakClient.GetUserFederationProviders(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return fmt.Errorf("failed to get user federation providers: %w", err)
+	}
+
+	contents := make(map[string][]byte, len(files))
+	for name, value := range files {
+		if contents[name], err = normalizedJSON(value); err != nil {
+			return fmt.Errorf("failed to normalize %s: %w", name, err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	for name, data := range contents {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *GitExportService) initRepository() error {
+	if err := os.MkdirAll(s.repoPath, 0755); err != nil {
+		return fmt.Errorf("failed to create export repository: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.repoPath, ".git")); err == nil {
+		return nil
+	}
+	if _, err := s.git("init", "-q"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// removeStaleDirectories deletes the directories of clusters that are no longer registered
+func (s *GitExportService) removeStaleDirectories(directories map[string]bool) error {
+	entries, err := os.ReadDir(s.repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to read export repository: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || directories[entry.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.repoPath, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (s *GitExportService) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", s.repoPath}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func clusterDirectory(name string) string {
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if slug == "" {
+		return "cluster"
+	}
+	return slug
+}
+
+// normalizedJSON renders a value with sorted keys and lists and masked secrets,
+// so the file only changes when the configuration does
+func normalizedJSON(value interface{}) ([]byte, error) {
+	var generic interface{}
+	if err := decodeExportValue(value, &generic); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(normalizeExportValue(generic), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func normalizeExportValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSecretKey(key) && item != nil && item != "" {
+				v[key] = maskedSecret
+				continue
+			}
+			v[key] = normalizeExportValue(item)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeExportValue(v[i])
+		}
+		// Keycloak returns most lists (redirect URIs, scopes, roles, groups) in no particular order
+		sort.SliceStable(v, func(i, j int) bool { return exportSortKey(v[i]) < exportSortKey(v[j]) })
+		return v
+	default:
+		return v
+	}
+}
+
+func isSecretKey(key string) bool {
+	lower := strings.ToLower(key)
+	return secretKeys[lower] || strings.HasSuffix(lower, "secret")
+}
+
+// exportSortKey orders list items: strings by value and objects by their identifying field
+func exportSortKey(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		for _, key := range []string{"path", "clientId", "name", "alias", "id"} {
+			if s, ok := v[key].(string); ok {
+				return s
+			}
+		}
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
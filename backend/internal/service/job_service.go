@@ -0,0 +1,591 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/repository/postgres"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// jobHeartbeatInterval is how often a running job stores its progress and checks for cancellation
+	jobHeartbeatInterval = 2 * time.Second
+	// jobStaleAfter is how long a running job may go without a heartbeat before another worker takes it over
+	jobStaleAfter = time.Minute
+	// jobPollInterval is how often idle workers look for due jobs (new jobs of this server wake them right away)
+	jobPollInterval = 5 * time.Second
+	// jobRetryDelay is the delay before the first retry; it doubles with every further attempt up to jobMaxRetryDelay
+	jobRetryDelay    = 30 * time.Second
+	jobMaxRetryDelay = 15 * time.Minute
+	// jobOutputChunkSize is the size of the chunks job outputs are stored in
+	jobOutputChunkSize = 1 << 20
+)
+
+// ErrJobCancelled is returned by a job handler that stopped because cancelling the job was requested
+var ErrJobCancelled = errors.New("job cancelled")
+
+// errJobTakenOver is returned when a worker lost a job, e.g. because its heartbeats were late and
+// the job was recovered and claimed by another worker. The worker stops and stores nothing.
+var errJobTakenOver = errors.New("job was taken over by another worker")
+
+// JobHandler runs one attempt of a job. The returned value is stored as the job result.
+type JobHandler func(job *JobContext) (interface{}, error)
+
+type registeredJob struct {
+	handler     JobHandler
+	maxAttempts int
+}
+
+// JobService runs long operations in the background. Jobs are stored in Postgres, so their status
+// survives restarts and any server instance can pick them up.
+type JobService struct {
+	jobRepo  *postgres.JobRepository
+	events   *EventService
+	audit    *AuditService
+	types    map[string]registeredJob
+	workerID string // prefix of the IDs of this server's workers
+	wake     chan struct{}
+}
+
+func NewJobService(jobRepo *postgres.JobRepository) *JobService {
+	hostname, _ := os.Hostname()
+	return &JobService{
+		jobRepo:  jobRepo,
+		types:    make(map[string]registeredJob),
+		workerID: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// SetEventService publishes the start, progress and completion of jobs to the event stream
+func (s *JobService) SetEventService(events *EventService) {
+	s.events = events
+}
+
+// SetAuditService records the outcome of every finished job in the audit log
+func (s *JobService) SetAuditService(audit *AuditService) {
+	s.audit = audit
+}
+
+// Register sets the handler of a job type. A failed attempt is retried until maxAttempts is reached.
+func (s *JobService) Register(jobType string, maxAttempts int, handler JobHandler) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	s.types[jobType] = registeredJob{handler: handler, maxAttempts: maxAttempts}
+}
+
+// Start runs the given number of workers and requeues the jobs of workers that stopped
+func (s *JobService) Start(workers int) {
+	go func() {
+		ticker := time.NewTicker(jobStaleAfter / 2)
+		defer ticker.Stop()
+
+		for {
+			if n, err := s.jobRepo.RecoverStale(time.Now().Add(-jobStaleAfter)); err != nil {
+				log.Printf("Jobs: failed to recover stale jobs: %v", err)
+			} else if n > 0 {
+				log.Printf("Jobs: recovered %d jobs of stopped workers", n)
+			}
+			<-ticker.C
+		}
+	}()
+
+	// Every worker locks jobs with its own ID, so a job recovered from a worker is never
+	// mistaken for its own by the same worker or another one of this server
+	for i := 1; i <= workers; i++ {
+		go s.work(fmt.Sprintf("%s-%d", s.workerID, i))
+	}
+}
+
+func (s *JobService) work(workerID string) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := s.jobRepo.Claim(workerID)
+		if err != nil {
+			log.Printf("Jobs: failed to claim a job: %v", err)
+		}
+		if job != nil {
+			s.run(job, workerID)
+			continue
+		}
+
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Submit queues a job and returns it right away
+func (s *JobService) Submit(jobType string, payload interface{}, createdBy *int) (*domain.Job, error) {
+	t, ok := s.types[jobType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported job type: %s", jobType)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal job payload: %w", err)
+	}
+
+	job := &domain.Job{
+		Type:        jobType,
+		Status:      domain.JobStatusQueued,
+		Payload:     data,
+		MaxAttempts: t.maxAttempts,
+		CreatedBy:   createdBy,
+	}
+	if err := s.jobRepo.Create(job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+func (s *JobService) GetJob(id int) (*domain.Job, error) {
+	job, err := s.jobRepo.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	if job == nil {
+		return nil, fmt.Errorf("job not found")
+	}
+	return job, nil
+}
+
+func (s *JobService) GetJobs(status, jobType string, limit int) ([]*domain.Job, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	jobs, err := s.jobRepo.List(status, jobType, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs: %w", err)
+	}
+	if jobs == nil {
+		jobs = []*domain.Job{}
+	}
+	return jobs, nil
+}
+
+// GetLogs returns the log lines of a job after the line afterID
+func (s *JobService) GetLogs(id, afterID int) ([]domain.JobLog, error) {
+	if _, err := s.GetJob(id); err != nil {
+		return nil, err
+	}
+	logs, err := s.jobRepo.GetLogs(id, afterID, 1000)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job logs: %w", err)
+	}
+	return logs, nil
+}
+
+// GetOutput returns the content type and size of the file a job produced
+func (s *JobService) GetOutput(id int) (string, int64, error) {
+	contentType, size, err := s.jobRepo.GetOutputInfo(id)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get job output: %w", err)
+	}
+	if contentType == "" {
+		return "", 0, fmt.Errorf("job output not found")
+	}
+	return contentType, size, nil
+}
+
+// WriteOutput writes the file a job produced to w, one stored chunk at a time
+func (s *JobService) WriteOutput(id int, w io.Writer) error {
+	return s.jobRepo.ForEachOutputChunk(id, func(data []byte) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Cancel cancels a queued job, or asks the worker of a running job to stop
+func (s *JobService) Cancel(id int) (*domain.Job, error) {
+	ok, err := s.jobRepo.RequestCancel(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel job: %w", err)
+	}
+	job, err := s.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid state: job is already %s", job.Status)
+	}
+	return job, nil
+}
+
+// Retry queues a failed or cancelled job again
+func (s *JobService) Retry(id int) (*domain.Job, error) {
+	ok, err := s.jobRepo.Retry(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retry job: %w", err)
+	}
+	job, err := s.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid state: only failed or cancelled jobs can be retried, job is %s", job.Status)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// run executes one attempt of a job the worker claimed and stores its outcome
+func (s *JobService) run(job *domain.Job, workerID string) {
+	started := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jc := &JobContext{Job: job, service: s, ctx: ctx, workerID: workerID}
+	if job.Attempts > 1 {
+		jc.Logf(domain.JobLogInfo, "Starting attempt %d of %d", job.Attempts, job.MaxAttempts)
+	}
+
+	// Job events need the permission of the job routes; the result itself is read from the job
+	events := s.events.operation(job.Type, jobOperationID(job.ID), "view_cluster_detail", jobClusterIDs(job)...)
+	events.started(0)
+
+	stop := make(chan struct{})
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		lastDone, lastTotal := 0, 0
+		ticker := time.NewTicker(jobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				done, total := jc.progress()
+				if done != lastDone || total != lastTotal {
+					events.progress(done, total)
+					lastDone, lastTotal = done, total
+				}
+				cancelRequested, owned, err := s.jobRepo.Heartbeat(job.ID, workerID, done, total)
+				switch {
+				case err != nil:
+					log.Printf("Jobs: heartbeat of job %d failed: %v", job.ID, err)
+				case !owned:
+					jc.takenOver()
+					cancel()
+				case cancelRequested:
+					cancel()
+				}
+			}
+		}
+	}()
+
+	result, err := s.callHandler(jc)
+	close(stop)
+	<-heartbeatDone
+
+	var resultJSON json.RawMessage
+	if result != nil {
+		data, marshalErr := json.Marshal(result)
+		if marshalErr != nil && err == nil {
+			err = fmt.Errorf("failed to marshal job result: %w", marshalErr)
+		}
+		resultJSON = data
+	}
+
+	if jc.isTakenOver() || errors.Is(err, errJobTakenOver) {
+		log.Printf("Jobs: job %d was taken over by another worker, dropping the outcome of attempt %d", job.ID, job.Attempts)
+		return
+	}
+
+	done, total := jc.progress()
+	var status string
+	var owned bool
+	var finishErr error
+	switch {
+	case errors.Is(err, ErrJobCancelled):
+		status = domain.JobStatusCancelled
+		owned, finishErr = s.jobRepo.Finish(job.ID, workerID, status, resultJSON, "", done, total)
+	case err != nil && job.Attempts < job.MaxAttempts:
+		status = "retrying"
+		owned, finishErr = s.jobRepo.Requeue(job.ID, workerID, err.Error(), time.Now().Add(jobRetryBackoff(job.Attempts)))
+	case err != nil:
+		status = domain.JobStatusFailed
+		owned, finishErr = s.jobRepo.Finish(job.ID, workerID, status, resultJSON, err.Error(), done, total)
+	default:
+		status = domain.JobStatusSucceeded
+		owned, finishErr = s.jobRepo.Finish(job.ID, workerID, status, resultJSON, "", done, total)
+	}
+	if finishErr != nil {
+		log.Printf("Jobs: failed to store the outcome of job %d: %v", job.ID, finishErr)
+	} else if !owned {
+		log.Printf("Jobs: job %d was taken over by another worker, dropping the outcome of attempt %d", job.ID, job.Attempts)
+		return
+	}
+
+	switch status {
+	case domain.JobStatusCancelled:
+		jc.Logf(domain.JobLogWarn, "Job cancelled")
+		events.completed(status, nil, nil)
+	case "retrying":
+		jc.Logf(domain.JobLogWarn, "Attempt %d failed, retrying in %s: %v", job.Attempts, jobRetryBackoff(job.Attempts), err)
+		events.completed(status, err, nil)
+	case domain.JobStatusFailed:
+		jc.Logf(domain.JobLogError, "Job failed: %v", err)
+		events.completed(status, err, nil)
+	default:
+		jc.Logf(domain.JobLogInfo, "Job succeeded")
+		events.completed(status, nil, nil)
+	}
+
+	// The submitting request was audited when the job was queued; a retried attempt is not an outcome yet
+	if err == nil || errors.Is(err, ErrJobCancelled) || job.Attempts >= job.MaxAttempts {
+		s.auditJob(job, err, time.Since(started))
+	}
+}
+
+// jobRetryBackoff is the delay before retrying a job whose attempt failed: jobRetryDelay after the
+// first attempt, doubling with every further attempt up to jobMaxRetryDelay
+func jobRetryBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := jobRetryDelay << (attempt - 1)
+	if delay > jobMaxRetryDelay || delay <= 0 {
+		delay = jobMaxRetryDelay
+	}
+	return delay
+}
+
+// auditJob records the outcome of a finished job on behalf of the user who submitted it
+func (s *JobService) auditJob(job *domain.Job, err error, duration time.Duration) {
+	event := &domain.AuditEvent{
+		ActorID:        job.CreatedBy,
+		Action:         "job." + job.Type,
+		ObjectType:     "jobs",
+		ObjectName:     jobOperationID(job.ID),
+		RequestSummary: AuditJSON(job.Payload),
+		Outcome:        domain.AuditOutcomeSuccess,
+		DurationMs:     int(duration.Milliseconds()),
+	}
+	// The last cluster of the payload is the one the job changes, e.g. the destination of a bulk sync
+	if ids := jobClusterIDs(job); len(ids) > 0 {
+		event.ClusterID = &ids[len(ids)-1]
+	}
+	if err != nil {
+		event.Outcome = domain.AuditOutcomeFailure
+		event.Error = err.Error()
+	}
+	s.audit.Record(event)
+}
+
+// jobOperationID tags the events of a job on the event stream
+func jobOperationID(id int) string {
+	return fmt.Sprintf("job-%d", id)
+}
+
+// jobClusterIDs reads the clusters a job works on from its payload
+func jobClusterIDs(job *domain.Job) []int {
+	var payload struct {
+		ClusterID            int `json:"cluster_id"`
+		SourceClusterID      int `json:"source_cluster_id"`
+		DestinationClusterID int `json:"destination_cluster_id"`
+	}
+	json.Unmarshal(job.Payload, &payload)
+
+	var ids []int
+	for _, id := range []int{payload.ClusterID, payload.SourceClusterID, payload.DestinationClusterID} {
+		if id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// callHandler runs the handler of the job type and turns a panic into a failed attempt
+func (s *JobService) callHandler(jc *JobContext) (result interface{}, err error) {
+	t, ok := s.types[jc.Job.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported job type: %s", jc.Job.Type)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	return t.handler(jc)
+}
+
+// JobContext is handed to a job handler to read its payload and report progress
+type JobContext struct {
+	Job      *domain.Job
+	service  *JobService
+	ctx      context.Context
+	workerID string
+
+	mu    sync.Mutex
+	done  int
+	total int
+	lost  bool
+}
+
+// Decode reads the job payload into target
+func (jc *JobContext) Decode(target interface{}) error {
+	if err := json.Unmarshal(jc.Job.Payload, target); err != nil {
+		return fmt.Errorf("invalid job payload: %w", err)
+	}
+	return nil
+}
+
+// Context is cancelled when cancelling the job was requested
+func (jc *JobContext) Context() context.Context {
+	return jc.ctx
+}
+
+// Cancelled tells whether cancelling the job was requested. Handlers check it between steps
+// and return ErrJobCancelled when they stop early.
+func (jc *JobContext) Cancelled() bool {
+	return jc.ctx.Err() != nil
+}
+
+// SetTotal sets the number of steps the job has
+func (jc *JobContext) SetTotal(total int) {
+	jc.mu.Lock()
+	jc.total = total
+	jc.mu.Unlock()
+}
+
+// Advance marks n more steps as done. Progress is stored with the next heartbeat.
+func (jc *JobContext) Advance(n int) {
+	jc.mu.Lock()
+	jc.done += n
+	jc.mu.Unlock()
+}
+
+func (jc *JobContext) progress() (int, int) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	return jc.done, jc.total
+}
+
+// takenOver records that the job is no longer locked by the worker
+func (jc *JobContext) takenOver() {
+	jc.mu.Lock()
+	jc.lost = true
+	jc.mu.Unlock()
+}
+
+func (jc *JobContext) isTakenOver() bool {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	return jc.lost
+}
+
+// Logf adds a line to the job log
+func (jc *JobContext) Logf(level, format string, args ...interface{}) {
+	entry := &domain.JobLog{
+		JobID:     jc.Job.ID,
+		Level:     level,
+		Message:   fmt.Sprintf(format, args...),
+		CreatedAt: time.Now(),
+	}
+	if err := jc.service.jobRepo.AddLog(entry); err != nil {
+		log.Printf("Jobs: failed to log for job %d: %v (%s)", jc.Job.ID, err, entry.Message)
+	}
+}
+
+// OutputWriter starts the file the job produces, replacing what an earlier attempt wrote.
+// The file is stored in chunks as it is written and can be downloaded once the writer is closed
+// and the job succeeded.
+func (jc *JobContext) OutputWriter(contentType string) (*JobOutput, error) {
+	owned, err := jc.service.jobRepo.ResetOutput(jc.Job.ID, jc.workerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset job output: %w", err)
+	}
+	if !owned {
+		return nil, errJobTakenOver
+	}
+	return &JobOutput{jc: jc, contentType: contentType, buf: make([]byte, 0, jobOutputChunkSize)}, nil
+}
+
+// JobOutput writes the file of a job into stored chunks of jobOutputChunkSize
+type JobOutput struct {
+	jc          *JobContext
+	contentType string
+	buf         []byte
+	seq         int
+	size        int64
+}
+
+func (o *JobOutput) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(o.buf[len(o.buf):cap(o.buf)], p)
+		o.buf = o.buf[:len(o.buf)+n]
+		p = p[n:]
+		written += n
+		if len(o.buf) == cap(o.buf) {
+			if err := o.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close stores the last chunk and makes the output downloadable
+func (o *JobOutput) Close() error {
+	if err := o.flush(); err != nil {
+		return err
+	}
+	owned, err := o.jc.service.jobRepo.CompleteOutput(o.jc.Job.ID, o.jc.workerID, o.contentType)
+	if err != nil {
+		return fmt.Errorf("failed to store job output: %w", err)
+	}
+	if !owned {
+		return errJobTakenOver
+	}
+	return nil
+}
+
+// Size is the number of bytes written so far
+func (o *JobOutput) Size() int64 {
+	return o.size + int64(len(o.buf))
+}
+
+func (o *JobOutput) flush() error {
+	if len(o.buf) == 0 {
+		return nil
+	}
+	owned, err := o.jc.service.jobRepo.AddOutputChunk(o.jc.Job.ID, o.jc.workerID, o.seq, o.buf)
+	if err != nil {
+		return fmt.Errorf("failed to store job output: %w", err)
+	}
+	if !owned {
+		return errJobTakenOver
+	}
+	o.seq++
+	o.size += int64(len(o.buf))
+	o.buf = o.buf[:0]
+	return nil
+}
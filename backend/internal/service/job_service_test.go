@@ -0,0 +1,64 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"keycloak-multi-manage/internal/domain"
+)
+
+func TestJobRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, jobRetryDelay},
+		{1, jobRetryDelay},
+		{2, 2 * jobRetryDelay},
+		{3, 4 * jobRetryDelay},
+		{5, 16 * jobRetryDelay},
+		{6, jobMaxRetryDelay},
+		{20, jobMaxRetryDelay},
+		// A shift beyond the width of a Duration must not wrap around to a short or negative delay
+		{70, jobMaxRetryDelay},
+	}
+
+	for _, tt := range tests {
+		if got := jobRetryBackoff(tt.attempt); got != tt.want {
+			t.Errorf("jobRetryBackoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestJobRetryBackoffGrows(t *testing.T) {
+	previous := time.Duration(0)
+	for attempt := 1; attempt <= 64; attempt++ {
+		delay := jobRetryBackoff(attempt)
+		if delay < previous || delay > jobMaxRetryDelay {
+			t.Fatalf("jobRetryBackoff(%d) = %s after %s", attempt, delay, previous)
+		}
+		previous = delay
+	}
+}
+
+func TestJobClusterIDs(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    []int
+	}{
+		{`{"cluster_id": 3}`, []int{3}},
+		{`{"source_cluster_id": 1, "destination_cluster_id": 2}`, []int{1, 2}},
+		{`{"cluster_ids": [1, 2], "tag_id": 4}`, nil},
+		{`{}`, nil},
+		{`not json`, nil},
+	}
+
+	for _, tt := range tests {
+		job := &domain.Job{Payload: json.RawMessage(tt.payload)}
+		if got := jobClusterIDs(job); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("jobClusterIDs(%s) = %v, want %v", tt.payload, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keycloak-multi-manage/internal/client/keycloak"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/repository/postgres"
+	"log"
+	"time"
+)
+
+const (
+	// keycloakEventRetention is how long collected events are kept
+	keycloakEventRetention = 90 * 24 * time.Hour
+	// keycloakEventFirstLookback is how far back the first collection from a cluster reaches
+	keycloakEventFirstLookback = 7 * 24 * time.Hour
+)
+
+// errCheckpointReached stops paging once events older than the checkpoint are returned
+var errCheckpointReached = errors.New("checkpoint reached")
+
+// KeycloakEventService collects the login and admin events of every cluster into Postgres,
+// so incidents can be investigated across clusters without logging into each console
+type KeycloakEventService struct {
+	eventRepo      *postgres.KeycloakEventRepository
+	clusterRepo    *postgres.ClusterRepository
+	keycloakClient *keycloak.Client
+}
+
+func NewKeycloakEventService(eventRepo *postgres.KeycloakEventRepository, clusterRepo *postgres.ClusterRepository) *KeycloakEventService {
+	return &KeycloakEventService{
+		eventRepo:      eventRepo,
+		clusterRepo:    clusterRepo,
+		keycloakClient: keycloak.NewClient(),
+	}
+}
+
+// StartCollector collects the new events of every cluster every interval and deletes events past the retention
+func (s *KeycloakEventService) StartCollector(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.collectDue(interval)
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *KeycloakEventService) collectDue(interval time.Duration) {
+	clusters, err := s.clusterRepo.GetAll()
+	if err != nil {
+		log.Printf("Keycloak events: failed to get clusters: %v", err)
+		return
+	}
+
+	for _, cluster := range clusters {
+		for _, kind := range domain.KeycloakEventKinds {
+			// Another server instance may have collected these events within the interval
+			checkpoint, err := s.eventRepo.ClaimCheckpoint(cluster.ID, kind, time.Now(), interval)
+			if err != nil {
+				log.Printf("Keycloak events: failed to claim %s events of cluster %s: %v", kind, cluster.Name, err)
+				continue
+			}
+			if checkpoint == nil {
+				continue
+			}
+			s.collect(cluster, checkpoint)
+			if checkpoint.LastError != "" {
+				log.Printf("Keycloak events: failed to collect %s events of cluster %s: %s", kind, cluster.Name, checkpoint.LastError)
+			}
+		}
+	}
+
+	if _, err := s.eventRepo.DeleteBefore(time.Now().Add(-keycloakEventRetention)); err != nil {
+		log.Printf("Keycloak events: failed to delete old events: %v", err)
+	}
+}
+
+// Collect pulls the new events of a cluster right away, outside the schedule
+func (s *KeycloakEventService) Collect(clusterID int) (*domain.CollectKeycloakEventsResult, error) {
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+
+	result := &domain.CollectKeycloakEventsResult{ClusterID: cluster.ID}
+	for _, kind := range domain.KeycloakEventKinds {
+		checkpoint, err := s.eventRepo.ClaimCheckpoint(cluster.ID, kind, time.Now(), 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get checkpoint: %w", err)
+		}
+		s.collect(cluster, checkpoint)
+		result.Checkpoints = append(result.Checkpoints, checkpoint)
+	}
+	return result, nil
+}
+
+// collect stores the events of one kind that happened since the checkpoint and moves the checkpoint
+// to the newest of them. After a failure the checkpoint stays where it was; events stored before
+// the failure are skipped when they are pulled again.
+func (s *KeycloakEventService) collect(cluster *domain.Cluster, checkpoint *domain.KeycloakEventCheckpoint) {
+	now := time.Now()
+	since := now.Add(-keycloakEventFirstLookback)
+	if checkpoint.LastEventTime != nil {
+		since = *checkpoint.LastEventTime
+	}
+
+	stored, newest, err := s.pull(cluster, checkpoint.Kind, since, now)
+
+	checkpoint.ClusterName = cluster.Name
+	checkpoint.LastCollectedAt = &now
+	checkpoint.Collected = stored
+	checkpoint.LastError = ""
+	if err != nil {
+		checkpoint.LastError = err.Error()
+	} else if newest != nil && (checkpoint.LastEventTime == nil || newest.After(*checkpoint.LastEventTime)) {
+		checkpoint.LastEventTime = newest
+	}
+
+	if err := s.eventRepo.SaveCheckpoint(checkpoint); err != nil {
+		log.Printf("Keycloak events: failed to save the %s checkpoint of cluster %s: %v", checkpoint.Kind, cluster.Name, err)
+	}
+}
+
+// pull pages through the events of one kind, newest first, until it reaches events older than since.
+// It returns the number of newly stored events and the time of the newest event.
+func (s *KeycloakEventService) pull(cluster *domain.Cluster, kind string, since, now time.Time) (int, *time.Time, error) {
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	forEach := s.keycloakClient.ForEachEvent
+	if kind == domain.KeycloakEventKindAdmin {
+		forEach = s.keycloakClient.ForEachAdminEvent
+	}
+
+	stored := 0
+	var newest *time.Time
+	err = forEach(cluster.BaseURL, cluster.Realm, tokenResp.AccessToken, since, func(page []map[string]interface{}) error {
+		events := make([]*domain.KeycloakEvent, 0, len(page))
+		reached := false
+		for _, raw := range page {
+			event := normalizeKeycloakEvent(cluster, kind, raw, now)
+			// Events at the checkpoint itself are pulled again and skipped when stored
+			if event.Time.Before(since) {
+				reached = true
+				break
+			}
+			if newest == nil || event.Time.After(*newest) {
+				t := event.Time
+				newest = &t
+			}
+			events = append(events, event)
+		}
+
+		n, err := s.eventRepo.Save(events)
+		stored += n
+		if err != nil {
+			return err
+		}
+		if reached {
+			return errCheckpointReached
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errCheckpointReached) {
+		return stored, nil, err
+	}
+	return stored, newest, nil
+}
+
+// normalizeKeycloakEvent turns a login event or admin event representation into a KeycloakEvent
+func normalizeKeycloakEvent(cluster *domain.Cluster, kind string, raw map[string]interface{}, now time.Time) *domain.KeycloakEvent {
+	event := &domain.KeycloakEvent{
+		ClusterID:   cluster.ID,
+		Realm:       cluster.Realm,
+		Kind:        kind,
+		EventKey:    getString(raw, "id"),
+		Error:       getString(raw, "error"),
+		CollectedAt: now,
+	}
+	if ms, ok := raw["time"].(float64); ok {
+		event.Time = time.UnixMilli(int64(ms))
+	}
+	// Keycloak versions before 22 have no event IDs; the content identifies the event instead
+	if event.EventKey == "" {
+		data, _ := json.Marshal(raw)
+		event.EventKey = sha256Hex(data)
+	}
+
+	if kind == domain.KeycloakEventKindAdmin {
+		auth, _ := raw["authDetails"].(map[string]interface{})
+		event.Type = getString(raw, "operationType")
+		event.UserID = getString(auth, "userId")
+		event.ClientID = getString(auth, "clientId")
+		event.IPAddress = getString(auth, "ipAddress")
+		event.ResourceType = getString(raw, "resourceType")
+		event.ResourcePath = getString(raw, "resourcePath")
+
+		// The representation is only sent when the realm includes details in admin events
+		if representation := getString(raw, "representation"); representation != "" {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(representation), &decoded); err != nil {
+				decoded = representation
+			}
+			event.Details = AuditJSON(decoded)
+		}
+		return event
+	}
+
+	details, _ := raw["details"].(map[string]interface{})
+	event.Type = getString(raw, "type")
+	event.UserID = getString(raw, "userId")
+	event.Username = getString(details, "username")
+	event.ClientID = getString(raw, "clientId")
+	event.IPAddress = getString(raw, "ipAddress")
+	event.SessionID = getString(raw, "sessionId")
+	if len(details) > 0 {
+		event.Details = AuditJSON(details)
+	}
+	return event
+}
+
+func (s *KeycloakEventService) Search(filter domain.KeycloakEventFilter) (*domain.KeycloakEventPage, error) {
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	events, total, err := s.eventRepo.Search(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search keycloak events: %w", err)
+	}
+	return &domain.KeycloakEventPage{Total: total, Events: events}, nil
+}
+
+func (s *KeycloakEventService) GetCheckpoints() ([]*domain.KeycloakEventCheckpoint, error) {
+	return s.eventRepo.GetCheckpoints()
+}
+
+// GetEventsConfig returns the event settings of a cluster's realm
+func (s *KeycloakEventService) GetEventsConfig(clusterID int) (*domain.RealmEventsConfig, error) {
+	cluster, accessToken, err := s.clusterToken(clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := s.keycloakClient.GetEventsConfig(cluster.BaseURL, cluster.Realm, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get events config: %w", err)
+	}
+	return config, nil
+}
+
+// UpdateEventsConfig enables or disables the login and admin events of a cluster's realm.
+// Fields left out of the request keep their current value.
+func (s *KeycloakEventService) UpdateEventsConfig(clusterID int, req domain.UpdateRealmEventsConfigRequest) (*domain.RealmEventsConfig, error) {
+	cluster, accessToken, err := s.clusterToken(clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := s.keycloakClient.GetEventsConfig(cluster.BaseURL, cluster.Realm, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get events config: %w", err)
+	}
+
+	if req.EventsEnabled != nil {
+		config.EventsEnabled = *req.EventsEnabled
+	}
+	if req.EventsExpiration != nil {
+		if *req.EventsExpiration < 0 {
+			return nil, fmt.Errorf("invalid events_expiration: must not be negative")
+		}
+		config.EventsExpiration = req.EventsExpiration
+	}
+	if req.EventsListeners != nil {
+		config.EventsListeners = req.EventsListeners
+	}
+	if req.EnabledEventTypes != nil {
+		config.EnabledEventTypes = req.EnabledEventTypes
+	}
+	if req.AdminEventsEnabled != nil {
+		config.AdminEventsEnabled = *req.AdminEventsEnabled
+	}
+	if req.AdminEventsDetailsEnabled != nil {
+		config.AdminEventsDetailsEnabled = *req.AdminEventsDetailsEnabled
+	}
+
+	if err := s.keycloakClient.UpdateEventsConfig(cluster.BaseURL, cluster.Realm, accessToken, config); err != nil {
+		return nil, fmt.Errorf("failed to update events config: %w", err)
+	}
+	return config, nil
+}
+
+func (s *KeycloakEventService) clusterToken(clusterID int) (*domain.Cluster, string, error) {
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, "", fmt.Errorf("cluster not found")
+	}
+
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get access token: %w", err)
+	}
+	return cluster, tokenResp.AccessToken, nil
+}
@@ -4,17 +4,20 @@ import (
 	"fmt"
 	"keycloak-multi-manage/internal/domain"
 	"keycloak-multi-manage/internal/repository/postgres"
+	"keycloak-multi-manage/pkg/secrets"
 )
 
 type LDAPConfigService struct {
 	repo               *postgres.LDAPConfigRepository
 	certificateService *CertificateService
+	secretResolver     *secrets.Resolver
 }
 
-func NewLDAPConfigService(repo *postgres.LDAPConfigRepository, certService *CertificateService) *LDAPConfigService {
+func NewLDAPConfigService(repo *postgres.LDAPConfigRepository, certService *CertificateService, secretResolver *secrets.Resolver) *LDAPConfigService {
 	return &LDAPConfigService{
 		repo:               repo,
 		certificateService: certService,
+		secretResolver:     secretResolver,
 	}
 }
 
@@ -23,6 +26,23 @@ func (s *LDAPConfigService) Get() (*domain.LDAPConfig, error) {
 }
 
 func (s *LDAPConfigService) Update(req *domain.UpdateLDAPConfigRequest) (*domain.LDAPConfig, error) {
+	if req.BindPasswordRef != nil && *req.BindPasswordRef != "" {
+		if err := s.secretResolver.ValidateExternal(*req.BindPasswordRef); err != nil {
+			return nil, err
+		}
+	}
+	
+	// A password moved back from an external store has to be given, the database has none
+	if req.BindPasswordRef != nil && *req.BindPasswordRef == "" && req.BindPassword == "" {
+		existing, err := s.repo.Get()
+		if err != nil {
+			return nil, err
+		}
+		if existing.BindPasswordRef != "" {
+			return nil, fmt.Errorf("bind_password is required when bind_password_ref is removed")
+		}
+	}
+	
 	return s.repo.Update(req)
 }
 
@@ -32,7 +52,7 @@ func (s *LDAPConfigService) TestConnection() error {
 		return err
 	}
 
-	ldapService := NewLDAPService(config, s.certificateService)
+	ldapService := NewLDAPService(config, s.certificateService, s.secretResolver)
 	return ldapService.TestConnection()
 }
 
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -9,17 +10,23 @@ import (
 
 	"github.com/go-ldap/ldap/v3"
 	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/pkg/secrets"
 )
 
+// ldapSecretTimeout bounds the read of the bind password from its store
+const ldapSecretTimeout = 10 * time.Second
+
 type LDAPService struct {
 	config            *domain.LDAPConfig
 	certificateService *CertificateService
+	secretResolver    *secrets.Resolver
 }
 
-func NewLDAPService(config *domain.LDAPConfig, certService *CertificateService) *LDAPService {
+func NewLDAPService(config *domain.LDAPConfig, certService *CertificateService, secretResolver *secrets.Resolver) *LDAPService {
 	return &LDAPService{
 		config:            config,
 		certificateService: certService,
+		secretResolver:    secretResolver,
 	}
 }
 
@@ -37,7 +44,7 @@ func (s *LDAPService) Authenticate(username, password string) (*domain.User, err
 	defer conn.Close()
 
 	// Bind with service account
-	err = conn.Bind(s.config.BindDN, s.config.BindPassword)
+	err = s.bind(conn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to bind with service account: %w", err)
 	}
@@ -114,7 +121,7 @@ func (s *LDAPService) TestConnection() error {
 	defer conn.Close()
 
 	// Try to bind with service account
-	err = conn.Bind(s.config.BindDN, s.config.BindPassword)
+	err = s.bind(conn)
 	if err != nil {
 		return fmt.Errorf("failed to bind with service account: %w", err)
 	}
@@ -122,6 +129,22 @@ func (s *LDAPService) TestConnection() error {
 	return nil
 }
 
+// bind binds as the service account. The password is read from its store, the configured reference or else
+// the database, at every bind, so a password rotated in its store is used without editing the configuration.
+func (s *LDAPService) bind(conn *ldap.Conn) error {
+	ref := s.config.BindPasswordRef
+	if ref == "" {
+		ref = secrets.DatabaseRef("ldap_config", s.config.ID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ldapSecretTimeout)
+	defer cancel()
+	password, err := s.secretResolver.Resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return conn.Bind(s.config.BindDN, password)
+}
+
 func (s *LDAPService) connect() (*ldap.Conn, error) {
 	var conn *ldap.Conn
 	var err error
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+)
+
+// RestoreService reverts realm objects to the state stored in a snapshot of the same cluster
+type RestoreService struct {
+	syncService     *SyncService
+	snapshotService *SnapshotService
+	diffService     *DiffService
+}
+
+func NewRestoreService(syncService *SyncService, snapshotService *SnapshotService, diffService *DiffService) *RestoreService {
+	return &RestoreService{
+		syncService:     syncService,
+		snapshotService: snapshotService,
+		diffService:     diffService,
+	}
+}
+
+// PlanRestore compares a snapshot with the live realm and plans restoring the selected objects.
+// Objects created after the snapshot are left untouched.
+func (s *RestoreService) PlanRestore(snapshotID int, req domain.RestoreRequest, createdBy *int) (*domain.RestorePreview, error) {
+	if !isSyncObjectType(req.ObjectType) {
+		return nil, fmt.Errorf("unsupported object type: %s", req.ObjectType)
+	}
+
+	snapshot, err := s.snapshotService.GetSnapshot(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("snapshot not found")
+	}
+
+	diff, err := s.diffService.Compare(domain.CompareRequest{
+		Source:      domain.DiffSource{SnapshotID: snapshot.ID},
+		Destination: domain.DiffSource{ClusterID: snapshot.ClusterID},
+		ObjectTypes: []string{req.ObjectType},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare snapshot with cluster: %w", err)
+	}
+
+	content, err := s.snapshotService.GetSnapshotContent(snapshot, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ObjectName != "" {
+		if !snapshotHasObject(content, req.ObjectType, req.ObjectName) {
+			return nil, fmt.Errorf("%s '%s' not found in snapshot", req.ObjectType, req.ObjectName)
+		}
+		filterCompareResult(diff, req.ObjectType, req.ObjectName)
+	}
+
+	preview := &domain.RestorePreview{Snapshot: snapshot, Diff: diff}
+
+	names := restorableNames(diff, req.ObjectType)
+	if len(names) == 0 && req.ObjectType == "group" && parentGroupPath(req.ObjectName) != "" {
+		// The diff only lists top level groups, a subgroup is planned and only changed where it differs
+		names = []string{req.ObjectName}
+	}
+	if len(names) == 0 {
+		return preview, nil
+	}
+
+	preview.Plan, err = s.syncService.CreateRestorePlan(snapshot, content, req, names, createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// restorableNames returns the objects that were deleted or changed since the snapshot
+func restorableNames(diff *domain.CompareResult, objectType string) []string {
+	var names []string
+	add := func(name, status string) {
+		if status != "missing_in_source" {
+			names = append(names, name)
+		}
+	}
+
+	switch objectType {
+	case "role":
+		for _, d := range diff.Roles {
+			add(d.Role.Name, d.Status)
+		}
+	case "client":
+		for _, d := range diff.Clients {
+			add(d.Client.ClientID, d.Status)
+		}
+	case "group":
+		for _, d := range diff.Groups {
+			add(d.Group.Path, d.Status)
+		}
+	case "user":
+		for _, d := range diff.Users {
+			add(d.User.Username, d.Status)
+		}
+	}
+
+	return names
+}
+
+// filterCompareResult keeps only the diff entries of one object
+func filterCompareResult(diff *domain.CompareResult, objectType, name string) {
+	switch objectType {
+	case "role":
+		var roles []domain.RoleDiff
+		for _, d := range diff.Roles {
+			if d.Role.Name == name {
+				roles = append(roles, d)
+			}
+		}
+		diff.Roles = roles
+	case "client":
+		var clients []domain.ClientDiff
+		for _, d := range diff.Clients {
+			if d.Client.ClientID == name {
+				clients = append(clients, d)
+			}
+		}
+		diff.Clients = clients
+	case "group":
+		var groups []domain.GroupDiff
+		for _, d := range diff.Groups {
+			if d.Group.Path == name {
+				groups = append(groups, d)
+			}
+		}
+		diff.Groups = groups
+	case "user":
+		var users []domain.UserDiff
+		for _, d := range diff.Users {
+			if d.User.Username == name {
+				users = append(users, d)
+			}
+		}
+		diff.Users = users
+	}
+}
+
+func snapshotHasObject(content *domain.RealmSnapshotContent, objectType, name string) bool {
+	switch objectType {
+	case "role":
+		for _, role := range content.Roles {
+			if role.Name == name {
+				return true
+			}
+		}
+	case "client":
+		for _, client := range content.Clients {
+			if client.ClientID == name {
+				return true
+			}
+		}
+	case "group":
+		return findGroupDetail(content.Groups, name) != nil
+	case "user":
+		for _, user := range content.Users {
+			if user.Username == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,374 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/repository/postgres"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type RewriteRuleService struct {
+	ruleRepo *postgres.RewriteRuleRepository
+	tagRepo  *postgres.EnvironmentTagRepository
+}
+
+func NewRewriteRuleService(ruleRepo *postgres.RewriteRuleRepository, tagRepo *postgres.EnvironmentTagRepository) *RewriteRuleService {
+	return &RewriteRuleService{
+		ruleRepo: ruleRepo,
+		tagRepo:  tagRepo,
+	}
+}
+
+func (s *RewriteRuleService) GetAll() ([]*domain.RewriteRule, error) {
+	return s.ruleRepo.GetAll()
+}
+
+func (s *RewriteRuleService) GetByID(id int) (*domain.RewriteRule, error) {
+	return s.ruleRepo.GetByID(id)
+}
+
+func (s *RewriteRuleService) Create(req domain.CreateRewriteRuleRequest) (*domain.RewriteRule, error) {
+	rule := &domain.RewriteRule{}
+	applyRewriteRuleRequest(rule, req)
+
+	if err := validateRewriteRule(rule); err != nil {
+		return nil, err
+	}
+	if err := s.ruleRepo.Create(rule); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// Update replaces every field of a rule with the request
+func (s *RewriteRuleService) Update(id int, req domain.CreateRewriteRuleRequest) (*domain.RewriteRule, error) {
+	rule, err := s.ruleRepo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, fmt.Errorf("rewrite rule not found")
+	}
+
+	applyRewriteRuleRequest(rule, req)
+	if err := validateRewriteRule(rule); err != nil {
+		return nil, err
+	}
+	if err := s.ruleRepo.Update(rule); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+func (s *RewriteRuleService) Delete(id int) error {
+	return s.ruleRepo.Delete(id)
+}
+
+// RewriterFor returns the enabled rules that apply when objects move from the source to the destination cluster.
+// A sourceClusterID of 0 means the origin is unknown (e.g. an uploaded file), so rules scoped to a source do not apply.
+func (s *RewriteRuleService) RewriterFor(sourceClusterID, destinationClusterID int) (*Rewriter, error) {
+	rules, err := s.ruleRepo.GetEnabled()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rewrite rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return &Rewriter{}, nil
+	}
+
+	sourceTags, err := s.clusterTagIDs(sourceClusterID)
+	if err != nil {
+		return nil, err
+	}
+	destTags, err := s.clusterTagIDs(destinationClusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	rewriter := &Rewriter{}
+	for _, rule := range rules {
+		if !scopeMatches(rule.SourceClusterID, sourceClusterID) || !scopeMatches(rule.DestinationClusterID, destinationClusterID) {
+			continue
+		}
+		if (rule.SourceTagID != nil && !sourceTags[*rule.SourceTagID]) || (rule.DestinationTagID != nil && !destTags[*rule.DestinationTagID]) {
+			continue
+		}
+
+		compiled := compiledRewriteRule{rule: rule}
+		if rule.IsRegex {
+			compiled.re, err = regexp.Compile(rule.Match)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern in rewrite rule '%s': %w", rule.Name, err)
+			}
+		}
+		rewriter.rules = append(rewriter.rules, compiled)
+	}
+
+	return rewriter, nil
+}
+
+// Preview applies the rules of a cluster pair to one representation and returns the result
+func (s *RewriteRuleService) Preview(req domain.RewritePreviewRequest) (*domain.RewritePreviewResult, error) {
+	rewriter, err := s.RewriterFor(req.SourceClusterID, req.DestinationClusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	representation := req.Representation
+	if representation == nil {
+		representation = make(map[string]interface{})
+	}
+	name, _ := representation[representationNameField(req.ObjectType)].(string)
+	rewrites := rewriter.Apply(req.ObjectType, name, representation)
+	if rewrites == nil {
+		rewrites = []domain.SyncFieldChange{}
+	}
+
+	return &domain.RewritePreviewResult{
+		Representation: representation,
+		Rewrites:       rewrites,
+	}, nil
+}
+
+func (s *RewriteRuleService) clusterTagIDs(clusterID int) (map[int]bool, error) {
+	ids := make(map[int]bool)
+	if clusterID == 0 {
+		return ids, nil
+	}
+
+	tags, err := s.tagRepo.GetTagsByClusterID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get environment tags of cluster %d: %w", clusterID, err)
+	}
+	for _, tag := range tags {
+		ids[tag.ID] = true
+	}
+	return ids, nil
+}
+
+func scopeMatches(scope *int, clusterID int) bool {
+	return scope == nil || *scope == clusterID
+}
+
+func applyRewriteRuleRequest(rule *domain.RewriteRule, req domain.CreateRewriteRuleRequest) {
+	rule.Name = req.Name
+	rule.Description = req.Description
+	rule.SourceClusterID = req.SourceClusterID
+	rule.DestinationClusterID = req.DestinationClusterID
+	rule.SourceTagID = req.SourceTagID
+	rule.DestinationTagID = req.DestinationTagID
+	rule.ObjectType = req.ObjectType
+	rule.Field = req.Field
+	rule.Match = req.Match
+	rule.Replace = req.Replace
+	rule.IsRegex = req.IsRegex
+	rule.Priority = req.Priority
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+}
+
+func validateRewriteRule(rule *domain.RewriteRule) error {
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	switch rule.ObjectType {
+	case "client", "user", "group":
+	default:
+		return fmt.Errorf("unsupported object type: %s", rule.ObjectType)
+	}
+	if rule.Field == "" || rule.Field == "attributes." {
+		return errors.New("field is required")
+	}
+	if rule.Match == "" {
+		return errors.New("match is required")
+	}
+	if rule.DestinationClusterID == nil && rule.DestinationTagID == nil {
+		return errors.New("destination_cluster_id or destination_tag_id is required")
+	}
+	if rule.IsRegex {
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("invalid match pattern: %w", err)
+		}
+	}
+	return nil
+}
+
+func representationNameField(objectType string) string {
+	switch objectType {
+	case "client":
+		return "clientId"
+	case "user":
+		return "username"
+	case "group":
+		return "path"
+	}
+	return "name"
+}
+
+// Rewriter applies the rewrite rules of one source/destination pair. A nil Rewriter changes nothing.
+type Rewriter struct {
+	rules []compiledRewriteRule
+}
+
+type compiledRewriteRule struct {
+	rule *domain.RewriteRule
+	re   *regexp.Regexp
+}
+
+// Apply rewrites a raw Keycloak representation in place and returns every field it changed.
+// Nested attribute maps and slices are copied before they are changed, so a shallow copy of a cached
+// representation is enough to keep the cache untouched.
+func (r *Rewriter) Apply(objectType, objectName string, rep map[string]interface{}) []domain.SyncFieldChange {
+	if r == nil || rep == nil {
+		return nil
+	}
+
+	object := objectType + ":" + objectName
+	var changes []domain.SyncFieldChange
+	attributesCopied := false
+
+	for _, compiled := range r.rules {
+		rule := compiled.rule
+		if rule.ObjectType != objectType {
+			continue
+		}
+
+		if strings.HasPrefix(rule.Field, "attributes.") {
+			attributes, ok := rep["attributes"].(map[string]interface{})
+			if typed, isTyped := rep["attributes"].(map[string][]string); isTyped {
+				attributes, ok = make(map[string]interface{}, len(typed)), true
+				for k, v := range typed {
+					attributes[k] = v
+				}
+			}
+			if !ok {
+				continue
+			}
+			attributeName := strings.TrimPrefix(rule.Field, "attributes.")
+
+			names := make([]string, 0, len(attributes))
+			for name := range attributes {
+				if attributeName == "*" || attributeName == name {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				value, changed := compiled.rewriteValue(attributes[name])
+				if !changed {
+					continue
+				}
+				if !attributesCopied {
+					copied := make(map[string]interface{}, len(attributes))
+					for k, v := range attributes {
+						copied[k] = v
+					}
+					attributes = copied
+					rep["attributes"] = attributes
+					attributesCopied = true
+				}
+				changes = recordRewrite(changes, object, "attributes."+name, attributes[name], value)
+				attributes[name] = value
+			}
+			continue
+		}
+
+		current, ok := rep[rule.Field]
+		if !ok {
+			continue
+		}
+		if value, changed := compiled.rewriteValue(current); changed {
+			changes = recordRewrite(changes, object, rule.Field, current, value)
+			rep[rule.Field] = value
+		}
+	}
+
+	return changes
+}
+
+// ApplyTo rewrites a typed value (ClientDetail, UserDetail, GroupDetail, ...) through its JSON representation
+func (r *Rewriter) ApplyTo(objectType, objectName string, v interface{}) ([]domain.SyncFieldChange, error) {
+	if r == nil || len(r.rules) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var rep map[string]interface{}
+	if err := json.Unmarshal(data, &rep); err != nil {
+		return nil, err
+	}
+
+	changes := r.Apply(objectType, objectName, rep)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	data, err = json.Marshal(rep)
+	if err != nil {
+		return nil, err
+	}
+	// Decode into a zeroed value: json.Unmarshal merges into existing maps, which may be shared with a cache
+	target := reflect.ValueOf(v).Elem()
+	target.Set(reflect.Zero(target.Type()))
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("failed to apply rewritten values: %w", err)
+	}
+	return changes, nil
+}
+
+// rewriteValue rewrites a string or every string of a list; other values are left alone
+func (c compiledRewriteRule) rewriteValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		rewritten := c.rewriteString(v)
+		return rewritten, rewritten != v
+	case []string:
+		out := make([]string, len(v))
+		changed := false
+		for i, s := range v {
+			out[i] = c.rewriteString(s)
+			changed = changed || out[i] != s
+		}
+		return out, changed
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		changed := false
+		for i, item := range v {
+			out[i] = item
+			if s, ok := item.(string); ok {
+				rewritten := c.rewriteString(s)
+				out[i] = rewritten
+				changed = changed || rewritten != s
+			}
+		}
+		return out, changed
+	}
+	return value, false
+}
+
+func (c compiledRewriteRule) rewriteString(s string) string {
+	if c.re != nil {
+		return c.re.ReplaceAllString(s, c.rule.Replace)
+	}
+	return strings.ReplaceAll(s, c.rule.Match, c.rule.Replace)
+}
+
+// recordRewrite adds a change, merging it with an earlier rule's change of the same field
+func recordRewrite(changes []domain.SyncFieldChange, object, field string, from, to interface{}) []domain.SyncFieldChange {
+	for i := range changes {
+		if changes[i].Object == object && changes[i].Field == field {
+			changes[i].To = to
+			return changes
+		}
+	}
+	return append(changes, domain.SyncFieldChange{Object: object, Field: field, From: from, To: to})
+}
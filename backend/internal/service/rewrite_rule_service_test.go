@@ -0,0 +1,243 @@
+package service
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"keycloak-multi-manage/internal/domain"
+)
+
+// testRewriter compiles rules the way RewriterFor does, in the given order
+func testRewriter(t *testing.T, rules ...domain.RewriteRule) *Rewriter {
+	t.Helper()
+	r := &Rewriter{}
+	for i := range rules {
+		compiled := compiledRewriteRule{rule: &rules[i]}
+		if rules[i].IsRegex {
+			compiled.re = regexp.MustCompile(rules[i].Match)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r
+}
+
+func TestRewriterApply(t *testing.T) {
+	tests := []struct {
+		name        string
+		rules       []domain.RewriteRule
+		objectType  string
+		rep         map[string]interface{}
+		want        map[string]interface{}
+		wantChanges []string
+	}{
+		{
+			name:        "string field",
+			rules:       []domain.RewriteRule{{ObjectType: "client", Field: "rootUrl", Match: "dev.example.com", Replace: "prod.example.com"}},
+			objectType:  "client",
+			rep:         map[string]interface{}{"rootUrl": "https://dev.example.com/app"},
+			want:        map[string]interface{}{"rootUrl": "https://prod.example.com/app"},
+			wantChanges: []string{"client:app rootUrl"},
+		},
+		{
+			name:        "every string of a list",
+			rules:       []domain.RewriteRule{{ObjectType: "client", Field: "redirectUris", Match: "dev", Replace: "prod"}},
+			objectType:  "client",
+			rep:         map[string]interface{}{"redirectUris": []interface{}{"https://dev/cb", "https://other/cb", 3.0}},
+			want:        map[string]interface{}{"redirectUris": []interface{}{"https://prod/cb", "https://other/cb", 3.0}},
+			wantChanges: []string{"client:app redirectUris"},
+		},
+		{
+			name:        "regex with a group",
+			rules:       []domain.RewriteRule{{ObjectType: "client", Field: "baseUrl", Match: `^https://([a-z]+)\.dev\.local`, Replace: "https://$1.example.com", IsRegex: true}},
+			objectType:  "client",
+			rep:         map[string]interface{}{"baseUrl": "https://shop.dev.local/home"},
+			want:        map[string]interface{}{"baseUrl": "https://shop.example.com/home"},
+			wantChanges: []string{"client:app baseUrl"},
+		},
+		{
+			name:       "named attribute",
+			rules:      []domain.RewriteRule{{ObjectType: "user", Field: "attributes.site", Match: "dev", Replace: "prod"}},
+			objectType: "user",
+			rep: map[string]interface{}{"attributes": map[string]interface{}{
+				"site": []interface{}{"dev-1"}, "team": []interface{}{"dev"},
+			}},
+			want: map[string]interface{}{"attributes": map[string]interface{}{
+				"site": []interface{}{"prod-1"}, "team": []interface{}{"dev"},
+			}},
+			wantChanges: []string{"user:app attributes.site"},
+		},
+		{
+			name:       "every attribute",
+			rules:      []domain.RewriteRule{{ObjectType: "group", Field: "attributes.*", Match: "dev", Replace: "prod"}},
+			objectType: "group",
+			rep: map[string]interface{}{"attributes": map[string][]string{
+				"b": {"dev"}, "a": {"dev", "x"}, "c": {"x"},
+			}},
+			want: map[string]interface{}{"attributes": map[string]interface{}{
+				"a": []string{"prod", "x"}, "b": []string{"prod"}, "c": []string{"x"},
+			}},
+			wantChanges: []string{"group:app attributes.a", "group:app attributes.b"},
+		},
+		{
+			name: "rules on one field in order",
+			rules: []domain.RewriteRule{
+				{ObjectType: "client", Field: "rootUrl", Match: "dev", Replace: "stage"},
+				{ObjectType: "client", Field: "rootUrl", Match: "stage", Replace: "prod"},
+			},
+			objectType:  "client",
+			rep:         map[string]interface{}{"rootUrl": "https://dev"},
+			want:        map[string]interface{}{"rootUrl": "https://prod"},
+			wantChanges: []string{"client:app rootUrl"},
+		},
+		{
+			name:       "other object type",
+			rules:      []domain.RewriteRule{{ObjectType: "client", Field: "rootUrl", Match: "dev", Replace: "prod"}},
+			objectType: "user",
+			rep:        map[string]interface{}{"rootUrl": "https://dev"},
+			want:       map[string]interface{}{"rootUrl": "https://dev"},
+		},
+		{
+			name:       "missing field and non-string value",
+			rules:      []domain.RewriteRule{{ObjectType: "client", Field: "rootUrl", Match: "dev", Replace: "prod"}, {ObjectType: "client", Field: "enabled", Match: "true", Replace: "false"}},
+			objectType: "client",
+			rep:        map[string]interface{}{"enabled": true},
+			want:       map[string]interface{}{"enabled": true},
+		},
+		{
+			name:       "no match",
+			rules:      []domain.RewriteRule{{ObjectType: "client", Field: "rootUrl", Match: "dev", Replace: "prod"}},
+			objectType: "client",
+			rep:        map[string]interface{}{"rootUrl": "https://example.com"},
+			want:       map[string]interface{}{"rootUrl": "https://example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := testRewriter(t, tt.rules...).Apply(tt.objectType, "app", tt.rep)
+			if !reflect.DeepEqual(tt.rep, tt.want) {
+				t.Errorf("representation = %v, want %v", tt.rep, tt.want)
+			}
+
+			var got []string
+			for _, change := range changes {
+				got = append(got, change.Object+" "+change.Field)
+			}
+			if !reflect.DeepEqual(got, tt.wantChanges) {
+				t.Errorf("changes = %q, want %q", got, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func TestRewriterApplyRecordsFirstAndLastValue(t *testing.T) {
+	r := testRewriter(t,
+		domain.RewriteRule{ObjectType: "client", Field: "rootUrl", Match: "dev", Replace: "stage"},
+		domain.RewriteRule{ObjectType: "client", Field: "rootUrl", Match: "stage", Replace: "prod"},
+	)
+	changes := r.Apply("client", "app", map[string]interface{}{"rootUrl": "dev"})
+	if len(changes) != 1 || changes[0].From != "dev" || changes[0].To != "prod" {
+		t.Fatalf("changes = %+v, want one change from dev to prod", changes)
+	}
+}
+
+func TestRewriterApplyKeepsSharedValues(t *testing.T) {
+	r := testRewriter(t,
+		domain.RewriteRule{ObjectType: "client", Field: "attributes.*", Match: "dev", Replace: "prod"},
+		domain.RewriteRule{ObjectType: "client", Field: "redirectUris", Match: "dev", Replace: "prod"},
+	)
+	cached := map[string]interface{}{
+		"attributes":   map[string]interface{}{"url": "dev"},
+		"redirectUris": []interface{}{"dev"},
+	}
+
+	// A shallow copy, as the planner makes of cached representations
+	rep := make(map[string]interface{}, len(cached))
+	for k, v := range cached {
+		rep[k] = v
+	}
+	r.Apply("client", "app", rep)
+
+	if cached["attributes"].(map[string]interface{})["url"] != "dev" || cached["redirectUris"].([]interface{})[0] != "dev" {
+		t.Fatalf("cached representation was changed: %v", cached)
+	}
+	if rep["attributes"].(map[string]interface{})["url"] != "prod" {
+		t.Fatalf("copy was not rewritten: %v", rep)
+	}
+}
+
+func TestRewriterApplyTo(t *testing.T) {
+	r := testRewriter(t, domain.RewriteRule{ObjectType: "client", Field: "redirectUris", Match: "dev", Replace: "prod"})
+	client := domain.ClientDetail{ClientID: "app", RedirectUris: []string{"https://dev/*"}, DefaultClientScopes: []string{"email"}}
+	shared := client.RedirectUris
+
+	changes, err := r.ApplyTo("client", "app", &client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || !reflect.DeepEqual(client.RedirectUris, []string{"https://prod/*"}) {
+		t.Fatalf("got %v with changes %+v", client.RedirectUris, changes)
+	}
+	if client.ClientID != "app" || !reflect.DeepEqual(client.DefaultClientScopes, []string{"email"}) {
+		t.Errorf("other fields changed: %+v", client)
+	}
+	if shared[0] != "https://dev/*" {
+		t.Error("ApplyTo changed the original slice")
+	}
+
+	var nilRewriter *Rewriter
+	if changes, err := nilRewriter.ApplyTo("client", "app", &client); changes != nil || err != nil {
+		t.Errorf("nil rewriter returned %v, %v", changes, err)
+	}
+	if changes := nilRewriter.Apply("client", "app", map[string]interface{}{"rootUrl": "dev"}); changes != nil {
+		t.Errorf("nil rewriter returned %v", changes)
+	}
+}
+
+func TestValidateRewriteRule(t *testing.T) {
+	dest := 2
+	valid := domain.RewriteRule{Name: "urls", ObjectType: "client", Field: "rootUrl", Match: "dev", DestinationClusterID: &dest}
+
+	tests := []struct {
+		name    string
+		change  func(rule *domain.RewriteRule)
+		wantErr string
+	}{
+		{"valid", func(*domain.RewriteRule) {}, ""},
+		{"destination tag", func(r *domain.RewriteRule) { r.DestinationClusterID, r.DestinationTagID = nil, &dest }, ""},
+		{"valid regex", func(r *domain.RewriteRule) { r.IsRegex, r.Match = true, `^(\w+)\.dev$` }, ""},
+		{"no name", func(r *domain.RewriteRule) { r.Name = "" }, "name is required"},
+		{"unsupported type", func(r *domain.RewriteRule) { r.ObjectType = "role" }, "unsupported object type"},
+		{"no field", func(r *domain.RewriteRule) { r.Field = "" }, "field is required"},
+		{"attribute without a name", func(r *domain.RewriteRule) { r.Field = "attributes." }, "field is required"},
+		{"no match", func(r *domain.RewriteRule) { r.Match = "" }, "match is required"},
+		{"no destination", func(r *domain.RewriteRule) { r.DestinationClusterID = nil }, "destination_cluster_id or destination_tag_id is required"},
+		{"invalid regex", func(r *domain.RewriteRule) { r.IsRegex, r.Match = true, "(" }, "invalid match pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.change(&rule)
+			err := validateRewriteRule(&rule)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("err = %v, want the rule accepted", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScopeMatches(t *testing.T) {
+	one, two := 1, 2
+	if !scopeMatches(nil, 1) || !scopeMatches(&one, 1) || scopeMatches(&two, 1) {
+		t.Error("scopeMatches: a nil scope matches every cluster, a set scope only its own")
+	}
+}
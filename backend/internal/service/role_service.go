@@ -28,12 +28,7 @@ func (s *RoleService) GetRoles(clusterID int) ([]domain.Role, error) {
 		return nil, fmt.Errorf("cluster not found")
 	}
 	
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return nil, err
 	}
@@ -46,3 +41,20 @@ func (s *RoleService) GetRoles(clusterID int) ([]domain.Role, error) {
 	return roles, nil
 }
 
+// GetRoleDetails returns realm roles including attributes and composite children
+func (s *RoleService) GetRoleDetails(clusterID int) ([]domain.Role, error) {
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+	
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return nil, err
+	}
+	
+	return s.keycloakClient.GetRoleDetails(cluster.BaseURL, cluster.Realm, tokenResp.AccessToken)
+}
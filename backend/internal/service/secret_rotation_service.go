@@ -0,0 +1,438 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"keycloak-multi-manage/internal/client/keycloak"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/repository/postgres"
+	"keycloak-multi-manage/pkg/secrets"
+	"log"
+	"time"
+)
+
+const (
+	// secretRotationCheckInterval is how often the scheduler looks for secrets past their maximum age
+	secretRotationCheckInterval = time.Hour
+	// secretVerifyAttempts and secretVerifyDelay give Keycloak nodes time to see a new secret
+	secretVerifyAttempts = 3
+	secretVerifyDelay    = 2 * time.Second
+	// secretRotationLease is how long a rotation claims a cluster's secret, well beyond the time it needs
+	secretRotationLease = 5 * time.Minute
+	// secretDeliveryAttempts and secretDeliveryTimeout bound the delivery of a rotated secret to a sink
+	secretDeliveryAttempts = 3
+	secretDeliveryTimeout  = 30 * time.Second
+)
+
+// SecretRotationService rotates the secret of the multi-manage client each cluster is managed with
+// and the secrets of application clients, which are delivered to a sink
+type SecretRotationService struct {
+	rotationRepo   *postgres.SecretRotationRepository
+	clusterRepo    *postgres.ClusterRepository
+	tagRepo        *postgres.EnvironmentTagRepository
+	keycloakClient *keycloak.Client
+	sinks          map[string]secrets.Sink
+}
+
+func NewSecretRotationService(rotationRepo *postgres.SecretRotationRepository, clusterRepo *postgres.ClusterRepository, tagRepo *postgres.EnvironmentTagRepository) *SecretRotationService {
+	return &SecretRotationService{
+		rotationRepo:   rotationRepo,
+		clusterRepo:    clusterRepo,
+		tagRepo:        tagRepo,
+		keycloakClient: keycloak.NewClient(),
+	}
+}
+
+// SetSinks sets the sinks rotated application client secrets can be delivered to, by name
+func (s *SecretRotationService) SetSinks(sinks map[string]secrets.Sink) {
+	s.sinks = sinks
+}
+
+// StartScheduler rotates every secret that was last rotated longer than maxAge ago
+func (s *SecretRotationService) StartScheduler(maxAge time.Duration) {
+	go func() {
+		ticker := time.NewTicker(secretRotationCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			s.rotateDue(maxAge)
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *SecretRotationService) rotateDue(maxAge time.Duration) {
+	rotatedBefore := time.Now().Add(-maxAge)
+	clusterIDs, err := s.rotationRepo.GetDueClusterIDs(rotatedBefore)
+	if err != nil {
+		log.Printf("Secret rotation: failed to get clusters: %v", err)
+		return
+	}
+
+	for _, clusterID := range clusterIDs {
+		cluster, err := s.clusterRepo.GetByID(clusterID)
+		if err != nil || cluster == nil {
+			continue
+		}
+		// Another server instance may have rotated the secret since it was listed
+		rotation, err := s.rotate(cluster, rotatedBefore, domain.SecretRotationScheduled, nil)
+		if err != nil {
+			log.Printf("Secret rotation: failed to rotate the secret of cluster %s: %v", cluster.Name, err)
+			continue
+		}
+		if rotation.Status == domain.SecretRotationFailed {
+			log.Printf("Secret rotation: failed to rotate the secret of cluster %s: %s", cluster.Name, rotation.Error)
+		}
+	}
+}
+
+// RotateCluster rotates the secret of one cluster right away
+func (s *SecretRotationService) RotateCluster(clusterID int, rotatedBy *int) (*domain.SecretRotation, error) {
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+	if cluster.SecretRef != "" {
+		return nil, fmt.Errorf("invalid cluster: the secret of cluster %s is managed in an external secret store", cluster.Name)
+	}
+
+	return s.rotate(cluster, time.Now(), domain.SecretRotationManual, rotatedBy)
+}
+
+// RotateClustersJob rotates the secrets of the clusters a secret_rotation job selects, one after the other
+func (s *SecretRotationService) RotateClustersJob(job *JobContext) (interface{}, error) {
+	var payload domain.SecretRotationJobPayload
+	if err := job.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	clusterIDs, err := s.selectClusters(payload.ClusterIDs, payload.TagID)
+	if err != nil {
+		return nil, err
+	}
+	job.SetTotal(len(clusterIDs))
+
+	result := &domain.RotateClusterSecretsResult{Rotations: []*domain.SecretRotation{}}
+	for _, clusterID := range clusterIDs {
+		if job.Cancelled() {
+			return result, ErrJobCancelled
+		}
+
+		rotation, err := s.RotateCluster(clusterID, job.Job.CreatedBy)
+		if err != nil {
+			rotation = &domain.SecretRotation{ClusterID: clusterID, Status: domain.SecretRotationSkipped, Error: err.Error()}
+		}
+		result.Rotations = append(result.Rotations, rotation)
+
+		switch rotation.Status {
+		case domain.SecretRotationSucceeded:
+			result.Succeeded++
+			job.Logf(domain.JobLogInfo, "Rotated the secret of cluster %s", rotation.ClusterName)
+		case domain.SecretRotationFailed:
+			result.Failed++
+			job.Logf(domain.JobLogError, "Failed to rotate the secret of cluster %s: %s", rotation.ClusterName, rotation.Error)
+		default:
+			result.Skipped++
+			job.Logf(domain.JobLogWarn, "Skipped cluster %d: %s", clusterID, rotation.Error)
+		}
+		job.Advance(1)
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("failed to rotate %d of %d secrets", result.Failed, len(clusterIDs))
+	}
+	return result, nil
+}
+
+// ValidateRequest checks that a rotation request selects clusters before it is submitted as a job and returns them
+func (s *SecretRotationService) ValidateRequest(req domain.RotateClusterSecretsRequest) ([]int, error) {
+	return s.selectClusters(req.ClusterIDs, req.TagID)
+}
+
+func (s *SecretRotationService) selectClusters(clusterIDs []int, tagID *int) ([]int, error) {
+	if len(clusterIDs) > 0 {
+		return clusterIDs, nil
+	}
+	if tagID == nil {
+		return nil, fmt.Errorf("invalid request: cluster_ids or tag_id is required")
+	}
+
+	tag, err := s.tagRepo.GetByID(*tagID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get environment tag: %w", err)
+	}
+	if tag == nil {
+		return nil, fmt.Errorf("environment tag not found")
+	}
+	ids, err := s.tagRepo.GetClusterIDsByTagID(*tagID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clusters of tag %s: %w", tag.Name, err)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("invalid request: no clusters have the tag %s", tag.Name)
+	}
+	return ids, nil
+}
+
+// rotate regenerates the multi-manage client secret with a token of the current one, checks that the new
+// secret gets a token and stores it. The cluster is only claimed for the rotation, no transaction is held
+// while Keycloak is called. Once Keycloak generated a new secret the old one no longer works, so the new
+// secret is kept as pending right away and stored even when the check fails; the rotation is recorded as
+// failed then. A pending secret an earlier rotation could not store is stored first if it still works.
+func (s *SecretRotationService) rotate(cluster *domain.Cluster, rotatedBefore time.Time, trigger string, rotatedBy *int) (*domain.SecretRotation, error) {
+	rotation := &domain.SecretRotation{
+		ClusterID:   cluster.ID,
+		ClusterName: cluster.Name,
+		ClientID:    cluster.ClientID,
+		Trigger:     trigger,
+		RotatedBy:   rotatedBy,
+	}
+
+	current, pending, claimed, err := s.rotationRepo.ClaimClientSecret(cluster.ID, rotatedBefore, secretRotationLease)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim secret for rotation: %w", err)
+	}
+	if !claimed {
+		rotation.Status = domain.SecretRotationSkipped
+		rotation.Error = "the secret is being rotated or was rotated in the meantime"
+		return rotation, nil
+	}
+
+	var secret string
+	if pending != "" && s.verify(cluster, pending) == nil {
+		secret = pending
+	} else {
+		secret, err = s.regenerate(cluster, current)
+	}
+	rotation.Status = domain.SecretRotationSucceeded
+	if err != nil {
+		rotation.Status = domain.SecretRotationFailed
+		rotation.Error = err.Error()
+	}
+
+	if err := s.rotationRepo.CompleteClientSecretRotation(cluster.ID, secret, rotation); err != nil {
+		if secret != "" {
+			return nil, fmt.Errorf("failed to store rotated secret, it is kept as pending and stored by the next rotation: %w", err)
+		}
+		return nil, fmt.Errorf("failed to record rotation: %w", err)
+	}
+	return rotation, nil
+}
+
+func (s *SecretRotationService) regenerate(cluster *domain.Cluster, currentSecret string) (string, error) {
+	tokenResp, err := s.keycloakClient.GetCachedClientCredentialsToken(cluster.BaseURL, cluster.Realm, cluster.ClientID, currentSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	secret, err := s.keycloakClient.RegenerateClientSecret(cluster.BaseURL, cluster.Realm, tokenResp.AccessToken, cluster.ClientID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.rotationRepo.SetPendingClientSecret(cluster.ID, secret); err != nil {
+		log.Printf("Secret rotation: failed to keep the new secret of cluster %s as pending: %v", cluster.Name, err)
+	}
+
+	if err := s.verify(cluster, secret); err != nil {
+		return secret, fmt.Errorf("new secret was stored but no token could be obtained with it: %w", err)
+	}
+	return secret, nil
+}
+
+// verify checks that a secret gets a token, giving Keycloak nodes time to see a new secret
+func (s *SecretRotationService) verify(cluster *domain.Cluster, secret string) error {
+	for attempt := 1; ; attempt++ {
+		_, err := s.keycloakClient.GetClientCredentialsToken(cluster.BaseURL, cluster.Realm, cluster.ClientID, secret)
+		if err == nil || attempt == secretVerifyAttempts {
+			return err
+		}
+		time.Sleep(secretVerifyDelay)
+	}
+}
+
+// RotateClientSecret regenerates the secret of an application client on one cluster and delivers it to the
+// requested sink. The secret is never returned; a failed delivery can be repeated with DeliverOnly.
+func (s *SecretRotationService) RotateClientSecret(clusterID int, req domain.RotateClientSecretRequest, rotatedBy *int) (*domain.SecretRotation, error) {
+	sink, err := s.sink(req)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+	if req.ClientID == cluster.ClientID {
+		return nil, fmt.Errorf("invalid client_id: the %s client is rotated with /clusters/:id/rotate-secret", cluster.ClientID)
+	}
+
+	rotation := &domain.SecretRotation{
+		ClusterID:   cluster.ID,
+		ClusterName: cluster.Name,
+		ClientID:    req.ClientID,
+		Status:      domain.SecretRotationSucceeded,
+		Trigger:     domain.SecretRotationManual,
+		RotatedBy:   rotatedBy,
+		Sink:        req.Sink,
+	}
+	if err := s.rotateClient(cluster, req, sink, rotation); err != nil {
+		rotation.Status = domain.SecretRotationFailed
+		rotation.Error = err.Error()
+	}
+
+	if err := s.rotationRepo.Create(rotation); err != nil {
+		return nil, fmt.Errorf("failed to record rotation: %w", err)
+	}
+	return rotation, nil
+}
+
+// RotateClientSecretsJob rotates the secret of an application client on the clusters a client_secret_rotation job selects
+func (s *SecretRotationService) RotateClientSecretsJob(job *JobContext) (interface{}, error) {
+	var payload domain.ClientSecretRotationJobPayload
+	if err := job.Decode(&payload); err != nil {
+		return nil, err
+	}
+	req := domain.RotateClientSecretRequest{
+		ClientID:           payload.ClientID,
+		Sink:               payload.Sink,
+		InvalidatePrevious: payload.InvalidatePrevious,
+		DeliverOnly:        payload.DeliverOnly,
+	}
+
+	clusterIDs, err := s.selectClusters(payload.ClusterIDs, payload.TagID)
+	if err != nil {
+		return nil, err
+	}
+	job.SetTotal(len(clusterIDs))
+
+	result := &domain.RotateClusterSecretsResult{Rotations: []*domain.SecretRotation{}}
+	for _, clusterID := range clusterIDs {
+		if job.Cancelled() {
+			return result, ErrJobCancelled
+		}
+
+		rotation, err := s.RotateClientSecret(clusterID, req, job.Job.CreatedBy)
+		if err != nil {
+			rotation = &domain.SecretRotation{ClusterID: clusterID, ClientID: req.ClientID, Status: domain.SecretRotationSkipped, Error: err.Error()}
+		}
+		result.Rotations = append(result.Rotations, rotation)
+
+		switch rotation.Status {
+		case domain.SecretRotationSucceeded:
+			result.Succeeded++
+			job.Logf(domain.JobLogInfo, "Delivered the secret of client %s on cluster %s to %s", req.ClientID, rotation.ClusterName, req.Sink)
+		case domain.SecretRotationFailed:
+			result.Failed++
+			job.Logf(domain.JobLogError, "Failed to rotate the secret of client %s on cluster %s: %s", req.ClientID, rotation.ClusterName, rotation.Error)
+		default:
+			result.Skipped++
+			job.Logf(domain.JobLogWarn, "Skipped cluster %d: %s", clusterID, rotation.Error)
+		}
+		job.Advance(1)
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("failed to rotate %d of %d secrets", result.Failed, len(clusterIDs))
+	}
+	return result, nil
+}
+
+// ValidateClientRequest checks a request to rotate an application client secret on several clusters before
+// it is submitted as a job and returns the clusters it selects
+func (s *SecretRotationService) ValidateClientRequest(req domain.RotateClientSecretRequest) ([]int, error) {
+	if _, err := s.sink(req); err != nil {
+		return nil, err
+	}
+	return s.selectClusters(req.ClusterIDs, req.TagID)
+}
+
+func (s *SecretRotationService) sink(req domain.RotateClientSecretRequest) (secrets.Sink, error) {
+	if req.ClientID == "" {
+		return nil, fmt.Errorf("invalid request: client_id is required")
+	}
+	if req.Sink == "" {
+		return nil, fmt.Errorf("invalid request: sink is required, rotated secrets are only delivered to a sink")
+	}
+	sink, ok := s.sinks[req.Sink]
+	if !ok {
+		return nil, fmt.Errorf("invalid sink %s: not configured on the server", req.Sink)
+	}
+	return sink, nil
+}
+
+// rotateClient regenerates an application client's secret (or reads it with DeliverOnly) and delivers it.
+// With a client-secret rotation policy Keycloak keeps accepting the previous secret for a grace period,
+// so applications can switch over without downtime; InvalidatePrevious ends it after the delivery.
+func (s *SecretRotationService) rotateClient(cluster *domain.Cluster, req domain.RotateClientSecretRequest, sink secrets.Sink, rotation *domain.SecretRotation) error {
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+	accessToken := tokenResp.AccessToken
+
+	var secret string
+	if req.DeliverOnly {
+		secret, err = s.keycloakClient.GetCurrentClientSecret(cluster.BaseURL, cluster.Realm, accessToken, req.ClientID)
+	} else {
+		secret, err = s.keycloakClient.RegenerateClientSecret(cluster.BaseURL, cluster.Realm, accessToken, req.ClientID)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Without a rotation policy, or before Keycloak 22, the previous secret stopped working already
+	expiresAt, err := s.keycloakClient.GetRotatedClientSecretExpiry(cluster.BaseURL, cluster.Realm, accessToken, req.ClientID)
+	if err != nil {
+		log.Printf("Secret rotation: failed to read the rotated secret of client %s on cluster %s: %v", req.ClientID, cluster.Name, err)
+	}
+	rotation.PreviousSecretExpiresAt = expiresAt
+
+	delivery := &secrets.Delivery{
+		Cluster:                 cluster.Name,
+		Realm:                   cluster.Realm,
+		ClientID:                req.ClientID,
+		Secret:                  secret,
+		RotatedAt:               time.Now(),
+		PreviousSecretExpiresAt: expiresAt,
+	}
+	if err := deliverSecret(sink, delivery); err != nil {
+		if req.DeliverOnly {
+			return fmt.Errorf("failed to deliver secret to %s: %w", req.Sink, err)
+		}
+		return fmt.Errorf("secret was regenerated but not delivered to %s, deliver it again with deliver_only: %w", req.Sink, err)
+	}
+
+	if req.InvalidatePrevious && expiresAt != nil {
+		if err := s.keycloakClient.InvalidateRotatedClientSecret(cluster.BaseURL, cluster.Realm, accessToken, req.ClientID); err != nil {
+			return fmt.Errorf("secret was delivered but the previous secret could not be invalidated: %w", err)
+		}
+		rotation.PreviousSecretExpiresAt = nil
+	}
+	return nil
+}
+
+func deliverSecret(sink secrets.Sink, delivery *secrets.Delivery) error {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), secretDeliveryTimeout)
+		err := sink.Deliver(ctx, delivery)
+		cancel()
+		if err == nil || attempt == secretDeliveryAttempts {
+			return err
+		}
+		time.Sleep(secretVerifyDelay)
+	}
+}
+
+// GetRotations lists the latest rotations, optionally of one cluster and one client
+func (s *SecretRotationService) GetRotations(clusterID int, clientID string, limit int) ([]*domain.SecretRotation, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	return s.rotationRepo.GetRotations(clusterID, clientID, limit)
+}
@@ -0,0 +1,370 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"keycloak-multi-manage/internal/client/keycloak"
+	"keycloak-multi-manage/internal/domain"
+	"keycloak-multi-manage/internal/repository/postgres"
+	"log"
+	"strings"
+	"time"
+)
+
+type SnapshotService struct {
+	snapshotRepo   *postgres.SnapshotRepository
+	clusterRepo    *postgres.ClusterRepository
+	keycloakClient *keycloak.Client
+}
+
+func NewSnapshotService(snapshotRepo *postgres.SnapshotRepository, clusterRepo *postgres.ClusterRepository) *SnapshotService {
+	return &SnapshotService{
+		snapshotRepo:   snapshotRepo,
+		clusterRepo:    clusterRepo,
+		keycloakClient: keycloak.NewClient(),
+	}
+}
+
+// StartScheduler snapshots every registered cluster whose latest snapshot is older than interval.
+// Clusters are checked every 10 minutes (or every interval when it is shorter).
+func (s *SnapshotService) StartScheduler(interval time.Duration) {
+	tick := 10 * time.Minute
+	if interval < tick {
+		tick = interval
+	}
+
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			s.snapshotDueClusters(interval)
+			<-ticker.C
+		}
+	}()
+}
+
+func (s *SnapshotService) snapshotDueClusters(interval time.Duration) {
+	clusters, err := s.clusterRepo.GetAll()
+	if err != nil {
+		log.Printf("Snapshots: failed to get clusters: %v", err)
+		return
+	}
+
+	for _, cluster := range clusters {
+		latest, err := s.snapshotRepo.GetAt(cluster.ID, time.Now())
+		if err != nil {
+			log.Printf("Snapshots: failed to get latest snapshot of cluster %s: %v", cluster.Name, err)
+			continue
+		}
+		if latest != nil && time.Since(latest.LastVerifiedAt) < interval {
+			continue
+		}
+
+		if _, err := s.TakeSnapshot(cluster.ID, domain.SnapshotTriggerScheduled, nil); err != nil {
+			log.Printf("Snapshots: failed to snapshot cluster %s: %v", cluster.Name, err)
+		}
+	}
+}
+
+// TakeSnapshot exports the realm of a cluster and stores it as a new version.
+// When nothing changed since the latest snapshot, that snapshot is marked as verified instead.
+func (s *SnapshotService) TakeSnapshot(clusterID int, trigger string, createdBy *int) (*domain.TakeSnapshotResult, error) {
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster not found")
+	}
+
+	content, err := s.exportContent(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	sectionData := map[string]interface{}{
+		domain.SnapshotSectionRealm:         content.Realm,
+		domain.SnapshotSectionRoles:         content.Roles,
+		domain.SnapshotSectionClients:       content.Clients,
+		domain.SnapshotSectionClientExports: content.ClientExports,
+		domain.SnapshotSectionGroups:        content.Groups,
+		domain.SnapshotSectionUsers:         content.Users,
+	}
+
+	snapshot := &domain.RealmSnapshot{
+		ClusterID: cluster.ID,
+		Realm:     cluster.Realm,
+		Sections:  make(map[string]string, len(sectionData)),
+		Counts: map[string]int{
+			domain.SnapshotSectionRoles:   len(content.Roles),
+			domain.SnapshotSectionClients: len(content.Clients),
+			domain.SnapshotSectionGroups:  len(content.Groups),
+			domain.SnapshotSectionUsers:   len(content.Users),
+		},
+		Trigger:   trigger,
+		CreatedBy: createdBy,
+	}
+
+	blobs := make(map[string][]byte, len(sectionData))
+	var hashes []string
+	for _, section := range domain.SnapshotSections {
+		data, err := json.Marshal(sectionData[section])
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal snapshot section %s: %w", section, err)
+		}
+		// Client secrets and other credentials are never stored, so they are masked before hashing
+		if data, err = maskSecrets(data); err != nil {
+			return nil, fmt.Errorf("failed to mask secrets of snapshot section %s: %w", section, err)
+		}
+		hash := sha256Hex(data)
+		snapshot.Sections[section] = hash
+		blobs[hash] = data
+		hashes = append(hashes, section+"="+hash)
+	}
+	snapshot.ContentHash = sha256Hex([]byte(strings.Join(hashes, "\n")))
+
+	latest, err := s.snapshotRepo.GetAt(cluster.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && latest.ContentHash == snapshot.ContentHash && latest.Realm == snapshot.Realm {
+		now := time.Now()
+		if err := s.snapshotRepo.MarkVerified(latest.ID, now); err != nil {
+			return nil, err
+		}
+		latest.LastVerifiedAt = now
+		return &domain.TakeSnapshotResult{Snapshot: latest, Created: false}, nil
+	}
+
+	for hash, data := range blobs {
+		compressed, err := gzipBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.snapshotRepo.SaveBlob(hash, compressed, len(data)); err != nil {
+			return nil, fmt.Errorf("failed to save snapshot content: %w", err)
+		}
+	}
+
+	if err := s.snapshotRepo.Create(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	return &domain.TakeSnapshotResult{Snapshot: snapshot, Created: true}, nil
+}
+
+// ListSnapshots lists snapshots newest first; clusterID 0 lists all clusters
+func (s *SnapshotService) ListSnapshots(clusterID, limit int) ([]*domain.RealmSnapshot, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return s.snapshotRepo.GetRecent(clusterID, limit)
+}
+
+func (s *SnapshotService) GetSnapshot(id int) (*domain.RealmSnapshot, error) {
+	return s.snapshotRepo.GetByID(id)
+}
+
+// GetSnapshotAt returns the snapshot that describes a cluster's realm at the given time
+func (s *SnapshotService) GetSnapshotAt(clusterID int, at time.Time) (*domain.RealmSnapshot, error) {
+	return s.snapshotRepo.GetAt(clusterID, at)
+}
+
+// GetSnapshotContent loads the given sections of a snapshot; no sections loads all of them
+func (s *SnapshotService) GetSnapshotContent(snapshot *domain.RealmSnapshot, sections []string) (*domain.RealmSnapshotContent, error) {
+	if len(sections) == 0 {
+		sections = domain.SnapshotSections
+	}
+
+	content := &domain.RealmSnapshotContent{}
+	for _, section := range sections {
+		data, err := s.GetSnapshotSection(snapshot, section)
+		if err != nil {
+			return nil, err
+		}
+
+		var target interface{}
+		switch section {
+		case domain.SnapshotSectionRealm:
+			target = &content.Realm
+		case domain.SnapshotSectionRoles:
+			target = &content.Roles
+		case domain.SnapshotSectionClients:
+			target = &content.Clients
+		case domain.SnapshotSectionClientExports:
+			target = &content.ClientExports
+		case domain.SnapshotSectionGroups:
+			target = &content.Groups
+		case domain.SnapshotSectionUsers:
+			target = &content.Users
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			return nil, fmt.Errorf("failed to decode snapshot section %s: %w", section, err)
+		}
+	}
+
+	return content, nil
+}
+
+// GetSnapshotSection returns the raw JSON of one section with its secrets masked.
+// Snapshots taken before secrets were masked at capture are masked when they are read.
+func (s *SnapshotService) GetSnapshotSection(snapshot *domain.RealmSnapshot, section string) ([]byte, error) {
+	hash, ok := snapshot.Sections[section]
+	if !ok {
+		return nil, fmt.Errorf("unknown snapshot section: %s", section)
+	}
+
+	compressed, err := s.snapshotRepo.GetBlob(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot section %s: %w", section, err)
+	}
+	if compressed == nil {
+		return nil, fmt.Errorf("snapshot content %s is missing", hash)
+	}
+
+	data, err := gunzipBytes(compressed)
+	if err != nil {
+		return nil, err
+	}
+	return maskSecrets(data)
+}
+
+// DeleteSnapshot removes a snapshot and the content no other snapshot shares
+func (s *SnapshotService) DeleteSnapshot(id int) error {
+	if err := s.snapshotRepo.Delete(id); err != nil {
+		return err
+	}
+	if _, err := s.snapshotRepo.DeleteUnreferencedBlobs(); err != nil {
+		return fmt.Errorf("failed to delete unreferenced snapshot content: %w", err)
+	}
+	return nil
+}
+
+func (s *SnapshotService) exportContent(cluster *domain.Cluster) (*domain.RealmSnapshotContent, error) {
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+	token := tokenResp.AccessToken
+
+	content := &domain.RealmSnapshotContent{}
+	if content.Realm, err = s.keycloakClient.ExportRealm(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return nil, fmt.Errorf("failed to export realm: %w", err)
+	}
+	if content.Roles, err = s.keycloakClient.GetRoleDetails(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+	if content.Clients, err = s.keycloakClient.GetClientDetails(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return nil, fmt.Errorf("failed to get clients: %w", err)
+	}
+	if content.ClientExports, err = s.keycloakClient.ExportClients(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return nil, fmt.Errorf("failed to export clients: %w", err)
+	}
+	if content.Groups, err = s.keycloakClient.GetGroupDetails(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+	if content.Users, err = s.keycloakClient.GetUserDetails(cluster.BaseURL, cluster.Realm, token); err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	return content, nil
+}
+
+// maskSecrets replaces the values of secret fields in a JSON document with the masked placeholder.
+// It masks the same fields as the git export, and only replaces strings, so the document still
+// decodes into its types.
+func maskSecrets(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(maskSecretFields(value))
+}
+
+// maskContentSecrets masks the secrets of loaded content the way stored snapshots are masked
+func maskContentSecrets(content *domain.RealmSnapshotContent) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	if data, err = maskSecrets(data); err != nil {
+		return err
+	}
+	var masked domain.RealmSnapshotContent
+	if err := json.Unmarshal(data, &masked); err != nil {
+		return err
+	}
+	*content = masked
+	return nil
+}
+
+func maskSecretFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSecretKey(key) {
+				v[key] = maskSecretValue(item)
+			} else {
+				v[key] = maskSecretFields(item)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = maskSecretFields(v[i])
+		}
+	}
+	return value
+}
+
+// maskSecretValue masks every non-empty string of a secret field's value, e.g. each value of an attribute
+func maskSecretValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			return maskedSecret
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = maskSecretValue(item)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = maskSecretValue(v[i])
+		}
+	}
+	return value
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBytes(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"keycloak-multi-manage/internal/domain"
+)
+
+func TestMaskSecrets(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{
+			name:  "client secret",
+			value: `[{"clientId": "app", "secret": "s3cret", "publicClient": false}]`,
+			want:  `[{"clientId": "app", "secret": "**********", "publicClient": false}]`,
+		},
+		{
+			name:  "nested secrets in any case",
+			value: `{"identityProviders": [{"alias": "google", "config": {"clientSecret": "g", "clientId": "id"}}], "smtpServer": {"password": "p"}}`,
+			want:  `{"identityProviders": [{"alias": "google", "config": {"clientSecret": "**********", "clientId": "id"}}], "smtpServer": {"password": "**********"}}`,
+		},
+		{
+			name:  "attribute values keep their list",
+			value: `[{"name": "svc", "attributes": {"apiSecret": ["a", "b"], "team": ["x"]}}]`,
+			want:  `[{"name": "svc", "attributes": {"apiSecret": ["**********", "**********"], "team": ["x"]}}]`,
+		},
+		{
+			name:  "structured credentials",
+			value: `[{"username": "a", "credentials": [{"type": "password", "value": "p", "temporary": false}]}]`,
+			want:  `[{"username": "a", "credentials": [{"type": "**********", "value": "**********", "temporary": false}]}]`,
+		},
+		{
+			name:  "empty and missing secrets",
+			value: `[{"clientId": "web", "secret": "", "bindCredential": null}]`,
+			want:  `[{"clientId": "web", "secret": "", "bindCredential": null}]`,
+		},
+		{
+			name:  "large numbers",
+			value: `{"createdTimestamp": 1712345678901, "notBefore": 0}`,
+			want:  `{"createdTimestamp": 1712345678901, "notBefore": 0}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := maskSecrets([]byte(tt.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotValue, wantValue interface{}
+			json.Unmarshal(got, &gotValue)
+			json.Unmarshal([]byte(tt.want), &wantValue)
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("maskSecrets = %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := maskSecrets([]byte("{")); err == nil {
+		t.Error("maskSecrets accepted invalid JSON")
+	}
+}
+
+// Masked content still decodes into its types and holds no secret
+func TestMaskContentSecrets(t *testing.T) {
+	content := &domain.RealmSnapshotContent{
+		Roles:         []domain.Role{{Name: "r", Attributes: map[string][]string{"signingSecret": {"k"}}}},
+		ClientExports: []map[string]interface{}{{"clientId": "app", "secret": "s3cret"}},
+		Users:         []domain.UserDetail{{Username: "alice", Attributes: map[string][]string{"dept": {"it"}}}},
+	}
+	if err := maskContentSecrets(content); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(content)
+	if strings.Contains(string(data), "s3cret") || strings.Contains(string(data), `"k"`) {
+		t.Fatalf("masked content still holds a secret: %s", data)
+	}
+	if content.Roles[0].Attributes["signingSecret"][0] != maskedSecret || content.Users[0].Attributes["dept"][0] != "it" {
+		t.Errorf("content = %+v", content)
+	}
+}
+
+func TestKeepMaskedAttributes(t *testing.T) {
+	tests := []struct {
+		name   string
+		source map[string][]string
+		dest   map[string][]string
+		want   map[string][]string
+	}{
+		{"no masked values", map[string][]string{"a": {"1"}}, map[string][]string{"a": {"2"}}, map[string][]string{"a": {"1"}}},
+		{"destination value kept", map[string][]string{"a": {"1"}, "key": {maskedSecret}}, map[string][]string{"key": {"real"}}, map[string][]string{"a": {"1"}, "key": {"real"}}},
+		{"left out without a destination value", map[string][]string{"a": {"1"}, "key": {maskedSecret}}, nil, map[string][]string{"a": {"1"}}},
+		{"nil", nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := make(map[string][]string)
+			for k, v := range tt.source {
+				source[k] = v
+			}
+			got := keepMaskedAttributes(tt.source, tt.dest)
+			if len(got) != len(tt.want) || (len(tt.want) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("keepMaskedAttributes = %v, want %v", got, tt.want)
+			}
+			if len(tt.source) > 0 && !reflect.DeepEqual(tt.source, source) {
+				t.Error("keepMaskedAttributes changed the source attributes")
+			}
+		})
+	}
+}
+
+// Restoring a confidential client from a snapshot neither pushes the placeholder nor copies a secret
+func TestPlanClientSkipsMaskedValues(t *testing.T) {
+	p := testPlanner(nil)
+	p.source.clients = []map[string]interface{}{{
+		"clientId":   "app",
+		"secret":     maskedSecret,
+		"attributes": map[string]interface{}{"tls.client.certificate.secret": maskedSecret, "pkce.code.challenge.method": "S256"},
+	}}
+	p.source.clientDetails = []domain.ClientDetail{{ClientID: "app"}}
+
+	if err := p.plan("client", "app", ""); err != nil {
+		t.Fatal(err)
+	}
+	step := p.steps[0]
+	if step.CopySecret {
+		t.Error("step copies a masked secret")
+	}
+	want := map[string]interface{}{"pkce.code.challenge.method": "S256"}
+	if !reflect.DeepEqual(step.Payload["attributes"], want) {
+		t.Errorf("attributes = %v, want %v", step.Payload["attributes"], want)
+	}
+	if _, ok := p.source.clients[0]["attributes"].(map[string]interface{})["tls.client.certificate.secret"]; !ok {
+		t.Error("planning changed the snapshot content")
+	}
+}
@@ -0,0 +1,385 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+)
+
+// executeSteps runs plan steps in order against the destination cluster.
+// A failed optional step is recorded and execution continues; a failed required step
+// stops the run and the remaining steps are reported as skipped.
+// Every step is sent with the cluster's current token, so long plans outlive a single token.
+// The source is only read for client secrets, which plans never store; it may be nil if no step copies one.
+func (s *SyncService) executeSteps(source, dest *syncTarget, steps []domain.SyncPlanStep) ([]domain.SyncStepResult, bool) {
+	results := make([]domain.SyncStepResult, 0, len(steps))
+
+	for i, step := range steps {
+		result := domain.SyncStepResult{
+			Order:  step.Order,
+			Action: step.Action,
+			Target: step.Target,
+			Status: "success",
+		}
+
+		err := s.refreshToken(dest)
+		if err == nil {
+			err = s.executeStep(source, dest, step)
+		}
+		if err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+			results = append(results, result)
+
+			if step.Optional {
+				fmt.Printf("Warning: sync step %d (%s %s) failed: %v\n", step.Order, step.Action, step.Target, err)
+				continue
+			}
+
+			results = append(results, skipSteps(steps[i+1:], fmt.Sprintf("step %d failed", step.Order))...)
+			return results, false
+		}
+
+		results = append(results, result)
+	}
+
+	return results, true
+}
+
+func (s *SyncService) executeStep(source, dest *syncTarget, step domain.SyncPlanStep) error {
+	baseURL := dest.cluster.BaseURL
+	realm := dest.cluster.Realm
+
+	switch step.Action {
+	case domain.SyncActionCreateRole:
+		var role domain.Role
+		if err := decodePayload(step.Payload, &role); err != nil {
+			return err
+		}
+		return s.keycloakClient.CreateRole(baseURL, realm, dest.token, role)
+
+	case domain.SyncActionUpdateRole:
+		var role domain.Role
+		if err := decodePayload(step.Payload, &role); err != nil {
+			return err
+		}
+		return s.keycloakClient.UpdateRole(baseURL, realm, dest.token, step.Target, role)
+
+	case domain.SyncActionAddRoleComposites, domain.SyncActionRemoveRoleComposites:
+		var composites domain.RoleComposites
+		if err := decodePayload(step.Payload, &composites); err != nil {
+			return err
+		}
+		if step.Action == domain.SyncActionRemoveRoleComposites {
+			return s.keycloakClient.RemoveRoleComposites(baseURL, realm, dest.token, step.Target, composites)
+		}
+		return s.keycloakClient.AddRoleComposites(baseURL, realm, dest.token, step.Target, composites)
+
+	case domain.SyncActionImportClient:
+		// The reviewed request is sent as planned: a POST creates the client, a PUT updates the
+		// client the planner found in the destination
+		client := make(map[string]interface{}, len(step.Payload)+1)
+		for k, v := range step.Payload {
+			if k != "id" {
+				client[k] = v
+			}
+		}
+		if step.CopySecret {
+			secret, err := s.sourceClientSecret(source, step.Target)
+			if err != nil {
+				return err
+			}
+			client["secret"] = secret
+		}
+
+		switch step.Method {
+		case "POST":
+			return s.keycloakClient.SendJSON(step.Method, baseURL, step.Path, dest.token, client, fmt.Sprintf("create client '%s'", step.Target))
+		case "PUT":
+			return s.keycloakClient.SendJSON(step.Method, baseURL, step.Path, dest.token, client, fmt.Sprintf("update client '%s'", step.Target))
+		}
+		return fmt.Errorf("unsupported method %s for client '%s'", step.Method, step.Target)
+
+	case domain.SyncActionCreateClientRole:
+		clientUUID, err := s.findClientUUID(dest, step.Parent)
+		if err != nil {
+			return err
+		}
+		var role domain.Role
+		if err := decodePayload(step.Payload, &role); err != nil {
+			return err
+		}
+		return s.keycloakClient.CreateClientRole(baseURL, realm, dest.token, clientUUID, role)
+
+	case domain.SyncActionAddClientRoleComposites:
+		clientUUID, err := s.findClientUUID(dest, step.Parent)
+		if err != nil {
+			return err
+		}
+		var composites domain.RoleComposites
+		if err := decodePayload(step.Payload, &composites); err != nil {
+			return err
+		}
+		return s.keycloakClient.AddClientRoleComposites(baseURL, realm, dest.token, clientUUID, step.Target, composites)
+
+	case domain.SyncActionCreateClientScope:
+		return s.keycloakClient.CreateClientScope(baseURL, realm, dest.token, step.Payload)
+
+	case domain.SyncActionCreateScopeMapper:
+		scope, err := s.keycloakClient.GetClientScopeDetails(baseURL, realm, dest.token, step.Parent)
+		if err != nil {
+			return fmt.Errorf("failed to find client scope '%s': %w", step.Parent, err)
+		}
+		scopeID, _ := scope["id"].(string)
+		if scopeID == "" {
+			return fmt.Errorf("client scope '%s' has no ID", step.Parent)
+		}
+		return s.keycloakClient.CreateClientScopeMapper(baseURL, realm, dest.token, scopeID, step.Payload)
+
+	case domain.SyncActionAssignClientScopes:
+		clientUUID, err := s.findClientUUID(dest, step.Parent)
+		if err != nil {
+			return err
+		}
+		var payload struct {
+			Scopes []string `json:"scopes"`
+		}
+		if err := decodePayload(step.Payload, &payload); err != nil {
+			return err
+		}
+		return s.keycloakClient.UpdateClientScopes(baseURL, realm, dest.token, clientUUID, step.Target, payload.Scopes)
+
+	case domain.SyncActionCreateGroup:
+		var group domain.GroupDetail
+		if err := decodePayload(step.Payload, &group); err != nil {
+			return err
+		}
+		parentPath := parentGroupPath(group.Path)
+		if parentPath == "" {
+			return s.keycloakClient.CreateGroup(baseURL, realm, dest.token, group)
+		}
+		parentID, err := s.keycloakClient.FindGroupIDByPath(baseURL, realm, dest.token, parentPath)
+		if err != nil {
+			return err
+		}
+		if parentID == "" {
+			return fmt.Errorf("parent group '%s' not found in destination cluster", parentPath)
+		}
+		return s.keycloakClient.CreateChildGroup(baseURL, realm, dest.token, parentID, group)
+
+	case domain.SyncActionUpdateGroup, domain.SyncActionAssignGroupRoles, domain.SyncActionAssignGroupClientRoles,
+		domain.SyncActionRemoveGroupRoles, domain.SyncActionRemoveGroupClientRoles:
+		groupID, err := s.keycloakClient.FindGroupIDByPath(baseURL, realm, dest.token, step.Target)
+		if err != nil {
+			return err
+		}
+		if groupID == "" {
+			return fmt.Errorf("group '%s' not found in destination cluster", step.Target)
+		}
+
+		if step.Action == domain.SyncActionUpdateGroup {
+			var group domain.GroupDetail
+			if err := decodePayload(step.Payload, &group); err != nil {
+				return err
+			}
+			return s.keycloakClient.UpdateGroup(baseURL, realm, dest.token, groupID, group)
+		}
+
+		roles, err := decodeRoleNames(step.Payload)
+		if err != nil {
+			return err
+		}
+		switch step.Action {
+		case domain.SyncActionAssignGroupRoles:
+			return s.keycloakClient.AssignRealmRolesToGroup(baseURL, realm, dest.token, groupID, roles)
+		case domain.SyncActionRemoveGroupRoles:
+			return s.keycloakClient.RemoveRealmRolesFromGroup(baseURL, realm, dest.token, groupID, roles)
+		case domain.SyncActionRemoveGroupClientRoles:
+			return s.keycloakClient.RemoveClientRolesFromGroup(baseURL, realm, dest.token, groupID, step.Parent, roles)
+		}
+		return s.keycloakClient.AssignClientRolesToGroup(baseURL, realm, dest.token, groupID, map[string][]string{step.Parent: roles})
+
+	case domain.SyncActionDeleteRole:
+		return s.keycloakClient.DeleteRole(baseURL, realm, dest.token, step.Target)
+
+	case domain.SyncActionDeleteClient:
+		clientUUID, err := s.findClientUUID(dest, step.Target)
+		if err != nil {
+			return err
+		}
+		delete(dest.clientUUIDs, step.Target)
+		return s.keycloakClient.DeleteClient(baseURL, realm, dest.token, clientUUID)
+
+	case domain.SyncActionDeleteGroup:
+		groupID, err := s.keycloakClient.FindGroupIDByPath(baseURL, realm, dest.token, step.Target)
+		if err != nil {
+			return err
+		}
+		if groupID == "" {
+			// Already removed, e.g. together with a deleted parent group
+			return nil
+		}
+		return s.keycloakClient.DeleteGroup(baseURL, realm, dest.token, groupID)
+
+	case domain.SyncActionCreateUser:
+		var user domain.UserDetail
+		if err := decodePayload(step.Payload, &user); err != nil {
+			return err
+		}
+		return s.keycloakClient.CreateUser(baseURL, realm, dest.token, user)
+
+	case domain.SyncActionUpdateUser, domain.SyncActionAssignUserRoles, domain.SyncActionAssignUserClientRoles,
+		domain.SyncActionRemoveUserRoles, domain.SyncActionRemoveUserClientRoles,
+		domain.SyncActionAddUserToGroup, domain.SyncActionRemoveUserFromGroup:
+		userID, err := s.keycloakClient.FindUserIDByUsername(baseURL, realm, dest.token, step.Target)
+		if err != nil {
+			return err
+		}
+		if userID == "" {
+			return fmt.Errorf("user '%s' not found in destination cluster", step.Target)
+		}
+
+		switch step.Action {
+		case domain.SyncActionUpdateUser:
+			var user domain.UserDetail
+			if err := decodePayload(step.Payload, &user); err != nil {
+				return err
+			}
+			return s.keycloakClient.UpdateUser(baseURL, realm, dest.token, userID, user)
+
+		case domain.SyncActionAddUserToGroup, domain.SyncActionRemoveUserFromGroup:
+			groupID, err := s.keycloakClient.FindGroupIDByPath(baseURL, realm, dest.token, step.Parent)
+			if err != nil {
+				return err
+			}
+			if groupID == "" {
+				return fmt.Errorf("group '%s' not found in destination cluster", step.Parent)
+			}
+			if step.Action == domain.SyncActionRemoveUserFromGroup {
+				return s.keycloakClient.RemoveUserFromGroup(baseURL, realm, dest.token, userID, groupID)
+			}
+			return s.keycloakClient.AddUserToGroup(baseURL, realm, dest.token, userID, groupID)
+		}
+
+		roles, err := decodeRoleNames(step.Payload)
+		if err != nil {
+			return err
+		}
+		switch step.Action {
+		case domain.SyncActionAssignUserRoles:
+			return s.keycloakClient.AssignRealmRolesToUser(baseURL, realm, dest.token, userID, roles)
+		case domain.SyncActionRemoveUserRoles:
+			return s.keycloakClient.RemoveRealmRolesFromUser(baseURL, realm, dest.token, userID, roles)
+		case domain.SyncActionRemoveUserClientRoles:
+			return s.keycloakClient.RemoveClientRolesFromUser(baseURL, realm, dest.token, userID, step.Parent, roles)
+		}
+		return s.keycloakClient.AssignClientRolesToUser(baseURL, realm, dest.token, userID, map[string][]string{step.Parent: roles})
+	}
+
+	return fmt.Errorf("unknown sync action: %s", step.Action)
+}
+
+// findClientUUID resolves a clientId to the internal client ID in the destination cluster.
+// Resolved IDs are kept for the rest of the run, as many steps refer to the same client.
+func (s *SyncService) findClientUUID(dest *syncTarget, clientID string) (string, error) {
+	if uuid, ok := dest.clientUUIDs[clientID]; ok {
+		return uuid, nil
+	}
+
+	uuid, err := s.keycloakClient.FindClientUUID(dest.cluster.BaseURL, dest.cluster.Realm, dest.token, clientID)
+	if err != nil {
+		return "", fmt.Errorf("failed to find client '%s' in destination cluster: %w", clientID, err)
+	}
+	if uuid == "" {
+		return "", fmt.Errorf("client '%s' not found in destination cluster", clientID)
+	}
+
+	if dest.clientUUIDs == nil {
+		dest.clientUUIDs = make(map[string]string)
+	}
+	dest.clientUUIDs[clientID] = uuid
+	return uuid, nil
+}
+
+// sourceClientSecret reads the secret of a confidential client from the source at apply time.
+// Preloaded sources (desired-state documents) hold it in their client representations.
+func (s *SyncService) sourceClientSecret(source *syncTarget, clientID string) (string, error) {
+	if source == nil {
+		return "", fmt.Errorf("the secret of client '%s' can only be copied from a source cluster", clientID)
+	}
+
+	if source.preloaded {
+		for _, client := range source.clients {
+			if id, _ := client["clientId"].(string); id == clientID {
+				if secret, _ := client["secret"].(string); secret != "" && secret != maskedSecret {
+					return secret, nil
+				}
+				break
+			}
+		}
+		return "", fmt.Errorf("source has no secret for client '%s'", clientID)
+	}
+
+	if err := s.refreshToken(source); err != nil {
+		return "", err
+	}
+	uuid, err := s.keycloakClient.FindClientUUID(source.cluster.BaseURL, source.cluster.Realm, source.token, clientID)
+	if err != nil {
+		return "", fmt.Errorf("failed to find client '%s' in source cluster: %w", clientID, err)
+	}
+	if uuid == "" {
+		return "", fmt.Errorf("client '%s' not found in source cluster", clientID)
+	}
+	secret, err := s.keycloakClient.GetClientSecret(source.cluster.BaseURL, source.cluster.Realm, source.token, uuid)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the secret of client '%s' from source cluster: %w", clientID, err)
+	}
+	return secret, nil
+}
+
+// decodePayload converts a step payload back into a typed value
+func decodePayload(payload map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid step payload: %w", err)
+	}
+	return nil
+}
+
+func decodeRoleNames(payload map[string]interface{}) ([]string, error) {
+	var p struct {
+		Roles []string `json:"roles"`
+	}
+	if err := decodePayload(payload, &p); err != nil {
+		return nil, err
+	}
+	return p.Roles, nil
+}
+
+// skipSteps reports every given step as skipped with the same reason
+func skipSteps(steps []domain.SyncPlanStep, reason string) []domain.SyncStepResult {
+	results := make([]domain.SyncStepResult, 0, len(steps))
+	for _, step := range steps {
+		results = append(results, domain.SyncStepResult{
+			Order:  step.Order,
+			Action: step.Action,
+			Target: step.Target,
+			Status: "skipped",
+			Error:  reason,
+		})
+	}
+	return results
+}
+
+// stepError returns the error of the required step that stopped the run, which is the last failed step
+func stepError(results []domain.SyncStepResult) error {
+	for i := len(results) - 1; i >= 0; i-- {
+		if result := results[i]; result.Status == "failed" {
+			return fmt.Errorf("%s %s failed: %s", result.Action, result.Target, result.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"keycloak-multi-manage/internal/client/keycloak"
+	"keycloak-multi-manage/internal/domain"
+)
+
+// recordedRequest is one Admin REST call the executor sent
+type recordedRequest struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+func recordingServer(t *testing.T, status int, requests *[]recordedRequest) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		*requests = append(*requests, recordedRequest{method: r.Method, path: r.URL.Path, body: body})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestExecuteImportClientSendsThePlannedRequest(t *testing.T) {
+	source := &syncTarget{
+		cluster:   &domain.Cluster{Name: "doc", Realm: "r"},
+		preloaded: true,
+		clients:   []map[string]interface{}{{"clientId": "app", "secret": "s3cret"}},
+	}
+
+	tests := []struct {
+		name       string
+		step       domain.SyncPlanStep
+		status     int
+		wantSecret interface{}
+		wantErr    bool
+	}{
+		{
+			name:   "update the planned client",
+			step:   domain.SyncPlanStep{Method: "PUT", Path: "/admin/realms/r/clients/dest-uuid"},
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "create a new client",
+			step:   domain.SyncPlanStep{Method: "POST", Path: "/admin/realms/r/clients"},
+			status: http.StatusCreated,
+		},
+		{
+			name:       "secret read from the source",
+			step:       domain.SyncPlanStep{Method: "PUT", Path: "/admin/realms/r/clients/dest-uuid", CopySecret: true},
+			status:     http.StatusNoContent,
+			wantSecret: "s3cret",
+		},
+		{
+			name:    "existing client on create",
+			step:    domain.SyncPlanStep{Method: "POST", Path: "/admin/realms/r/clients"},
+			status:  http.StatusConflict,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []recordedRequest
+			server := recordingServer(t, tt.status, &requests)
+			s := &SyncService{keycloakClient: keycloak.NewClient()}
+			dest := &syncTarget{cluster: &domain.Cluster{Name: "dest", BaseURL: server.URL, Realm: "r"}, token: "t"}
+
+			step := tt.step
+			step.Action = domain.SyncActionImportClient
+			step.Target = "app"
+			step.Payload = map[string]interface{}{"id": "source-uuid", "clientId": "app"}
+
+			err := s.executeStep(source, dest, step)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// Exactly the planned call, without a lookup or a fallback request
+			if len(requests) != 1 || requests[0].method != step.Method || requests[0].path != step.Path {
+				t.Fatalf("requests = %+v, want one %s %s", requests, step.Method, step.Path)
+			}
+			body := requests[0].body
+			if _, ok := body["id"]; ok {
+				t.Error("request carries the source client id")
+			}
+			if body["secret"] != tt.wantSecret {
+				t.Errorf("secret = %v, want %v", body["secret"], tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestSourceClientSecret(t *testing.T) {
+	s := &SyncService{}
+	document := &syncTarget{
+		preloaded: true,
+		clients:   []map[string]interface{}{{"clientId": "app", "secret": "s3cret"}, {"clientId": "web"}},
+	}
+
+	if secret, err := s.sourceClientSecret(document, "app"); err != nil || secret != "s3cret" {
+		t.Errorf("sourceClientSecret(app) = %q, %v", secret, err)
+	}
+	if _, err := s.sourceClientSecret(document, "web"); err == nil {
+		t.Error("sourceClientSecret returned a secret for a client without one")
+	}
+	// Restore plans have no live source to read the secret from
+	if _, err := s.sourceClientSecret(nil, "app"); err == nil {
+		t.Error("sourceClientSecret succeeded without a source")
+	}
+}
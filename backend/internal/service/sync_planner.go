@@ -0,0 +1,990 @@
+package service
+
+import (
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"sort"
+	"strings"
+)
+
+// syncPlanner computes the ordered steps for one requested object.
+// Objects the request references but the destination lacks (realm roles, clients, client roles,
+// parent groups) are planned first, so every later step can rely on them, and are reported as dependencies.
+type syncPlanner struct {
+	s       *SyncService
+	source  *syncTarget
+	dest    *syncTarget
+	steps   []domain.SyncPlanStep
+	deps    []domain.SyncDependency
+	planned map[string]bool // "type:name" of every object already planned in this run
+
+	// removeExtra also removes role mappings, composites and memberships the source object does not have
+	removeExtra bool
+
+	// Destination state, loaded once per plan
+	destRoles       map[string]bool
+	destClients     map[string]string          // clientId -> UUID
+	destClientRoles map[string]map[string]bool // clientId -> role names
+	destGroups      map[string]bool            // group path -> exists
+}
+
+// buildSteps computes the plan steps for one object and numbers them in execution order
+func (s *SyncService) buildSteps(source, dest *syncTarget, objectType, objectName string, removeExtra bool) ([]domain.SyncPlanStep, []domain.SyncDependency, error) {
+	return s.buildStepsFor(source, dest, objectType, []string{objectName}, removeExtra)
+}
+
+// buildStepsFor plans several objects of one type in a single plan
+func (s *SyncService) buildStepsFor(source, dest *syncTarget, objectType string, objectNames []string, removeExtra bool) ([]domain.SyncPlanStep, []domain.SyncDependency, error) {
+	items := make([]domain.BulkSyncItem, 0, len(objectNames))
+	for _, objectName := range objectNames {
+		items = append(items, domain.BulkSyncItem{ObjectType: objectType, Name: objectName})
+	}
+	return s.buildStepsForItems(source, dest, items, removeExtra)
+}
+
+// buildStepsForItems plans several objects in a single plan; shared prerequisites are planned once
+func (s *SyncService) buildStepsForItems(source, dest *syncTarget, items []domain.BulkSyncItem, removeExtra bool) ([]domain.SyncPlanStep, []domain.SyncDependency, error) {
+	p := &syncPlanner{
+		s:               s,
+		source:          source,
+		dest:            dest,
+		steps:           []domain.SyncPlanStep{},
+		planned:         make(map[string]bool),
+		removeExtra:     removeExtra,
+		destClientRoles: make(map[string]map[string]bool),
+		destGroups:      make(map[string]bool),
+	}
+
+	for _, item := range items {
+		if err := p.plan(item.ObjectType, item.Name, ""); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i := range p.steps {
+		p.steps[i].Order = i + 1
+	}
+
+	return p.steps, p.deps, nil
+}
+
+func (p *syncPlanner) plan(objectType, name, requiredBy string) error {
+	key := objectType + ":" + name
+	if p.planned[key] {
+		return nil
+	}
+	p.planned[key] = true
+
+	switch objectType {
+	case "role":
+		return p.planRole(name, requiredBy)
+	case "client":
+		return p.planClient(name, requiredBy)
+	case "group":
+		return p.planGroup(name, requiredBy)
+	case "user":
+		return p.planUser(name)
+	case "client_scope":
+		return p.planClientScope(name, requiredBy)
+	}
+
+	return fmt.Errorf("unsupported object type: %s", objectType)
+}
+
+// require plans a referenced role, client or group before the object that needs it,
+// unless it already exists in the destination or is already part of the plan
+func (p *syncPlanner) require(objectType, name, requiredBy string) error {
+	if p.planned[objectType+":"+name] {
+		return nil
+	}
+
+	var exists bool
+	var err error
+	switch objectType {
+	case "role":
+		exists, err = p.destHasRole(name)
+	case "client":
+		exists, err = p.destHasClient(name)
+	case "group":
+		exists, err = p.destHasGroup(name)
+	default:
+		return fmt.Errorf("unsupported dependency type: %s", objectType)
+	}
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	p.deps = append(p.deps, domain.SyncDependency{ObjectType: objectType, Name: name, RequiredBy: requiredBy})
+	return p.plan(objectType, name, requiredBy)
+}
+
+// requireClientRole makes sure a client role exists in the destination. A missing client is
+// synced as a whole, which brings all of its roles; otherwise only the missing role is created.
+func (p *syncPlanner) requireClientRole(clientID, roleName, requiredBy string) error {
+	hasClient, err := p.destHasClient(clientID)
+	if err != nil {
+		return err
+	}
+	if !hasClient {
+		return p.require("client", clientID, requiredBy)
+	}
+
+	roles, err := p.destClientRoleNames(clientID)
+	if err != nil {
+		return err
+	}
+	key := "client_role:" + clientID + "/" + roleName
+	if roles[roleName] || p.planned[key] {
+		return nil
+	}
+	p.planned[key] = true
+
+	sourceRole, sourceClientUUID, err := p.sourceClientRole(clientID, roleName)
+	if err != nil {
+		return err
+	}
+	role, err := p.sourceClientRoleDetail(sourceClientUUID, sourceRole)
+	if err != nil {
+		return err
+	}
+
+	// As for realm roles, the role is created before its children are required, so a composite cycle resolves
+	p.deps = append(p.deps, domain.SyncDependency{ObjectType: "client_role", Name: clientID + "/" + roleName, RequiredBy: requiredBy})
+	clientRef := p.destClients[clientID]
+	p.steps = append(p.steps, p.createClientRoleStep(clientID, clientRef, role, false, requiredBy))
+	return p.planClientRoleComposites(clientID, clientRef, role, false, requiredBy)
+}
+
+// createClientRoleStep creates a client role with its attributes and without its composite children
+func (p *syncPlanner) createClientRoleStep(clientID, clientRef string, role domain.Role, optional bool, requiredBy string) domain.SyncPlanStep {
+	payload := map[string]interface{}{
+		"name":        role.Name,
+		"description": role.Description,
+		"composite":   role.Composite,
+	}
+	if attributes := keepMaskedAttributes(role.Attributes, nil); len(attributes) > 0 {
+		payload["attributes"] = attributes
+	}
+
+	return domain.SyncPlanStep{
+		Action:      domain.SyncActionCreateClientRole,
+		Method:      "POST",
+		Path:        fmt.Sprintf("/admin/realms/%s/clients/%s/roles", p.dest.cluster.Realm, clientRef),
+		Target:      role.Name,
+		Parent:      clientID,
+		Description: fmt.Sprintf("Create client role '%s' in client '%s'", role.Name, clientID),
+		Optional:    optional,
+		RequiredBy:  requiredBy,
+		Payload:     payload,
+	}
+}
+
+// planClientRoleComposites requires the composite children of a created client role and adds them to it
+func (p *syncPlanner) planClientRoleComposites(clientID, clientRef string, role domain.Role, optional bool, requiredBy string) error {
+	composites := roleComposites(role)
+	if len(composites.Realm) == 0 && len(composites.Client) == 0 {
+		return nil
+	}
+
+	ref := "client_role:" + clientID + "/" + role.Name
+	for _, childName := range composites.Realm {
+		if err := p.require("role", childName, ref); err != nil {
+			return err
+		}
+	}
+	for _, childClientID := range sortedKeys(composites.Client) {
+		for _, childName := range composites.Client[childClientID] {
+			if err := p.requireClientRole(childClientID, childName, ref); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.steps = append(p.steps, domain.SyncPlanStep{
+		Action:      domain.SyncActionAddClientRoleComposites,
+		Method:      "POST",
+		Path:        fmt.Sprintf("/admin/realms/%s/clients/%s/roles/%s/composites", p.dest.cluster.Realm, clientRef, role.Name),
+		Target:      role.Name,
+		Parent:      clientID,
+		Description: fmt.Sprintf("Add composite children to client role '%s' of '%s' (realm: %v, client: %v)", role.Name, clientID, composites.Realm, composites.Client),
+		Optional:    optional,
+		RequiredBy:  requiredBy,
+		Payload: map[string]interface{}{
+			"realm":  composites.Realm,
+			"client": composites.Client,
+		},
+	})
+	return nil
+}
+
+// requireRoleMappings plans every realm and client role referenced by a group or user
+func (p *syncPlanner) requireRoleMappings(realmRoles []string, clientRoles map[string][]string, requiredBy string) error {
+	for _, roleName := range realmRoles {
+		if isDefaultRealmRole(roleName) {
+			continue
+		}
+		if err := p.require("role", roleName, requiredBy); err != nil {
+			return err
+		}
+	}
+
+	for _, clientID := range sortedKeys(clientRoles) {
+		for _, roleName := range clientRoles[clientID] {
+			if err := p.requireClientRole(clientID, roleName, requiredBy); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *syncPlanner) planRole(roleName, requiredBy string) error {
+	sourceRoles, err := p.s.sourceRoles(p.source)
+	if err != nil {
+		return err
+	}
+
+	var role *domain.Role
+	for _, r := range sourceRoles {
+		if r.Name == roleName {
+			role = &r
+			break
+		}
+	}
+
+	if role == nil {
+		return fmt.Errorf("role '%s' not found in source cluster", roleName)
+	}
+
+	exists, err := p.destHasRole(roleName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if requiredBy != "" {
+			return nil
+		}
+		return p.planRoleUpdate(role)
+	}
+
+	rolePayload := map[string]interface{}{
+		"name":        role.Name,
+		"description": role.Description,
+		"composite":   role.Composite,
+	}
+	if attributes := keepMaskedAttributes(role.Attributes, nil); len(attributes) > 0 {
+		rolePayload["attributes"] = attributes
+	}
+
+	p.steps = append(p.steps, domain.SyncPlanStep{
+		Action:      domain.SyncActionCreateRole,
+		Method:      "POST",
+		Path:        fmt.Sprintf("/admin/realms/%s/roles", p.dest.cluster.Realm),
+		Target:      role.Name,
+		Description: fmt.Sprintf("Create realm role '%s'", role.Name),
+		RequiredBy:  requiredBy,
+		Payload:     rolePayload,
+	})
+	p.destRoles[role.Name] = true
+
+	// The role is created without children and they are added once they exist. Creating it first
+	// lets a composite cycle (A contains B, B contains A) resolve, as the child planning the
+	// role again finds it already created.
+	composites := roleComposites(*role)
+	for _, childName := range composites.Realm {
+		if err := p.require("role", childName, "role:"+roleName); err != nil {
+			return err
+		}
+	}
+	for _, clientID := range sortedKeys(composites.Client) {
+		for _, childName := range composites.Client[clientID] {
+			if err := p.requireClientRole(clientID, childName, "role:"+roleName); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(composites.Realm) > 0 || len(composites.Client) > 0 {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionAddRoleComposites,
+			Method:      "POST",
+			Path:        fmt.Sprintf("/admin/realms/%s/roles/%s/composites", p.dest.cluster.Realm, role.Name),
+			Target:      role.Name,
+			Description: fmt.Sprintf("Add composite children to role '%s' (realm: %v, client: %v)", role.Name, composites.Realm, composites.Client),
+			RequiredBy:  requiredBy,
+			Payload: map[string]interface{}{
+				"realm":  composites.Realm,
+				"client": composites.Client,
+			},
+		})
+	}
+
+	return nil
+}
+
+func (p *syncPlanner) planClient(clientID, requiredBy string) error {
+	exportedClients, err := p.s.sourceClients(p.source)
+	if err != nil {
+		return err
+	}
+
+	var clientToSync map[string]interface{}
+	for _, client := range exportedClients {
+		if clientIDStr, ok := client["clientId"].(string); ok && clientIDStr == clientID {
+			clientToSync = client
+			break
+		}
+	}
+
+	if clientToSync == nil {
+		return fmt.Errorf("client '%s' not found in source cluster", clientID)
+	}
+
+	sourceClientDetails, err := p.s.sourceClientDetails(p.source)
+	if err != nil {
+		return err
+	}
+
+	var sourceClientDetail *domain.ClientDetail
+	for _, client := range sourceClientDetails {
+		if client.ClientID == clientID {
+			sourceClientDetail = &client
+			break
+		}
+	}
+
+	if sourceClientDetail == nil {
+		return fmt.Errorf("source client detail not found")
+	}
+
+	// An empty UUID means the client will be created
+	if _, err := p.destHasClient(clientID); err != nil {
+		return err
+	}
+	destClientUUID := p.destClients[clientID]
+
+	// The secret of a confidential client is never stored in the plan; the executor reads it
+	// from the source when the step is applied. Values masked in a snapshot are left out, so
+	// the destination keeps its own.
+	payload := make(map[string]interface{}, len(clientToSync))
+	for k, v := range clientToSync {
+		if k == "id" || k == "secret" {
+			continue
+		}
+		if v, keep := withoutMaskedValues(v); keep {
+			payload[k] = v
+		}
+	}
+	secret, _ := clientToSync["secret"].(string)
+	copySecret := secret != "" && secret != maskedSecret
+
+	rewrites := p.dest.rewriter.Apply("client", clientID, payload)
+
+	clientRef := destClientUUID
+	if destClientUUID == "" {
+		clientRef = fmt.Sprintf("{id of %s}", clientID)
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionImportClient,
+			Method:      "POST",
+			Path:        fmt.Sprintf("/admin/realms/%s/clients", p.dest.cluster.Realm),
+			Target:      clientID,
+			Description: fmt.Sprintf("Create client '%s'", clientID),
+			RequiredBy:  requiredBy,
+			Payload:     payload,
+			Rewrites:    rewrites,
+			CopySecret:  copySecret,
+		})
+	} else {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionImportClient,
+			Method:      "PUT",
+			Path:        fmt.Sprintf("/admin/realms/%s/clients/%s", p.dest.cluster.Realm, destClientUUID),
+			Target:      clientID,
+			Description: fmt.Sprintf("Update client '%s' with the source configuration", clientID),
+			RequiredBy:  requiredBy,
+			Payload:     payload,
+			Rewrites:    rewrites,
+			CopySecret:  copySecret,
+		})
+	}
+
+	// Client roles missing in destination
+	destRoleMap := make(map[string]bool)
+	if destClientUUID != "" {
+		destRoleMap, err = p.destClientRoleNames(clientID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(sourceClientDetail.ClientRoles) > 0 {
+		sourceRoles, err := p.sourceClientRoles(*sourceClientDetail)
+		if err != nil {
+			return err
+		}
+
+		// Every missing role is created before composite children are added, so roles of the
+		// same client can contain each other
+		var created []domain.Role
+		for _, sourceRole := range sourceRoles {
+			roleName, _ := sourceRole["name"].(string)
+			key := "client_role:" + clientID + "/" + roleName
+			if roleName == "" || destRoleMap[roleName] || p.planned[key] {
+				continue
+			}
+			role, err := p.sourceClientRoleDetail(sourceClientDetail.ID, sourceRole)
+			if err != nil {
+				return err
+			}
+
+			p.planned[key] = true
+			p.steps = append(p.steps, p.createClientRoleStep(clientID, clientRef, role, true, requiredBy))
+			created = append(created, role)
+		}
+
+		for _, role := range created {
+			if err := p.planClientRoleComposites(clientID, clientRef, role, true, requiredBy); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Client scopes (default and optional) must exist in the destination realm before they are assigned
+	var scopeNames []string
+	seenScopes := make(map[string]bool)
+	for _, scopeName := range append(append([]string{}, sourceClientDetail.DefaultClientScopes...), sourceClientDetail.OptionalClientScopes...) {
+		if !seenScopes[scopeName] {
+			seenScopes[scopeName] = true
+			scopeNames = append(scopeNames, scopeName)
+		}
+	}
+
+	for _, scopeName := range scopeNames {
+		if err := p.plan("client_scope", scopeName, requiredBy); err != nil {
+			return err
+		}
+	}
+
+	p.steps = append(p.steps, domain.SyncPlanStep{
+		Action:      domain.SyncActionAssignClientScopes,
+		Method:      "PUT",
+		Path:        fmt.Sprintf("/admin/realms/%s/clients/%s/default-client-scopes", p.dest.cluster.Realm, clientRef),
+		Target:      "default",
+		Parent:      clientID,
+		Description: fmt.Sprintf("Set default client scopes of '%s' to %v", clientID, sourceClientDetail.DefaultClientScopes),
+		Optional:    true,
+		RequiredBy:  requiredBy,
+		Payload:     map[string]interface{}{"scopes": sourceClientDetail.DefaultClientScopes},
+	}, domain.SyncPlanStep{
+		Action:      domain.SyncActionAssignClientScopes,
+		Method:      "PUT",
+		Path:        fmt.Sprintf("/admin/realms/%s/clients/%s/optional-client-scopes", p.dest.cluster.Realm, clientRef),
+		Target:      "optional",
+		Parent:      clientID,
+		Description: fmt.Sprintf("Set optional client scopes of '%s' to %v", clientID, sourceClientDetail.OptionalClientScopes),
+		Optional:    true,
+		RequiredBy:  requiredBy,
+		Payload:     map[string]interface{}{"scopes": sourceClientDetail.OptionalClientScopes},
+	})
+
+	return nil
+}
+
+// planClientScope creates a client scope the destination lacks, or adds the protocol mappers it lacks
+func (p *syncPlanner) planClientScope(scopeName, requiredBy string) error {
+	sourceScopeDetails := p.source.clientScopes[scopeName]
+	if sourceScopeDetails == nil {
+		// Snapshots do not store client scope definitions and documents may leave a scope out;
+		// such scopes are only assigned and must already exist
+		if p.source.preloaded {
+			return nil
+		}
+
+		var err error
+		sourceScopeDetails, err = p.s.keycloakClient.GetClientScopeDetails(p.source.cluster.BaseURL, p.source.cluster.Realm, p.source.token, scopeName)
+		if err != nil {
+			fmt.Printf("Warning: failed to get source client scope details for '%s': %v\n", scopeName, err)
+			return nil
+		}
+	}
+
+	// If the scope cannot be read from destination it does not exist there
+	destScopeDetails, err := p.s.keycloakClient.GetClientScopeDetails(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token, scopeName)
+	if err != nil {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionCreateClientScope,
+			Method:      "POST",
+			Path:        fmt.Sprintf("/admin/realms/%s/client-scopes", p.dest.cluster.Realm),
+			Target:      scopeName,
+			Description: fmt.Sprintf("Create client scope '%s' with its protocol mappers", scopeName),
+			Optional:    true,
+			RequiredBy:  requiredBy,
+			Payload:     sourceScopeDetails,
+		})
+		return nil
+	}
+
+	for _, step := range p.s.planScopeMappers(p.source, p.dest, sourceScopeDetails, destScopeDetails, scopeName) {
+		step.RequiredBy = requiredBy
+		p.steps = append(p.steps, step)
+	}
+
+	return nil
+}
+
+func (p *syncPlanner) planGroup(groupPath, requiredBy string) error {
+	sourceGroups, err := p.s.sourceGroups(p.source)
+	if err != nil {
+		return err
+	}
+
+	group := findGroupDetail(sourceGroups, groupPath)
+	if group == nil {
+		return fmt.Errorf("group '%s' not found in source cluster", groupPath)
+	}
+
+	exists, err := p.destHasGroup(groupPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if requiredBy != "" {
+			return nil
+		}
+		return p.planGroupUpdate(group)
+	}
+
+	ref := "group:" + group.Path
+	if parentPath := parentGroupPath(group.Path); parentPath != "" {
+		if err := p.require("group", parentPath, ref); err != nil {
+			return err
+		}
+	}
+	if err := p.requireRoleMappings(group.RealmRoles, group.ClientRoles, ref); err != nil {
+		return err
+	}
+
+	groupPayload := map[string]interface{}{
+		"name": group.Name,
+		"path": group.Path,
+	}
+	if attributes := keepMaskedAttributes(group.Attributes, nil); len(attributes) > 0 {
+		groupPayload["attributes"] = attributes
+	}
+	groupRewrites := p.dest.rewriter.Apply("group", group.Path, groupPayload)
+
+	createPath := fmt.Sprintf("/admin/realms/%s/groups", p.dest.cluster.Realm)
+	if parentPath := parentGroupPath(group.Path); parentPath != "" {
+		createPath = fmt.Sprintf("/admin/realms/%s/groups/{id of %s}/children", p.dest.cluster.Realm, parentPath)
+	}
+
+	groupRef := fmt.Sprintf("{id of %s}", group.Path)
+	p.steps = append(p.steps, domain.SyncPlanStep{
+		Action:      domain.SyncActionCreateGroup,
+		Method:      "POST",
+		Path:        createPath,
+		Target:      group.Path,
+		Description: fmt.Sprintf("Create group '%s'", group.Path),
+		RequiredBy:  requiredBy,
+		Payload:     groupPayload,
+		Rewrites:    groupRewrites,
+	})
+	p.destGroups[group.Path] = true
+
+	realmRoles := withoutDefaultRealmRoles(group.RealmRoles)
+	if len(realmRoles) > 0 {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionAssignGroupRoles,
+			Method:      "POST",
+			Path:        fmt.Sprintf("/admin/realms/%s/groups/%s/role-mappings/realm", p.dest.cluster.Realm, groupRef),
+			Target:      group.Path,
+			Description: fmt.Sprintf("Assign realm roles %v to group '%s'", realmRoles, group.Path),
+			Optional:    true,
+			RequiredBy:  requiredBy,
+			Payload:     map[string]interface{}{"roles": realmRoles},
+		})
+	}
+
+	for _, clientID := range sortedKeys(group.ClientRoles) {
+		roles := group.ClientRoles[clientID]
+		if len(roles) == 0 {
+			continue
+		}
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionAssignGroupClientRoles,
+			Method:      "POST",
+			Path:        fmt.Sprintf("/admin/realms/%s/groups/%s/role-mappings/clients/{id of %s}", p.dest.cluster.Realm, groupRef, clientID),
+			Target:      group.Path,
+			Parent:      clientID,
+			Description: fmt.Sprintf("Assign client roles %v of '%s' to group '%s'", roles, clientID, group.Path),
+			Optional:    true,
+			RequiredBy:  requiredBy,
+			Payload:     map[string]interface{}{"roles": roles},
+		})
+	}
+
+	return nil
+}
+
+func (p *syncPlanner) planUser(username string) error {
+	sourceUsers, err := p.s.sourceUsers(p.source)
+	if err != nil {
+		return err
+	}
+
+	var user *domain.UserDetail
+	for _, u := range sourceUsers {
+		if u.Username == username {
+			user = &u
+			break
+		}
+	}
+
+	if user == nil {
+		return fmt.Errorf("user not found in source cluster")
+	}
+
+	destUserID, err := p.s.keycloakClient.FindUserIDByUsername(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token, username)
+	if err != nil {
+		return fmt.Errorf("failed to get destination users: %w", err)
+	}
+	if destUserID != "" {
+		return p.planUserUpdate(user, destUserID)
+	}
+
+	ref := "user:" + user.Username
+	for _, groupPath := range user.Groups {
+		if err := p.require("group", groupPath, ref); err != nil {
+			return err
+		}
+	}
+	if err := p.requireRoleMappings(user.RealmRoles, user.ClientRoles, ref); err != nil {
+		return err
+	}
+
+	userPayload := map[string]interface{}{
+		"username":  user.Username,
+		"email":     user.Email,
+		"firstName": user.FirstName,
+		"lastName":  user.LastName,
+		"enabled":   user.Enabled,
+	}
+	if attributes := keepMaskedAttributes(user.Attributes, nil); len(attributes) > 0 {
+		userPayload["attributes"] = attributes
+	}
+	if len(user.RequiredActions) > 0 {
+		userPayload["requiredActions"] = user.RequiredActions
+	}
+	userRewrites := p.dest.rewriter.Apply("user", user.Username, userPayload)
+
+	userRef := fmt.Sprintf("{id of %s}", user.Username)
+	p.steps = append(p.steps, domain.SyncPlanStep{
+		Action:      domain.SyncActionCreateUser,
+		Method:      "POST",
+		Path:        fmt.Sprintf("/admin/realms/%s/users", p.dest.cluster.Realm),
+		Target:      user.Username,
+		Description: fmt.Sprintf("Create user '%s'", user.Username),
+		Payload:     userPayload,
+		Rewrites:    userRewrites,
+	})
+
+	realmRoles := withoutDefaultRealmRoles(user.RealmRoles)
+	if len(realmRoles) > 0 {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionAssignUserRoles,
+			Method:      "POST",
+			Path:        fmt.Sprintf("/admin/realms/%s/users/%s/role-mappings/realm", p.dest.cluster.Realm, userRef),
+			Target:      user.Username,
+			Description: fmt.Sprintf("Assign realm roles %v to user '%s'", realmRoles, user.Username),
+			Optional:    true,
+			Payload:     map[string]interface{}{"roles": realmRoles},
+		})
+	}
+
+	for _, clientID := range sortedKeys(user.ClientRoles) {
+		roles := user.ClientRoles[clientID]
+		if len(roles) == 0 {
+			continue
+		}
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionAssignUserClientRoles,
+			Method:      "POST",
+			Path:        fmt.Sprintf("/admin/realms/%s/users/%s/role-mappings/clients/{id of %s}", p.dest.cluster.Realm, userRef, clientID),
+			Target:      user.Username,
+			Parent:      clientID,
+			Description: fmt.Sprintf("Assign client roles %v of '%s' to user '%s'", roles, clientID, user.Username),
+			Optional:    true,
+			Payload:     map[string]interface{}{"roles": roles},
+		})
+	}
+
+	for _, groupPath := range user.Groups {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionAddUserToGroup,
+			Method:      "PUT",
+			Path:        fmt.Sprintf("/admin/realms/%s/users/%s/groups/{id of %s}", p.dest.cluster.Realm, userRef, groupPath),
+			Target:      user.Username,
+			Parent:      groupPath,
+			Description: fmt.Sprintf("Add user '%s' to group '%s'", user.Username, groupPath),
+			Optional:    true,
+		})
+	}
+
+	return nil
+}
+
+func (p *syncPlanner) destHasRole(roleName string) (bool, error) {
+	if p.destRoles == nil {
+		roles, err := p.s.keycloakClient.GetRoles(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token)
+		if err != nil {
+			return false, fmt.Errorf("failed to get destination roles: %w", err)
+		}
+		p.destRoles = make(map[string]bool, len(roles))
+		for _, r := range roles {
+			p.destRoles[r.Name] = true
+		}
+	}
+	return p.destRoles[roleName], nil
+}
+
+func (p *syncPlanner) destHasClient(clientID string) (bool, error) {
+	if p.destClients == nil {
+		clients, err := p.s.keycloakClient.GetClients(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token)
+		if err != nil {
+			return false, fmt.Errorf("failed to get destination clients: %w", err)
+		}
+		p.destClients = make(map[string]string, len(clients))
+		for _, client := range clients {
+			id, _ := client["clientId"].(string)
+			uuid, _ := client["id"].(string)
+			if id != "" {
+				p.destClients[id] = uuid
+			}
+		}
+	}
+	_, ok := p.destClients[clientID]
+	return ok, nil
+}
+
+func (p *syncPlanner) destHasGroup(groupPath string) (bool, error) {
+	if exists, ok := p.destGroups[groupPath]; ok {
+		return exists, nil
+	}
+	groupID, err := p.s.keycloakClient.FindGroupIDByPath(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token, groupPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to get destination groups: %w", err)
+	}
+	p.destGroups[groupPath] = groupID != ""
+	return groupID != "", nil
+}
+
+func (p *syncPlanner) destClientRoleNames(clientID string) (map[string]bool, error) {
+	if roles, ok := p.destClientRoles[clientID]; ok {
+		return roles, nil
+	}
+	roles := make(map[string]bool)
+	if uuid := p.destClients[clientID]; uuid != "" {
+		clientRoles, err := p.s.keycloakClient.GetClientRoles(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token, uuid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get destination client roles: %w", err)
+		}
+		for _, role := range clientRoles {
+			if name, ok := role["name"].(string); ok {
+				roles[name] = true
+			}
+		}
+	}
+	p.destClientRoles[clientID] = roles
+	return roles, nil
+}
+
+// sourceClientRole returns the representation of a source client role and the source client's internal ID
+func (p *syncPlanner) sourceClientRole(clientID, roleName string) (map[string]interface{}, string, error) {
+	clients, err := p.s.sourceClients(p.source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, client := range clients {
+		if id, _ := client["clientId"].(string); id == clientID {
+			uuid, _ := client["id"].(string)
+			roles, err := p.sourceClientRoles(domain.ClientDetail{ID: uuid, ClientID: clientID})
+			if err != nil {
+				return nil, "", err
+			}
+			for _, role := range roles {
+				if name, _ := role["name"].(string); name == roleName {
+					return role, uuid, nil
+				}
+			}
+			break
+		}
+	}
+
+	return nil, "", fmt.Errorf("client role '%s' of client '%s' not found in source cluster", roleName, clientID)
+}
+
+// sourceClientRoleDetail returns a source client role with its attributes and composite children.
+// Role listings leave both out, so they are read from the source cluster; preloaded sources only
+// have what their representation holds.
+func (p *syncPlanner) sourceClientRoleDetail(sourceClientUUID string, sourceRole map[string]interface{}) (domain.Role, error) {
+	var role domain.Role
+	if err := decodePayload(sourceRole, &role); err != nil {
+		return role, err
+	}
+	if p.source.preloaded || sourceClientUUID == "" {
+		return role, nil
+	}
+
+	detail, err := p.s.keycloakClient.GetClientRoleDetail(p.source.cluster.BaseURL, p.source.cluster.Realm, p.source.token, sourceClientUUID, role.Name)
+	if err != nil {
+		return role, fmt.Errorf("failed to get source client role '%s': %w", role.Name, err)
+	}
+	return *detail, nil
+}
+
+// sourceClientRoles returns the role representations of a source client.
+// Snapshots and documents only hold the role names, so such client roles get no description.
+func (p *syncPlanner) sourceClientRoles(client domain.ClientDetail) ([]map[string]interface{}, error) {
+	if p.source.preloaded {
+		details, err := p.s.sourceClientDetails(p.source)
+		if err != nil {
+			return nil, err
+		}
+
+		var roles []map[string]interface{}
+		for _, detail := range details {
+			if detail.ClientID == client.ClientID {
+				for _, roleName := range detail.ClientRoles {
+					roles = append(roles, map[string]interface{}{"name": roleName})
+				}
+				break
+			}
+		}
+		return roles, nil
+	}
+
+	roles, err := p.s.keycloakClient.GetClientRoles(p.source.cluster.BaseURL, p.source.cluster.Realm, p.source.token, client.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source client roles: %w", err)
+	}
+	return roles, nil
+}
+
+// planScopeMappers plans the protocol mappers that exist in the source scope but not in the destination scope.
+// Existing mappers are left untouched.
+func (s *SyncService) planScopeMappers(
+	source, dest *syncTarget,
+	sourceScopeDetails, destScopeDetails map[string]interface{},
+	scopeName string,
+) []domain.SyncPlanStep {
+	sourceMappers := s.scopeMappers(source, sourceScopeDetails)
+	destMappers := s.scopeMappers(dest, destScopeDetails)
+
+	destMapperMap := make(map[string]bool)
+	for _, mapper := range destMappers {
+		if name, ok := mapper["name"].(string); ok {
+			destMapperMap[name] = true
+		}
+	}
+
+	destScopeID, _ := destScopeDetails["id"].(string)
+
+	var steps []domain.SyncPlanStep
+	for _, sourceMapper := range sourceMappers {
+		mapperName, ok := sourceMapper["name"].(string)
+		if !ok || mapperName == "" || destMapperMap[mapperName] {
+			continue
+		}
+
+		steps = append(steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionCreateScopeMapper,
+			Method:      "POST",
+			Path:        fmt.Sprintf("/admin/realms/%s/client-scopes/%s/protocol-mappers/models", dest.cluster.Realm, destScopeID),
+			Target:      mapperName,
+			Parent:      scopeName,
+			Description: fmt.Sprintf("Create protocol mapper '%s' in client scope '%s'", mapperName, scopeName),
+			Optional:    true,
+			Payload:     sourceMapper,
+		})
+	}
+
+	return steps
+}
+
+// scopeMappers returns the protocol mappers of a scope, fetching them separately if they are not in the scope details
+func (s *SyncService) scopeMappers(target *syncTarget, scopeDetails map[string]interface{}) []map[string]interface{} {
+	var mappers []map[string]interface{}
+	if list, ok := scopeDetails["protocolMappers"].([]interface{}); ok {
+		for _, m := range list {
+			if mapper, ok := m.(map[string]interface{}); ok {
+				mappers = append(mappers, mapper)
+			}
+		}
+		return mappers
+	}
+
+	if scopeID, ok := scopeDetails["id"].(string); ok && scopeID != "" {
+		if list, err := s.keycloakClient.GetClientScopeMappers(
+			target.cluster.BaseURL,
+			target.cluster.Realm,
+			target.token,
+			scopeID,
+		); err == nil {
+			mappers = list
+		}
+	}
+
+	return mappers
+}
+
+// findGroupDetail searches a group tree for the group with the given path
+func findGroupDetail(groups []domain.GroupDetail, groupPath string) *domain.GroupDetail {
+	for i := range groups {
+		if groups[i].Path == groupPath {
+			return &groups[i]
+		}
+		if found := findGroupDetail(groups[i].SubGroups, groupPath); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// parentGroupPath returns the path of the parent group, or an empty string for top-level groups
+func parentGroupPath(groupPath string) string {
+	idx := strings.LastIndex(groupPath, "/")
+	if idx <= 0 {
+		return ""
+	}
+	return groupPath[:idx]
+}
+
+// isDefaultRealmRole reports whether a role is the realm's default composite role.
+// Its name contains the realm name and Keycloak assigns the destination's own one automatically.
+func isDefaultRealmRole(roleName string) bool {
+	return strings.HasPrefix(roleName, "default-roles-")
+}
+
+func withoutDefaultRealmRoles(roleNames []string) []string {
+	var roles []string
+	for _, roleName := range roleNames {
+		if !isDefaultRealmRole(roleName) {
+			roles = append(roles, roleName)
+		}
+	}
+	return roles
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
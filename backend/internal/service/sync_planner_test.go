@@ -0,0 +1,370 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"keycloak-multi-manage/internal/client/keycloak"
+	"keycloak-multi-manage/internal/domain"
+)
+
+// testPlanner plans against preloaded source roles and clients and a destination that has
+// the given realm roles and the client "app" with the role "viewer"
+func testPlanner(sourceRoles []domain.Role, destRoles ...string) *syncPlanner {
+	source := &syncTarget{
+		cluster:       &domain.Cluster{Name: "source", Realm: "r"},
+		preloaded:     true,
+		roles:         sourceRoles,
+		clients:       []map[string]interface{}{{"clientId": "app"}},
+		clientDetails: []domain.ClientDetail{{ClientID: "app", ClientRoles: []string{"viewer", "editor"}}},
+	}
+	p := &syncPlanner{
+		s:               &SyncService{},
+		source:          source,
+		dest:            &syncTarget{cluster: &domain.Cluster{Name: "dest", Realm: "r"}},
+		steps:           []domain.SyncPlanStep{},
+		planned:         make(map[string]bool),
+		destRoles:       make(map[string]bool),
+		destClients:     map[string]string{"app": "app-uuid"},
+		destClientRoles: map[string]map[string]bool{"app": {"viewer": true}},
+		destGroups:      make(map[string]bool),
+	}
+	for _, name := range destRoles {
+		p.destRoles[name] = true
+	}
+	return p
+}
+
+func compositeRole(name string, realm ...string) domain.Role {
+	return domain.Role{Name: name, Composite: true, Composites: &domain.RoleComposites{Realm: realm}}
+}
+
+// stepList renders steps as "action target" for comparison
+func stepList(steps []domain.SyncPlanStep) []string {
+	list := make([]string, 0, len(steps))
+	for _, step := range steps {
+		list = append(list, fmt.Sprintf("%s %s", step.Action, step.Target))
+	}
+	return list
+}
+
+func TestPlanRole(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    []domain.Role
+		dest      []string
+		role      string
+		wantSteps []string
+		wantDeps  []string
+		wantErr   bool
+	}{
+		{
+			name:      "plain role",
+			source:    []domain.Role{{Name: "a"}},
+			role:      "a",
+			wantSteps: []string{"create_role a"},
+		},
+		{
+			name:      "composite with existing child",
+			source:    []domain.Role{compositeRole("a", "b"), {Name: "b"}},
+			dest:      []string{"b"},
+			role:      "a",
+			wantSteps: []string{"create_role a", "add_role_composites a"},
+		},
+		{
+			name:      "composite with missing child",
+			source:    []domain.Role{compositeRole("a", "b"), {Name: "b"}},
+			role:      "a",
+			wantSteps: []string{"create_role a", "create_role b", "add_role_composites a"},
+			wantDeps:  []string{"role b for role:a"},
+		},
+		{
+			name:      "cycle",
+			source:    []domain.Role{compositeRole("a", "b"), compositeRole("b", "a")},
+			role:      "a",
+			wantSteps: []string{"create_role a", "create_role b", "add_role_composites b", "add_role_composites a"},
+			wantDeps:  []string{"role b for role:a"},
+		},
+		{
+			name:      "longer cycle",
+			source:    []domain.Role{compositeRole("a", "b"), compositeRole("b", "c"), compositeRole("c", "a")},
+			role:      "a",
+			wantSteps: []string{"create_role a", "create_role b", "create_role c", "add_role_composites c", "add_role_composites b", "add_role_composites a"},
+			wantDeps:  []string{"role b for role:a", "role c for role:b"},
+		},
+		{
+			name:      "role containing itself",
+			source:    []domain.Role{compositeRole("a", "a")},
+			role:      "a",
+			wantSteps: []string{"create_role a", "add_role_composites a"},
+		},
+		{
+			name: "missing client role child",
+			source: []domain.Role{{Name: "a", Composite: true, Composites: &domain.RoleComposites{
+				Client: map[string][]string{"app": {"viewer", "editor"}},
+			}}},
+			role:      "a",
+			wantSteps: []string{"create_role a", "create_client_role editor", "add_role_composites a"},
+			wantDeps:  []string{"client_role app/editor for role:a"},
+		},
+		{
+			name:    "unknown role",
+			source:  []domain.Role{{Name: "a"}},
+			role:    "b",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPlanner(tt.source, tt.dest...)
+			err := p.plan("role", tt.role, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := stepList(p.steps); !reflect.DeepEqual(got, tt.wantSteps) {
+				t.Errorf("steps = %q\nwant    %q", got, tt.wantSteps)
+			}
+			var deps []string
+			for _, dep := range p.deps {
+				deps = append(deps, fmt.Sprintf("%s %s for %s", dep.ObjectType, dep.Name, dep.RequiredBy))
+			}
+			if !reflect.DeepEqual(deps, tt.wantDeps) {
+				t.Errorf("deps = %q, want %q", deps, tt.wantDeps)
+			}
+		})
+	}
+}
+
+// Every composite child must be created before the step that adds it to its parent
+func TestPlanRoleCreatesChildrenBeforeAddingThem(t *testing.T) {
+	p := testPlanner([]domain.Role{compositeRole("a", "b", "c"), compositeRole("b", "a", "c"), compositeRole("c", "b")})
+	if err := p.plan("role", "a", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	created := make(map[string]bool)
+	for _, step := range p.steps {
+		switch step.Action {
+		case domain.SyncActionCreateRole:
+			created[step.Target] = true
+		case domain.SyncActionAddRoleComposites:
+			if !created[step.Target] {
+				t.Errorf("composites added to '%s' before it is created", step.Target)
+			}
+			for _, child := range step.Payload["realm"].([]string) {
+				if !created[child] {
+					t.Errorf("'%s' is added to '%s' before it is created", child, step.Target)
+				}
+			}
+		}
+	}
+}
+
+// clientRoleSource serves the client "app" of a live source cluster. Its role "editor" has attributes
+// and contains the realm role "base" and the client roles "viewer" and "admin"; "admin" contains "editor".
+func clientRoleSource(t *testing.T) *httptest.Server {
+	t.Helper()
+	responses := map[string]string{
+		"/admin/realms/r/clients":                                 `[{"id": "src-app", "clientId": "app"}]`,
+		"/admin/realms/r/clients/src-app/roles":                   `[{"name": "viewer"}, {"name": "editor", "composite": true}, {"name": "admin", "composite": true}]`,
+		"/admin/realms/r/clients/src-app/roles/viewer":            `{"name": "viewer"}`,
+		"/admin/realms/r/clients/src-app/roles/editor":            `{"name": "editor", "description": "Edits", "composite": true, "attributes": {"level": ["2"]}}`,
+		"/admin/realms/r/clients/src-app/roles/editor/composites": `[{"name": "base", "clientRole": false}, {"name": "viewer", "clientRole": true, "containerId": "src-app"}, {"name": "admin", "clientRole": true, "containerId": "src-app"}]`,
+		"/admin/realms/r/clients/src-app/roles/admin":             `{"name": "admin", "composite": true}`,
+		"/admin/realms/r/clients/src-app/roles/admin/composites":  `[{"name": "editor", "clientRole": true, "containerId": "src-app"}]`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// liveClientRolePlanner plans against the live source of clientRoleSource
+func liveClientRolePlanner(t *testing.T) *syncPlanner {
+	server := clientRoleSource(t)
+	p := testPlanner([]domain.Role{{Name: "base"}})
+	p.s = &SyncService{keycloakClient: keycloak.NewClient()}
+	p.source.cluster.BaseURL = server.URL
+	p.source.preloaded = false
+	p.source.clients = []map[string]interface{}{{"id": "src-app", "clientId": "app"}}
+	p.source.clientDetails = []domain.ClientDetail{{ID: "src-app", ClientID: "app", ClientRoles: []string{"viewer", "editor", "admin"}}}
+	return p
+}
+
+// A client role required by another object is copied with its attributes and composite children
+func TestRequireCompositeClientRole(t *testing.T) {
+	p := liveClientRolePlanner(t)
+	if err := p.requireClientRole("app", "editor", "group:/staff"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"create_client_role editor",
+		"create_role base",
+		"create_client_role admin",
+		"add_client_role_composites admin",
+		"add_client_role_composites editor",
+	}
+	if got := stepList(p.steps); !reflect.DeepEqual(got, want) {
+		t.Fatalf("steps = %v, want %v", got, want)
+	}
+
+	create := p.steps[0]
+	if !reflect.DeepEqual(create.Payload["attributes"], map[string][]string{"level": {"2"}}) || create.Payload["description"] != "Edits" {
+		t.Errorf("create payload = %v, want the attributes and description of the source role", create.Payload)
+	}
+	add := p.steps[4]
+	if add.Path != "/admin/realms/r/clients/app-uuid/roles/editor/composites" || add.Parent != "app" {
+		t.Errorf("composites step = %s %s (parent %s)", add.Method, add.Path, add.Parent)
+	}
+	wantComposites := map[string]interface{}{"realm": []string{"base"}, "client": map[string][]string{"app": {"viewer", "admin"}}}
+	if !reflect.DeepEqual(add.Payload, wantComposites) {
+		t.Errorf("composites payload = %v, want %v", add.Payload, wantComposites)
+	}
+
+	var deps []string
+	for _, dep := range p.deps {
+		deps = append(deps, dep.ObjectType+" "+dep.Name+" for "+dep.RequiredBy)
+	}
+	wantDeps := []string{
+		"client_role app/editor for group:/staff",
+		"role base for client_role:app/editor",
+		"client_role app/admin for client_role:app/editor",
+	}
+	if !reflect.DeepEqual(deps, wantDeps) {
+		t.Errorf("deps = %v, want %v", deps, wantDeps)
+	}
+}
+
+// Roles of a new client are all created before any of them gets its composite children
+func TestPlanClientCompositeRoles(t *testing.T) {
+	p := liveClientRolePlanner(t)
+	p.destClients = map[string]string{}
+	if err := p.plan("client", "app", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"import_client app",
+		"create_client_role viewer",
+		"create_client_role editor",
+		"create_client_role admin",
+		"create_role base",
+		"add_client_role_composites editor",
+		"add_client_role_composites admin",
+		"assign_client_scopes default",
+		"assign_client_scopes optional",
+	}
+	if got := stepList(p.steps); !reflect.DeepEqual(got, want) {
+		t.Fatalf("steps = %v, want %v", got, want)
+	}
+	if path := p.steps[5].Path; path != "/admin/realms/r/clients/{id of app}/roles/editor/composites" {
+		t.Errorf("composites path = %s", path)
+	}
+}
+
+// A confidential client's secret is read from the source at apply time and never stored in the plan
+func TestPlanClientLeavesSecretOutOfThePlan(t *testing.T) {
+	tests := []struct {
+		client     string
+		wantMethod string
+		wantPath   string
+	}{
+		{"svc", "POST", "/admin/realms/r/clients"},
+		{"app", "PUT", "/admin/realms/r/clients/app-uuid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.client, func(t *testing.T) {
+			p := testPlanner(nil)
+			p.source.clients = []map[string]interface{}{
+				{"id": "source-uuid", "clientId": tt.client, "publicClient": false, "secret": "s3cret-value"},
+			}
+			p.source.clientDetails = []domain.ClientDetail{{ID: "source-uuid", ClientID: tt.client}}
+
+			if err := p.plan("client", tt.client, ""); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := json.Marshal(p.steps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(stored), "s3cret-value") {
+				t.Fatalf("stored plan contains the client secret: %s", stored)
+			}
+
+			step := p.steps[0]
+			if step.Action != domain.SyncActionImportClient || step.Method != tt.wantMethod || step.Path != tt.wantPath {
+				t.Fatalf("first step = %s %s %s, want %s %s", step.Action, step.Method, step.Path, tt.wantMethod, tt.wantPath)
+			}
+			if !step.CopySecret {
+				t.Error("step does not copy the secret from the source")
+			}
+			if _, ok := step.Payload["id"]; ok {
+				t.Error("payload carries the source client id")
+			}
+			// The source listing keeps its secret for the executor
+			if p.source.clients[0]["secret"] != "s3cret-value" {
+				t.Error("planning removed the secret from the source listing")
+			}
+		})
+	}
+
+	// A public client has no secret to copy
+	p := testPlanner(nil)
+	p.source.clients = []map[string]interface{}{{"clientId": "web", "publicClient": true}}
+	p.source.clientDetails = []domain.ClientDetail{{ClientID: "web"}}
+	if err := p.plan("client", "web", ""); err != nil {
+		t.Fatal(err)
+	}
+	if p.steps[0].CopySecret {
+		t.Error("public client step copies a secret")
+	}
+}
+
+func TestMissingFrom(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want []string
+	}{
+		{[]string{"x", "y"}, []string{"y"}, []string{"x"}},
+		{[]string{"x"}, []string{"x", "z"}, nil},
+		{nil, []string{"x"}, nil},
+		{[]string{"x", "y"}, nil, []string{"x", "y"}},
+	}
+
+	for _, tt := range tests {
+		if got := missingFrom(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("missingFrom(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMissingClientRoles(t *testing.T) {
+	a := map[string][]string{"app": {"viewer", "editor"}, "api": {"read"}}
+	b := map[string][]string{"app": {"viewer"}, "api": {"read"}}
+
+	want := map[string][]string{"app": {"editor"}}
+	if got := missingClientRoles(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("missingClientRoles = %v, want %v", got, want)
+	}
+	if got := missingClientRoles(b, a); got != nil {
+		t.Errorf("missingClientRoles = %v, want nil when nothing is missing", got)
+	}
+}
@@ -9,603 +9,349 @@ import (
 
 type SyncService struct {
 	clusterRepo    *postgres.ClusterRepository
+	planRepo       *postgres.SyncPlanRepository
 	keycloakClient *keycloak.Client
+	rewriteService *RewriteRuleService
 }
 
-func NewSyncService(clusterRepo *postgres.ClusterRepository) *SyncService {
+func NewSyncService(clusterRepo *postgres.ClusterRepository, planRepo *postgres.SyncPlanRepository) *SyncService {
 	return &SyncService{
 		clusterRepo:    clusterRepo,
+		planRepo:       planRepo,
 		keycloakClient: keycloak.NewClient(),
 	}
 }
 
-// SyncRole syncs a role from source cluster to destination cluster
-func (s *SyncService) SyncRole(sourceClusterID, destinationClusterID int, roleName string) error {
-	// Get source cluster
-	sourceCluster, err := s.clusterRepo.GetByID(sourceClusterID)
+// SetRewriteService enables the environment specific rewrite rules for every sync
+func (s *SyncService) SetRewriteService(rewriteService *RewriteRuleService) {
+	s.rewriteService = rewriteService
+}
+
+// syncTarget holds a cluster together with an access token for it
+type syncTarget struct {
+	cluster *domain.Cluster
+	token   string
+
+	// Internal IDs of the clients steps referred to, resolved once per run
+	clientUUIDs map[string]string
+
+	// Rewrite rules for values copied into this cluster, only set on the destination
+	rewriter *Rewriter
+
+	// Set when the source listings come from a stored snapshot or a desired-state document;
+	// the source cluster is then never queried
+	preloaded bool
+
+	// Client scope definitions of a desired-state document, keyed by name
+	clientScopes map[string]map[string]interface{}
+
+	// Source listings, loaded once and shared by every object planned in the same run
+	roles         []domain.Role
+	clients       []map[string]interface{}
+	clientDetails []domain.ClientDetail
+	groups        []domain.GroupDetail
+	users         []domain.UserDetail
+}
+
+// SyncRole syncs a role from source cluster to destination cluster.
+// Missing prerequisites are created first and returned as dependencies.
+// An existing role is updated; removeExtra also drops composites the source role does not have.
+func (s *SyncService) SyncRole(sourceClusterID, destinationClusterID int, roleName string, removeExtra bool) (*domain.SyncResult, error) {
+	return s.syncObject(sourceClusterID, destinationClusterID, "role", roleName, removeExtra)
+}
+
+// SyncClient syncs a client from source cluster to destination cluster using export/import
+func (s *SyncService) SyncClient(sourceClusterID, destinationClusterID int, clientID string, removeExtra bool) (*domain.SyncResult, error) {
+	return s.syncObject(sourceClusterID, destinationClusterID, "client", clientID, removeExtra)
+}
+
+// SyncGroup syncs a group from source cluster to destination cluster
+func (s *SyncService) SyncGroup(sourceClusterID, destinationClusterID int, groupPath string, removeExtra bool) (*domain.SyncResult, error) {
+	return s.syncObject(sourceClusterID, destinationClusterID, "group", groupPath, removeExtra)
+}
+
+// SyncUser syncs a user from source cluster to destination cluster
+func (s *SyncService) SyncUser(sourceClusterID, destinationClusterID int, username string, removeExtra bool) (*domain.SyncResult, error) {
+	return s.syncObject(sourceClusterID, destinationClusterID, "user", username, removeExtra)
+}
+
+// syncObject computes a plan for a single object and executes it right away without storing it
+func (s *SyncService) syncObject(sourceClusterID, destinationClusterID int, objectType, objectName string, removeExtra bool) (*domain.SyncResult, error) {
+	source, dest, err := s.getSyncTargets(sourceClusterID, destinationClusterID)
 	if err != nil {
-		return fmt.Errorf("failed to get source cluster: %w", err)
-	}
-	if sourceCluster == nil {
-		return fmt.Errorf("source cluster not found")
+		return nil, err
 	}
-	
-	// Get destination cluster
-	destCluster, err := s.clusterRepo.GetByID(destinationClusterID)
-	if err != nil {
-		return fmt.Errorf("failed to get destination cluster: %w", err)
-	}
-	if destCluster == nil {
-		return fmt.Errorf("destination cluster not found")
-	}
-	
-	// Get access tokens
-	sourceTokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceCluster.ClientID,
-		sourceCluster.ClientSecret,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get source token: %w", err)
-	}
-	sourceToken := sourceTokenResp.AccessToken
-	
-	destTokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destCluster.ClientID,
-		destCluster.ClientSecret,
-	)
+
+	steps, deps, err := s.buildSteps(source, dest, objectType, objectName, removeExtra)
 	if err != nil {
-		return fmt.Errorf("failed to get destination token: %w", err)
+		return nil, err
+	}
+
+	results, ok := s.executeSteps(source, dest, steps)
+	result := &domain.SyncResult{
+		Dependencies: deps,
+		Changes:      collectChanges(steps),
+		Steps:        results,
+	}
+	if !ok {
+		return result, stepError(results)
 	}
-	destToken := destTokenResp.AccessToken
+
+	return result, nil
+}
+
+// CreatePlan computes every Admin REST call a sync would make and stores it for review.
+// Only read requests are sent to the clusters.
+func (s *SyncService) CreatePlan(req domain.CreateSyncPlanRequest, createdBy *int) (*domain.SyncPlan, error) {
+	source, dest, err := s.getSyncTargets(req.SourceClusterID, req.DestinationClusterID)
 	if err != nil {
-		return fmt.Errorf("failed to get destination token: %w", err)
-	}
-	
-	// Get role from source
-	sourceRoles, err := s.keycloakClient.GetRoles(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceToken,
-	)
+		return nil, err
+	}
+
+	steps, deps, err := s.buildSteps(source, dest, req.ObjectType, req.ObjectName, req.RemoveExtra)
 	if err != nil {
-		return fmt.Errorf("failed to get source roles: %w", err)
-	}
-	
-	var role *domain.Role
-	for _, r := range sourceRoles {
-		if r.Name == roleName {
-			role = &r
-			break
-		}
+		return nil, err
+	}
+
+	plan := &domain.SyncPlan{
+		SourceClusterID:      req.SourceClusterID,
+		DestinationClusterID: req.DestinationClusterID,
+		ObjectType:           req.ObjectType,
+		ObjectName:           req.ObjectName,
+		Steps:                steps,
+		Dependencies:         deps,
+		Status:               domain.SyncPlanStatusPending,
+		CreatedBy:            createdBy,
+	}
+
+	if err := s.planRepo.Create(plan); err != nil {
+		return nil, fmt.Errorf("failed to save sync plan: %w", err)
 	}
-	
-	if role == nil {
-		return fmt.Errorf("role not found in source cluster")
-	}
-	
-	// Create role in destination
-	return s.keycloakClient.CreateRole(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destToken,
-		*role,
-	)
+
+	return plan, nil
 }
 
-// SyncClient syncs a client from source cluster to destination cluster using export/import
-func (s *SyncService) SyncClient(sourceClusterID, destinationClusterID int, clientID string) error {
-	// Get clusters
-	sourceCluster, err := s.clusterRepo.GetByID(sourceClusterID)
-	if err != nil || sourceCluster == nil {
-		return fmt.Errorf("source cluster not found")
-	}
-	
-	destCluster, err := s.clusterRepo.GetByID(destinationClusterID)
-	if err != nil || destCluster == nil {
-		return fmt.Errorf("destination cluster not found")
-	}
-	
-	// Get access tokens
-	sourceTokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceCluster.ClientID,
-		sourceCluster.ClientSecret,
-	)
+func (s *SyncService) GetPlan(id int) (*domain.SyncPlan, error) {
+	return s.planRepo.GetByID(id)
+}
+
+func (s *SyncService) ListPlans(limit int) ([]*domain.SyncPlan, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return s.planRepo.GetRecent(limit)
+}
+
+// ApplyPlan executes exactly the steps of a stored plan against its destination cluster.
+// A plan can only be applied once.
+func (s *SyncService) ApplyPlan(id int, appliedBy *int) (*domain.SyncPlan, error) {
+	plan, err := s.planRepo.GetByID(id)
 	if err != nil {
-		return fmt.Errorf("failed to get source token: %w", err)
-	}
-	sourceToken := sourceTokenResp.AccessToken
-	
-	destTokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destCluster.ClientID,
-		destCluster.ClientSecret,
-	)
+		return nil, err
+	}
+	if plan == nil {
+		return nil, fmt.Errorf("sync plan not found")
+	}
+	if plan.Status != domain.SyncPlanStatusPending {
+		return nil, fmt.Errorf("sync plan is %s and cannot be applied", plan.Status)
+	}
+
+	claimed, err := s.planRepo.ClaimForApply(plan.ID)
 	if err != nil {
-		return fmt.Errorf("failed to get destination token: %w", err)
-	}
-	destToken := destTokenResp.AccessToken
-	
-	// Export all clients from source
-	exportedClients, err := s.keycloakClient.ExportClients(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceToken,
-	)
+		return nil, err
+	}
+	if !claimed {
+		return nil, fmt.Errorf("sync plan is already being applied")
+	}
+
+	plan.AppliedBy = appliedBy
+	dest, err := s.getSyncTarget(plan.DestinationClusterID, "destination")
+	var source *syncTarget
+	if err == nil && plan.SourceSnapshotID == nil && copiesSecrets(plan.Steps) {
+		source, err = s.getSyncTarget(plan.SourceClusterID, "source")
+	}
 	if err != nil {
-		return fmt.Errorf("failed to export clients from source: %w", err)
-	}
-	
-	// Find the specific client to sync
-	var clientToSync map[string]interface{}
-	for _, client := range exportedClients {
-		if clientIDStr, ok := client["clientId"].(string); ok && clientIDStr == clientID {
-			clientToSync = client
-			break
+		plan.Status = domain.SyncPlanStatusFailed
+		plan.Results = skipSteps(plan.Steps, err.Error())
+	} else {
+		results, ok := s.executeSteps(source, dest, plan.Steps)
+		plan.Results = results
+		if ok {
+			plan.Status = domain.SyncPlanStatusApplied
+		} else {
+			plan.Status = domain.SyncPlanStatusFailed
 		}
 	}
-	
-	if clientToSync == nil {
-		return fmt.Errorf("client not found in source cluster")
-	}
-	
-	// Import only the specific client to destination
-	clientsToImport := []map[string]interface{}{clientToSync}
-	if err := s.keycloakClient.ImportClients(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destToken,
-		clientsToImport,
-	); err != nil {
-		return fmt.Errorf("failed to import client: %w", err)
-	}
-
-	// After client import, sync client roles
-	// Get source client details to get client roles
-	sourceClientDetails, err := s.keycloakClient.GetClientDetails(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceToken,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get source client details: %w", err)
+
+	if err := s.planRepo.MarkApplied(plan); err != nil {
+		return nil, fmt.Errorf("failed to save sync plan results: %w", err)
 	}
 
-	var sourceClientDetail *domain.ClientDetail
-	for _, client := range sourceClientDetails {
-		if client.ClientID == clientID {
-			sourceClientDetail = &client
-			break
+	return plan, nil
+}
+
+// copiesSecrets reports whether a step reads a client secret from the source when it is applied
+func copiesSecrets(steps []domain.SyncPlanStep) bool {
+	for _, step := range steps {
+		if step.CopySecret {
+			return true
 		}
 	}
+	return false
+}
 
-	if sourceClientDetail == nil {
-		return fmt.Errorf("source client detail not found")
+// CreateRestorePlan plans restoring objects of one type from a snapshot into the cluster it was taken from.
+// The plan is stored for review and applied like any other sync plan.
+func (s *SyncService) CreateRestorePlan(snapshot *domain.RealmSnapshot, content *domain.RealmSnapshotContent, req domain.RestoreRequest, objectNames []string, createdBy *int) (*domain.SyncPlan, error) {
+	dest, err := s.getSyncTarget(snapshot.ClusterID, "destination")
+	if err != nil {
+		return nil, err
+	}
+
+	// Empty sections are kept non-nil so the source loaders never query the live cluster
+	source := &syncTarget{
+		cluster:       dest.cluster,
+		preloaded:     true,
+		roles:         append([]domain.Role{}, content.Roles...),
+		clients:       append([]map[string]interface{}{}, content.ClientExports...),
+		clientDetails: append([]domain.ClientDetail{}, content.Clients...),
+		groups:        append([]domain.GroupDetail{}, content.Groups...),
+		users:         append([]domain.UserDetail{}, content.Users...),
 	}
 
-	// Get destination client UUID
-	destClients, err := s.keycloakClient.GetClients(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destToken,
-	)
+	steps, deps, err := s.buildStepsFor(source, dest, req.ObjectType, objectNames, req.RemoveExtra)
 	if err != nil {
-		return fmt.Errorf("failed to get destination clients: %w", err)
+		return nil, err
 	}
 
-	var destClientUUID string
-	for _, client := range destClients {
-		if clientIDStr, ok := client["clientId"].(string); ok && clientIDStr == clientID {
-			if uuid, ok := client["id"].(string); ok {
-				destClientUUID = uuid
-				break
-			}
-		}
+	objectName := req.ObjectName
+	if objectName == "" {
+		objectName = "*"
 	}
 
-	if destClientUUID == "" {
-		return fmt.Errorf("destination client UUID not found after import")
+	plan := &domain.SyncPlan{
+		SourceClusterID:      snapshot.ClusterID,
+		SourceSnapshotID:     &snapshot.ID,
+		DestinationClusterID: snapshot.ClusterID,
+		ObjectType:           req.ObjectType,
+		ObjectName:           objectName,
+		Steps:                steps,
+		Dependencies:         deps,
+		Status:               domain.SyncPlanStatusPending,
+		CreatedBy:            createdBy,
 	}
 
-	// Get destination client roles
-	destClientRoles, err := s.keycloakClient.GetClientRoles(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destToken,
-		destClientUUID,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get destination client roles: %w", err)
+	if err := s.planRepo.Create(plan); err != nil {
+		return nil, fmt.Errorf("failed to save restore plan: %w", err)
 	}
 
-	// Create a map of destination client role names
-	destRoleMap := make(map[string]bool)
-	for _, role := range destClientRoles {
-		if roleName, ok := role["name"].(string); ok {
-			destRoleMap[roleName] = true
-		}
+	return plan, nil
+}
+
+func (s *SyncService) getSyncTargets(sourceClusterID, destinationClusterID int) (*syncTarget, *syncTarget, error) {
+	source, err := s.getSyncTarget(sourceClusterID, "source")
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Sync missing client roles from source to destination
-	for _, roleName := range sourceClientDetail.ClientRoles {
-		if !destRoleMap[roleName] {
-			// Get full role details from source
-			sourceClientUUID := sourceClientDetail.ID
-			sourceRoles, err := s.keycloakClient.GetClientRoles(
-				sourceCluster.BaseURL,
-				sourceCluster.Realm,
-				sourceToken,
-				sourceClientUUID,
-			)
-			if err != nil {
-				continue // Skip if we can't get source roles
-			}
-
-			var sourceRole map[string]interface{}
-			for _, role := range sourceRoles {
-				if name, ok := role["name"].(string); ok && name == roleName {
-					sourceRole = role
-					break
-				}
-			}
-
-			if sourceRole == nil {
-				continue
-			}
-
-			// Create role in destination
-			roleName, _ := sourceRole["name"].(string)
-			roleDesc, _ := sourceRole["description"].(string)
-			roleComposite, _ := sourceRole["composite"].(bool)
-			
-			role := domain.Role{
-				Name:        roleName,
-				Description: roleDesc,
-				Composite:   roleComposite,
-			}
-
-			if err := s.keycloakClient.CreateClientRole(
-				destCluster.BaseURL,
-				destCluster.Realm,
-				destToken,
-				destClientUUID,
-				role,
-			); err != nil {
-				// Log error but continue with other roles
-				fmt.Printf("Warning: failed to create client role %s: %v\n", roleName, err)
-			}
-		}
+	dest, err := s.getSyncTarget(destinationClusterID, "destination")
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Sync client scopes (default and optional)
-	// First, ensure all scopes exist in destination realm before assigning them to client
-	allScopesToSync := make(map[string]bool)
-	for _, scopeName := range sourceClientDetail.DefaultClientScopes {
-		allScopesToSync[scopeName] = true
-	}
-	for _, scopeName := range sourceClientDetail.OptionalClientScopes {
-		allScopesToSync[scopeName] = true
-	}
-	
-	// For each scope that needs to be synced, check if it exists in destination
-	for scopeName := range allScopesToSync {
-		// Get source scope details
-		sourceScopeDetails, err := s.keycloakClient.GetClientScopeDetails(
-			sourceCluster.BaseURL,
-			sourceCluster.Realm,
-			sourceToken,
-			scopeName,
-		)
+	if s.rewriteService != nil {
+		dest.rewriter, err = s.rewriteService.RewriterFor(sourceClusterID, destinationClusterID)
 		if err != nil {
-			fmt.Printf("Warning: failed to get source client scope details for '%s': %v\n", scopeName, err)
-			continue
+			return nil, nil, err
 		}
-		
-		// Try to get the scope from destination - if it fails, it doesn't exist
-		destScopeDetails, err := s.keycloakClient.GetClientScopeDetails(
-			destCluster.BaseURL,
-			destCluster.Realm,
-			destToken,
-			scopeName,
-		)
-		if err != nil {
-			// Scope doesn't exist in destination, create it with all mappers
-			if err := s.keycloakClient.CreateClientScope(
-				destCluster.BaseURL,
-				destCluster.Realm,
-				destToken,
-				sourceScopeDetails,
-			); err != nil {
-				fmt.Printf("Warning: failed to create client scope '%s' in destination realm: %v\n", scopeName, err)
-			} else {
-				fmt.Printf("Successfully created client scope '%s' in destination realm\n", scopeName)
-			}
-		} else {
-			// Scope exists, sync mappers
-			if err := s.syncScopeMappers(
-				sourceCluster,
-				destCluster,
-				sourceToken,
-				destToken,
-				sourceScopeDetails,
-				destScopeDetails,
-				scopeName,
-			); err != nil {
-				fmt.Printf("Warning: failed to sync mappers for scope '%s': %v\n", scopeName, err)
-			}
-		}
-	}
-	
-	// Now sync scopes to client (they should all exist in realm now)
-	// Always sync scopes, even if empty (to ensure consistency)
-	if err := s.keycloakClient.UpdateClientScopes(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destToken,
-		destClientUUID,
-		"default",
-		sourceClientDetail.DefaultClientScopes,
-	); err != nil {
-		// Log error but don't fail the entire sync
-		fmt.Printf("Warning: failed to sync default client scopes for client %s: %v\n", clientID, err)
-	}
-
-	if err := s.keycloakClient.UpdateClientScopes(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destToken,
-		destClientUUID,
-		"optional",
-		sourceClientDetail.OptionalClientScopes,
-	); err != nil {
-		// Log error but don't fail the entire sync
-		fmt.Printf("Warning: failed to sync optional client scopes for client %s: %v\n", clientID, err)
 	}
 
-	return nil
+	return source, dest, nil
 }
 
-// syncScopeMappers syncs protocol mappers from source scope to destination scope
-func (s *SyncService) syncScopeMappers(
-	sourceCluster, destCluster *domain.Cluster,
-	sourceToken, destToken string,
-	sourceScopeDetails, destScopeDetails map[string]interface{},
-	scopeName string,
-) error {
-	// Get source mappers
-	var sourceMappers []map[string]interface{}
-	if mappers, ok := sourceScopeDetails["protocolMappers"].([]interface{}); ok {
-		for _, m := range mappers {
-			if mapper, ok := m.(map[string]interface{}); ok {
-				sourceMappers = append(sourceMappers, mapper)
-			}
-		}
-	} else {
-		// If protocolMappers is not in the response, fetch it separately
-		if scopeID, ok := sourceScopeDetails["id"].(string); ok && scopeID != "" {
-			if mappers, err := s.keycloakClient.GetClientScopeMappers(
-				sourceCluster.BaseURL,
-				sourceCluster.Realm,
-				sourceToken,
-				scopeID,
-			); err == nil {
-				sourceMappers = mappers
-			}
-		}
+func (s *SyncService) getSyncTarget(clusterID int, side string) (*syncTarget, error) {
+	cluster, err := s.clusterRepo.GetByID(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s cluster: %w", side, err)
 	}
-	
-	// Get destination mappers
-	var destMappers []map[string]interface{}
-	if mappers, ok := destScopeDetails["protocolMappers"].([]interface{}); ok {
-		for _, m := range mappers {
-			if mapper, ok := m.(map[string]interface{}); ok {
-				destMappers = append(destMappers, mapper)
-			}
-		}
-	} else {
-		// If protocolMappers is not in the response, fetch it separately
-		if scopeID, ok := destScopeDetails["id"].(string); ok && scopeID != "" {
-			if mappers, err := s.keycloakClient.GetClientScopeMappers(
-				destCluster.BaseURL,
-				destCluster.Realm,
-				destToken,
-				scopeID,
-			); err == nil {
-				destMappers = mappers
-			}
-		}
+	if cluster == nil {
+		return nil, fmt.Errorf("%s cluster not found", side)
 	}
-	
-	// Create a map of destination mapper names for quick lookup
-	destMapperMap := make(map[string]map[string]interface{})
-	for _, mapper := range destMappers {
-		if name, ok := mapper["name"].(string); ok {
-			destMapperMap[name] = mapper
-		}
+
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s token: %w", side, err)
 	}
-	
-	// Get destination scope ID
-	destScopeID, ok := destScopeDetails["id"].(string)
-	if !ok || destScopeID == "" {
-		return fmt.Errorf("destination scope ID not found for scope '%s'", scopeName)
-	}
-	
-	// Sync each source mapper to destination
-	for _, sourceMapper := range sourceMappers {
-		mapperName, ok := sourceMapper["name"].(string)
-		if !ok || mapperName == "" {
-			continue
-		}
-		
-		_, exists := destMapperMap[mapperName]
-		if !exists {
-			// Mapper doesn't exist in destination, create it
-			if err := s.keycloakClient.CreateClientScopeMapper(
-				destCluster.BaseURL,
-				destCluster.Realm,
-				destToken,
-				destScopeID,
-				sourceMapper,
-			); err != nil {
-				fmt.Printf("Warning: failed to create mapper '%s' for scope '%s': %v\n", mapperName, scopeName, err)
-			} else {
-				fmt.Printf("Successfully created mapper '%s' for scope '%s'\n", mapperName, scopeName)
-			}
-		} else {
-			// Mapper exists, check if config is different
-			// For now, we'll skip updating existing mappers as it requires DELETE + CREATE
-			// or PUT with full mapper config. This can be enhanced later if needed.
-			// Just log that mapper already exists
-			fmt.Printf("Mapper '%s' already exists in scope '%s', skipping\n", mapperName, scopeName)
-		}
+
+	return &syncTarget{
+		cluster: cluster,
+		token:   tokenResp.AccessToken,
+	}, nil
+}
+
+// refreshToken takes the cluster's current access token from the token cache, which renews tokens
+// before they expire. Long runs call it before every step, so they never send an expired token.
+func (s *SyncService) refreshToken(target *syncTarget) error {
+	tokenResp, err := s.keycloakClient.GetClusterToken(target.cluster)
+	if err != nil {
+		return fmt.Errorf("failed to refresh token for cluster %s: %w", target.cluster.Name, err)
 	}
-	
+
+	target.token = tokenResp.AccessToken
 	return nil
 }
 
-// SyncGroup syncs a group from source cluster to destination cluster
-func (s *SyncService) SyncGroup(sourceClusterID, destinationClusterID int, groupPath string) error {
-	// Get clusters
-	sourceCluster, err := s.clusterRepo.GetByID(sourceClusterID)
-	if err != nil || sourceCluster == nil {
-		return fmt.Errorf("source cluster not found")
-	}
-	
-	destCluster, err := s.clusterRepo.GetByID(destinationClusterID)
-	if err != nil || destCluster == nil {
-		return fmt.Errorf("destination cluster not found")
-	}
-	
-	// Get access tokens
-	sourceTokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceCluster.ClientID,
-		sourceCluster.ClientSecret,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get source token: %w", err)
-	}
-	sourceToken := sourceTokenResp.AccessToken
-	
-	destTokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destCluster.ClientID,
-		destCluster.ClientSecret,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get destination token: %w", err)
+func (s *SyncService) sourceRoles(source *syncTarget) ([]domain.Role, error) {
+	if source.roles == nil {
+		roles, err := s.keycloakClient.GetRoleDetails(source.cluster.BaseURL, source.cluster.Realm, source.token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get source roles: %w", err)
+		}
+		source.roles = roles
 	}
-	destToken := destTokenResp.AccessToken
-	if err != nil {
-		return fmt.Errorf("failed to get destination token: %w", err)
-	}
-	
-	// Get group details from source
-	sourceGroups, err := s.keycloakClient.GetGroupDetails(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceToken,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get source groups: %w", err)
-	}
-	
-	var group *domain.GroupDetail
-	for _, g := range sourceGroups {
-		if g.Path == groupPath {
-			group = &g
-			break
+	return source.roles, nil
+}
+
+func (s *SyncService) sourceClients(source *syncTarget) ([]map[string]interface{}, error) {
+	if source.clients == nil {
+		clients, err := s.keycloakClient.ExportClients(source.cluster.BaseURL, source.cluster.Realm, source.token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export clients from source: %w", err)
 		}
+		source.clients = clients
 	}
-	
-	if group == nil {
-		return fmt.Errorf("group not found in source cluster")
-	}
-	
-	// Create group in destination
-	return s.keycloakClient.CreateGroup(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destToken,
-		*group,
-	)
+	return source.clients, nil
 }
 
-// SyncUser syncs a user from source cluster to destination cluster
-func (s *SyncService) SyncUser(sourceClusterID, destinationClusterID int, username string) error {
-	// Get clusters
-	sourceCluster, err := s.clusterRepo.GetByID(sourceClusterID)
-	if err != nil || sourceCluster == nil {
-		return fmt.Errorf("source cluster not found")
-	}
-	
-	destCluster, err := s.clusterRepo.GetByID(destinationClusterID)
-	if err != nil || destCluster == nil {
-		return fmt.Errorf("destination cluster not found")
-	}
-	
-	// Get access tokens
-	sourceTokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceCluster.ClientID,
-		sourceCluster.ClientSecret,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get source token: %w", err)
-	}
-	sourceToken := sourceTokenResp.AccessToken
-	
-	destTokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destCluster.ClientID,
-		destCluster.ClientSecret,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get destination token: %w", err)
+func (s *SyncService) sourceClientDetails(source *syncTarget) ([]domain.ClientDetail, error) {
+	if source.clientDetails == nil {
+		details, err := s.keycloakClient.GetClientDetails(source.cluster.BaseURL, source.cluster.Realm, source.token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get source client details: %w", err)
+		}
+		source.clientDetails = details
 	}
-	destToken := destTokenResp.AccessToken
-	if err != nil {
-		return fmt.Errorf("failed to get destination token: %w", err)
-	}
-	
-	// Get user details from source
-	sourceUsers, err := s.keycloakClient.GetUserDetails(
-		sourceCluster.BaseURL,
-		sourceCluster.Realm,
-		sourceToken,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to get source users: %w", err)
-	}
-	
-	var user *domain.UserDetail
-	for _, u := range sourceUsers {
-		if u.Username == username {
-			user = &u
-			break
+	return source.clientDetails, nil
+}
+
+func (s *SyncService) sourceGroups(source *syncTarget) ([]domain.GroupDetail, error) {
+	if source.groups == nil {
+		groups, err := s.keycloakClient.GetGroupDetails(source.cluster.BaseURL, source.cluster.Realm, source.token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get source groups: %w", err)
 		}
+		source.groups = groups
 	}
-	
-	if user == nil {
-		return fmt.Errorf("user not found in source cluster")
-	}
-	
-	// Create user in destination
-	return s.keycloakClient.CreateUser(
-		destCluster.BaseURL,
-		destCluster.Realm,
-		destToken,
-		*user,
-	)
+	return source.groups, nil
 }
 
+func (s *SyncService) sourceUsers(source *syncTarget) ([]domain.UserDetail, error) {
+	if source.users == nil {
+		users, err := s.keycloakClient.GetUserDetails(source.cluster.BaseURL, source.cluster.Realm, source.token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get source users: %w", err)
+		}
+		source.users = users
+	}
+	return source.users, nil
+}
@@ -0,0 +1,504 @@
+package service
+
+import (
+	"fmt"
+	"keycloak-multi-manage/internal/domain"
+	"strings"
+)
+
+// planRoleUpdate brings an existing destination role to the source role's description, attributes and composites
+func (p *syncPlanner) planRoleUpdate(role *domain.Role) error {
+	destRole, err := p.s.keycloakClient.GetRoleDetail(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token, role.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get destination role '%s': %w", role.Name, err)
+	}
+
+	// A secret masked in a snapshot keeps the destination's value
+	updated := *role
+	updated.Attributes = keepMaskedAttributes(role.Attributes, destRole.Attributes)
+	role = &updated
+
+	object := "role:" + role.Name
+	var changes []domain.SyncFieldChange
+	if role.Description != destRole.Description {
+		changes = append(changes, domain.SyncFieldChange{Object: object, Field: "description", From: destRole.Description, To: role.Description})
+	}
+	if !equalAttributes(role.Attributes, destRole.Attributes) {
+		changes = append(changes, domain.SyncFieldChange{Object: object, Field: "attributes", From: destRole.Attributes, To: role.Attributes})
+	}
+
+	if len(changes) > 0 {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionUpdateRole,
+			Method:      "PUT",
+			Path:        fmt.Sprintf("/admin/realms/%s/roles/%s", p.dest.cluster.Realm, role.Name),
+			Target:      role.Name,
+			Description: fmt.Sprintf("Update %s of role '%s'", changedFields(changes), role.Name),
+			Payload: map[string]interface{}{
+				"name":        role.Name,
+				"description": role.Description,
+				"composite":   role.Composite || destRole.Composite,
+				"attributes":  role.Attributes,
+			},
+			Changes: changes,
+		})
+	}
+
+	sourceComposites := roleComposites(*role)
+	destComposites := roleComposites(*destRole)
+
+	var compositeChanges []domain.SyncFieldChange
+	if change := p.mappingChange(object, "composites.realm", destComposites.Realm, sourceComposites.Realm); change != nil {
+		compositeChanges = append(compositeChanges, *change)
+	}
+	for _, clientID := range sortedKeys(mergeClientRoles(sourceComposites.Client, destComposites.Client)) {
+		if change := p.mappingChange(object, "composites.client."+clientID, destComposites.Client[clientID], sourceComposites.Client[clientID]); change != nil {
+			compositeChanges = append(compositeChanges, *change)
+		}
+	}
+
+	missing := domain.RoleComposites{
+		Realm:  missingFrom(sourceComposites.Realm, destComposites.Realm),
+		Client: missingClientRoles(sourceComposites.Client, destComposites.Client),
+	}
+	if len(missing.Realm) > 0 || len(missing.Client) > 0 {
+		for _, childName := range missing.Realm {
+			if err := p.require("role", childName, object); err != nil {
+				return err
+			}
+		}
+		for _, clientID := range sortedKeys(missing.Client) {
+			for _, childName := range missing.Client[clientID] {
+				if err := p.requireClientRole(clientID, childName, object); err != nil {
+					return err
+				}
+			}
+		}
+
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionAddRoleComposites,
+			Method:      "POST",
+			Path:        fmt.Sprintf("/admin/realms/%s/roles/%s/composites", p.dest.cluster.Realm, role.Name),
+			Target:      role.Name,
+			Description: fmt.Sprintf("Add composite children to role '%s' (realm: %v, client: %v)", role.Name, missing.Realm, missing.Client),
+			Payload: map[string]interface{}{
+				"realm":  missing.Realm,
+				"client": missing.Client,
+			},
+			Changes: compositeChanges,
+		})
+		compositeChanges = nil
+	}
+
+	if !p.removeExtra {
+		return nil
+	}
+
+	extra := domain.RoleComposites{
+		Realm:  missingFrom(destComposites.Realm, sourceComposites.Realm),
+		Client: missingClientRoles(destComposites.Client, sourceComposites.Client),
+	}
+	if len(extra.Realm) > 0 || len(extra.Client) > 0 {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionRemoveRoleComposites,
+			Method:      "DELETE",
+			Path:        fmt.Sprintf("/admin/realms/%s/roles/%s/composites", p.dest.cluster.Realm, role.Name),
+			Target:      role.Name,
+			Description: fmt.Sprintf("Remove composite children from role '%s' (realm: %v, client: %v)", role.Name, extra.Realm, extra.Client),
+			Payload: map[string]interface{}{
+				"realm":  extra.Realm,
+				"client": extra.Client,
+			},
+			Changes: compositeChanges,
+		})
+	}
+
+	return nil
+}
+
+// planGroupUpdate brings an existing destination group to the source group's attributes and role mappings
+func (p *syncPlanner) planGroupUpdate(group *domain.GroupDetail) error {
+	destGroupID, err := p.s.keycloakClient.FindGroupIDByPath(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token, group.Path)
+	if err != nil {
+		return fmt.Errorf("failed to get destination groups: %w", err)
+	}
+	destGroup, err := p.s.keycloakClient.GetGroupDetail(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token, destGroupID)
+	if err != nil {
+		return fmt.Errorf("failed to get destination group '%s': %w", group.Path, err)
+	}
+
+	// Compare and write the values as the rewrite rules of this cluster pair turn them out
+	rewritten := *group
+	rewrites, err := p.dest.rewriter.ApplyTo("group", group.Path, &rewritten)
+	if err != nil {
+		return err
+	}
+	group = &rewritten
+	group.Attributes = keepMaskedAttributes(group.Attributes, destGroup.Attributes)
+
+	object := "group:" + group.Path
+	if !equalAttributes(group.Attributes, destGroup.Attributes) {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionUpdateGroup,
+			Method:      "PUT",
+			Path:        fmt.Sprintf("/admin/realms/%s/groups/%s", p.dest.cluster.Realm, destGroupID),
+			Target:      group.Path,
+			Description: fmt.Sprintf("Update attributes of group '%s'", group.Path),
+			Payload: map[string]interface{}{
+				"name":       group.Name,
+				"path":       group.Path,
+				"attributes": group.Attributes,
+			},
+			Changes:  []domain.SyncFieldChange{{Object: object, Field: "attributes", From: destGroup.Attributes, To: group.Attributes}},
+			Rewrites: rewrites,
+		})
+	}
+
+	return p.planRoleMappingUpdate(
+		"group",
+		group.Path,
+		fmt.Sprintf("/admin/realms/%s/groups/%s", p.dest.cluster.Realm, destGroupID),
+		group.RealmRoles, destGroup.RealmRoles,
+		group.ClientRoles, destGroup.ClientRoles,
+	)
+}
+
+// planUserUpdate brings an existing destination user to the source user's profile, role mappings and group membership
+func (p *syncPlanner) planUserUpdate(user *domain.UserDetail, destUserID string) error {
+	destUser, err := p.s.keycloakClient.GetUserDetail(p.dest.cluster.BaseURL, p.dest.cluster.Realm, p.dest.token, destUserID)
+	if err != nil {
+		return fmt.Errorf("failed to get destination user '%s': %w", user.Username, err)
+	}
+
+	rewritten := *user
+	rewrites, err := p.dest.rewriter.ApplyTo("user", user.Username, &rewritten)
+	if err != nil {
+		return err
+	}
+	user = &rewritten
+	user.Attributes = keepMaskedAttributes(user.Attributes, destUser.Attributes)
+
+	object := "user:" + user.Username
+	var changes []domain.SyncFieldChange
+	if user.Email != destUser.Email {
+		changes = append(changes, domain.SyncFieldChange{Object: object, Field: "email", From: destUser.Email, To: user.Email})
+	}
+	if user.FirstName != destUser.FirstName {
+		changes = append(changes, domain.SyncFieldChange{Object: object, Field: "firstName", From: destUser.FirstName, To: user.FirstName})
+	}
+	if user.LastName != destUser.LastName {
+		changes = append(changes, domain.SyncFieldChange{Object: object, Field: "lastName", From: destUser.LastName, To: user.LastName})
+	}
+	if user.Enabled != destUser.Enabled {
+		changes = append(changes, domain.SyncFieldChange{Object: object, Field: "enabled", From: destUser.Enabled, To: user.Enabled})
+	}
+	if !equalStringSlices(user.RequiredActions, destUser.RequiredActions) {
+		changes = append(changes, domain.SyncFieldChange{Object: object, Field: "requiredActions", From: destUser.RequiredActions, To: user.RequiredActions})
+	}
+	if !equalAttributes(user.Attributes, destUser.Attributes) {
+		changes = append(changes, domain.SyncFieldChange{Object: object, Field: "attributes", From: destUser.Attributes, To: user.Attributes})
+	}
+
+	// Groups have to exist before memberships and role mappings reference them
+	missingGroups := missingFrom(user.Groups, destUser.Groups)
+	for _, groupPath := range missingGroups {
+		if err := p.require("group", groupPath, object); err != nil {
+			return err
+		}
+	}
+
+	userPath := fmt.Sprintf("/admin/realms/%s/users/%s", p.dest.cluster.Realm, destUserID)
+	if len(changes) > 0 {
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      domain.SyncActionUpdateUser,
+			Method:      "PUT",
+			Path:        userPath,
+			Target:      user.Username,
+			Description: fmt.Sprintf("Update %s of user '%s'", changedFields(changes), user.Username),
+			Payload: map[string]interface{}{
+				"username":        user.Username,
+				"email":           user.Email,
+				"firstName":       user.FirstName,
+				"lastName":        user.LastName,
+				"enabled":         user.Enabled,
+				"attributes":      user.Attributes,
+				"requiredActions": user.RequiredActions,
+			},
+			Changes:  changes,
+			Rewrites: rewrites,
+		})
+	}
+
+	if err := p.planRoleMappingUpdate(
+		"user",
+		user.Username,
+		userPath,
+		user.RealmRoles, destUser.RealmRoles,
+		user.ClientRoles, destUser.ClientRoles,
+	); err != nil {
+		return err
+	}
+
+	groupChange := p.mappingChange(object, "groups", destUser.Groups, user.Groups)
+	for _, groupPath := range missingGroups {
+		step := domain.SyncPlanStep{
+			Action:      domain.SyncActionAddUserToGroup,
+			Method:      "PUT",
+			Path:        fmt.Sprintf("%s/groups/{id of %s}", userPath, groupPath),
+			Target:      user.Username,
+			Parent:      groupPath,
+			Description: fmt.Sprintf("Add user '%s' to group '%s'", user.Username, groupPath),
+		}
+		if groupChange != nil {
+			step.Changes = []domain.SyncFieldChange{*groupChange}
+			groupChange = nil
+		}
+		p.steps = append(p.steps, step)
+	}
+
+	if p.removeExtra {
+		for _, groupPath := range missingFrom(destUser.Groups, user.Groups) {
+			step := domain.SyncPlanStep{
+				Action:      domain.SyncActionRemoveUserFromGroup,
+				Method:      "DELETE",
+				Path:        fmt.Sprintf("%s/groups/{id of %s}", userPath, groupPath),
+				Target:      user.Username,
+				Parent:      groupPath,
+				Description: fmt.Sprintf("Remove user '%s' from group '%s'", user.Username, groupPath),
+			}
+			if groupChange != nil {
+				step.Changes = []domain.SyncFieldChange{*groupChange}
+				groupChange = nil
+			}
+			p.steps = append(p.steps, step)
+		}
+	}
+
+	return nil
+}
+
+// planRoleMappingUpdate adds the realm and client role mappings a group or user is missing and,
+// with removeExtra, removes the ones the source does not have
+func (p *syncPlanner) planRoleMappingUpdate(
+	kind, target, basePath string,
+	sourceRealmRoles, destRealmRoles []string,
+	sourceClientRoles, destClientRoles map[string][]string,
+) error {
+	object := kind + ":" + target
+	assignRealm, assignClient := domain.SyncActionAssignGroupRoles, domain.SyncActionAssignGroupClientRoles
+	removeRealm, removeClient := domain.SyncActionRemoveGroupRoles, domain.SyncActionRemoveGroupClientRoles
+	if kind == "user" {
+		assignRealm, assignClient = domain.SyncActionAssignUserRoles, domain.SyncActionAssignUserClientRoles
+		removeRealm, removeClient = domain.SyncActionRemoveUserRoles, domain.SyncActionRemoveUserClientRoles
+	}
+
+	// The default roles composite is named after the realm, Keycloak manages it on both sides
+	sourceRealmRoles = withoutDefaultRealmRoles(sourceRealmRoles)
+	destRealmRoles = withoutDefaultRealmRoles(destRealmRoles)
+
+	realmChange := p.mappingChange(object, "realmRoles", destRealmRoles, sourceRealmRoles)
+	if missing := missingFrom(sourceRealmRoles, destRealmRoles); len(missing) > 0 {
+		for _, roleName := range missing {
+			if err := p.require("role", roleName, object); err != nil {
+				return err
+			}
+		}
+		p.steps = append(p.steps, domain.SyncPlanStep{
+			Action:      assignRealm,
+			Method:      "POST",
+			Path:        basePath + "/role-mappings/realm",
+			Target:      target,
+			Description: fmt.Sprintf("Assign realm roles %v to %s '%s'", missing, kind, target),
+			Payload:     map[string]interface{}{"roles": missing},
+			Changes:     changeList(realmChange),
+		})
+		realmChange = nil
+	}
+	if p.removeExtra {
+		if extra := missingFrom(destRealmRoles, sourceRealmRoles); len(extra) > 0 {
+			p.steps = append(p.steps, domain.SyncPlanStep{
+				Action:      removeRealm,
+				Method:      "DELETE",
+				Path:        basePath + "/role-mappings/realm",
+				Target:      target,
+				Description: fmt.Sprintf("Remove realm roles %v from %s '%s'", extra, kind, target),
+				Payload:     map[string]interface{}{"roles": extra},
+				Changes:     changeList(realmChange),
+			})
+		}
+	}
+
+	for _, clientID := range sortedKeys(mergeClientRoles(sourceClientRoles, destClientRoles)) {
+		clientChange := p.mappingChange(object, "clientRoles."+clientID, destClientRoles[clientID], sourceClientRoles[clientID])
+		if missing := missingFrom(sourceClientRoles[clientID], destClientRoles[clientID]); len(missing) > 0 {
+			for _, roleName := range missing {
+				if err := p.requireClientRole(clientID, roleName, object); err != nil {
+					return err
+				}
+			}
+			p.steps = append(p.steps, domain.SyncPlanStep{
+				Action:      assignClient,
+				Method:      "POST",
+				Path:        fmt.Sprintf("%s/role-mappings/clients/{id of %s}", basePath, clientID),
+				Target:      target,
+				Parent:      clientID,
+				Description: fmt.Sprintf("Assign client roles %v of '%s' to %s '%s'", missing, clientID, kind, target),
+				Payload:     map[string]interface{}{"roles": missing},
+				Changes:     changeList(clientChange),
+			})
+			clientChange = nil
+		}
+		if p.removeExtra {
+			if extra := missingFrom(destClientRoles[clientID], sourceClientRoles[clientID]); len(extra) > 0 {
+				p.steps = append(p.steps, domain.SyncPlanStep{
+					Action:      removeClient,
+					Method:      "DELETE",
+					Path:        fmt.Sprintf("%s/role-mappings/clients/{id of %s}", basePath, clientID),
+					Target:      target,
+					Parent:      clientID,
+					Description: fmt.Sprintf("Remove client roles %v of '%s' from %s '%s'", extra, clientID, kind, target),
+					Payload:     map[string]interface{}{"roles": extra},
+					Changes:     changeList(clientChange),
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// mappingChange describes how a list-valued field changes: missing source entries are added and,
+// with removeExtra, destination-only entries are removed. It returns nil when nothing changes.
+func (p *syncPlanner) mappingChange(object, field string, from, source []string) *domain.SyncFieldChange {
+	to := append([]string{}, source...)
+	if !p.removeExtra {
+		to = append(to, missingFrom(from, source)...)
+	}
+	if equalStringSlices(from, to) {
+		return nil
+	}
+	return &domain.SyncFieldChange{Object: object, Field: field, From: from, To: to}
+}
+
+func changeList(change *domain.SyncFieldChange) []domain.SyncFieldChange {
+	if change == nil {
+		return nil
+	}
+	return []domain.SyncFieldChange{*change}
+}
+
+// collectChanges flattens the field changes of all plan steps
+func collectChanges(steps []domain.SyncPlanStep) []domain.SyncFieldChange {
+	var changes []domain.SyncFieldChange
+	for _, step := range steps {
+		changes = append(changes, step.Changes...)
+	}
+	return changes
+}
+
+func changedFields(changes []domain.SyncFieldChange) string {
+	fields := make([]string, 0, len(changes))
+	for _, change := range changes {
+		fields = append(fields, change.Field)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// missingFrom returns the entries of a that are not in b
+func missingFrom(a, b []string) []string {
+	present := make(map[string]bool, len(b))
+	for _, v := range b {
+		present[v] = true
+	}
+	var missing []string
+	for _, v := range a {
+		if !present[v] {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
+
+// missingClientRoles returns, per client, the roles of a that are not in b
+func missingClientRoles(a, b map[string][]string) map[string][]string {
+	missing := make(map[string][]string)
+	for clientID, roles := range a {
+		if diff := missingFrom(roles, b[clientID]); len(diff) > 0 {
+			missing[clientID] = diff
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return missing
+}
+
+// mergeClientRoles returns a map containing every client of a and b, used to iterate over both sides
+func mergeClientRoles(a, b map[string][]string) map[string][]string {
+	merged := make(map[string][]string, len(a)+len(b))
+	for clientID := range a {
+		merged[clientID] = nil
+	}
+	for clientID := range b {
+		merged[clientID] = nil
+	}
+	return merged
+}
+
+// keepMaskedAttributes returns the source attributes with masked secret values replaced by the
+// destination's values. Snapshots only hold the placeholder, so such an attribute is kept as it is
+// in the destination, or left out when the destination does not have it.
+func keepMaskedAttributes(source, dest map[string][]string) map[string][]string {
+	var attributes map[string][]string
+	for key, values := range source {
+		masked := false
+		for _, value := range values {
+			masked = masked || value == maskedSecret
+		}
+		if !masked {
+			continue
+		}
+
+		if attributes == nil {
+			attributes = make(map[string][]string, len(source))
+			for k, v := range source {
+				attributes[k] = v
+			}
+		}
+		if destValues, ok := dest[key]; ok {
+			attributes[key] = destValues
+		} else {
+			delete(attributes, key)
+		}
+	}
+
+	if attributes == nil {
+		return source
+	}
+	return attributes
+}
+
+// withoutMaskedValues copies a representation without the values that are only the masked placeholder
+func withoutMaskedValues(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, v != maskedSecret
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if item, keep := withoutMaskedValues(item); keep {
+				copied[key] = item
+			}
+		}
+		return copied, true
+	case []interface{}:
+		copied := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if item, keep := withoutMaskedValues(item); keep {
+				copied = append(copied, item)
+			}
+		}
+		return copied, true
+	}
+	return value, true
+}
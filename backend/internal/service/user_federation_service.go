@@ -35,12 +35,7 @@ func (s *UserFederationService) GetUserFederationProviders(clusterID int, realm
 	}
 
 	// Get access token using client credentials
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -125,12 +120,7 @@ func (s *UserFederationService) GetUserFederationProvider(clusterID int, realm,
 	}
 
 	// Get access token using client credentials
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -196,12 +186,7 @@ func (s *UserFederationService) CreateUserFederationProvider(clusterID int, real
 	}
 
 	// Get access token using client credentials
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -254,6 +239,9 @@ func (s *UserFederationService) CreateUserFederationProvider(clusterID int, real
 		cleaned := cleanControlChars(v)
 		config[k] = []string{cleaned}
 	}
+	if err := s.resolveBindCredential(config); err != nil {
+		return nil, err
+	}
 	
 	// Set required Keycloak fields
 	// Edit Mode is mandatory for LDAP providers
@@ -436,12 +424,7 @@ func (s *UserFederationService) UpdateUserFederationProvider(clusterID int, real
 	}
 
 	// Get access token using client credentials
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -503,6 +486,9 @@ func (s *UserFederationService) UpdateUserFederationProvider(clusterID int, real
 		cleaned := cleanControlChars(v)
 		config[k] = []string{cleaned}
 	}
+	if err := s.resolveBindCredential(config); err != nil {
+		return nil, err
+	}
 	
 	// Ensure required fields are set correctly
 	// Priority - overwrite empty strings
@@ -591,6 +577,23 @@ func (s *UserFederationService) UpdateUserFederationProvider(clusterID int, real
 	return s.GetUserFederationProvider(clusterID, targetRealm, providerID)
 }
 
+// resolveBindCredential replaces a bindCredentialRef with the bind credential it points to, e.g.
+// "vault:ldap/prod#password", so the credential is read from the secret store only when it is sent to Keycloak
+func (s *UserFederationService) resolveBindCredential(config map[string][]string) error {
+	ref := config["bindCredentialRef"]
+	delete(config, "bindCredentialRef")
+	if len(ref) == 0 || ref[0] == "" {
+		return nil
+	}
+
+	secret, err := s.keycloakClient.ResolveSecret(ref[0])
+	if err != nil {
+		return fmt.Errorf("failed to resolve bindCredentialRef: %w", err)
+	}
+	config["bindCredential"] = []string{secret}
+	return nil
+}
+
 // DeleteUserFederationProvider deletes a user federation provider
 func (s *UserFederationService) DeleteUserFederationProvider(clusterID int, realm, providerID string) error {
 	cluster, err := s.clusterRepo.GetByID(clusterID)
@@ -602,12 +605,7 @@ func (s *UserFederationService) DeleteUserFederationProvider(clusterID int, real
 	}
 
 	// Get access token using client credentials
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -634,12 +632,7 @@ func (s *UserFederationService) TestUserFederationConnection(clusterID int, real
 	}
 
 	// Get access token using client credentials
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
@@ -655,25 +648,20 @@ func (s *UserFederationService) TestUserFederationConnection(clusterID int, real
 	return s.keycloakClient.TestUserFederationConnection(cluster.BaseURL, targetRealm, accessToken, providerID)
 }
 
-// SyncUserFederation syncs users from a user federation provider
-func (s *UserFederationService) SyncUserFederation(clusterID int, realm, providerID string, req domain.SyncUserFederationRequest) error {
+// SyncUserFederation syncs users from a user federation provider and returns Keycloak's synchronization result, if any
+func (s *UserFederationService) SyncUserFederation(clusterID int, realm, providerID string, req domain.SyncUserFederationRequest) (map[string]interface{}, error) {
 	cluster, err := s.clusterRepo.GetByID(clusterID)
 	if err != nil {
-		return fmt.Errorf("failed to get cluster: %w", err)
+		return nil, fmt.Errorf("failed to get cluster: %w", err)
 	}
 	if cluster == nil {
-		return fmt.Errorf("cluster not found")
+		return nil, fmt.Errorf("cluster not found")
 	}
 
 	// Get access token using client credentials
-	tokenResp, err := s.keycloakClient.GetClientCredentialsToken(
-		cluster.BaseURL,
-		cluster.Realm,
-		cluster.ClientID,
-		cluster.ClientSecret,
-	)
+	tokenResp, err := s.keycloakClient.GetClusterToken(cluster)
 	if err != nil {
-		return fmt.Errorf("failed to get access token: %w", err)
+		return nil, fmt.Errorf("failed to get access token: %w", err)
 	}
 	accessToken := tokenResp.AccessToken
 
@@ -687,6 +675,28 @@ func (s *UserFederationService) SyncUserFederation(clusterID int, realm, provide
 	return s.keycloakClient.SyncUserFederation(cluster.BaseURL, targetRealm, accessToken, providerID, req.Action)
 }
 
+// SyncUserFederationJob runs a federation sync submitted as a job
+func (s *UserFederationService) SyncUserFederationJob(job *JobContext) (interface{}, error) {
+	var payload domain.FederationSyncJobPayload
+	if err := job.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	job.Logf(domain.JobLogInfo, "Running %s of provider %s on cluster %d", payload.Action, payload.ProviderID, payload.ClusterID)
+	result, err := s.SyncUserFederation(payload.ClusterID, payload.Realm, payload.ProviderID, domain.SyncUserFederationRequest{Action: payload.Action})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, nil
+	}
+	if status, ok := result["status"].(string); ok {
+		job.Logf(domain.JobLogInfo, "Keycloak reported: %s", status)
+	}
+
+	return result, nil
+}
+
 // TestLDAPConnection tests LDAP connection with just URL (before creating provider)
 func (s *UserFederationService) TestLDAPConnection(clusterID int, req domain.TestLDAPConnectionRequest) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
@@ -33,6 +33,15 @@ func (s *UserService) GetAllUsers() ([]*domain.User, error) {
 	return users, nil
 }
 
+func (s *UserService) GetUserByID(id int) (*domain.User, error) {
+	user, err := s.userRepo.GetByID(id)
+	if err != nil || user == nil {
+		return user, err
+	}
+	user.PasswordHash = ""
+	return user, nil
+}
+
 func (s *UserService) CreateUser(req *domain.CreateUserRequest) (*domain.User, error) {
 	// Check if username already exists
 	existingUser, err := s.userRepo.GetByUsername(req.Username)
@@ -0,0 +1,18 @@
+-- Create sync_plans table (reviewable dry-run sync plans)
+CREATE TABLE IF NOT EXISTS sync_plans (
+    id SERIAL PRIMARY KEY,
+    source_cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE,
+    destination_cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE,
+    object_type VARCHAR(20) NOT NULL,
+    object_name VARCHAR(500) NOT NULL,
+    steps JSONB NOT NULL DEFAULT '[]',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    results JSONB,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    applied_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    applied_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_plans_status ON sync_plans(status);
+CREATE INDEX IF NOT EXISTS idx_sync_plans_destination ON sync_plans(destination_cluster_id);
@@ -0,0 +1,3 @@
+-- Store the prerequisites a sync plan pulls in transitively
+ALTER TABLE sync_plans
+ADD COLUMN IF NOT EXISTS dependencies JSONB NOT NULL DEFAULT '[]';
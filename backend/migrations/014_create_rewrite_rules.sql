@@ -0,0 +1,22 @@
+-- Create rewrite_rules table (environment specific value rewriting during sync and import)
+CREATE TABLE IF NOT EXISTS rewrite_rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    description TEXT,
+    source_cluster_id INTEGER REFERENCES clusters(id) ON DELETE CASCADE,
+    destination_cluster_id INTEGER REFERENCES clusters(id) ON DELETE CASCADE,
+    source_tag_id INTEGER REFERENCES environment_tags(id) ON DELETE CASCADE,
+    destination_tag_id INTEGER REFERENCES environment_tags(id) ON DELETE CASCADE,
+    object_type VARCHAR(20) NOT NULL, -- client, user, group
+    field VARCHAR(255) NOT NULL,      -- top-level field (redirectUris, rootUrl, ...), attributes.<name> or attributes.*
+    match_value TEXT NOT NULL,
+    replace_value TEXT NOT NULL DEFAULT '',
+    is_regex BOOLEAN NOT NULL DEFAULT FALSE,
+    priority INTEGER NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_rewrite_rules_destination_cluster ON rewrite_rules(destination_cluster_id);
+CREATE INDEX IF NOT EXISTS idx_rewrite_rules_destination_tag ON rewrite_rules(destination_tag_id);
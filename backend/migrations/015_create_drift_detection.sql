@@ -0,0 +1,28 @@
+-- Create cluster_pairs table (clusters that are compared on a schedule)
+CREATE TABLE IF NOT EXISTS cluster_pairs (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    source_cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE,
+    destination_cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE,
+    interval_minutes INTEGER NOT NULL DEFAULT 60,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    last_run_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (source_cluster_id, destination_cluster_id)
+);
+
+-- Create drift_runs table (one diff of a cluster pair)
+CREATE TABLE IF NOT EXISTS drift_runs (
+    id SERIAL PRIMARY KEY,
+    pair_id INTEGER NOT NULL REFERENCES cluster_pairs(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    drift_count INTEGER NOT NULL DEFAULT 0,
+    counts JSONB NOT NULL DEFAULT '{}',
+    items JSONB NOT NULL DEFAULT '[]',
+    error TEXT,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_drift_runs_pair_started ON drift_runs(pair_id, started_at DESC);
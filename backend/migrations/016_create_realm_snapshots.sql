@@ -0,0 +1,25 @@
+-- Create snapshot_blobs table (content-addressed, gzip compressed JSON shared by all snapshots)
+CREATE TABLE IF NOT EXISTS snapshot_blobs (
+    hash CHAR(64) PRIMARY KEY, -- sha256 of the uncompressed JSON
+    content BYTEA NOT NULL,
+    size INTEGER NOT NULL,     -- uncompressed size in bytes
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create realm_snapshots table (versioned point-in-time records of a cluster's realm)
+CREATE TABLE IF NOT EXISTS realm_snapshots (
+    id SERIAL PRIMARY KEY,
+    cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE,
+    realm VARCHAR(255) NOT NULL,
+    version INTEGER NOT NULL,
+    content_hash CHAR(64) NOT NULL,
+    sections JSONB NOT NULL DEFAULT '{}', -- section name -> blob hash
+    counts JSONB NOT NULL DEFAULT '{}',
+    trigger VARCHAR(20) NOT NULL DEFAULT 'manual',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_verified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- last time the realm was found unchanged
+    UNIQUE (cluster_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_realm_snapshots_cluster_created ON realm_snapshots(cluster_id, created_at DESC);
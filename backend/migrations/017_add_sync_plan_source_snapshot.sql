@@ -0,0 +1,3 @@
+-- Restore plans take their source values from a stored realm snapshot instead of a live cluster
+ALTER TABLE sync_plans
+ADD COLUMN IF NOT EXISTS source_snapshot_id INTEGER REFERENCES realm_snapshots(id) ON DELETE SET NULL;
@@ -0,0 +1,39 @@
+-- Create audit_events table (who changed what through the API)
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    actor_username VARCHAR(255),
+    action VARCHAR(255) NOT NULL,
+    method VARCHAR(10),
+    path TEXT,
+    cluster_id INTEGER,
+    cluster_name VARCHAR(255),
+    realm VARCHAR(255),
+    object_type VARCHAR(100),
+    object_name VARCHAR(500),
+    request_summary JSONB,
+    outcome VARCHAR(20) NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    before_state JSONB,
+    after_state JSONB,
+    ip_address VARCHAR(100),
+    duration_ms INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_created ON audit_events(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_cluster ON audit_events(cluster_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action);
+
+-- Reading the audit log is its own permission; admins get it
+INSERT INTO permissions (name, description) VALUES
+    ('view_audit_log', 'View and export the audit log')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'view_audit_log'
+ON CONFLICT DO NOTHING;
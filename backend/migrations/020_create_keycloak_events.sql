@@ -0,0 +1,41 @@
+-- Create keycloak_events table (login and admin events collected from every cluster)
+CREATE TABLE IF NOT EXISTS keycloak_events (
+    id BIGSERIAL PRIMARY KEY,
+    cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE,
+    realm VARCHAR(255) NOT NULL,
+    kind VARCHAR(10) NOT NULL,
+    event_key VARCHAR(100) NOT NULL,
+    event_time TIMESTAMP NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    user_id VARCHAR(255),
+    username VARCHAR(255),
+    client_id VARCHAR(255),
+    ip_address VARCHAR(100),
+    session_id VARCHAR(255),
+    resource_type VARCHAR(100),
+    resource_path TEXT,
+    error VARCHAR(255),
+    details JSONB,
+    collected_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(cluster_id, kind, event_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_keycloak_events_time ON keycloak_events(event_time DESC);
+CREATE INDEX IF NOT EXISTS idx_keycloak_events_cluster ON keycloak_events(cluster_id, event_time DESC);
+CREATE INDEX IF NOT EXISTS idx_keycloak_events_user ON keycloak_events(user_id);
+CREATE INDEX IF NOT EXISTS idx_keycloak_events_username ON keycloak_events(LOWER(username));
+CREATE INDEX IF NOT EXISTS idx_keycloak_events_client ON keycloak_events(client_id);
+CREATE INDEX IF NOT EXISTS idx_keycloak_events_ip ON keycloak_events(ip_address);
+CREATE INDEX IF NOT EXISTS idx_keycloak_events_type ON keycloak_events(type);
+
+-- Create keycloak_event_checkpoints table (how far each kind of event has been collected from a cluster)
+CREATE TABLE IF NOT EXISTS keycloak_event_checkpoints (
+    cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL,
+    last_event_time TIMESTAMP,
+    last_collected_at TIMESTAMP,
+    last_error TEXT,
+    collected INTEGER NOT NULL DEFAULT 0,
+    claimed_at TIMESTAMP,
+    PRIMARY KEY (cluster_id, kind)
+);
@@ -0,0 +1,10 @@
+-- Secrets are stored as envelopes (enc:v2:<key id>:<wrapped data key>:<ciphertext>), which are longer than the plaintext.
+-- The ciphertext is bound to its (table, column, id) as additional authenticated data, e.g. "clusters.client_secret:12",
+-- so a value copied into another row or column does not decrypt (see pkg/secrets/keyring.go).
+-- Plaintext and enc:v1 values, which were not bound to their row, are re-encrypted by the server on startup;
+-- rotate-secrets re-wraps them for a new key.
+ALTER TABLE clusters ALTER COLUMN client_secret TYPE TEXT;
+ALTER TABLE ldap_config ALTER COLUMN bind_password TYPE TEXT;
+
+-- The master realm credentials of the first cluster version are no longer used and were stored in plaintext
+UPDATE clusters SET username = NULL, password = NULL WHERE username IS NOT NULL OR password IS NOT NULL;
@@ -0,0 +1,3 @@
+-- A cluster can reference its service account secret in an external store (file:<name>, vault:<path>#<field>)
+-- instead of keeping it in client_secret. Such clusters have no client_secret.
+ALTER TABLE clusters ADD COLUMN IF NOT EXISTS secret_ref VARCHAR(500);
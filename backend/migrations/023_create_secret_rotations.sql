@@ -0,0 +1,16 @@
+-- When the multi-manage client secret of a cluster was last rotated (NULL: never, the cluster's age counts)
+ALTER TABLE clusters ADD COLUMN IF NOT EXISTS secret_rotated_at TIMESTAMP;
+
+-- Create secret_rotations table (history of client secret rotations)
+CREATE TABLE IF NOT EXISTS secret_rotations (
+    id SERIAL PRIMARY KEY,
+    cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE,
+    client_id VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    trigger VARCHAR(20) NOT NULL,
+    error TEXT,
+    rotated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_secret_rotations_cluster ON secret_rotations(cluster_id, created_at DESC);
@@ -0,0 +1,4 @@
+-- Rotations of application client secrets name the sink the new secret was delivered to
+-- and, with Keycloak's dual-secret rotation, until when the previous secret stays valid
+ALTER TABLE secret_rotations ADD COLUMN IF NOT EXISTS sink VARCHAR(50);
+ALTER TABLE secret_rotations ADD COLUMN IF NOT EXISTS previous_secret_expires_at TIMESTAMP;
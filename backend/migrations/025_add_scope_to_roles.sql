@@ -0,0 +1,19 @@
+-- Scope of a role: the clusters its permissions apply to (all, clusters or tags)
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS scope VARCHAR(20) NOT NULL DEFAULT 'all';
+
+-- Clusters of roles with the clusters scope
+CREATE TABLE IF NOT EXISTS role_scope_clusters (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    cluster_id INTEGER NOT NULL REFERENCES clusters(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, cluster_id)
+);
+
+-- Environment tags of roles with the tags scope; a role covers every cluster that carries one of them
+CREATE TABLE IF NOT EXISTS role_scope_tags (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES environment_tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_role_scope_clusters_cluster ON role_scope_clusters(cluster_id);
+CREATE INDEX IF NOT EXISTS idx_role_scope_tags_tag ON role_scope_tags(tag_id);
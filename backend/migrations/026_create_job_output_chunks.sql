@@ -0,0 +1,14 @@
+-- Job output files (e.g. user exports) are stored in chunks, so neither writing nor downloading
+-- one holds the whole file in memory. output_content_type is set once the output is complete.
+CREATE TABLE IF NOT EXISTS job_output_chunks (
+    job_id INTEGER NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (job_id, seq)
+);
+
+INSERT INTO job_output_chunks (job_id, seq, data)
+SELECT id, 0, output FROM jobs WHERE output IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS output;
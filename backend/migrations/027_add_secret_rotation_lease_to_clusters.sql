@@ -0,0 +1,7 @@
+-- A rotation claims a cluster's secret until secret_rotation_lease_until instead of holding a row lock
+-- while it talks to Keycloak. A lease left behind by a crashed instance simply runs out.
+ALTER TABLE clusters ADD COLUMN IF NOT EXISTS secret_rotation_lease_until TIMESTAMP;
+
+-- A secret Keycloak regenerated but that could not be stored yet (encrypted). The next rotation of the
+-- cluster checks it and stores it as client_secret.
+ALTER TABLE clusters ADD COLUMN IF NOT EXISTS pending_client_secret TEXT;
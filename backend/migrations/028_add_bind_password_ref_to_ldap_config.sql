@@ -0,0 +1,3 @@
+-- The LDAP bind password can be referenced in an external store (file:<name>, vault:<path>#<field>)
+-- instead of being kept in bind_password; it is then read at every bind and bind_password stays empty.
+ALTER TABLE ldap_config ADD COLUMN IF NOT EXISTS bind_password_ref VARCHAR(500);
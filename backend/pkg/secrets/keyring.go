@@ -0,0 +1,327 @@
+// Package secrets encrypts secrets stored in the database with envelope encryption.
+//
+// Every value is encrypted with its own random data key (AES-256-GCM). The data key is
+// encrypted ("wrapped") with a master key from the keyring and stored next to the
+// ciphertext together with the master key's ID:
+//
+//	enc:v2:<key id>:<wrapped data key>:<ciphertext>
+//
+// The ciphertext is bound to the table, column and row it is stored in (see AAD), so a value
+// copied into another row or column does not decrypt. Values of the first format (enc:v1) were
+// not bound to their row; like plaintext values they are only read by Rotate, which the server
+// runs on startup to re-encrypt them.
+//
+// Rotating to a new master key only re-wraps the data keys; old master keys stay in the
+// keyring until nothing is encrypted with them anymore.
+package secrets
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	prefix = "enc:v2:"
+	// legacyPrefix marks envelopes written before values were bound to their row
+	legacyPrefix = "enc:v1:"
+	// keySize is the size of master and data keys (AES-256)
+	keySize = 32
+)
+
+// ErrNoKeys is returned by LoadKeyring when no master key is configured
+var ErrNoKeys = errors.New("no secret encryption keys configured, set SECRET_KEYS or SECRET_KEYS_FILE")
+
+// ErrNotEncrypted is returned by Decrypt for a value that is stored in plaintext or in the legacy format
+var ErrNotEncrypted = errors.New("secret is not encrypted for its record, restart the server to re-encrypt it")
+
+// Keyring holds the master keys by ID; new values are encrypted with the active one
+type Keyring struct {
+	keys     map[string][]byte
+	order    []string
+	activeID string
+}
+
+// NewKeyring creates a keyring from 32 byte master keys. activeID defaults to the first key.
+func NewKeyring(ids []string, keys [][]byte, activeID string) (*Keyring, error) {
+	if len(ids) == 0 {
+		return nil, ErrNoKeys
+	}
+	if len(ids) != len(keys) {
+		return nil, fmt.Errorf("got %d key IDs for %d keys", len(ids), len(keys))
+	}
+
+	k := &Keyring{keys: make(map[string][]byte, len(ids))}
+	for i, id := range ids {
+		if id == "" || strings.ContainsAny(id, ":, \t") {
+			return nil, fmt.Errorf("invalid key ID %q: must not be empty or contain ':', ',' or whitespace", id)
+		}
+		if len(keys[i]) != keySize {
+			return nil, fmt.Errorf("key %s is %d bytes, expected %d", id, len(keys[i]), keySize)
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate key ID %s", id)
+		}
+		k.keys[id] = keys[i]
+		k.order = append(k.order, id)
+	}
+
+	k.activeID = activeID
+	if k.activeID == "" {
+		k.activeID = ids[0]
+	}
+	if _, ok := k.keys[k.activeID]; !ok {
+		return nil, fmt.Errorf("active key %s is not configured", k.activeID)
+	}
+	return k, nil
+}
+
+// LoadKeyring reads the master keys from the environment. SECRET_KEYS holds comma separated
+// "id:base64key" entries; SECRET_KEYS_FILE names a file with one entry per line (# starts a comment).
+// SECRET_ACTIVE_KEY selects the key new values are encrypted with, by default the first one listed.
+func LoadKeyring() (*Keyring, error) {
+	var entries []string
+	if value := os.Getenv("SECRET_KEYS"); value != "" {
+		entries = append(entries, strings.Split(value, ",")...)
+	}
+	if path := os.Getenv("SECRET_KEYS_FILE"); path != "" {
+		lines, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, lines...)
+	}
+
+	var ids []string
+	var keys [][]byte
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid secret key entry, expected id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret key %s: %w", id, err)
+		}
+		ids = append(ids, strings.TrimSpace(id))
+		keys = append(keys, key)
+	}
+
+	return NewKeyring(ids, keys, os.Getenv("SECRET_ACTIVE_KEY"))
+}
+
+func readKeyFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open secret key file: %w", err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read secret key file: %w", err)
+	}
+	return lines, nil
+}
+
+// GenerateKey returns a new random master key, base64 encoded for SECRET_KEYS
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeID
+}
+
+// KeyIDs lists the IDs of all configured keys, the active one included
+func (k *Keyring) KeyIDs() []string {
+	return append([]string(nil), k.order...)
+}
+
+// AAD returns the additional authenticated data that binds a value to the table, column and row it is stored in
+func AAD(table, column string, id int) string {
+	return fmt.Sprintf("%s.%s:%d", table, column, id)
+}
+
+// IsEncrypted reports whether a stored value is an envelope of the current format rather than
+// a plaintext or legacy value that Rotate still has to re-encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyID returns the ID of the master key a stored value is encrypted with, or "" for a plaintext or legacy value
+func KeyID(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	return id
+}
+
+// NeedsRotation reports whether a stored value is not yet encrypted with the active key.
+// Empty values are never encrypted.
+func (k *Keyring) NeedsRotation(value string) bool {
+	return value != "" && KeyID(value) != k.activeID
+}
+
+// Encrypt encrypts a secret with a new data key wrapped by the active master key and binds it to aad,
+// see AAD. An empty secret stays empty.
+func (k *Keyring) Encrypt(plaintext, aad string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.activeID], dataKey, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.activeID + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt returns the secret of a stored value that was encrypted with the same aad. An empty value is
+// an empty secret; plaintext and legacy values are refused with ErrNotEncrypted.
+func (k *Keyring) Decrypt(value, aad string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if !IsEncrypted(value) {
+		return "", ErrNotEncrypted
+	}
+
+	dataKey, ciphertext, err := k.open(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := unseal(dataKey, ciphertext, []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret, it may belong to another record: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Rotate re-encrypts a stored value for the active master key. Encrypted values keep their
+// data key and ciphertext; only the data key is re-wrapped, once the ciphertext is checked to
+// belong to aad. Plaintext and legacy values are encrypted and bound to aad.
+func (k *Keyring) Rotate(value, aad string) (string, error) {
+	switch {
+	case value == "":
+		return "", nil
+	case strings.HasPrefix(value, legacyPrefix):
+		dataKey, ciphertext, err := k.open(strings.TrimPrefix(value, legacyPrefix))
+		if err != nil {
+			return "", err
+		}
+		plaintext, err := unseal(dataKey, ciphertext, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt secret: %w", err)
+		}
+		return k.Encrypt(string(plaintext), aad)
+	case !IsEncrypted(value):
+		return k.Encrypt(value, aad)
+	case !k.NeedsRotation(value):
+		return value, nil
+	}
+
+	dataKey, ciphertext, err := k.open(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", err
+	}
+	if _, err := unseal(dataKey, ciphertext, []byte(aad)); err != nil {
+		return "", fmt.Errorf("failed to decrypt secret, it may belong to another record: %w", err)
+	}
+	wrapped, err := seal(k.keys[k.activeID], dataKey, nil)
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.activeID + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// open unwraps the data key of an envelope, given without its version prefix
+func (k *Keyring) open(envelope string) ([]byte, []byte, error) {
+	parts := strings.Split(envelope, ":")
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("invalid encrypted secret")
+	}
+
+	masterKey, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, nil, fmt.Errorf("secret is encrypted with unknown key %s", parts[0])
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid encrypted secret: %w", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid encrypted secret: %w", err)
+	}
+
+	dataKey, err := unseal(masterKey, wrapped, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap data key with key %s: %w", parts[0], err)
+	}
+	return dataKey, ciphertext, nil
+}
+
+// seal encrypts with AES-GCM, authenticating aad as well, and prepends the nonce
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func unseal(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,238 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func testKeyring(t *testing.T, ids []string, keys [][]byte, activeID string) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(ids, keys, activeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// legacyEnvelope builds a value in the enc:v1 format, which bound no AAD
+func legacyEnvelope(t *testing.T, keyID string, masterKey []byte, plaintext string) string {
+	t.Helper()
+	dataKey := testKey(9)
+	ciphertext, err := seal(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := seal(masterKey, dataKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return legacyPrefix + keyID + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(ciphertext)
+}
+
+// tamper changes one character of the ciphertext, away from the unused bits of the last one
+func tamper(value string) string {
+	i := len(value) - 10
+	c := byte('A')
+	if value[i] == c {
+		c = 'B'
+	}
+	return value[:i] + string(c) + value[i+1:]
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	k := testKeyring(t, []string{"k1"}, [][]byte{testKey(1)}, "")
+	aad := AAD("clusters", "client_secret", 7)
+
+	for _, secret := range []string{"s3cret", "with:colons", strings.Repeat("x", 4096), "ünïcode"} {
+		encrypted, err := k.Encrypt(secret, aad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(encrypted) || KeyID(encrypted) != "k1" || strings.Contains(encrypted, secret) {
+			t.Fatalf("Encrypt(%q) = %q, want an envelope for k1", secret, encrypted)
+		}
+		decrypted, err := k.Decrypt(encrypted, aad)
+		if err != nil || decrypted != secret {
+			t.Fatalf("Decrypt = %q, %v, want %q", decrypted, err, secret)
+		}
+	}
+
+	// Every value gets its own data key and nonce
+	a, _ := k.Encrypt("same", aad)
+	b, _ := k.Encrypt("same", aad)
+	if a == b {
+		t.Error("two encryptions of the same secret are identical")
+	}
+}
+
+func TestKeyringEmptyValue(t *testing.T) {
+	k := testKeyring(t, []string{"k1"}, [][]byte{testKey(1)}, "")
+
+	if encrypted, err := k.Encrypt("", "aad"); err != nil || encrypted != "" {
+		t.Errorf("Encrypt(\"\") = %q, %v, want an empty value", encrypted, err)
+	}
+	if decrypted, err := k.Decrypt("", "aad"); err != nil || decrypted != "" {
+		t.Errorf("Decrypt(\"\") = %q, %v, want an empty secret", decrypted, err)
+	}
+	if k.NeedsRotation("") {
+		t.Error("an empty value needs rotation")
+	}
+}
+
+func TestKeyringDecryptErrors(t *testing.T) {
+	k := testKeyring(t, []string{"k1"}, [][]byte{testKey(1)}, "")
+	aad := AAD("clusters", "client_secret", 1)
+	encrypted, err := k.Encrypt("s3cret", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := testKeyring(t, []string{"k2"}, [][]byte{testKey(2)}, "")
+	sameIDOtherKey := testKeyring(t, []string{"k1"}, [][]byte{testKey(3)}, "")
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		value   string
+		aad     string
+		wantErr error
+		errText string
+	}{
+		{name: "plaintext", keyring: k, value: "s3cret", aad: aad, wantErr: ErrNotEncrypted},
+		{name: "legacy envelope", keyring: k, value: legacyEnvelope(t, "k1", testKey(1), "s3cret"), aad: aad, wantErr: ErrNotEncrypted},
+		{name: "other row", keyring: k, value: encrypted, aad: AAD("clusters", "client_secret", 2), errText: "another record"},
+		{name: "other column", keyring: k, value: encrypted, aad: AAD("clusters", "pending_client_secret", 1), errText: "another record"},
+		{name: "missing key id", keyring: other, value: encrypted, aad: aad, errText: "unknown key k1"},
+		{name: "wrong key under the same id", keyring: sameIDOtherKey, value: encrypted, aad: aad, errText: "failed to unwrap data key with key k1"},
+		{name: "truncated", keyring: k, value: encrypted[:strings.LastIndex(encrypted, ":")], aad: aad, errText: "invalid encrypted secret"},
+		{name: "bad encoding", keyring: k, value: prefix + "k1:!!:!!", aad: aad, errText: "invalid encrypted secret"},
+		{name: "tampered ciphertext", keyring: k, value: tamper(encrypted), aad: aad, errText: "failed to decrypt secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypted, err := tt.keyring.Decrypt(tt.value, tt.aad)
+			if err == nil {
+				t.Fatalf("Decrypt = %q, want an error", decrypted)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.errText != "" && !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("err = %v, want %q", err, tt.errText)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	aad := AAD("ldap_config", "bind_password", 1)
+	old := testKeyring(t, []string{"old"}, [][]byte{testKey(1)}, "")
+	encrypted, err := old.Encrypt("s3cret", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key is active, the old one is still configured for values not rotated yet
+	k := testKeyring(t, []string{"new", "old"}, [][]byte{testKey(2), testKey(1)}, "")
+	if !k.NeedsRotation(encrypted) {
+		t.Fatal("a value of the old key does not need rotation")
+	}
+	if decrypted, err := k.Decrypt(encrypted, aad); err != nil || decrypted != "s3cret" {
+		t.Fatalf("Decrypt with the old key = %q, %v", decrypted, err)
+	}
+
+	rotated, err := k.Rotate(encrypted, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyID(rotated) != "new" || k.NeedsRotation(rotated) {
+		t.Fatalf("rotated value %q is not encrypted with the new key", rotated)
+	}
+	// Only the data key is re-wrapped
+	if rotated[strings.LastIndex(rotated, ":"):] != encrypted[strings.LastIndex(encrypted, ":"):] {
+		t.Error("rotation changed the ciphertext")
+	}
+
+	// Once rotated, the old key can be removed
+	newOnly := testKeyring(t, []string{"new"}, [][]byte{testKey(2)}, "")
+	if decrypted, err := newOnly.Decrypt(rotated, aad); err != nil || decrypted != "s3cret" {
+		t.Fatalf("Decrypt without the old key = %q, %v", decrypted, err)
+	}
+	if again, err := newOnly.Rotate(rotated, aad); err != nil || again != rotated {
+		t.Errorf("Rotate of a current value = %q, %v, want it unchanged", again, err)
+	}
+
+	// A value copied from another row is not re-wrapped for the row it was found in
+	if _, err := k.Rotate(encrypted, AAD("ldap_config", "bind_password", 2)); err == nil {
+		t.Error("Rotate re-wrapped a value bound to another row")
+	}
+}
+
+func TestKeyringRotateUpgradesLegacyValues(t *testing.T) {
+	k := testKeyring(t, []string{"k1"}, [][]byte{testKey(1)}, "")
+	aad := AAD("clusters", "client_secret", 3)
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"plaintext", "s3cret"},
+		{"legacy envelope", legacyEnvelope(t, "k1", testKey(1), "s3cret")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if IsEncrypted(tt.value) || !k.NeedsRotation(tt.value) {
+				t.Fatal("legacy value is not reported for re-encryption")
+			}
+			upgraded, err := k.Rotate(tt.value, aad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decrypted, err := k.Decrypt(upgraded, aad); err != nil || decrypted != "s3cret" {
+				t.Fatalf("Decrypt of the upgraded value = %q, %v", decrypted, err)
+			}
+			if _, err := k.Decrypt(upgraded, AAD("clusters", "client_secret", 4)); err == nil {
+				t.Error("upgraded value is not bound to its row")
+			}
+		})
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name     string
+		ids      []string
+		keys     [][]byte
+		activeID string
+		wantErr  bool
+	}{
+		{"first key active", []string{"a", "b"}, [][]byte{testKey(1), testKey(2)}, "", false},
+		{"explicit active key", []string{"a", "b"}, [][]byte{testKey(1), testKey(2)}, "b", false},
+		{"no keys", nil, nil, "", true},
+		{"unknown active key", []string{"a"}, [][]byte{testKey(1)}, "c", true},
+		{"short key", []string{"a"}, [][]byte{testKey(1)[:16]}, "", true},
+		{"duplicate id", []string{"a", "a"}, [][]byte{testKey(1), testKey(2)}, "", true},
+		{"id with a colon", []string{"a:b"}, [][]byte{testKey(1)}, "", true},
+		{"missing key", []string{"a", "b"}, [][]byte{testKey(1)}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewKeyring(tt.ids, tt.keys, tt.activeID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.activeID != "" && k.ActiveKeyID() != tt.activeID {
+				t.Errorf("active key = %s, want %s", k.ActiveKeyID(), tt.activeID)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Delivery is a rotated client secret handed to a sink
+type Delivery struct {
+	Cluster   string    `json:"cluster"`
+	Realm     string    `json:"realm"`
+	ClientID  string    `json:"client_id"`
+	Secret    string    `json:"client_secret"`
+	RotatedAt time.Time `json:"rotated_at"`
+	// PreviousSecretExpiresAt is set while Keycloak still accepts the previous secret (dual-secret rotation)
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+}
+
+// Sink delivers rotated client secrets to where the applications read them from
+type Sink interface {
+	Deliver(ctx context.Context, delivery *Delivery) error
+}
+
+// LoadSinks configures the sinks rotated client secrets can be delivered to, by name:
+//
+//	SECRET_SINK_DIR              "file": writes <dir>/<cluster>/<realm>/<client id>
+//	SECRET_SINK_WEBHOOK_URL      "webhook": POSTs the delivery as JSON
+//	SECRET_SINK_WEBHOOK_SECRET   signs webhook bodies with HMAC-SHA256 in the X-Signature-256 header
+//	SECRET_SINK_VAULT_PATH       "vault": writes <path>/<cluster>/<realm>/<client id> with the VAULT_* settings
+func LoadSinks() (map[string]Sink, error) {
+	sinks := make(map[string]Sink)
+
+	if dir := os.Getenv("SECRET_SINK_DIR"); dir != "" {
+		sinks["file"] = NewFileSink(dir)
+	}
+	if webhookURL := os.Getenv("SECRET_SINK_WEBHOOK_URL"); webhookURL != "" {
+		sinks["webhook"] = NewWebhookSink(webhookURL, os.Getenv("SECRET_SINK_WEBHOOK_SECRET"))
+	}
+	if path := os.Getenv("SECRET_SINK_VAULT_PATH"); path != "" {
+		vault, err := vaultStoreFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if vault == nil {
+			return nil, fmt.Errorf("SECRET_SINK_VAULT_PATH is set but VAULT_ADDR is not")
+		}
+		sinks["vault"] = &VaultSink{store: vault, path: strings.Trim(path, "/")}
+	}
+
+	return sinks, nil
+}
+
+// deliveryPath places a delivery below a directory or path prefix
+func deliveryPath(delivery *Delivery) []string {
+	return []string{safeName(delivery.Cluster), safeName(delivery.Realm), safeName(delivery.ClientID)}
+}
+
+// safeName turns a cluster, realm or client name into a single path segment
+func safeName(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+	if safe == "" || strings.Trim(safe, ".") == "" {
+		return "_"
+	}
+	return safe
+}
+
+// FileSink writes each secret into its own file, readable only by the owner, e.g. on a volume
+// that a secret sync agent picks up
+type FileSink struct {
+	dir string
+}
+
+func NewFileSink(dir string) *FileSink {
+	return &FileSink{dir: dir}
+}
+
+func (s *FileSink) Deliver(ctx context.Context, delivery *Delivery) error {
+	path := filepath.Join(append([]string{s.dir}, deliveryPath(delivery)...)...)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create secret directory: %w", err)
+	}
+
+	// Readers never see a partly written secret
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".secret-*")
+	if err != nil {
+		return fmt.Errorf("failed to write secret file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(delivery.Secret); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write secret file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write secret file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write secret file: %w", err)
+	}
+	return nil
+}
+
+// WebhookSink posts each delivery as JSON to a URL
+type WebhookSink struct {
+	url        string
+	signingKey string
+	httpClient *http.Client
+}
+
+func NewWebhookSink(url, signingKey string) *WebhookSink {
+	return &WebhookSink{
+		url:        url,
+		signingKey: signingKey,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WebhookSink) Deliver(ctx context.Context, delivery *Delivery) error {
+	body, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.signingKey != "" {
+		mac := hmac.New(sha256.New, []byte(s.signingKey))
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// VaultSink writes each secret as a new version of a Vault KV version 2 secret with the fields client_id and client_secret
+type VaultSink struct {
+	store *VaultStore
+	path  string
+}
+
+func (s *VaultSink) Deliver(ctx context.Context, delivery *Delivery) error {
+	path := strings.Join(append([]string{s.path}, deliveryPath(delivery)...), "/")
+	return s.store.Put(ctx, path, map[string]string{
+		"client_id":     delivery.ClientID,
+		"client_secret": delivery.Secret,
+	})
+}
@@ -0,0 +1,326 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when a secret does not exist
+var ErrNotFound = errors.New("secret not found")
+
+// Store reads secrets from where they are kept: the application database (see DatabaseRef), a directory
+// of mounted files or Vault. Secrets are read through a Store each time they are used, never copied into
+// the records that reference them, so a secret changed in its store takes effect with its next use.
+type Store interface {
+	// Get returns the secret at path; the path format depends on the store
+	Get(ctx context.Context, path string) (string, error)
+}
+
+// Resolver resolves secret references of the form "<store>:<path>", e.g. "file:keycloak/prod",
+// "vault:keycloak/prod#client_secret" or "db:clusters/12", with the store registered under the prefix
+type Resolver struct {
+	stores map[string]Store
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{stores: make(map[string]Store)}
+}
+
+// Register makes a store available under a reference prefix
+func (r *Resolver) Register(scheme string, store Store) {
+	r.stores[scheme] = store
+}
+
+// Schemes lists the prefixes of the registered stores
+func (r *Resolver) Schemes() []string {
+	schemes := make([]string, 0, len(r.stores))
+	for scheme := range r.stores {
+		schemes = append(schemes, scheme)
+	}
+	return schemes
+}
+
+// Validate checks that a reference is well formed and names a registered store, without reading the secret
+func (r *Resolver) Validate(ref string) error {
+	_, _, err := r.store(ref)
+	return err
+}
+
+// Resolve reads the secret a reference points to
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	store, path, err := r.store(ref)
+	if err != nil {
+		return "", err
+	}
+	secret, err := store.Get(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s: %w", ref, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("secret %s is empty", ref)
+	}
+	return secret, nil
+}
+
+// ValidateExternal checks a reference given by a user: it must name a store outside the database, so a
+// record cannot be pointed at the secret another record keeps in the database
+func (r *Resolver) ValidateExternal(ref string) error {
+	if IsDatabaseRef(ref) {
+		return fmt.Errorf("invalid secret reference %q: secrets kept in the database cannot be referenced", ref)
+	}
+	return r.Validate(ref)
+}
+
+func (r *Resolver) store(ref string) (Store, string, error) {
+	scheme, path, ok := strings.Cut(ref, ":")
+	if !ok || scheme == "" || path == "" {
+		return nil, "", fmt.Errorf("invalid secret reference %q, expected <store>:<path>", ref)
+	}
+	store, ok := r.stores[scheme]
+	if !ok {
+		return nil, "", fmt.Errorf("invalid secret reference %q: secret store %s is not configured", ref, scheme)
+	}
+	return store, path, nil
+}
+
+// DatabaseScheme is the prefix of secrets kept encrypted in the application database
+const DatabaseScheme = "db"
+
+// DatabaseRef returns the reference of the secret a database record keeps, e.g. "db:clusters/12"
+func DatabaseRef(table string, id int) string {
+	return fmt.Sprintf("%s:%s/%d", DatabaseScheme, table, id)
+}
+
+// IsDatabaseRef reports whether a reference points into the application database
+func IsDatabaseRef(ref string) bool {
+	return strings.HasPrefix(ref, DatabaseScheme+":")
+}
+
+// LoadResolver configures the external secret stores from the environment; the database store is
+// registered by the caller, which owns the connection:
+//
+//	SECRETS_DIR            directory of mounted secret files, referenced as file:<name>
+//	VAULT_ADDR             Vault server, referenced as vault:<path>#<field>
+//	VAULT_TOKEN            Vault token, or VAULT_TOKEN_FILE to read it from a file (e.g. written by Vault Agent)
+//	VAULT_KV_MOUNT         mount path of the KV version 2 engine, "secret" by default
+//	VAULT_NAMESPACE        Vault Enterprise namespace
+func LoadResolver() (*Resolver, error) {
+	r := NewResolver()
+
+	if dir := os.Getenv("SECRETS_DIR"); dir != "" {
+		r.Register("file", NewFileStore(dir))
+	}
+
+	vault, err := vaultStoreFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if vault != nil {
+		r.Register("vault", vault)
+	}
+
+	return r, nil
+}
+
+// vaultStoreFromEnv returns the Vault store configured by VAULT_ADDR, or nil without VAULT_ADDR
+func vaultStoreFromEnv() (*VaultStore, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, nil
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	tokenFile := os.Getenv("VAULT_TOKEN_FILE")
+	if token == "" && tokenFile == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is set but neither VAULT_TOKEN nor VAULT_TOKEN_FILE is")
+	}
+	store := NewVaultStore(addr, token, os.Getenv("VAULT_KV_MOUNT"))
+	store.TokenFile = tokenFile
+	store.Namespace = os.Getenv("VAULT_NAMESPACE")
+	return store, nil
+}
+
+// FileStore reads secrets from files in a directory, such as a mounted Kubernetes secret.
+// The path is the file name relative to the directory. Files are read on every call,
+// so secrets updated in place are picked up without a restart.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (s *FileStore) Get(ctx context.Context, path string) (string, error) {
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("invalid secret path %q: must be relative to the secrets directory", path)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, path))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	// Secret files written by hand usually end with a newline
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// VaultStore reads secrets from a HashiCorp Vault KV version 2 engine. Paths have the form
+// "<secret path>#<field>"; the field may be left out when the secret has a single field.
+type VaultStore struct {
+	Addr      string
+	Token     string
+	TokenFile string // read on every call instead of Token when set
+	Mount     string
+	Namespace string
+
+	httpClient *http.Client
+}
+
+func NewVaultStore(addr, token, mount string) *VaultStore {
+	if mount == "" {
+		mount = "secret"
+	}
+	return &VaultStore{
+		Addr:       strings.TrimRight(addr, "/"),
+		Token:      token,
+		Mount:      strings.Trim(mount, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *VaultStore) Get(ctx context.Context, path string) (string, error) {
+	secretPath, field, _ := strings.Cut(path, "#")
+	secretPath = strings.Trim(secretPath, "/")
+	if secretPath == "" {
+		return "", fmt.Errorf("invalid vault secret path %q", path)
+	}
+
+	token, err := s.token()
+	if err != nil {
+		return "", err
+	}
+
+	reqURL := fmt.Sprintf("%s/v1/%s/data/%s", s.Addr, s.Mount, escapePath(secretPath))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if s.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.Namespace)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	// A deleted latest version is returned with null data
+	if body.Data.Data == nil {
+		return "", ErrNotFound
+	}
+
+	if field == "" {
+		if len(body.Data.Data) != 1 {
+			return "", fmt.Errorf("secret has %d fields, name one with <path>#<field>", len(body.Data.Data))
+		}
+		for name := range body.Data.Data {
+			field = name
+		}
+	}
+	value, ok := body.Data.Data[field]
+	if !ok {
+		return "", fmt.Errorf("field %s: %w", field, ErrNotFound)
+	}
+	secret, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %s is not a string", field)
+	}
+	return secret, nil
+}
+
+// Put writes a new version of the secret at path with the given fields
+func (s *VaultStore) Put(ctx context.Context, path string, data map[string]string) error {
+	secretPath := strings.Trim(path, "/")
+	if secretPath == "" {
+		return fmt.Errorf("invalid vault secret path %q", path)
+	}
+
+	token, err := s.token()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return err
+	}
+	reqURL := fmt.Sprintf("%s/v1/%s/data/%s", s.Addr, s.Mount, escapePath(secretPath))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", token)
+	if s.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.Namespace)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *VaultStore) token() (string, error) {
+	if s.TokenFile == "" {
+		return s.Token, nil
+	}
+	data, err := os.ReadFile(s.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read vault token file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,280 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// vaultServer answers KV v2 reads with body for the request path it was given, and 404 for any other path
+func vaultServer(t *testing.T, path, body string, seen *http.Request) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if seen != nil {
+			*seen = *r.Clone(context.Background())
+		}
+		if r.URL.EscapedPath() != path {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVaultStoreGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		mount   string
+		path    string
+		reqPath string
+		body    string
+		want    string
+		wantErr error
+		errText string
+	}{
+		{
+			name:    "named field",
+			path:    "keycloak/prod#client_secret",
+			reqPath: "/v1/secret/data/keycloak/prod",
+			body:    `{"data": {"data": {"client_id": "multi-manage", "client_secret": "s3cret"}}}`,
+			want:    "s3cret",
+		},
+		{
+			name:    "single field by default",
+			path:    "keycloak/prod",
+			reqPath: "/v1/secret/data/keycloak/prod",
+			body:    `{"data": {"data": {"password": "only"}}}`,
+			want:    "only",
+		},
+		{
+			name:    "several fields without a name",
+			path:    "keycloak/prod",
+			reqPath: "/v1/secret/data/keycloak/prod",
+			body:    `{"data": {"data": {"a": "1", "b": "2"}}}`,
+			errText: "secret has 2 fields",
+		},
+		{
+			name:    "custom mount and escaped segments",
+			mount:   "/kv/",
+			path:    "/team a/prod#key",
+			reqPath: "/v1/kv/data/team%20a/prod",
+			body:    `{"data": {"data": {"key": "v"}}}`,
+			want:    "v",
+		},
+		{
+			name:    "missing secret",
+			path:    "other#key",
+			reqPath: "/v1/secret/data/keycloak/prod",
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "deleted latest version",
+			path:    "keycloak/prod#key",
+			reqPath: "/v1/secret/data/keycloak/prod",
+			body:    `{"data": {"data": null, "metadata": {"deletion_time": "2024-01-01T00:00:00Z"}}}`,
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "missing field",
+			path:    "keycloak/prod#other",
+			reqPath: "/v1/secret/data/keycloak/prod",
+			body:    `{"data": {"data": {"key": "v"}}}`,
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "field is not a string",
+			path:    "keycloak/prod#port",
+			reqPath: "/v1/secret/data/keycloak/prod",
+			body:    `{"data": {"data": {"port": 8080}}}`,
+			errText: "not a string",
+		},
+		{
+			name:    "empty path",
+			path:    "#key",
+			errText: "invalid vault secret path",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := vaultServer(t, tt.reqPath, tt.body, nil)
+			store := NewVaultStore(server.URL+"/", "token", tt.mount)
+
+			got, err := store.Get(context.Background(), tt.path)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			case tt.errText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("err = %v, want %q", err, tt.errText)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case got != tt.want:
+				t.Errorf("Get = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultStoreStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	_, err := NewVaultStore(server.URL, "token", "").Get(context.Background(), "keycloak/prod")
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want a status error for a 403", err)
+	}
+}
+
+func TestVaultStoreHeaders(t *testing.T) {
+	var seen http.Request
+	server := vaultServer(t, "/v1/secret/data/app", `{"data": {"data": {"key": "v"}}}`, &seen)
+
+	store := NewVaultStore(server.URL, "static-token", "")
+	if _, err := store.Get(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	if seen.Header.Get("X-Vault-Token") != "static-token" {
+		t.Errorf("X-Vault-Token = %q", seen.Header.Get("X-Vault-Token"))
+	}
+	if _, ok := seen.Header["X-Vault-Namespace"]; ok {
+		t.Error("X-Vault-Namespace sent without a namespace")
+	}
+
+	store.Namespace = "team/a"
+	if _, err := store.Get(context.Background(), "app"); err != nil {
+		t.Fatal(err)
+	}
+	if seen.Header.Get("X-Vault-Namespace") != "team/a" {
+		t.Errorf("X-Vault-Namespace = %q, want team/a", seen.Header.Get("X-Vault-Namespace"))
+	}
+}
+
+func TestVaultStoreTokenFile(t *testing.T) {
+	var seen http.Request
+	server := vaultServer(t, "/v1/secret/data/app", `{"data": {"data": {"key": "v"}}}`, &seen)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	store := NewVaultStore(server.URL, "ignored", "")
+	store.TokenFile = tokenFile
+
+	// The file is read on every call, so a token renewed by Vault Agent is picked up
+	for _, token := range []string{"agent-token-1", "agent-token-2"} {
+		if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Get(context.Background(), "app"); err != nil {
+			t.Fatal(err)
+		}
+		if seen.Header.Get("X-Vault-Token") != token {
+			t.Errorf("X-Vault-Token = %q, want %q", seen.Header.Get("X-Vault-Token"), token)
+		}
+	}
+
+	os.Remove(tokenFile)
+	if _, err := store.Get(context.Background(), "app"); err == nil {
+		t.Error("Get succeeded without the token file")
+	}
+}
+
+func TestFileStoreGet(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "prod"), []byte("s3cret\r\n"), 0o600)
+	os.MkdirAll(filepath.Join(dir, "team"), 0o700)
+	os.WriteFile(filepath.Join(dir, "team", "dev"), []byte("dev-secret"), 0o600)
+	// A file next to the secrets directory that no path may reach
+	os.WriteFile(filepath.Join(filepath.Dir(dir), "outside"), []byte("x"), 0o600)
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr error
+		invalid bool
+	}{
+		{path: "prod", want: "s3cret"},
+		{path: "team/dev", want: "dev-secret"},
+		{path: "missing", wantErr: ErrNotFound},
+		{path: "../x", invalid: true},
+		{path: "../outside", invalid: true},
+		{path: "team/../../outside", invalid: true},
+		{path: "/etc/passwd", invalid: true},
+		{path: "", invalid: true},
+	}
+
+	store := NewFileStore(dir)
+	for _, tt := range tests {
+		got, err := store.Get(context.Background(), tt.path)
+		switch {
+		case tt.invalid:
+			if err == nil || !strings.Contains(err.Error(), "invalid secret path") {
+				t.Errorf("Get(%q) = %q, %v, want the path rejected", tt.path, got, err)
+			}
+		case tt.wantErr != nil:
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Get(%q) err = %v, want %v", tt.path, err, tt.wantErr)
+			}
+		case err != nil || got != tt.want:
+			t.Errorf("Get(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "prod"), []byte("s3cret"), 0o600)
+	os.WriteFile(filepath.Join(dir, "empty"), nil, 0o600)
+
+	r := NewResolver()
+	r.Register("file", NewFileStore(dir))
+
+	if secret, err := r.Resolve(context.Background(), "file:prod"); err != nil || secret != "s3cret" {
+		t.Fatalf("Resolve(file:prod) = %q, %v", secret, err)
+	}
+	if _, err := r.Resolve(context.Background(), "file:missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(file:missing) err = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Resolve(context.Background(), "file:empty"); err == nil {
+		t.Error("Resolve accepted an empty secret")
+	}
+
+	for _, ref := range []string{"prod", "file:", ":prod", "vault:keycloak/prod"} {
+		if err := r.Validate(ref); err == nil {
+			t.Errorf("Validate(%q) accepted the reference", ref)
+		}
+	}
+}
+
+func TestResolverValidateExternal(t *testing.T) {
+	r := NewResolver()
+	r.Register("file", NewFileStore(t.TempDir()))
+	r.Register(DatabaseScheme, NewFileStore(t.TempDir()))
+
+	ref := DatabaseRef("clusters", 12)
+	if ref != "db:clusters/12" || !IsDatabaseRef(ref) || IsDatabaseRef("file:db:clusters/12") {
+		t.Fatalf("DatabaseRef = %q", ref)
+	}
+	if err := r.Validate(ref); err != nil {
+		t.Errorf("Validate(%s) = %v, want the registered store accepted", ref, err)
+	}
+	if err := r.ValidateExternal(ref); err == nil {
+		t.Errorf("ValidateExternal(%s) accepted a database secret", ref)
+	}
+	if err := r.ValidateExternal("file:prod"); err != nil {
+		t.Errorf("ValidateExternal(file:prod) = %v", err)
+	}
+	if err := r.ValidateExternal("vault:keycloak/prod"); err == nil {
+		t.Error("ValidateExternal accepted a store that is not configured")
+	}
+}